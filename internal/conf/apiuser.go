@@ -0,0 +1,62 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// APIRole is the role of an API user.
+type APIRole int
+
+// supported API roles.
+const (
+	APIRoleRead APIRole = iota
+	APIRoleAdmin
+)
+
+// MarshalJSON marshals a APIRole into JSON.
+func (d APIRole) MarshalJSON() ([]byte, error) {
+	var out string
+
+	switch d {
+	case APIRoleRead:
+		out = "read"
+
+	default:
+		out = "admin"
+	}
+
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON unmarshals a APIRole from JSON.
+func (d *APIRole) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	switch in {
+	case "", "read":
+		*d = APIRoleRead
+
+	case "admin":
+		*d = APIRoleAdmin
+
+	default:
+		return fmt.Errorf("invalid API role: '%s'", in)
+	}
+
+	return nil
+}
+
+func (d *APIRole) unmarshalEnv(s string) error {
+	return d.UnmarshalJSON([]byte(`"` + s + `"`))
+}
+
+// APIUser is a user that can access the API, metrics and pprof.
+type APIUser struct {
+	Pass  Credential `json:"pass"`
+	Token Credential `json:"token"`
+	Role  APIRole    `json:"role"`
+}
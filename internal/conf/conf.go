@@ -129,7 +129,8 @@ func loadFromFile(fpath string, conf *Conf) (bool, error) {
 				return fmt.Errorf("non-existent parameter: '%s'", k)
 			}
 
-			if fi == reflect.TypeOf(map[string]*PathConf{}) && v != nil {
+			if fi.Kind() == reflect.Map && fi.Elem().Kind() == reflect.Ptr &&
+				fi.Elem().Elem().Kind() == reflect.Struct && v != nil {
 				ma2, ok := v.(map[string]interface{})
 				if !ok {
 					return fmt.Errorf("parameter %s is not a map", k)
@@ -168,36 +169,61 @@ func loadFromFile(fpath string, conf *Conf) (bool, error) {
 // Conf is a configuration.
 type Conf struct {
 	// general
-	LogLevel                  LogLevel        `json:"logLevel"`
-	LogDestinations           LogDestinations `json:"logDestinations"`
-	LogFile                   string          `json:"logFile"`
-	ReadTimeout               StringDuration  `json:"readTimeout"`
-	WriteTimeout              StringDuration  `json:"writeTimeout"`
-	ReadBufferCount           int             `json:"readBufferCount"`
-	ExternalAuthenticationURL string          `json:"externalAuthenticationURL"`
-	API                       bool            `json:"api"`
-	APIAddress                string          `json:"apiAddress"`
-	Metrics                   bool            `json:"metrics"`
-	MetricsAddress            string          `json:"metricsAddress"`
-	PPROF                     bool            `json:"pprof"`
-	PPROFAddress              string          `json:"pprofAddress"`
-	RunOnConnect              string          `json:"runOnConnect"`
-	RunOnConnectRestart       bool            `json:"runOnConnectRestart"`
+	LogLevel                               LogLevel            `json:"logLevel"`
+	LogDestinations                        LogDestinations     `json:"logDestinations"`
+	LogFile                                string              `json:"logFile"`
+	ReadTimeout                            StringDuration      `json:"readTimeout"`
+	WriteTimeout                           StringDuration      `json:"writeTimeout"`
+	ReadBufferCount                        int                 `json:"readBufferCount"`
+	ExternalAuthenticationURL              string              `json:"externalAuthenticationURL"`
+	ExternalAuthenticationTimeout          StringDuration      `json:"externalAuthenticationTimeout"`
+	ExternalAuthenticationCacheTTL         StringDuration      `json:"externalAuthenticationCacheTTL"`
+	ExternalAuthenticationNegativeCacheTTL StringDuration      `json:"externalAuthenticationNegativeCacheTTL"`
+	ExternalAuthenticationHeaders          HTTPHeaders         `json:"externalAuthenticationHeaders"`
+	JWTSecret                              string              `json:"jwtSecret"`
+	JWTJWKS                                string              `json:"jwtJWKS"`
+	URLSigningKey                          string              `json:"urlSigningKey"`
+	Users                                  map[string]*User    `json:"users"`
+	AuthBanThreshold                       int                 `json:"authBanThreshold"`
+	AuthBanDuration                        StringDuration      `json:"authBanDuration"`
+	MaxConns                               int                 `json:"maxConns"`
+	MaxConnsPerIP                          int                 `json:"maxConnsPerIP"`
+	API                                    bool                `json:"api"`
+	APIAddress                             string              `json:"apiAddress"`
+	APIPersist                             bool                `json:"apiPersist"`
+	APIAllowOrigin                         string              `json:"apiAllowOrigin"`
+	APIUsers                               map[string]*APIUser `json:"apiUsers"`
+	APIEncryption                          bool                `json:"apiEncryption"`
+	APIServerKey                           string              `json:"apiServerKey"`
+	APIServerCert                          string              `json:"apiServerCert"`
+	Metrics                                bool                `json:"metrics"`
+	MetricsAddress                         string              `json:"metricsAddress"`
+	PPROF                                  bool                `json:"pprof"`
+	PPROFAddress                           string              `json:"pprofAddress"`
+	RunOnConnect                           string              `json:"runOnConnect"`
+	RunOnConnectRestart                    bool                `json:"runOnConnectRestart"`
+	WebhookOnConnect                       string              `json:"webhookOnConnect"`
+	WebhookTimeout                         StringDuration      `json:"webhookTimeout"`
+	WebhookAttempts                        int                 `json:"webhookAttempts"`
+	WebhookSecret                          string              `json:"webhookSecret"`
 
 	// RTSP
-	RTSPDisable       bool        `json:"rtspDisable"`
-	Protocols         Protocols   `json:"protocols"`
-	Encryption        Encryption  `json:"encryption"`
-	RTSPAddress       string      `json:"rtspAddress"`
-	RTSPSAddress      string      `json:"rtspsAddress"`
-	RTPAddress        string      `json:"rtpAddress"`
-	RTCPAddress       string      `json:"rtcpAddress"`
-	MulticastIPRange  string      `json:"multicastIPRange"`
-	MulticastRTPPort  int         `json:"multicastRTPPort"`
-	MulticastRTCPPort int         `json:"multicastRTCPPort"`
-	ServerKey         string      `json:"serverKey"`
-	ServerCert        string      `json:"serverCert"`
-	AuthMethods       AuthMethods `json:"authMethods"`
+	RTSPDisable           bool        `json:"rtspDisable"`
+	Protocols             Protocols   `json:"protocols"`
+	Encryption            Encryption  `json:"encryption"`
+	RTSPAddress           string      `json:"rtspAddress"`
+	RTSPSAddress          string      `json:"rtspsAddress"`
+	RTPAddress            string      `json:"rtpAddress"`
+	RTCPAddress           string      `json:"rtcpAddress"`
+	MulticastIPRange      string      `json:"multicastIPRange"`
+	MulticastRTPPort      int         `json:"multicastRTPPort"`
+	MulticastRTCPPort     int         `json:"multicastRTCPPort"`
+	ServerKey             string      `json:"serverKey"`
+	ServerCert            string      `json:"serverCert"`
+	AuthMethods           AuthMethods `json:"authMethods"`
+	RTSPTunnel            bool        `json:"rtspTunnel"`
+	RTSPTunnelAddress     string      `json:"rtspTunnelAddress"`
+	RTSPTunnelAllowOrigin string      `json:"rtspTunnelAllowOrigin"`
 
 	// RTMP
 	RTMPDisable bool   `json:"rtmpDisable"`
@@ -211,9 +237,28 @@ type Conf struct {
 	HLSSegmentDuration StringDuration `json:"hlsSegmentDuration"`
 	HLSSegmentMaxSize  StringSize     `json:"hlsSegmentMaxSize"`
 	HLSAllowOrigin     string         `json:"hlsAllowOrigin"`
+	HLSDVRWindow       StringDuration `json:"hlsDVRWindow"`
+	HLSDVRPath         string         `json:"hlsDVRPath"`
+	DASHEnable         bool           `json:"dashEnable"`
+
+	// recording
+	RecordPath            string         `json:"recordPath"`
+	RecordSegmentDuration StringDuration `json:"recordSegmentDuration"`
+	RecordSegmentMaxSize  StringSize     `json:"recordSegmentMaxSize"`
+	RecordDeleteAfter     StringDuration `json:"recordDeleteAfter"`
+
+	// snapshots
+	SnapshotDecoder string         `json:"snapshotDecoder"`
+	SnapshotTimeout StringDuration `json:"snapshotTimeout"`
+	SnapshotPath    string         `json:"snapshotPath"`
 
 	// paths
-	Paths map[string]*PathConf `json:"paths"`
+	Templates map[string]*PathConf `json:"templates"`
+	Paths     map[string]*PathConf `json:"paths"`
+
+	// paths with templates applied and missing parameters filled.
+	// Paths that don't use a template are shared with Paths.
+	ResolvedPaths map[string]*PathConf `json:"-"`
 
 	LiveWebSocketAddress string `yaml:"liveWebSocketAddress" json:"liveWebSocketAddress"`
 	CameraWebSocketPort  int    `yaml:"cameraWebSocketPort" json:"cameraWebSocketPort"`
@@ -287,6 +332,62 @@ func (conf *Conf) CheckAndFillMissing() error {
 			!strings.HasPrefix(conf.ExternalAuthenticationURL, "https://") {
 			return fmt.Errorf("'externalAuthenticationURL' must be a HTTP URL")
 		}
+
+		if conf.JWTSecret != "" || conf.JWTJWKS != "" {
+			return fmt.Errorf("'externalAuthenticationURL' can't be used with JWT authentication")
+		}
+	}
+
+	for name, user := range conf.Users {
+		if user == nil {
+			return fmt.Errorf("user '%s' is empty", name)
+		}
+
+		if name != "any" && user.Pass == "" {
+			return fmt.Errorf("user '%s' must have a pass", name)
+		}
+	}
+
+	if len(conf.Users) != 0 {
+		if conf.ExternalAuthenticationURL != "" {
+			return fmt.Errorf("'users' can't be used with 'externalAuthenticationURL'")
+		}
+
+		if conf.JWTSecret != "" || conf.JWTJWKS != "" {
+			return fmt.Errorf("'users' can't be used with JWT authentication")
+		}
+	}
+
+	if conf.AuthBanThreshold < 0 {
+		return fmt.Errorf("'authBanThreshold' can't be negative")
+	}
+
+	if conf.AuthBanDuration == 0 {
+		conf.AuthBanDuration = 10 * StringDuration(time.Minute)
+	}
+
+	if conf.MaxConns < 0 {
+		return fmt.Errorf("'maxConns' can't be negative")
+	}
+
+	if conf.MaxConnsPerIP < 0 {
+		return fmt.Errorf("'maxConnsPerIP' can't be negative")
+	}
+
+	if conf.ExternalAuthenticationTimeout == 0 {
+		conf.ExternalAuthenticationTimeout = 5 * StringDuration(time.Second)
+	}
+
+	if conf.WebhookOnConnect != "" && !isHTTPURL(conf.WebhookOnConnect) {
+		return fmt.Errorf("'webhookOnConnect' must be a HTTP URL")
+	}
+
+	if conf.WebhookTimeout == 0 {
+		conf.WebhookTimeout = 10 * StringDuration(time.Second)
+	}
+
+	if conf.WebhookAttempts == 0 {
+		conf.WebhookAttempts = 3
 	}
 
 	if conf.APIAddress == "" {
@@ -327,6 +428,10 @@ func (conf *Conf) CheckAndFillMissing() error {
 		conf.RTSPSAddress = ":8322"
 	}
 
+	if conf.RTSPTunnelAddress == "" {
+		conf.RTSPTunnelAddress = ":8080"
+	}
+
 	if conf.RTPAddress == "" {
 		conf.RTPAddress = ":8000"
 	}
@@ -355,6 +460,20 @@ func (conf *Conf) CheckAndFillMissing() error {
 		conf.ServerCert = "server.crt"
 	}
 
+	for name, user := range conf.APIUsers {
+		if user == nil || (user.Pass == "" && user.Token == "") {
+			return fmt.Errorf("API user '%s' must have a pass or a token", name)
+		}
+	}
+
+	if conf.APIServerKey == "" {
+		conf.APIServerKey = conf.ServerKey
+	}
+
+	if conf.APIServerCert == "" {
+		conf.APIServerCert = conf.ServerCert
+	}
+
 	if len(conf.AuthMethods) == 0 {
 		conf.AuthMethods = AuthMethods{headers.AuthBasic, headers.AuthDigest}
 	}
@@ -383,6 +502,40 @@ func (conf *Conf) CheckAndFillMissing() error {
 		conf.HLSAllowOrigin = "*"
 	}
 
+	if conf.RecordPath == "" {
+		conf.RecordPath = "./recordings"
+	}
+
+	if conf.RecordSegmentDuration == 0 {
+		conf.RecordSegmentDuration = 10 * StringDuration(time.Second)
+	}
+
+	if conf.RecordSegmentMaxSize == 0 {
+		conf.RecordSegmentMaxSize = 50 * 1024 * 1024
+	}
+
+	if conf.SnapshotDecoder == "" {
+		conf.SnapshotDecoder = "ffmpeg -hide_banner -loglevel error -f h264 -i - -frames:v 1 -f mjpeg -"
+	}
+
+	if conf.SnapshotTimeout == 0 {
+		conf.SnapshotTimeout = 10 * StringDuration(time.Second)
+	}
+
+	if conf.SnapshotPath == "" {
+		conf.SnapshotPath = "./snapshots"
+	}
+
+	if conf.Templates == nil {
+		conf.Templates = make(map[string]*PathConf)
+	}
+
+	for name, tconf := range conf.Templates {
+		if tconf == nil {
+			conf.Templates[name] = &PathConf{}
+		}
+	}
+
 	// do not add automatically "all", since user may want to
 	// initialize all paths through API or hot reloading.
 	if conf.Paths == nil {
@@ -395,17 +548,72 @@ func (conf *Conf) CheckAndFillMissing() error {
 		delete(conf.Paths, "all")
 	}
 
+	conf.ResolvedPaths = make(map[string]*PathConf)
+
 	for name, pconf := range conf.Paths {
 		if pconf == nil {
 			conf.Paths[name] = &PathConf{}
 			pconf = conf.Paths[name]
 		}
 
+		// paths that use a template are kept as they are,
+		// in order to allow the template to be edited later.
+		if pconf.Use != "" {
+			tconf, err := conf.resolveTemplate(pconf.Use, nil)
+			if err != nil {
+				return fmt.Errorf("path '%s': %s", name, err)
+			}
+
+			rconf := *pconf
+			rconf.inherit(tconf)
+			pconf = &rconf
+		}
+
 		err := pconf.checkAndFillMissing(conf, name)
 		if err != nil {
 			return err
 		}
+
+		conf.ResolvedPaths[name] = pconf
 	}
 
 	return nil
 }
+
+// Equal checks whether two Confs are equal.
+func (conf *Conf) Equal(other *Conf) bool {
+	a, _ := json.Marshal(conf)
+	b, _ := json.Marshal(other)
+	return string(a) == string(b)
+}
+
+// resolveTemplate returns a template with the templates it uses applied.
+func (conf *Conf) resolveTemplate(name string, visited []string) (*PathConf, error) {
+	for _, v := range visited {
+		if v == name {
+			return nil, fmt.Errorf("template '%s' uses itself", name)
+		}
+	}
+
+	tconf, ok := conf.Templates[name]
+	if !ok {
+		return nil, fmt.Errorf("template '%s' does not exist", name)
+	}
+
+	ret := *tconf
+
+	if ret.Use != "" {
+		parent, err := conf.resolveTemplate(ret.Use, append(visited, name))
+		if err != nil {
+			return nil, err
+		}
+
+		ret.inherit(parent)
+	}
+
+	return &ret, nil
+}
+
+func isHTTPURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
@@ -3,6 +3,7 @@ package conf
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"os"
@@ -94,6 +95,9 @@ func TestConfFromFileAndEnv(t *testing.T) {
 	os.Setenv("RTSP_PROTOCOLS", "tcp")
 	defer os.Unsetenv("RTSP_PROTOCOLS")
 
+	os.Setenv("RTSP_EXTERNALAUTHENTICATIONHEADERS", "Authorization: Bearer abc,X-Key:123")
+	defer os.Unsetenv("RTSP_EXTERNALAUTHENTICATIONHEADERS")
+
 	tmpf, err := writeTempFile([]byte("{}"))
 	require.NoError(t, err)
 	defer os.Remove(tmpf)
@@ -103,6 +107,10 @@ func TestConfFromFileAndEnv(t *testing.T) {
 	require.Equal(t, true, hasFile)
 
 	require.Equal(t, Protocols{Protocol(gortsplib.TransportTCP): {}}, conf.Protocols)
+	require.Equal(t, HTTPHeaders{
+		"Authorization": "Bearer abc",
+		"X-Key":         "123",
+	}, conf.ExternalAuthenticationHeaders)
 
 	pa, ok := conf.Paths["cam1"]
 	require.Equal(t, true, ok)
@@ -171,6 +179,37 @@ func TestConfEncryption(t *testing.T) {
 	require.Equal(t, true, ok)
 }
 
+func TestConfUsers(t *testing.T) {
+	os.Setenv("RTSP_USERS_VIEWER_PASS", "viewerpass")
+	defer os.Unsetenv("RTSP_USERS_VIEWER_PASS")
+
+	os.Setenv("RTSP_USERS_VIEWER_PERMISSIONS", "read:cam*,read:public")
+	defer os.Unsetenv("RTSP_USERS_VIEWER_PERMISSIONS")
+
+	tmpf, err := writeTempFile([]byte("users:\n" +
+		"  admin:\n" +
+		"    pass: $2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy\n" +
+		"    ips: [127.0.0.1]\n" +
+		"    permissions:\n" +
+		"      - action: publish\n" +
+		"        path: cam*\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	conf, _, err := Load(tmpf)
+	require.NoError(t, err)
+
+	require.Equal(t, true, conf.Users["admin"].Pass.IsBcrypt())
+	require.Equal(t, UserPermissions{{Action: "publish", Path: "cam*"}}, conf.Users["admin"].Permissions)
+	require.Equal(t, &User{
+		Pass: "viewerpass",
+		Permissions: UserPermissions{
+			{Action: "read", Path: "cam*"},
+			{Action: "read", Path: "public"},
+		},
+	}, conf.Users["viewer"])
+}
+
 func TestConfErrorNonExistentParameter(t *testing.T) {
 	func() {
 		tmpf, err := writeTempFile([]byte(`invalid: param`))
@@ -192,3 +231,490 @@ func TestConfErrorNonExistentParameter(t *testing.T) {
 		require.EqualError(t, err, "parameter paths, key mypath: non-existent parameter: 'invalid'")
 	}()
 }
+
+func TestIsValidPathName(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		err  string
+	}{
+		{"my/path.name_1~", ""},
+		{"a//x", ""},
+		{"..", "can't contain '.' or '..' elements"},
+		{"a/../../x", "can't contain '.' or '..' elements"},
+		{"a/./x", "can't contain '.' or '..' elements"},
+		{"/a", "can't begin with a slash"},
+	} {
+		err := IsValidPathName(ca.name)
+		if ca.err == "" {
+			require.NoError(t, err, ca.name)
+		} else {
+			require.EqualError(t, err, ca.err, ca.name)
+		}
+	}
+}
+
+func TestConfRegexpGroups(t *testing.T) {
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  '~^cam(\\d+)$':\n" +
+			"    source: rtsp://10.0.0.$G1/stream\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		_, _, err = Load(tmpf)
+		require.EqualError(t, err, "a path with a regular expression (or path 'all') can have a RTSP source"+
+			" only if 'sourceOnDemand' is enabled")
+	}()
+
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  '~^cam(\\d+)$':\n" +
+			"    source: rtsp://10.0.0.$G2/stream\n" +
+			"    sourceOnDemand: yes\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		_, _, err = Load(tmpf)
+		require.EqualError(t, err, "'rtsp://10.0.0.$G2/stream' refers to group 2, "+
+			"that is not present in the regular expression")
+	}()
+
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  '~^(?P<site>[a-z]+)/cam(\\d+)$':\n" +
+			"    source: rtsp://$site.example.com:$G2/stream\n" +
+			"    sourceOnDemand: yes\n" +
+			"    readUser: user$G2\n" +
+			"    readPass: pass\n" +
+
+			"    runOnReady: ffmpeg -i rtsp://localhost:$RTSP_PORT/$RTSP_PATH $site\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		conf, _, err := Load(tmpf)
+		require.NoError(t, err)
+
+		pconf := conf.Paths["~^(?P<site>[a-z]+)/cam(\\d+)$"]
+		require.Equal(t, "rtsp://$site.example.com:$G2/stream", pconf.Source)
+
+		matches := pconf.Regexp.FindStringSubmatch("north/cam554")
+		expanded := pconf.Expand(matches)
+		require.Equal(t, "rtsp://north.example.com:554/stream", expanded.Source)
+		require.Equal(t, Credential("user554"), expanded.ReadUser)
+		require.Equal(t, Credential("pass"), expanded.ReadPass)
+		require.Equal(t, "ffmpeg -i rtsp://localhost:$RTSP_PORT/$RTSP_PATH north", expanded.RunOnReady)
+
+		// the template must not be modified
+		require.Equal(t, "rtsp://$site.example.com:$G2/stream", pconf.Source)
+	}()
+
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  '~^(?P<site>[a-z]+)/cam(\\d+)$':\n" +
+			"    publishUser: $site\n" +
+			"    publishPass: pass$G2\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		conf, _, err := Load(tmpf)
+		require.NoError(t, err)
+
+		pconf := conf.Paths["~^(?P<site>[a-z]+)/cam(\\d+)$"]
+		expanded := pconf.Expand(pconf.Regexp.FindStringSubmatch("north/cam554"))
+		require.Equal(t, Credential("north"), expanded.PublishUser)
+		require.Equal(t, Credential("pass554"), expanded.PublishPass)
+		require.Equal(t, Credential("$site"), pconf.PublishUser)
+	}()
+}
+
+func TestConfTemplates(t *testing.T) {
+	func() {
+		tmpf, err := writeTempFile([]byte("templates:\n" +
+			"  base:\n" +
+			"    readUser: reader\n" +
+			"    readPass: pass\n" +
+			"  camera:\n" +
+			"    use: base\n" +
+			"    sourceProtocol: tcp\n" +
+			"    sourceOnDemand: yes\n" +
+			"    sourceOnDemandCloseAfter: 30s\n" +
+			"paths:\n" +
+			"  cam1:\n" +
+			"    use: camera\n" +
+			"    source: rtsp://10.0.0.1/stream\n" +
+			"    sourceOnDemandCloseAfter: 5s\n" +
+			"  cam2:\n" +
+			"    use: camera\n" +
+			"    source: rtsp://10.0.0.2/stream\n" +
+			"    sourceOnDemand: no\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		os.Setenv("RTSP_PATHS_CAM3_USE", "camera")
+		os.Setenv("RTSP_PATHS_CAM3_SOURCEONDEMAND", "no")
+		defer os.Unsetenv("RTSP_PATHS_CAM3_USE")
+		defer os.Unsetenv("RTSP_PATHS_CAM3_SOURCEONDEMAND")
+
+		conf, _, err := Load(tmpf)
+		require.NoError(t, err)
+
+		// raw path is left untouched
+		pa, ok := conf.Paths["cam1"]
+		require.Equal(t, true, ok)
+		require.Equal(t, &PathConf{
+			setKeys: map[string]struct{}{
+				"use":                      {},
+				"source":                   {},
+				"sourceOnDemandCloseAfter": {},
+			},
+			Use:                      "camera",
+			Source:                   "rtsp://10.0.0.1/stream",
+			SourceOnDemandCloseAfter: 5 * StringDuration(time.Second),
+		}, pa)
+
+		pa, ok = conf.ResolvedPaths["cam1"]
+		require.Equal(t, true, ok)
+		require.Equal(t, "camera", pa.Use)
+		require.Equal(t, "rtsp://10.0.0.1/stream", pa.Source)
+		tcp := gortsplib.TransportTCP
+		require.Equal(t, SourceProtocol{&tcp}, pa.SourceProtocol)
+		require.Equal(t, true, pa.SourceOnDemand)
+		require.Equal(t, 10*StringDuration(time.Second), pa.SourceOnDemandStartTimeout)
+		require.Equal(t, 5*StringDuration(time.Second), pa.SourceOnDemandCloseAfter)
+		require.Equal(t, Credential("reader"), pa.ReadUser)
+		require.Equal(t, Credential("pass"), pa.ReadPass)
+
+		// a path can disable a parameter enabled by the template
+		pa = conf.ResolvedPaths["cam2"]
+		require.Equal(t, false, pa.SourceOnDemand)
+		require.Equal(t, 30*StringDuration(time.Second), pa.SourceOnDemandCloseAfter)
+
+		byts, err := json.Marshal(conf.Paths["cam2"])
+		require.NoError(t, err)
+		require.Equal(t, `{"source":"rtsp://10.0.0.2/stream","sourceOnDemand":false,"use":"camera"}`, string(byts))
+
+		var cloned PathConf
+		err = json.Unmarshal(byts, &cloned)
+		require.NoError(t, err)
+		cloned.inherit(conf.Templates["camera"])
+		require.Equal(t, false, cloned.SourceOnDemand)
+
+		pa = conf.ResolvedPaths["cam3"]
+		require.Equal(t, false, pa.SourceOnDemand)
+		require.Equal(t, SourceProtocol{&tcp}, pa.SourceProtocol)
+	}()
+
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  cam1:\n" +
+			"    use: camera\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		_, _, err = Load(tmpf)
+		require.EqualError(t, err, "path 'cam1': template 'camera' does not exist")
+	}()
+
+	func() {
+		tmpf, err := writeTempFile([]byte("templates:\n" +
+			"  t1:\n" +
+			"    use: t2\n" +
+			"  t2:\n" +
+			"    use: t1\n" +
+			"paths:\n" +
+			"  cam1:\n" +
+			"    use: t1\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		_, _, err = Load(tmpf)
+		require.EqualError(t, err, "path 'cam1': template 't1' uses itself")
+	}()
+}
+
+func TestConfPlaylist(t *testing.T) {
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  channel:\n" +
+			"    source: playlist\n" +
+			"    playlist:\n" +
+			"      - source: file:///videos/intro.mp4\n" +
+			"        duration: 10s\n" +
+			"      - source: cam1\n" +
+			"        start: \"20:30\"\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		conf, _, err := Load(tmpf)
+		require.NoError(t, err)
+
+		pl := conf.Paths["channel"].Playlist
+		require.Equal(t, Playlist{
+			{Source: "file:///videos/intro.mp4", Duration: StringDuration(10 * time.Second)},
+			{Source: "cam1", Start: "20:30"},
+		}, pl)
+		require.Equal(t, true, pl[0].IsFile())
+
+		offset, ok := pl[1].StartOffset()
+		require.Equal(t, true, ok)
+		require.Equal(t, 20*time.Hour+30*time.Minute, offset)
+	}()
+
+	for _, ca := range []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{
+			"empty",
+			"    source: playlist\n",
+			"playlist must be filled",
+		},
+		{
+			"invalid start",
+			"    source: playlist\n" +
+				"    playlist:\n" +
+				"      - source: cam1\n" +
+				"        start: \"25:00\"\n",
+			"playlist item 1: invalid start time: '25:00'",
+		},
+		{
+			"invalid file",
+			"    source: playlist\n" +
+				"    playlist:\n" +
+				"      - source: file:///videos/intro.avi\n",
+			"playlist item 1: 'file:///videos/intro.avi' is not a valid file: " +
+				"only MP4 and MPEG-TS files are supported",
+		},
+		{
+			"itself",
+			"    source: playlist\n" +
+				"    playlist:\n" +
+				"      - source: channel\n",
+			"playlist of path 'channel' can't contain the path itself",
+		},
+		{
+			"wrong source",
+			"    source: publisher\n" +
+				"    playlist:\n" +
+				"      - source: cam1\n",
+			"'playlist' can be used only when source is 'playlist'",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tmpf, err := writeTempFile([]byte("paths:\n" +
+				"  channel:\n" + ca.conf))
+			require.NoError(t, err)
+			defer os.Remove(tmpf)
+
+			_, _, err = Load(tmpf)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestConfSave(t *testing.T) {
+	tmpf, err := writeTempFile([]byte("# general\n" +
+		"readTimeout: 5s # timeout\n" +
+		"\n" +
+		"# paths\n" +
+		"paths:\n" +
+		"  # first camera\n" +
+		"  cam1:\n" +
+		"    source: rtsp://10.0.0.1/stream\n" +
+		"  cam2:\n" +
+		"  all:\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	prev, _, err := Load(tmpf)
+	require.NoError(t, err)
+
+	var cur Conf
+	byts, _ := json.Marshal(prev)
+	err = json.Unmarshal(byts, &cur)
+	require.NoError(t, err)
+
+	cur.ReadTimeout = 3 * StringDuration(time.Second)
+	cur.APIPersist = true
+	cur.Paths["cam1"].SourceOnDemand = true
+	delete(cur.Paths, "cam2")
+	cur.Paths["cam3"] = &PathConf{
+		Source: "rtmp://10.0.0.3/stream",
+	}
+	err = cur.CheckAndFillMissing()
+	require.NoError(t, err)
+
+	err = Save(tmpf, prev, &cur)
+	require.NoError(t, err)
+
+	byts, err = ioutil.ReadFile(tmpf)
+	require.NoError(t, err)
+	require.Equal(t, "# general\n"+
+		"readTimeout: 3s # timeout\n"+
+		"# paths\n"+
+		"paths:\n"+
+		"  # first camera\n"+
+		"  cam1:\n"+
+		"    source: rtsp://10.0.0.1/stream\n"+
+		"    sourceOnDemand: yes\n"+
+		"  all:\n"+
+		"  cam3:\n"+
+		"    source: rtmp://10.0.0.3/stream\n"+
+		"apiPersist: yes\n", string(byts))
+
+	saved, _, err := Load(tmpf)
+	require.NoError(t, err)
+	require.Equal(t, true, saved.Equal(&cur))
+}
+
+func TestConfSaveTemplate(t *testing.T) {
+	tmpf, err := writeTempFile([]byte("templates:\n" +
+		"  camera:\n" +
+		"    source: rtsp://10.0.0.1/stream\n" +
+		"    sourceOnDemand: yes\n" +
+		"paths:\n" +
+		"  cam1:\n" +
+		"    use: camera\n"))
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	prev, _, err := Load(tmpf)
+	require.NoError(t, err)
+
+	var cur Conf
+	byts, _ := json.Marshal(prev)
+	err = json.Unmarshal(byts, &cur)
+	require.NoError(t, err)
+
+	err = json.Unmarshal([]byte(`{"sourceOnDemand":false}`), cur.Paths["cam1"])
+	require.NoError(t, err)
+	err = cur.CheckAndFillMissing()
+	require.NoError(t, err)
+
+	err = Save(tmpf, prev, &cur)
+	require.NoError(t, err)
+
+	byts, err = ioutil.ReadFile(tmpf)
+	require.NoError(t, err)
+	require.Equal(t, "templates:\n"+
+		"  camera:\n"+
+		"    source: rtsp://10.0.0.1/stream\n"+
+		"    sourceOnDemand: yes\n"+
+		"paths:\n"+
+		"  cam1:\n"+
+		"    use: camera\n"+
+		"    sourceOnDemand: no\n", string(byts))
+
+	saved, _, err := Load(tmpf)
+	require.NoError(t, err)
+	require.Equal(t, false, saved.ResolvedPaths["cam1"].SourceOnDemand)
+}
+
+func TestConfSaveEncryption(t *testing.T) {
+	key := "testing123testin"
+
+	os.Setenv("RTSP_CONFKEY", key)
+	defer os.Unsetenv("RTSP_CONFKEY")
+
+	encrypted, err := encrypt(key, []byte("paths:\n  path1:\n"))
+	require.NoError(t, err)
+
+	tmpf, err := writeTempFile(encrypted)
+	require.NoError(t, err)
+	defer os.Remove(tmpf)
+
+	prev, _, err := Load(tmpf)
+	require.NoError(t, err)
+
+	var cur Conf
+	byts, _ := json.Marshal(prev)
+	err = json.Unmarshal(byts, &cur)
+	require.NoError(t, err)
+
+	cur.Paths["path2"] = &PathConf{}
+	err = cur.CheckAndFillMissing()
+	require.NoError(t, err)
+
+	err = Save(tmpf, prev, &cur)
+	require.NoError(t, err)
+
+	byts, err = ioutil.ReadFile(tmpf)
+	require.NoError(t, err)
+
+	byts, err = decrypt(key, byts)
+	require.NoError(t, err)
+	require.Equal(t, "paths:\n  path1:\n  path2:\n", string(byts))
+}
+
+func TestConfTestPattern(t *testing.T) {
+	func() {
+		tmpf, err := writeTempFile([]byte("paths:\n" +
+			"  pattern:\n" +
+			"    source: testpattern\n" +
+			"  pattern2:\n" +
+			"    source: testpattern\n" +
+			"    testPatternResolution: 1280x720\n" +
+			"    testPatternFPS: 30\n" +
+			"    testPatternBitrate: 2000000\n"))
+		require.NoError(t, err)
+		defer os.Remove(tmpf)
+
+		conf, _, err := Load(tmpf)
+		require.NoError(t, err)
+
+		pa := conf.Paths["pattern"]
+		require.Equal(t, Resolution{Width: 640, Height: 360}, pa.TestPatternResolution)
+		require.Equal(t, 25, pa.TestPatternFPS)
+		require.Equal(t, 0, pa.TestPatternBitrate)
+
+		pa = conf.Paths["pattern2"]
+		require.Equal(t, Resolution{Width: 1280, Height: 720}, pa.TestPatternResolution)
+		require.Equal(t, 30, pa.TestPatternFPS)
+		require.Equal(t, 2000000, pa.TestPatternBitrate)
+	}()
+
+	for _, ca := range []struct {
+		name string
+		conf string
+		err  string
+	}{
+		{
+			"invalid resolution",
+			"  pattern:\n" +
+				"    source: testpattern\n" +
+				"    testPatternResolution: 640\n",
+			"json: cannot unmarshal number into Go value of type string",
+		},
+		{
+			"odd resolution",
+			"  pattern:\n" +
+				"    source: testpattern\n" +
+				"    testPatternResolution: 641x360\n",
+			"invalid test pattern: width and height must be even",
+		},
+		{
+			"invalid bitrate",
+			"  pattern:\n" +
+				"    source: testpattern\n" +
+				"    testPatternBitrate: -1\n",
+			"invalid test pattern: invalid bitrate: -1",
+		},
+		{
+			"regexp",
+			"  '~^pattern$':\n" +
+				"    source: testpattern\n",
+			"a path with a regular expression (or path 'all') can have a test pattern source" +
+				" only if 'sourceOnDemand' is enabled",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			tmpf, err := writeTempFile([]byte("paths:\n" + ca.conf))
+			require.NoError(t, err)
+			defer os.Remove(tmpf)
+
+			_, _, err = Load(tmpf)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
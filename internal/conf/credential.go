@@ -9,6 +9,8 @@ import (
 
 var reCredential = regexp.MustCompile(`^[a-zA-Z0-9!\$\(\)\*\+\.;<=>\[\]\^_\-\{\}]+$`)
 
+var reBcrypt = regexp.MustCompile(`^\$2[aby]?\$[0-9]{2}\$[./A-Za-z0-9]{53}$`)
+
 const credentialSupportedChars = "A-Z,0-9,!,$,(,),*,+,.,;,<,=,>,[,],^,_,-,{,}"
 
 // Credential is a parameter that is used as username or password.
@@ -28,6 +30,7 @@ func (d *Credential) UnmarshalJSON(b []byte) error {
 
 	if in != "" &&
 		!strings.HasPrefix(in, "sha256:") &&
+		!reBcrypt.MatchString(in) &&
 		!reCredential.MatchString(in) {
 		return fmt.Errorf("contains unsupported characters (supported are %s)", credentialSupportedChars)
 	}
@@ -36,6 +39,11 @@ func (d *Credential) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// IsBcrypt returns whether the credential is a bcrypt hash.
+func (d Credential) IsBcrypt() bool {
+	return reBcrypt.MatchString(string(d))
+}
+
 func (d *Credential) unmarshalEnv(s string) error {
 	return d.UnmarshalJSON([]byte(`"` + s + `"`))
 }
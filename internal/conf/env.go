@@ -12,6 +12,20 @@ type envUnmarshaler interface {
 	unmarshalEnv(string) error
 }
 
+// envSetTracker is implemented by structs that keep track of the fields that are set.
+type envSetTracker interface {
+	markSetByEnv(key string)
+}
+
+func envHasPrefix(env map[string]string, prefix string) bool {
+	for k := range env {
+		if k == prefix || strings.HasPrefix(k, prefix+"_") {
+			return true
+		}
+	}
+	return false
+}
+
 func loadEnvInternal(env map[string]string, prefix string, rv reflect.Value) error {
 	rt := rv.Type()
 
@@ -115,10 +129,16 @@ func loadEnvInternal(env map[string]string, prefix string, rv reflect.Value) err
 				continue
 			}
 
-			err := loadEnvInternal(env, prefix+"_"+strings.ToUpper(f.Name), rv.Field(i))
+			fieldPrefix := prefix + "_" + strings.ToUpper(f.Name)
+
+			err := loadEnvInternal(env, fieldPrefix, rv.Field(i))
 			if err != nil {
 				return err
 			}
+
+			if t, ok := rv.Addr().Interface().(envSetTracker); ok && envHasPrefix(env, fieldPrefix) {
+				t.markSetByEnv(f.Tag.Get("json"))
+			}
 		}
 		return nil
 	}
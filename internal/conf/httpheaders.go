@@ -0,0 +1,24 @@
+package conf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HTTPHeaders is a parameter that contains HTTP headers.
+type HTTPHeaders map[string]string
+
+func (d *HTTPHeaders) unmarshalEnv(s string) error {
+	*d = make(HTTPHeaders)
+
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, ":", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid header: '%s'", kv)
+		}
+
+		(*d)[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return nil
+}
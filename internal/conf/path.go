@@ -4,15 +4,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aler9/gortsplib/pkg/base"
+
+	"github.com/aler9/rtsp-simple-server/internal/testpattern"
 )
 
 var rePathName = regexp.MustCompile(`^[0-9a-zA-Z_\-/\.~]+$`)
 
+var reRegexpGroupRef = regexp.MustCompile(`\$([a-zA-Z_][a-zA-Z0-9_]*)`)
+
 // IsValidPathName checks if a path name is valid.
 func IsValidPathName(name string) error {
 	if name == "" {
@@ -31,6 +38,13 @@ func IsValidPathName(name string) error {
 		return fmt.Errorf("can contain only alphanumeric characters, underscore, dot, tilde, minus or slash")
 	}
 
+	// path names are used to build file paths
+	for _, part := range strings.Split(name, "/") {
+		if part == "." || part == ".." {
+			return fmt.Errorf("can't contain '.' or '..' elements")
+		}
+	}
+
 	return nil
 }
 
@@ -38,11 +52,23 @@ func IsValidPathName(name string) error {
 type PathConf struct {
 	Regexp *regexp.Regexp `json:"-"`
 
+	// parameters that are set explicitly by paths that use a template,
+	// even with a zero value
+	setKeys map[string]struct{} `json:"-"`
+
+	// template
+	Use string `json:"use"`
+
 	// source
 	Source                     string         `json:"source"`
 	SourceProtocol             SourceProtocol `json:"sourceProtocol"`
 	SourceAnyPortEnable        bool           `json:"sourceAnyPortEnable"`
 	SourceFingerprint          string         `json:"sourceFingerprint"`
+	SourceLoop                 bool           `json:"sourceLoop"`
+	Playlist                   Playlist       `json:"playlist"`
+	TestPatternResolution      Resolution     `json:"testPatternResolution"`
+	TestPatternFPS             int            `json:"testPatternFPS"`
+	TestPatternBitrate         int            `json:"testPatternBitrate"`
 	SourceOnDemand             bool           `json:"sourceOnDemand"`
 	SourceOnDemandStartTimeout StringDuration `json:"sourceOnDemandStartTimeout"`
 	SourceOnDemandCloseAfter   StringDuration `json:"sourceOnDemandCloseAfter"`
@@ -58,6 +84,22 @@ type PathConf struct {
 	ReadPass    Credential `json:"readPass"`
 	ReadIPs     IPsOrNets  `json:"readIPs"`
 
+	// limits
+	MaxReaders    int `json:"maxReaders"`
+	MaxPublishers int `json:"maxPublishers"`
+
+	// health
+	HealthStalledTimeout  StringDuration `json:"healthStalledTimeout"`
+	HealthKeyframeTimeout StringDuration `json:"healthKeyframeTimeout"`
+	HealthBitrateDrop     int            `json:"healthBitrateDrop"`
+	HealthTimestampJump   StringDuration `json:"healthTimestampJump"`
+
+	// recording
+	Record bool `json:"record"`
+
+	// snapshots
+	SnapshotPeriod StringDuration `json:"snapshotPeriod"`
+
 	// external commands
 	RunOnInit               string         `json:"runOnInit"`
 	RunOnInitRestart        bool           `json:"runOnInitRestart"`
@@ -69,6 +111,14 @@ type PathConf struct {
 	RunOnReadyRestart       bool           `json:"runOnReadyRestart"`
 	RunOnRead               string         `json:"runOnRead"`
 	RunOnReadRestart        bool           `json:"runOnReadRestart"`
+	RunOnHealthAlarm        string         `json:"runOnHealthAlarm"`
+	RunOnHealthAlarmRestart bool           `json:"runOnHealthAlarmRestart"`
+
+	// webhooks
+	WebhookOnInit   string `json:"webhookOnInit"`
+	WebhookOnDemand string `json:"webhookOnDemand"`
+	WebhookOnReady  string `json:"webhookOnReady"`
+	WebhookOnRead   string `json:"webhookOnRead"`
 }
 
 func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
@@ -101,21 +151,23 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 
 	case strings.HasPrefix(pconf.Source, "rtsp://") ||
 		strings.HasPrefix(pconf.Source, "rtsps://"):
-		if pconf.Regexp != nil {
-			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a RTSP source; use another path")
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a RTSP source" +
+				" only if 'sourceOnDemand' is enabled")
 		}
 
-		_, err := base.ParseURL(pconf.Source)
+		_, err := base.ParseURL(pconf.expandForValidation(pconf.Source))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.Source)
 		}
 
 	case strings.HasPrefix(pconf.Source, "rtmp://"):
-		if pconf.Regexp != nil {
-			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a RTMP source; use another path")
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a RTMP source" +
+				" only if 'sourceOnDemand' is enabled")
 		}
 
-		u, err := url.Parse(pconf.Source)
+		u, err := url.Parse(pconf.expandForValidation(pconf.Source))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid RTMP URL", pconf.Source)
 		}
@@ -134,11 +186,12 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 
 	case strings.HasPrefix(pconf.Source, "http://") ||
 		strings.HasPrefix(pconf.Source, "https://"):
-		if pconf.Regexp != nil {
-			return fmt.Errorf("a path with a regular expression (or path 'all') cannot have a HLS source; use another path")
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a HLS source" +
+				" only if 'sourceOnDemand' is enabled")
 		}
 
-		u, err := url.Parse(pconf.Source)
+		u, err := url.Parse(pconf.expandForValidation(pconf.Source))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid HLS URL", pconf.Source)
 		}
@@ -155,12 +208,71 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 			}
 		}
 
+	case strings.HasPrefix(pconf.Source, "mjpeg+http://") ||
+		strings.HasPrefix(pconf.Source, "mjpeg+https://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a MJPEG source" +
+				" only if 'sourceOnDemand' is enabled")
+		}
+
+		u, err := url.Parse(pconf.expandForValidation(strings.TrimPrefix(pconf.Source, "mjpeg+")))
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid MJPEG URL", pconf.Source)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("'%s' is not a valid MJPEG URL", pconf.Source)
+		}
+
+		if u.User != nil {
+			pass, _ := u.User.Password()
+			user := u.User.Username()
+			if user != "" && pass == "" ||
+				user == "" && pass != "" {
+				return fmt.Errorf("username and password must be both provided")
+			}
+		}
+
+	case strings.HasPrefix(pconf.Source, "file://"):
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a file source" +
+				" only if 'sourceOnDemand' is enabled")
+		}
+
+		fpath := strings.TrimPrefix(pconf.Source, "file://")
+		switch strings.ToLower(filepath.Ext(fpath)) {
+		case ".mp4", ".m4v", ".mov", ".ts":
+		default:
+			return fmt.Errorf("'%s' is not a valid file source: only MP4 and MPEG-TS files are supported", pconf.Source)
+		}
+
+	case pconf.Source == "playlist":
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a playlist source" +
+				" only if 'sourceOnDemand' is enabled")
+		}
+
+		if len(pconf.Playlist) == 0 {
+			return fmt.Errorf("playlist must be filled")
+		}
+
+		for _, item := range pconf.Playlist {
+			if item.Source == name {
+				return fmt.Errorf("playlist of path '%s' can't contain the path itself", name)
+			}
+		}
+
+	case pconf.Source == "testpattern":
+		if pconf.Regexp != nil && !pconf.SourceOnDemand {
+			return fmt.Errorf("a path with a regular expression (or path 'all') can have a test pattern source" +
+				" only if 'sourceOnDemand' is enabled")
+		}
+
 	case pconf.Source == "redirect":
 		if pconf.SourceRedirect == "" {
 			return fmt.Errorf("source redirect must be filled")
 		}
 
-		_, err := base.ParseURL(pconf.SourceRedirect)
+		_, err := base.ParseURL(pconf.expandForValidation(pconf.SourceRedirect))
 		if err != nil {
 			return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.SourceRedirect)
 		}
@@ -169,12 +281,37 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 		return fmt.Errorf("invalid source: '%s'", pconf.Source)
 	}
 
+	if pconf.Regexp != nil {
+		for _, v := range []string{pconf.Source, pconf.SourceRedirect, pconf.Fallback} {
+			err := pconf.checkRegexpGroupRefs(v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if pconf.SourceOnDemand {
 		if pconf.Source == "publisher" {
 			return fmt.Errorf("'sourceOnDemand' is useless when source is 'publisher'")
 		}
 	}
 
+	if pconf.Source == "testpattern" {
+		if pconf.TestPatternResolution.Width == 0 && pconf.TestPatternResolution.Height == 0 {
+			pconf.TestPatternResolution = Resolution{Width: 640, Height: 360}
+		}
+
+		if pconf.TestPatternFPS == 0 {
+			pconf.TestPatternFPS = 25
+		}
+
+		_, err := testpattern.NewVideo(pconf.TestPatternResolution.Width, pconf.TestPatternResolution.Height,
+			pconf.TestPatternFPS, pconf.TestPatternBitrate)
+		if err != nil {
+			return fmt.Errorf("invalid test pattern: %v", err)
+		}
+	}
+
 	if pconf.SourceOnDemandStartTimeout == 0 {
 		pconf.SourceOnDemandStartTimeout = 10 * StringDuration(time.Second)
 	}
@@ -185,12 +322,12 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 
 	if pconf.Fallback != "" {
 		if strings.HasPrefix(pconf.Fallback, "/") {
-			err := IsValidPathName(pconf.Fallback[1:])
+			err := IsValidPathName(pconf.expandForValidation(pconf.Fallback[1:]))
 			if err != nil {
 				return fmt.Errorf("'%s': %s", pconf.Fallback, err)
 			}
 		} else {
-			_, err := base.ParseURL(pconf.Fallback)
+			_, err := base.ParseURL(pconf.expandForValidation(pconf.Fallback))
 			if err != nil {
 				return fmt.Errorf("'%s' is not a valid RTSP URL", pconf.Fallback)
 			}
@@ -211,6 +348,10 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 		return fmt.Errorf("'publishUser' can't be used with 'externalAuthenticationURL'")
 	}
 
+	if pconf.PublishUser != "" && (conf.JWTSecret != "" || conf.JWTJWKS != "") {
+		return fmt.Errorf("'publishUser' can't be used with JWT authentication")
+	}
+
 	if len(pconf.PublishIPs) > 0 && pconf.Source != "publisher" {
 		return fmt.Errorf("'publishIPs' is useless when source is not 'publisher', since " +
 			"the stream is not provided by a publisher, but by a fixed source")
@@ -225,14 +366,55 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 		return fmt.Errorf("read username and password must be both filled")
 	}
 
+	if pconf.PublishUser.IsBcrypt() || pconf.PublishPass.IsBcrypt() ||
+		pconf.ReadUser.IsBcrypt() || pconf.ReadPass.IsBcrypt() {
+		return fmt.Errorf("bcrypt hashes can be used only in 'users'")
+	}
+
 	if pconf.ReadUser != "" && conf.ExternalAuthenticationURL != "" {
 		return fmt.Errorf("'readUser' can't be used with 'externalAuthenticationURL'")
 	}
 
+	if pconf.ReadUser != "" && (conf.JWTSecret != "" || conf.JWTJWKS != "") {
+		return fmt.Errorf("'readUser' can't be used with JWT authentication")
+	}
+
 	if len(pconf.ReadIPs) > 0 && conf.ExternalAuthenticationURL != "" {
 		return fmt.Errorf("'readIPs' can't be used with 'externalAuthenticationURL'")
 	}
 
+	if pconf.MaxReaders < 0 {
+		return fmt.Errorf("'maxReaders' can't be negative")
+	}
+
+	if pconf.MaxPublishers < 0 {
+		return fmt.Errorf("'maxPublishers' can't be negative")
+	}
+
+	if pconf.MaxPublishers != 0 && pconf.Source != "publisher" {
+		return fmt.Errorf("'maxPublishers' can be used only when source is 'publisher'")
+	}
+
+	if pconf.HealthBitrateDrop < 0 || pconf.HealthBitrateDrop >= 100 {
+		return fmt.Errorf("'healthBitrateDrop' must be between 0 and 99")
+	}
+
+	if len(pconf.Playlist) != 0 && pconf.Source != "playlist" {
+		return fmt.Errorf("'playlist' can be used only when source is 'playlist'")
+	}
+
+	if pconf.SourceLoop && !strings.HasPrefix(pconf.Source, "file://") {
+		return fmt.Errorf("'sourceLoop' can be used only when source is a file")
+	}
+
+	if pconf.Record && pconf.Source == "redirect" {
+		return fmt.Errorf("'record' can't be used when source is 'redirect'")
+	}
+
+	if pconf.SnapshotPeriod != 0 && pconf.Source == "redirect" {
+		return fmt.Errorf("'snapshotPeriod' can't be used when source is 'redirect'")
+	}
+
 	if pconf.RunOnInit != "" && pconf.Regexp != nil {
 		return fmt.Errorf("a path with a regular expression does not support option 'runOnInit'; use another path")
 	}
@@ -241,6 +423,24 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 		return fmt.Errorf("'runOnDemand' can be used only when source is 'publisher'")
 	}
 
+	for _, w := range []struct {
+		name string
+		url  string
+	}{
+		{"webhookOnInit", pconf.WebhookOnInit},
+		{"webhookOnDemand", pconf.WebhookOnDemand},
+		{"webhookOnReady", pconf.WebhookOnReady},
+		{"webhookOnRead", pconf.WebhookOnRead},
+	} {
+		if w.url != "" && !isHTTPURL(w.url) {
+			return fmt.Errorf("'%s' must be a HTTP URL", w.name)
+		}
+	}
+
+	if pconf.WebhookOnDemand != "" && pconf.Source != "publisher" {
+		return fmt.Errorf("'webhookOnDemand' can be used only when source is 'publisher'")
+	}
+
 	if pconf.RunOnDemandStartTimeout == 0 {
 		pconf.RunOnDemandStartTimeout = 10 * StringDuration(time.Second)
 	}
@@ -252,9 +452,229 @@ func (pconf *PathConf) checkAndFillMissing(conf *Conf, name string) error {
 	return nil
 }
 
+// pathConfAlias is a PathConf without its JSON methods.
+type pathConfAlias PathConf
+
+// UnmarshalJSON unmarshals a PathConf from JSON.
+func (pconf *PathConf) UnmarshalJSON(b []byte) error {
+	var m map[string]json.RawMessage
+	err := json.Unmarshal(b, &m)
+	if err != nil {
+		return err
+	}
+
+	// null values leave parameters untouched
+	for k, v := range m {
+		if string(v) == "null" {
+			delete(m, k)
+		}
+	}
+
+	b, err = json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, (*pathConfAlias)(pconf))
+	if err != nil {
+		return err
+	}
+
+	// a zero value must override the one of the template,
+	// therefore keep track of the parameters that are present.
+	if pconf.Use != "" {
+		for k := range m {
+			pconf.markSet(k)
+		}
+	}
+
+	return nil
+}
+
+// MarshalJSON marshals a PathConf into JSON.
+func (pconf PathConf) MarshalJSON() ([]byte, error) {
+	byts, err := json.Marshal(pathConfAlias(pconf))
+	if err != nil || pconf.Use == "" || pconf.setKeys == nil {
+		return byts, err
+	}
+
+	// write only the parameters that are set, the other ones are
+	// taken from the template.
+	var m map[string]json.RawMessage
+	err = json.Unmarshal(byts, &m)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := reflect.ValueOf(pconf)
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		key := rt.Field(i).Tag.Get("json")
+		if key != "" && key != "-" && !pconf.isSet(key, rv.Field(i)) {
+			delete(m, key)
+		}
+	}
+
+	return json.Marshal(m)
+}
+
+// markSet marks a parameter as set.
+func (pconf *PathConf) markSet(key string) {
+	// the map can be shared with copies of the configuration
+	setKeys := make(map[string]struct{}, len(pconf.setKeys)+1)
+	for k := range pconf.setKeys {
+		setKeys[k] = struct{}{}
+	}
+	setKeys[key] = struct{}{}
+	pconf.setKeys = setKeys
+}
+
+func (pconf *PathConf) markSetByEnv(key string) {
+	if pconf.Use != "" {
+		pconf.markSet(key)
+	}
+}
+
+func (pconf *PathConf) isSet(key string, v reflect.Value) bool {
+	if _, ok := pconf.setKeys[key]; ok {
+		return true
+	}
+	return !v.IsZero()
+}
+
+// inherit sets the parameters that are not set with the ones of a template.
+func (pconf *PathConf) inherit(tconf *PathConf) {
+	rv := reflect.ValueOf(pconf).Elem()
+	trv := reflect.ValueOf(tconf).Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rv.NumField(); i++ {
+		f := rt.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if !pconf.isSet(f.Tag.Get("json"), rv.Field(i)) {
+			rv.Field(i).Set(trv.Field(i))
+		}
+	}
+
+	// the resolved configuration is complete
+	pconf.setKeys = nil
+}
+
 // Equal checks whether two PathConfs are equal.
 func (pconf *PathConf) Equal(other *PathConf) bool {
 	a, _ := json.Marshal(pconf)
 	b, _ := json.Marshal(other)
 	return string(a) == string(b)
 }
+
+// EqualIgnoringPlaylist checks whether two PathConfs are equal,
+// without comparing their playlists.
+func (pconf *PathConf) EqualIgnoringPlaylist(other *PathConf) bool {
+	a := *pconf
+	a.Playlist = nil
+	b := *other
+	b.Playlist = nil
+	return a.Equal(&b)
+}
+
+// regexpGroups returns the values of the groups of the regular expression
+// of the path, indexed by position (G1, G2, ...) and by name.
+func (pconf *PathConf) regexpGroups(matches []string) map[string]string {
+	ret := make(map[string]string)
+
+	if len(matches) > 1 {
+		for i, ma := range matches[1:] {
+			ret["G"+strconv.FormatInt(int64(i+1), 10)] = ma
+		}
+
+		for i, name := range pconf.Regexp.SubexpNames() {
+			if name != "" && i < len(matches) {
+				ret[name] = matches[i]
+			}
+		}
+	}
+
+	return ret
+}
+
+func expandRegexpGroups(s string, groups map[string]string) string {
+	return reRegexpGroupRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := groups[ref[1:]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// expandForValidation replaces references to regular expression groups
+// with a placeholder, in order to validate URLs that contain them.
+func (pconf *PathConf) expandForValidation(s string) string {
+	if pconf.Regexp == nil {
+		return s
+	}
+
+	groups := make(map[string]string)
+	for i, name := range pconf.Regexp.SubexpNames()[1:] {
+		groups["G"+strconv.FormatInt(int64(i+1), 10)] = "0"
+		if name != "" {
+			groups[name] = "0"
+		}
+	}
+
+	return expandRegexpGroups(s, groups)
+}
+
+func (pconf *PathConf) checkRegexpGroupRefs(s string) error {
+	for _, m := range reRegexpGroupRef.FindAllStringSubmatch(s, -1) {
+		ref := m[1]
+
+		if ref[0] != 'G' {
+			continue
+		}
+
+		n, err := strconv.ParseUint(ref[1:], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if n == 0 || int(n) > pconf.Regexp.NumSubexp() {
+			return fmt.Errorf("'%s' refers to group %d, that is not present in the regular expression", s, n)
+		}
+	}
+
+	return nil
+}
+
+// Expand returns the configuration of a path whose name matches the
+// regular expression of this configuration. References to groups of the
+// regular expression ($G1, $G2, ..., or $name for named groups) are replaced
+// with the values extracted from the path name.
+func (pconf *PathConf) Expand(matches []string) *PathConf {
+	if pconf.Regexp == nil || len(matches) <= 1 {
+		return pconf
+	}
+
+	groups := pconf.regexpGroups(matches)
+
+	ret := *pconf
+	ret.Source = expandRegexpGroups(ret.Source, groups)
+	ret.SourceRedirect = expandRegexpGroups(ret.SourceRedirect, groups)
+	ret.Fallback = expandRegexpGroups(ret.Fallback, groups)
+	ret.PublishUser = Credential(expandRegexpGroups(string(ret.PublishUser), groups))
+	ret.PublishPass = Credential(expandRegexpGroups(string(ret.PublishPass), groups))
+	ret.ReadUser = Credential(expandRegexpGroups(string(ret.ReadUser), groups))
+	ret.ReadPass = Credential(expandRegexpGroups(string(ret.ReadPass), groups))
+	ret.RunOnInit = expandRegexpGroups(ret.RunOnInit, groups)
+	ret.RunOnDemand = expandRegexpGroups(ret.RunOnDemand, groups)
+	ret.RunOnReady = expandRegexpGroups(ret.RunOnReady, groups)
+	ret.RunOnRead = expandRegexpGroups(ret.RunOnRead, groups)
+	ret.RunOnHealthAlarm = expandRegexpGroups(ret.RunOnHealthAlarm, groups)
+	ret.WebhookOnInit = expandRegexpGroups(ret.WebhookOnInit, groups)
+	ret.WebhookOnDemand = expandRegexpGroups(ret.WebhookOnDemand, groups)
+	ret.WebhookOnReady = expandRegexpGroups(ret.WebhookOnReady, groups)
+	ret.WebhookOnRead = expandRegexpGroups(ret.WebhookOnRead, groups)
+	return &ret
+}
@@ -0,0 +1,126 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PlaylistItem is an item of a playlist.
+type PlaylistItem struct {
+	// a file (file:///path/to/file.mp4) or the name of another path.
+	Source string `json:"source"`
+
+	// how long the item is played. If zero, files are played until their end
+	// and paths are played until they stop being ready.
+	Duration StringDuration `json:"duration"`
+
+	// time of the day (HH:MM or HH:MM:SS) in which the item is started,
+	// regardless of the item being played.
+	Start string `json:"start"`
+}
+
+// IsFile checks whether the item is a file.
+func (i PlaylistItem) IsFile() bool {
+	return strings.HasPrefix(i.Source, "file://")
+}
+
+// StartOffset returns the distance between the start of the item and
+// the beginning of the day, if the item has a start time.
+func (i PlaylistItem) StartOffset() (time.Duration, bool) {
+	if i.Start == "" {
+		return 0, false
+	}
+
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		t, err := time.Parse(layout, i.Start)
+		if err == nil {
+			return time.Duration(t.Hour())*time.Hour +
+				time.Duration(t.Minute())*time.Minute +
+				time.Duration(t.Second())*time.Second, true
+		}
+	}
+
+	return 0, false
+}
+
+func (i PlaylistItem) check() error {
+	switch {
+	case i.Source == "":
+		return fmt.Errorf("source is empty")
+
+	case i.IsFile():
+		switch strings.ToLower(filepath.Ext(strings.TrimPrefix(i.Source, "file://"))) {
+		case ".mp4", ".m4v", ".mov", ".ts":
+		default:
+			return fmt.Errorf("'%s' is not a valid file: only MP4 and MPEG-TS files are supported", i.Source)
+		}
+
+	default:
+		err := IsValidPathName(i.Source)
+		if err != nil {
+			return fmt.Errorf("invalid path name: %s (%s)", err, i.Source)
+		}
+	}
+
+	if i.Duration < 0 {
+		return fmt.Errorf("duration can't be negative")
+	}
+
+	if _, ok := i.StartOffset(); i.Start != "" && !ok {
+		return fmt.Errorf("invalid start time: '%s'", i.Start)
+	}
+
+	return nil
+}
+
+// Playlist is a parameter that contains a playlist.
+type Playlist []PlaylistItem
+
+// UnmarshalJSON unmarshals a Playlist from JSON.
+func (d *Playlist) UnmarshalJSON(b []byte) error {
+	var in []PlaylistItem
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	for i, item := range in {
+		err := item.check()
+		if err != nil {
+			return fmt.Errorf("playlist item %d: %s", i+1, err)
+		}
+	}
+
+	*d = in
+	return nil
+}
+
+func (d *Playlist) unmarshalEnv(s string) error {
+	var in []PlaylistItem
+
+	// items are separated by commas, fields by semicolons:
+	// source;duration;start
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.Split(entry, ";")
+		item := PlaylistItem{Source: parts[0]}
+
+		if len(parts) >= 2 && parts[1] != "" {
+			du, err := time.ParseDuration(parts[1])
+			if err != nil {
+				return err
+			}
+			item.Duration = StringDuration(du)
+		}
+
+		if len(parts) >= 3 {
+			item.Start = parts[2]
+		}
+
+		in = append(in, item)
+	}
+
+	byts, _ := json.Marshal(in)
+	return d.UnmarshalJSON(byts)
+}
@@ -0,0 +1,50 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Resolution is a video resolution, in the format WIDTHxHEIGHT.
+type Resolution struct {
+	Width  int
+	Height int
+}
+
+// MarshalJSON marshals a Resolution into JSON.
+func (r Resolution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strconv.FormatInt(int64(r.Width), 10) + "x" + strconv.FormatInt(int64(r.Height), 10))
+}
+
+// UnmarshalJSON unmarshals a Resolution from JSON.
+func (r *Resolution) UnmarshalJSON(b []byte) error {
+	var in string
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	parts := strings.Split(in, "x")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid resolution: '%s'", in)
+	}
+
+	width, err := strconv.ParseUint(parts[0], 10, 31)
+	if err != nil {
+		return fmt.Errorf("invalid resolution: '%s'", in)
+	}
+
+	height, err := strconv.ParseUint(parts[1], 10, 31)
+	if err != nil {
+		return fmt.Errorf("invalid resolution: '%s'", in)
+	}
+
+	r.Width = int(width)
+	r.Height = int(height)
+	return nil
+}
+
+func (r *Resolution) unmarshalEnv(s string) error {
+	return r.UnmarshalJSON([]byte(`"` + s + `"`))
+}
@@ -0,0 +1,403 @@
+package conf
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"gopkg.in/yaml.v3"
+)
+
+func encrypt(key string, byts []byte) ([]byte, error) {
+	var secretKey [32]byte
+	copy(secretKey[:], key)
+
+	var nonce [24]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := secretbox.Seal(nonce[:], byts, &nonce, &secretKey)
+	return []byte(base64.StdEncoding.EncodeToString(encrypted)), nil
+}
+
+// toGenericMap converts a struct into a generic map, by using its JSON representation.
+func toGenericMap(v interface{}) (map[string]interface{}, error) {
+	byts, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(byts))
+	dec.UseNumber()
+
+	var ret map[string]interface{}
+	err = dec.Decode(&ret)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeNumbers(ret).(map[string]interface{}), nil
+}
+
+// normalizeNumbers converts json.Numbers into integers or floats, that can be encoded into YAML.
+func normalizeNumbers(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, v2 := range x {
+			x[k] = normalizeNumbers(v2)
+		}
+
+	case []interface{}:
+		for i, v2 := range x {
+			x[i] = normalizeNumbers(v2)
+		}
+
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	}
+
+	return v
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func isZeroValue(v interface{}) bool {
+	return v == nil || reflect.ValueOf(v).IsZero() ||
+		((reflect.ValueOf(v).Kind() == reflect.Slice || reflect.ValueOf(v).Kind() == reflect.Map) &&
+			reflect.ValueOf(v).Len() == 0)
+}
+
+func valueNode(v interface{}) (*yaml.Node, error) {
+	n := &yaml.Node{}
+
+	if v == nil {
+		n.Kind = yaml.ScalarNode
+		n.Tag = "!!null"
+		return n, nil
+	}
+
+	err := n.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	// use the same boolean notation of the default configuration file
+	var setBools func(n *yaml.Node)
+	setBools = func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode && n.Tag == "!!bool" {
+			n.Tag = ""
+			if n.Value == "true" {
+				n.Value = "yes"
+			} else {
+				n.Value = "no"
+			}
+		}
+		for _, c := range n.Content {
+			setBools(c)
+		}
+	}
+	setBools(n)
+
+	return n, nil
+}
+
+// findValue returns the index of the value of a key inside a YAML map.
+func findValue(m *yaml.Node, key string) int {
+	for i := 0; i < len(m.Content)-1; i += 2 {
+		if m.Content[i].Value == key {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+func setValue(m *yaml.Node, key string, n *yaml.Node) {
+	i := findValue(m, key)
+	if i >= 0 {
+		// keep comments of the previous value
+		n.HeadComment = m.Content[i].HeadComment
+		n.LineComment = m.Content[i].LineComment
+		n.FootComment = m.Content[i].FootComment
+		m.Content[i] = n
+		return
+	}
+
+	m.Content = append(m.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		n)
+}
+
+func deleteValue(m *yaml.Node, key string) {
+	i := findValue(m, key)
+	if i >= 0 {
+		m.Content = append(m.Content[:i-1], m.Content[i+1:]...)
+	}
+}
+
+// mapValue returns the value of a key inside a YAML map, converting it into a map if needed.
+func mapValue(m *yaml.Node, key string) *yaml.Node {
+	i := findValue(m, key)
+	if i >= 0 && m.Content[i].Kind == yaml.MappingNode {
+		return m.Content[i]
+	}
+
+	n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	setValue(m, key, n)
+	return n
+}
+
+// saveFields writes into a YAML map the fields that changed.
+func saveFields(m *yaml.Node, prev map[string]interface{}, cur map[string]interface{}) error {
+	for _, k := range sortedKeys(cur) {
+		if reflect.DeepEqual(prev[k], cur[k]) {
+			continue
+		}
+
+		// do not add parameters that are not set.
+		// paths that use a template contain only the parameters
+		// that are set, even with a zero value.
+		_, inPrev := prev[k]
+		if isZeroValue(cur[k]) && findValue(m, k) < 0 && inPrev {
+			continue
+		}
+
+		n, err := valueNode(cur[k])
+		if err != nil {
+			return err
+		}
+
+		setValue(m, k, n)
+	}
+
+	return nil
+}
+
+// saveSection writes into the YAML map of the path configurations the paths that changed.
+func saveSection(root *yaml.Node, key string, prev map[string]interface{}, cur map[string]interface{}, conf *Conf) error {
+	changed := false
+	for name := range prev {
+		if !reflect.DeepEqual(prev[name], cur[name]) {
+			changed = true
+		}
+	}
+	for name := range cur {
+		if _, ok := prev[name]; !ok {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	section := mapValue(root, key)
+
+	// "all" is an alias for "~^.*$"
+	fileKey := func(name string) string {
+		if name == "~^.*$" && findValue(section, name) < 0 && findValue(section, "all") >= 0 {
+			return "all"
+		}
+		return name
+	}
+
+	for name := range prev {
+		if _, ok := cur[name]; !ok {
+			deleteValue(section, fileKey(name))
+		}
+	}
+
+	for _, name := range sortedKeys(cur) {
+		curp, _ := cur[name].(map[string]interface{})
+
+		prevp, ok := prev[name].(map[string]interface{})
+		if !ok {
+			// write only parameters that differ from default values.
+			// templates and paths that use them are not filled.
+			pconf := &PathConf{}
+			if key != "templates" && curp["use"] == "" {
+				pconf.checkAndFillMissing(conf, name) //nolint:errcheck
+			}
+			if curp["use"] != "" {
+				prevp = make(map[string]interface{})
+			} else {
+				prevp, _ = toGenericMap(pconf)
+			}
+
+			if reflect.DeepEqual(prevp, curp) {
+				n, _ := valueNode(nil)
+				setValue(section, fileKey(name), n)
+				continue
+			}
+		} else if reflect.DeepEqual(prevp, curp) {
+			continue
+		}
+
+		err := saveFields(mapValue(section, fileKey(name)), prevp, curp)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeFileAtomic(fpath string, byts []byte) error {
+	// replace the target of symbolic links, not the links
+	if target, err := filepath.EvalSymlinks(fpath); err == nil {
+		fpath = target
+	}
+
+	mode := os.FileMode(0o644)
+	if fi, err := os.Stat(fpath); err == nil {
+		mode = fi.Mode()
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(fpath), "."+filepath.Base(fpath)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		defer f.Close()
+
+		err := f.Chmod(mode)
+		if err != nil {
+			return err
+		}
+
+		_, err = f.Write(byts)
+		if err != nil {
+			return err
+		}
+
+		return f.Sync()
+	}()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), fpath)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Save writes into a configuration file the parameters that differ between two configurations.
+// Comments and other parameters of the file are preserved.
+func Save(fpath string, prev *Conf, conf *Conf) error {
+	byts, err := ioutil.ReadFile(fpath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	key, encrypted := os.LookupEnv("RTSP_CONFKEY")
+	if encrypted && len(byts) != 0 {
+		byts, err = decrypt(key, byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	var doc yaml.Node
+	err = yaml.Unmarshal(byts, &doc)
+	if err != nil {
+		return err
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		doc = yaml.Node{
+			Kind:        yaml.DocumentNode,
+			HeadComment: doc.HeadComment,
+			Content:     []*yaml.Node{{}},
+		}
+	}
+
+	root := doc.Content[0]
+	switch {
+	case root.Kind == yaml.MappingNode:
+
+	case root.Kind == 0 || (root.Kind == yaml.ScalarNode && root.Tag == "!!null"):
+		*root = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+
+	default:
+		return fmt.Errorf("configuration file is not a map")
+	}
+
+	prevm, err := toGenericMap(prev)
+	if err != nil {
+		return err
+	}
+
+	curm, err := toGenericMap(conf)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range []string{"templates", "paths"} {
+		prevs, _ := prevm[k].(map[string]interface{})
+		curs, _ := curm[k].(map[string]interface{})
+
+		err := saveSection(root, k, prevs, curs, conf)
+		if err != nil {
+			return err
+		}
+
+		delete(prevm, k)
+		delete(curm, k)
+	}
+
+	err = saveFields(root, prevm, curm)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+
+	err = enc.Encode(&doc)
+	if err != nil {
+		return err
+	}
+
+	err = enc.Close()
+	if err != nil {
+		return err
+	}
+
+	byts = buf.Bytes()
+
+	if encrypted {
+		byts, err = encrypt(key, byts)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(fpath, byts)
+}
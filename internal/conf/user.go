@@ -0,0 +1,56 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// UserPermission grants an action on the paths that match a glob pattern.
+type UserPermission struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+}
+
+// UserPermissions is a parameter that contains the permissions of a user.
+type UserPermissions []UserPermission
+
+// UnmarshalJSON unmarshals a UserPermissions from JSON.
+func (d *UserPermissions) UnmarshalJSON(b []byte) error {
+	var in []UserPermission
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+
+	for _, p := range in {
+		if p.Action != "publish" && p.Action != "read" {
+			return fmt.Errorf("invalid action: '%s'", p.Action)
+		}
+	}
+
+	*d = in
+	return nil
+}
+
+func (d *UserPermissions) unmarshalEnv(s string) error {
+	var in []UserPermission
+
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(entry, ":", 2)
+		p := UserPermission{Action: parts[0]}
+		if len(parts) == 2 {
+			p.Path = parts[1]
+		}
+		in = append(in, p)
+	}
+
+	byts, _ := json.Marshal(in)
+	return d.UnmarshalJSON(byts)
+}
+
+// User is a user that can publish or read paths.
+type User struct {
+	Pass        Credential      `json:"pass"`
+	IPs         IPsOrNets       `json:"ips"`
+	Permissions UserPermissions `json:"permissions"`
+}
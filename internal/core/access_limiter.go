@@ -0,0 +1,370 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type accessLimiterBansListItem struct {
+	IP       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Expires  time.Time `json:"expires"`
+}
+
+type accessLimiterBansListData struct {
+	Items []accessLimiterBansListItem `json:"items"`
+}
+
+type accessLimiterStats struct {
+	conns              int64
+	rejectedBanned     int64
+	rejectedMaxConns   int64
+	rejectedMaxConnsIP int64
+	authFailures       map[string]int64 // by protocol
+	bans               int64
+	rejectedReaders    int64
+	rejectedPublishers int64
+}
+
+type accessLimiterFailures struct {
+	count int
+	first time.Time
+}
+
+type accessLimiterParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// accessLimiter limits the number of concurrent connections and bans
+// IPs that fail authentication too many times.
+type accessLimiter struct {
+	authBanThreshold int
+	authBanDuration  time.Duration
+	maxConns         int
+	maxConnsPerIP    int
+	metrics          *metrics
+	parent           accessLimiterParent
+
+	mutex      sync.Mutex
+	conns      int
+	connsPerIP map[string]int
+	failures   map[string]*accessLimiterFailures
+	bans       map[string]accessLimiterBansListItem
+	stats      accessLimiterStats
+}
+
+func newAccessLimiter(
+	authBanThreshold int,
+	authBanDuration conf.StringDuration,
+	maxConns int,
+	maxConnsPerIP int,
+	metrics *metrics,
+	parent accessLimiterParent,
+) *accessLimiter {
+	l := &accessLimiter{
+		authBanThreshold: authBanThreshold,
+		authBanDuration:  time.Duration(authBanDuration),
+		maxConns:         maxConns,
+		maxConnsPerIP:    maxConnsPerIP,
+		metrics:          metrics,
+		parent:           parent,
+		connsPerIP:       make(map[string]int),
+		failures:         make(map[string]*accessLimiterFailures),
+		bans:             make(map[string]accessLimiterBansListItem),
+		stats: accessLimiterStats{
+			authFailures: make(map[string]int64),
+		},
+	}
+
+	if l.metrics != nil {
+		l.metrics.onAccessLimiterSet(l)
+	}
+
+	return l
+}
+
+func (l *accessLimiter) close() {
+	l.mutex.Lock()
+	m := l.metrics
+	l.mutex.Unlock()
+
+	if m != nil {
+		m.onAccessLimiterSet(nil)
+	}
+}
+
+// onConfReload is called by core.
+func (l *accessLimiter) onConfReload(
+	authBanThreshold int,
+	authBanDuration conf.StringDuration,
+	maxConns int,
+	maxConnsPerIP int,
+) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.authBanThreshold = authBanThreshold
+	l.authBanDuration = time.Duration(authBanDuration)
+	l.maxConns = maxConns
+	l.maxConnsPerIP = maxConnsPerIP
+}
+
+// onMetricsSet is called by core when metrics are created or closed.
+func (l *accessLimiter) onMetricsSet(m *metrics) {
+	l.mutex.Lock()
+	changed := m != l.metrics
+	l.metrics = m
+	l.mutex.Unlock()
+
+	if changed && m != nil {
+		m.onAccessLimiterSet(l)
+	}
+}
+
+func (l *accessLimiter) log(level logger.Level, format string, args ...interface{}) {
+	l.parent.Log(level, "[access limiter] "+format, args...)
+}
+
+// isBannedUnsafe returns whether an IP is banned. Mutex must be locked.
+func (l *accessLimiter) isBannedUnsafe(key string) bool {
+	ban, ok := l.bans[key]
+	if !ok {
+		return false
+	}
+
+	if time.Now().After(ban.Expires) {
+		delete(l.bans, key)
+		return false
+	}
+
+	return true
+}
+
+// isBanned returns whether an IP is banned.
+func (l *accessLimiter) isBanned(ip net.IP) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.isBannedUnsafe(ip.String())
+}
+
+// acquire checks whether a connection from an IP is allowed and, if it is, counts it.
+func (l *accessLimiter) acquire(ip net.IP) error {
+	key := ip.String()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.isBannedUnsafe(key) {
+		atomic.AddInt64(&l.stats.rejectedBanned, 1)
+		return fmt.Errorf("IP is banned")
+	}
+
+	if l.maxConns != 0 && l.conns >= l.maxConns {
+		atomic.AddInt64(&l.stats.rejectedMaxConns, 1)
+		return fmt.Errorf("maximum number of connections reached")
+	}
+
+	if l.maxConnsPerIP != 0 && l.connsPerIP[key] >= l.maxConnsPerIP {
+		atomic.AddInt64(&l.stats.rejectedMaxConnsIP, 1)
+		return fmt.Errorf("maximum number of connections per IP reached")
+	}
+
+	l.conns++
+	l.connsPerIP[key]++
+	atomic.StoreInt64(&l.stats.conns, int64(l.conns))
+
+	return nil
+}
+
+// release removes a connection counted by acquire.
+func (l *accessLimiter) release(ip net.IP) {
+	key := ip.String()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.conns--
+	l.connsPerIP[key]--
+	if l.connsPerIP[key] <= 0 {
+		delete(l.connsPerIP, key)
+	}
+	atomic.StoreInt64(&l.stats.conns, int64(l.conns))
+}
+
+// onAuthFailure is called when an IP fails authentication.
+func (l *accessLimiter) onAuthFailure(ip net.IP, protocol string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.stats.authFailures[protocol]++
+
+	if l.authBanThreshold == 0 {
+		return
+	}
+
+	key := ip.String()
+	now := time.Now()
+
+	// failures older than the ban duration are forgotten
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.first) > l.authBanDuration {
+		f = &accessLimiterFailures{first: now}
+		l.failures[key] = f
+	}
+	f.count++
+
+	if f.count >= l.authBanThreshold {
+		delete(l.failures, key)
+
+		l.bans[key] = accessLimiterBansListItem{
+			IP:       key,
+			Failures: f.count,
+			Expires:  now.Add(l.authBanDuration),
+		}
+
+		l.log(logger.Warn, "IP %s has been banned for %v after %d authentication failures",
+			key, l.authBanDuration, f.count)
+	}
+
+	// remove expired entries
+	for k, f := range l.failures {
+		if now.Sub(f.first) > l.authBanDuration {
+			delete(l.failures, k)
+		}
+	}
+}
+
+// onReaderRejected is called by path when a reader is rejected.
+func (l *accessLimiter) onReaderRejected() {
+	atomic.AddInt64(&l.stats.rejectedReaders, 1)
+}
+
+// onPublisherRejected is called by pathManager when a publisher is rejected.
+func (l *accessLimiter) onPublisherRejected() {
+	atomic.AddInt64(&l.stats.rejectedPublishers, 1)
+}
+
+// bansList returns the IPs that are currently banned.
+func (l *accessLimiter) bansList() accessLimiterBansListData {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	data := accessLimiterBansListData{
+		Items: []accessLimiterBansListItem{},
+	}
+
+	for key, ban := range l.bans {
+		if l.isBannedUnsafe(key) {
+			data.Items = append(data.Items, ban)
+		}
+	}
+
+	sort.Slice(data.Items, func(i, j int) bool {
+		return data.Items[i].Expires.Before(data.Items[j].Expires)
+	})
+
+	return data
+}
+
+// unban removes the ban of an IP.
+func (l *accessLimiter) unban(ip string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if !l.isBannedUnsafe(ip) {
+		return fmt.Errorf("IP is not banned")
+	}
+
+	delete(l.bans, ip)
+	return nil
+}
+
+// statsGet returns a snapshot of the statistics.
+func (l *accessLimiter) statsGet() accessLimiterStats {
+	l.mutex.Lock()
+	bans := int64(0)
+	for key := range l.bans {
+		if l.isBannedUnsafe(key) {
+			bans++
+		}
+	}
+	authFailures := make(map[string]int64)
+	for protocol, v := range l.stats.authFailures {
+		authFailures[protocol] = v
+	}
+	l.mutex.Unlock()
+
+	return accessLimiterStats{
+		conns:              atomic.LoadInt64(&l.stats.conns),
+		rejectedBanned:     atomic.LoadInt64(&l.stats.rejectedBanned),
+		rejectedMaxConns:   atomic.LoadInt64(&l.stats.rejectedMaxConns),
+		rejectedMaxConnsIP: atomic.LoadInt64(&l.stats.rejectedMaxConnsIP),
+		authFailures:       authFailures,
+		bans:               bans,
+		rejectedReaders:    atomic.LoadInt64(&l.stats.rejectedReaders),
+		rejectedPublishers: atomic.LoadInt64(&l.stats.rejectedPublishers),
+	}
+}
+
+// listener wraps a listener in order to apply limits to accepted connections.
+func (l *accessLimiter) listener(ln net.Listener) net.Listener {
+	return &accessLimiterListener{
+		Listener: ln,
+		l:        l,
+	}
+}
+
+type accessLimiterListener struct {
+	net.Listener
+	l *accessLimiter
+}
+
+// Accept implements net.Listener.
+func (ln *accessLimiterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			return conn, nil
+		}
+
+		err = ln.l.acquire(tcpAddr.IP)
+		if err != nil {
+			ln.l.log(logger.Debug, "connection from %v rejected: %s", tcpAddr, err)
+			conn.Close()
+			continue
+		}
+
+		return &accessLimiterConn{
+			Conn: conn,
+			l:    ln.l,
+			ip:   tcpAddr.IP,
+		}, nil
+	}
+}
+
+type accessLimiterConn struct {
+	net.Conn
+	l         *accessLimiter
+	ip        net.IP
+	closeOnce sync.Once
+}
+
+// Close implements net.Conn.
+func (c *accessLimiterConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.l.release(c.ip)
+	})
+	return c.Conn.Close()
+}
@@ -0,0 +1,132 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestAccessLimiterConns(t *testing.T) {
+	l := newAccessLimiter(0, conf.StringDuration(10*time.Minute), 3, 2, nil, nilLogger{})
+	defer l.close()
+
+	ip1 := net.ParseIP("192.168.1.1")
+	ip2 := net.ParseIP("192.168.1.2")
+
+	require.NoError(t, l.acquire(ip1))
+	require.NoError(t, l.acquire(ip1))
+	require.EqualError(t, l.acquire(ip1), "maximum number of connections per IP reached")
+
+	require.NoError(t, l.acquire(ip2))
+	require.EqualError(t, l.acquire(ip2), "maximum number of connections reached")
+
+	l.release(ip1)
+	require.NoError(t, l.acquire(ip2))
+
+	stats := l.statsGet()
+	require.Equal(t, int64(3), stats.conns)
+	require.Equal(t, int64(1), stats.rejectedMaxConns)
+	require.Equal(t, int64(1), stats.rejectedMaxConnsIP)
+}
+
+func TestAccessLimiterConfReload(t *testing.T) {
+	l := newAccessLimiter(2, conf.StringDuration(10*time.Minute), 0, 0, nil, nilLogger{})
+	defer l.close()
+
+	ip := net.ParseIP("192.168.1.1")
+
+	require.NoError(t, l.acquire(ip))
+	l.onAuthFailure(ip, "rtsp")
+	l.onAuthFailure(ip, "rtsp")
+	require.True(t, l.isBanned(ip))
+
+	// bans and connections are preserved
+	l.onConfReload(5, conf.StringDuration(10*time.Minute), 0, 1)
+	require.True(t, l.isBanned(ip))
+	require.NoError(t, l.unban("192.168.1.1"))
+	require.EqualError(t, l.acquire(ip), "maximum number of connections per IP reached")
+
+	l.release(ip)
+	require.NoError(t, l.acquire(ip))
+}
+
+func TestAccessLimiterBans(t *testing.T) {
+	l := newAccessLimiter(3, conf.StringDuration(10*time.Minute), 0, 0, nil, nilLogger{})
+	defer l.close()
+
+	ip := net.ParseIP("192.168.1.1")
+
+	l.onAuthFailure(ip, "rtsp")
+	l.onAuthFailure(ip, "rtsp")
+	require.False(t, l.isBanned(ip))
+	require.NoError(t, l.acquire(ip))
+
+	l.onAuthFailure(ip, "rtsp")
+	require.True(t, l.isBanned(ip))
+	require.EqualError(t, l.acquire(ip), "IP is banned")
+
+	data := l.bansList()
+	require.Equal(t, 1, len(data.Items))
+	require.Equal(t, "192.168.1.1", data.Items[0].IP)
+	require.Equal(t, 3, data.Items[0].Failures)
+
+	require.NoError(t, l.unban("192.168.1.1"))
+	require.EqualError(t, l.unban("192.168.1.1"), "IP is not banned")
+	require.False(t, l.isBanned(ip))
+
+	stats := l.statsGet()
+	require.Equal(t, map[string]int64{"rtsp": 3}, stats.authFailures)
+	require.Equal(t, int64(1), stats.rejectedBanned)
+	require.Equal(t, int64(0), stats.bans)
+}
+
+func TestAccessLimiterListener(t *testing.T) {
+	l := newAccessLimiter(0, conf.StringDuration(10*time.Minute), 1, 0, nil, nilLogger{})
+	defer l.close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln = l.listener(ln)
+	defer ln.Close()
+
+	conn1, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn1.Close()
+
+	sconn1, err := ln.Accept()
+	require.NoError(t, err)
+
+	// the second connection is closed by the listener
+	conn2, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn2.Close()
+
+	acceptDone := make(chan net.Conn)
+	go func() {
+		sconn, err := ln.Accept()
+		if err == nil {
+			acceptDone <- sconn
+		}
+	}()
+
+	conn2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn2.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	// closing the first connection allows new ones
+	sconn1.Close()
+	sconn1.Close()
+	require.Equal(t, int64(0), l.statsGet().conns)
+
+	conn3, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn3.Close()
+
+	sconn3 := <-acceptDone
+	defer sconn3.Close()
+	require.Equal(t, int64(1), l.statsGet().conns)
+}
@@ -3,18 +3,27 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"net/http/httputil"
 	"reflect"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
+const (
+	apiEventsKeepalivePeriod = 30 * time.Second
+	apiEventsWriteTimeout    = 10 * time.Second
+)
+
 func interfaceIsEmpty(i interface{}) bool {
 	return reflect.ValueOf(i).Kind() != reflect.Ptr || reflect.ValueOf(i).IsNil()
 }
@@ -44,36 +53,55 @@ func cloneStruct(dest interface{}, source interface{}) {
 func loadConfData(ctx *gin.Context) (interface{}, error) {
 	var in struct {
 		// general
-		LogLevel                  *conf.LogLevel        `json:"logLevel"`
-		LogDestinations           *conf.LogDestinations `json:"logDestinations"`
-		LogFile                   *string               `json:"logFile"`
-		ReadTimeout               *conf.StringDuration  `json:"readTimeout"`
-		WriteTimeout              *conf.StringDuration  `json:"writeTimeout"`
-		ReadBufferCount           *int                  `json:"readBufferCount"`
-		ExternalAuthenticationURL *string               `json:"externalAuthenticationURL"`
-		API                       *bool                 `json:"api"`
-		APIAddress                *string               `json:"apiAddress"`
-		Metrics                   *bool                 `json:"metrics"`
-		MetricsAddress            *string               `json:"metricsAddress"`
-		PPROF                     *bool                 `json:"pprof"`
-		PPROFAddress              *string               `json:"pprofAddress"`
-		RunOnConnect              *string               `json:"runOnConnect"`
-		RunOnConnectRestart       *bool                 `json:"runOnConnectRestart"`
+		LogLevel                               *conf.LogLevel        `json:"logLevel"`
+		LogDestinations                        *conf.LogDestinations `json:"logDestinations"`
+		LogFile                                *string               `json:"logFile"`
+		ReadTimeout                            *conf.StringDuration  `json:"readTimeout"`
+		WriteTimeout                           *conf.StringDuration  `json:"writeTimeout"`
+		ReadBufferCount                        *int                  `json:"readBufferCount"`
+		ExternalAuthenticationURL              *string               `json:"externalAuthenticationURL"`
+		ExternalAuthenticationTimeout          *conf.StringDuration  `json:"externalAuthenticationTimeout"`
+		ExternalAuthenticationCacheTTL         *conf.StringDuration  `json:"externalAuthenticationCacheTTL"`
+		ExternalAuthenticationNegativeCacheTTL *conf.StringDuration  `json:"externalAuthenticationNegativeCacheTTL"`
+		ExternalAuthenticationHeaders          *conf.HTTPHeaders     `json:"externalAuthenticationHeaders"`
+		JWTSecret                              *string               `json:"jwtSecret"`
+		JWTJWKS                                *string               `json:"jwtJWKS"`
+		URLSigningKey                          *string               `json:"urlSigningKey"`
+		AuthBanThreshold                       *int                  `json:"authBanThreshold"`
+		AuthBanDuration                        *conf.StringDuration  `json:"authBanDuration"`
+		MaxConns                               *int                  `json:"maxConns"`
+		MaxConnsPerIP                          *int                  `json:"maxConnsPerIP"`
+		API                                    *bool                 `json:"api"`
+		APIAddress                             *string               `json:"apiAddress"`
+		APIAllowOrigin                         *string               `json:"apiAllowOrigin"`
+		Metrics                                *bool                 `json:"metrics"`
+		MetricsAddress                         *string               `json:"metricsAddress"`
+		PPROF                                  *bool                 `json:"pprof"`
+		PPROFAddress                           *string               `json:"pprofAddress"`
+		RunOnConnect                           *string               `json:"runOnConnect"`
+		RunOnConnectRestart                    *bool                 `json:"runOnConnectRestart"`
+		WebhookOnConnect                       *string               `json:"webhookOnConnect"`
+		WebhookTimeout                         *conf.StringDuration  `json:"webhookTimeout"`
+		WebhookAttempts                        *int                  `json:"webhookAttempts"`
+		WebhookSecret                          *string               `json:"webhookSecret"`
 
 		// RTSP
-		RTSPDisable       *bool             `json:"rtspDisable"`
-		Protocols         *conf.Protocols   `json:"protocols"`
-		Encryption        *conf.Encryption  `json:"encryption"`
-		RTSPAddress       *string           `json:"rtspAddress"`
-		RTSPSAddress      *string           `json:"rtspsAddress"`
-		RTPAddress        *string           `json:"rtpAddress"`
-		RTCPAddress       *string           `json:"rtcpAddress"`
-		MulticastIPRange  *string           `json:"multicastIPRange"`
-		MulticastRTPPort  *int              `json:"multicastRTPPort"`
-		MulticastRTCPPort *int              `json:"multicastRTCPPort"`
-		ServerKey         *string           `json:"serverKey"`
-		ServerCert        *string           `json:"serverCert"`
-		AuthMethods       *conf.AuthMethods `json:"authMethods"`
+		RTSPDisable           *bool             `json:"rtspDisable"`
+		Protocols             *conf.Protocols   `json:"protocols"`
+		Encryption            *conf.Encryption  `json:"encryption"`
+		RTSPAddress           *string           `json:"rtspAddress"`
+		RTSPSAddress          *string           `json:"rtspsAddress"`
+		RTPAddress            *string           `json:"rtpAddress"`
+		RTCPAddress           *string           `json:"rtcpAddress"`
+		MulticastIPRange      *string           `json:"multicastIPRange"`
+		MulticastRTPPort      *int              `json:"multicastRTPPort"`
+		MulticastRTCPPort     *int              `json:"multicastRTCPPort"`
+		ServerKey             *string           `json:"serverKey"`
+		ServerCert            *string           `json:"serverCert"`
+		AuthMethods           *conf.AuthMethods `json:"authMethods"`
+		RTSPTunnel            *bool             `json:"rtspTunnel"`
+		RTSPTunnelAddress     *string           `json:"rtspTunnelAddress"`
+		RTSPTunnelAllowOrigin *string           `json:"rtspTunnelAllowOrigin"`
 
 		// RTMP
 		RTMPDisable *bool   `json:"rtmpDisable"`
@@ -87,6 +115,12 @@ func loadConfData(ctx *gin.Context) (interface{}, error) {
 		HLSSegmentDuration *conf.StringDuration `json:"hlsSegmentDuration"`
 		HLSSegmentMaxSize  *conf.StringSize     `json:"hlsSegmentMaxSize"`
 		HLSAllowOrigin     *string              `json:"hlsAllowOrigin"`
+		HLSDVRWindow       *conf.StringDuration `json:"hlsDVRWindow"`
+		HLSDVRPath         *string              `json:"hlsDVRPath"`
+		DASHEnable         *bool                `json:"dashEnable"`
+
+		// paths
+		Templates *map[string]*conf.PathConf `json:"templates"`
 	}
 	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
 	if err != nil {
@@ -98,11 +132,19 @@ func loadConfData(ctx *gin.Context) (interface{}, error) {
 
 func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 	var in struct {
+		// template
+		Use *string `json:"use"`
+
 		// source
 		Source                     *string              `json:"source"`
 		SourceProtocol             *conf.SourceProtocol `json:"sourceProtocol"`
 		SourceAnyPortEnable        *bool                `json:"sourceAnyPortEnable"`
 		SourceFingerprint          *string              `json:"sourceFingerprint"`
+		SourceLoop                 *bool                `json:"sourceLoop"`
+		Playlist                   *conf.Playlist       `json:"playlist"`
+		TestPatternResolution      *conf.Resolution     `json:"testPatternResolution"`
+		TestPatternFPS             *int                 `json:"testPatternFPS"`
+		TestPatternBitrate         *int                 `json:"testPatternBitrate"`
 		SourceOnDemand             *bool                `json:"sourceOnDemand"`
 		SourceOnDemandStartTimeout *conf.StringDuration `json:"sourceOnDemandStartTimeout"`
 		SourceOnDemandCloseAfter   *conf.StringDuration `json:"sourceOnDemandCloseAfter"`
@@ -118,6 +160,22 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 		ReadPass    *conf.Credential `json:"readPass"`
 		ReadIPs     *conf.IPsOrNets  `json:"readIPs"`
 
+		// limits
+		MaxReaders    *int `json:"maxReaders"`
+		MaxPublishers *int `json:"maxPublishers"`
+
+		// health
+		HealthStalledTimeout  *conf.StringDuration `json:"healthStalledTimeout"`
+		HealthKeyframeTimeout *conf.StringDuration `json:"healthKeyframeTimeout"`
+		HealthBitrateDrop     *int                 `json:"healthBitrateDrop"`
+		HealthTimestampJump   *conf.StringDuration `json:"healthTimestampJump"`
+
+		// recording
+		Record *bool `json:"record"`
+
+		// snapshots
+		SnapshotPeriod *conf.StringDuration `json:"snapshotPeriod"`
+
 		// external commands
 		RunOnInit               *string              `json:"runOnInit"`
 		RunOnInitRestart        *bool                `json:"runOnInitRestart"`
@@ -129,6 +187,14 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 		RunOnReadyRestart       *bool                `json:"runOnReadyRestart"`
 		RunOnRead               *string              `json:"runOnRead"`
 		RunOnReadRestart        *bool                `json:"runOnReadRestart"`
+		RunOnHealthAlarm        *string              `json:"runOnHealthAlarm"`
+		RunOnHealthAlarmRestart *bool                `json:"runOnHealthAlarmRestart"`
+
+		// webhooks
+		WebhookOnInit   *string `json:"webhookOnInit"`
+		WebhookOnDemand *string `json:"webhookOnDemand"`
+		WebhookOnReady  *string `json:"webhookOnReady"`
+		WebhookOnRead   *string `json:"webhookOnRead"`
 	}
 	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
 	if err != nil {
@@ -140,6 +206,7 @@ func loadConfPathData(ctx *gin.Context) (interface{}, error) {
 
 type apiPathManager interface {
 	onAPIPathsList(req pathAPIPathsListReq) pathAPIPathsListRes
+	onAPISnapshot(req pathAPISnapshotReq) pathAPISnapshotRes
 }
 
 type apiRTSPServer interface {
@@ -162,54 +229,97 @@ type apiParent interface {
 }
 
 type api struct {
-	conf        *conf.Conf
-	pathManager apiPathManager
-	rtspServer  apiRTSPServer
-	rtspsServer apiRTSPServer
-	rtmpServer  apiRTMPServer
-	hlsServer   apiHLSServer
-	parent      apiParent
-
-	mutex sync.Mutex
-	s     *http.Server
+	users         map[string]*conf.APIUser
+	conf          *conf.Conf
+	confPath      string
+	confHistory   *confHistory
+	events        *eventBus
+	webhookSender *webhookSender
+	accessLimiter *accessLimiter
+	pathManager   apiPathManager
+	snapshotter   *snapshotter
+	rtspServer    apiRTSPServer
+	rtspsServer   apiRTSPServer
+	rtmpServer    apiRTMPServer
+	hlsServer     apiHLSServer
+	parent        apiParent
+
+	ctx       context.Context
+	ctxCancel func()
+	mutex     sync.Mutex
+	s         *http.Server
 }
 
 func newAPI(
 	address string,
+	users map[string]*conf.APIUser,
+	encryption bool,
+	serverKey string,
+	serverCert string,
 	conf *conf.Conf,
+	confPath string,
+	confHistory *confHistory,
+	events *eventBus,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
 	pathManager apiPathManager,
+	snapshotter *snapshotter,
 	rtspServer apiRTSPServer,
 	rtspsServer apiRTSPServer,
 	rtmpServer apiRTMPServer,
 	hlsServer apiHLSServer,
 	parent apiParent,
 ) (*api, error) {
-	ln, err := net.Listen("tcp", address)
+	ln, err := apiListen(address, encryption, serverKey, serverCert)
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
 	a := &api{
-		conf:        conf,
-		pathManager: pathManager,
-		rtspServer:  rtspServer,
-		rtspsServer: rtspsServer,
-		rtmpServer:  rtmpServer,
-		hlsServer:   hlsServer,
-		parent:      parent,
+		users:         users,
+		conf:          conf,
+		confPath:      confPath,
+		confHistory:   confHistory,
+		events:        events,
+		webhookSender: webhookSender,
+		accessLimiter: accessLimiter,
+		pathManager:   pathManager,
+		snapshotter:   snapshotter,
+		rtspServer:    rtspServer,
+		rtspsServer:   rtspsServer,
+		rtmpServer:    rtmpServer,
+		hlsServer:     hlsServer,
+		parent:        parent,
+		ctx:           ctx,
+		ctxCancel:     ctxCancel,
 	}
 
 	router := gin.New()
 	router.NoRoute(a.mwLog)
-	group := router.Group("/", a.mwLog)
+	group := router.Group("/", a.mwLog, a.mwAuth)
 
 	group.GET("/v1/config/get", a.onConfigGet)
 	group.POST("/v1/config/set", a.onConfigSet)
 	group.POST("/v1/config/paths/add/*name", a.onConfigPathsAdd)
 	group.POST("/v1/config/paths/edit/*name", a.onConfigPathsEdit)
 	group.POST("/v1/config/paths/remove/*name", a.onConfigPathsDelete)
+	group.GET("/v1/config/revisions/list", a.onConfigRevisionsList)
+	group.POST("/v1/config/rollback/:revision", a.onConfigRollback)
 
 	group.GET("/v1/paths/list", a.onPathsList)
+	group.POST("/v1/paths/sign/*name", a.onPathsSign)
+	group.GET("/v1/paths/snapshot/*name", a.onPathsSnapshot)
+
+	group.GET("/v1/recordings/list", a.onRecordingsList)
+
+	group.GET("/v1/events", a.onEvents)
+
+	group.GET("/v1/webhooks/deliveries/list", a.onWebhooksDeliveriesList)
+
+	group.GET("/v1/bans/list", a.onBansList)
+	group.POST("/v1/bans/remove/:ip", a.onBansRemove)
 
 	if !interfaceIsEmpty(a.rtspServer) {
 		group.GET("/v1/rtspsessions/list", a.onRTSPSessionsList)
@@ -241,6 +351,7 @@ func newAPI(
 
 func (a *api) close() {
 	a.log(logger.Info, "listener is closing")
+	a.ctxCancel() // close event streams
 	a.s.Shutdown(context.Background())
 }
 
@@ -251,8 +362,7 @@ func (a *api) log(level logger.Level, format string, args ...interface{}) {
 func (a *api) mwLog(ctx *gin.Context) {
 	a.log(logger.Info, "[conn %v] %s %s", ctx.Request.RemoteAddr, ctx.Request.Method, ctx.Request.URL.Path)
 
-	byts, _ := httputil.DumpRequest(ctx.Request, true)
-	a.log(logger.Debug, "[conn %v] [c->s] %s", ctx.Request.RemoteAddr, string(byts))
+	a.log(logger.Debug, "[conn %v] [c->s] %s", ctx.Request.RemoteAddr, httpDumpRequest(ctx.Request))
 
 	logw := &httpLogWriter{ResponseWriter: ctx.Writer}
 	ctx.Writer = logw
@@ -264,12 +374,45 @@ func (a *api) mwLog(ctx *gin.Context) {
 	a.log(logger.Debug, "[conn %v] [s->c] %s", ctx.Request.RemoteAddr, logw.dump())
 }
 
+func (a *api) mwAuth(ctx *gin.Context) {
+	if !apiAuthenticate(a.users, ctx.Writer, ctx.Request, false) {
+		ctx.Abort()
+	}
+}
+
 func (a *api) onConfigGet(ctx *gin.Context) {
 	a.mutex.Lock()
 	c := a.conf
 	a.mutex.Unlock()
 
-	ctx.JSON(http.StatusOK, c)
+	// credentials and secrets are never returned
+	var nc conf.Conf
+	cloneStruct(&nc, c)
+	for _, user := range nc.APIUsers {
+		user.Pass = ""
+		user.Token = ""
+	}
+	for _, user := range nc.Users {
+		user.Pass = ""
+	}
+	nc.JWTSecret = ""
+	nc.URLSigningKey = ""
+	nc.WebhookSecret = ""
+	for key := range nc.ExternalAuthenticationHeaders {
+		nc.ExternalAuthenticationHeaders[key] = ""
+	}
+	nc.ResolvedPaths = c.ResolvedPaths
+	c = &nc
+
+	_, revision := a.confHistory.latest()
+
+	// paths are returned both as they are written and
+	// with templates applied.
+	ctx.JSON(http.StatusOK, struct {
+		*conf.Conf
+		ResolvedPaths map[string]*conf.PathConf `json:"resolvedPaths"`
+		Revision      int                       `json:"revision"`
+	}{c, c.ResolvedPaths, revision})
 }
 
 func (a *api) onConfigSet(ctx *gin.Context) {
@@ -292,11 +435,11 @@ func (a *api) onConfigSet(ctx *gin.Context) {
 		return
 	}
 
-	a.conf = &newConf
-
-	// since reloading the configuration can cause the shutdown of the API,
-	// call it in a goroutine
-	go a.parent.onAPIConfigSet(&newConf)
+	err = a.applyConf(&newConf, "api")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
 	ctx.Status(http.StatusOK)
 }
@@ -327,7 +470,9 @@ func (a *api) onConfigPathsAdd(ctx *gin.Context) {
 	}
 
 	newConfPath := &conf.PathConf{}
-	fillStruct(newConfPath, in)
+	// parameters are copied through JSON, in order to keep track of
+	// the ones that are set, even with a zero value.
+	cloneStruct(newConfPath, in)
 
 	newConf.Paths[name] = newConfPath
 
@@ -337,11 +482,11 @@ func (a *api) onConfigPathsAdd(ctx *gin.Context) {
 		return
 	}
 
-	a.conf = &newConf
-
-	// since reloading the configuration can cause the shutdown of the API,
-	// call it in a goroutine
-	go a.parent.onAPIConfigSet(&newConf)
+	err = a.applyConf(&newConf, "api")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
 	ctx.Status(http.StatusOK)
 }
@@ -372,7 +517,9 @@ func (a *api) onConfigPathsEdit(ctx *gin.Context) {
 		return
 	}
 
-	fillStruct(newConfPath, in)
+	// parameters are copied through JSON, in order to keep track of
+	// the ones that are set, even with a zero value.
+	cloneStruct(newConfPath, in)
 
 	err = newConf.CheckAndFillMissing()
 	if err != nil {
@@ -380,11 +527,11 @@ func (a *api) onConfigPathsEdit(ctx *gin.Context) {
 		return
 	}
 
-	a.conf = &newConf
-
-	// since reloading the configuration can cause the shutdown of the API,
-	// call it in a goroutine
-	go a.parent.onAPIConfigSet(&newConf)
+	err = a.applyConf(&newConf, "api")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
 
 	ctx.Status(http.StatusOK)
 }
@@ -416,13 +563,72 @@ func (a *api) onConfigPathsDelete(ctx *gin.Context) {
 		return
 	}
 
-	a.conf = &newConf
+	err = a.applyConf(&newConf, "api")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onConfigRevisionsList(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.confHistory.list())
+}
+
+func (a *api) onConfigRollback(ctx *gin.Context) {
+	revision, err := strconv.ParseUint(ctx.Param("revision"), 10, 31)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	oldConf, ok := a.confHistory.get(int(revision))
+	if !ok {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	var newConf conf.Conf
+	cloneStruct(&newConf, oldConf)
+
+	err = newConf.CheckAndFillMissing()
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	err = a.applyConf(&newConf, "rollback")
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+// applyConf saves a new configuration into the history and into the
+// configuration file, then applies it.
+func (a *api) applyConf(newConf *conf.Conf, origin string) error {
+	if a.conf.APIPersist {
+		err := conf.Save(a.confPath, a.conf, newConf)
+		if err != nil {
+			a.log(logger.Error, "unable to save the configuration: %s", err)
+			return err
+		}
+	}
+
+	a.confHistory.add(newConf, origin)
+	a.conf = newConf
 
 	// since reloading the configuration can cause the shutdown of the API,
 	// call it in a goroutine
-	go a.parent.onAPIConfigSet(&newConf)
+	go a.parent.onAPIConfigSet(newConf)
 
-	ctx.Status(http.StatusOK)
+	return nil
 }
 
 func (a *api) onPathsList(ctx *gin.Context) {
@@ -435,6 +641,152 @@ func (a *api) onPathsList(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, res.data)
 }
 
+type apiRecordingsListItem struct {
+	Spans []recordingSpan `json:"spans"`
+}
+
+type apiRecordingsListData struct {
+	Items map[string]apiRecordingsListItem `json:"items"`
+}
+
+func (a *api) onRecordingsList(ctx *gin.Context) {
+	a.mutex.Lock()
+	recordPath := a.conf.RecordPath
+	a.mutex.Unlock()
+
+	list, err := recordingList(recordPath)
+	if err != nil {
+		a.log(logger.Warn, "unable to list recordings: %v", err)
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	data := apiRecordingsListData{
+		Items: make(map[string]apiRecordingsListItem),
+	}
+
+	for pathName, segs := range list {
+		data.Items[pathName] = apiRecordingsListItem{
+			Spans: recordingSpans(segs),
+		}
+	}
+
+	ctx.JSON(http.StatusOK, data)
+}
+
+func (a *api) onPathsSnapshot(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if len(name) < 2 || name[0] != '/' {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	name = name[1:]
+
+	format := ctx.Query("format")
+	if format != "" && format != "jpeg" && format != "h264" {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	res := a.pathManager.onAPISnapshot(pathAPISnapshotReq{
+		pathName: name,
+	})
+	if res.err != nil {
+		a.log(logger.Debug, "unable to get snapshot of path '%s': %v", name, res.err)
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("Last-Modified", res.frame.time.UTC().Format(http.TimeFormat))
+
+	if format == "h264" {
+		ctx.Data(http.StatusOK, "video/h264", res.frame.annexB)
+		return
+	}
+
+	byts, err := a.snapshotter.jpeg(name, res.frame)
+	if err != nil {
+		a.log(logger.Warn, "unable to decode snapshot of path '%s': %v", name, err)
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.Data(http.StatusOK, "image/jpeg", byts)
+}
+
+type apiSignedURLData struct {
+	Expires time.Time         `json:"expires"`
+	Query   string            `json:"query"`
+	URLs    map[string]string `json:"urls"`
+}
+
+func (a *api) onPathsSign(ctx *gin.Context) {
+	name := ctx.Param("name")
+	if len(name) < 2 || name[0] != '/' {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	name = name[1:]
+
+	var in struct {
+		Duration conf.StringDuration `json:"duration"`
+		IP       string              `json:"ip"`
+	}
+	err := json.NewDecoder(ctx.Request.Body).Decode(&in)
+	if err != nil || in.Duration <= 0 || (in.IP != "" && net.ParseIP(in.IP) == nil) {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	a.mutex.Lock()
+	c := a.conf
+	a.mutex.Unlock()
+
+	if c.URLSigningKey == "" {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(in.Duration)).Truncate(time.Second)
+	query := signedURLQuery(c.URLSigningKey, name, expires, in.IP).Encode()
+
+	host := ctx.Request.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	urlFor := func(scheme string, address string, suffix string) string {
+		_, port, _ := net.SplitHostPort(address)
+		return scheme + "://" + net.JoinHostPort(host, port) + "/" + name + suffix + "?" + query
+	}
+
+	urls := make(map[string]string)
+
+	if !c.RTSPDisable {
+		if c.Encryption == conf.EncryptionNo || c.Encryption == conf.EncryptionOptional {
+			urls["rtsp"] = urlFor("rtsp", c.RTSPAddress, "")
+		}
+		if c.Encryption == conf.EncryptionStrict || c.Encryption == conf.EncryptionOptional {
+			urls["rtsps"] = urlFor("rtsps", c.RTSPSAddress, "")
+		}
+	}
+
+	if !c.RTMPDisable {
+		urls["rtmp"] = urlFor("rtmp", c.RTMPAddress, "")
+	}
+
+	if !c.HLSDisable {
+		urls["hls"] = urlFor("http", c.HLSAddress, "/index.m3u8")
+	}
+
+	ctx.JSON(http.StatusOK, apiSignedURLData{
+		Expires: expires,
+		Query:   query,
+		URLs:    urls,
+	})
+}
+
 func (a *api) onRTSPSessionsList(ctx *gin.Context) {
 	res := a.rtspServer.onAPISessionsList(rtspServerAPISessionsListReq{})
 	if res.err != nil {
@@ -454,6 +806,14 @@ func (a *api) onRTSPSessionsKick(ctx *gin.Context) {
 		return
 	}
 
+	a.events.publish(event{
+		Type: eventSessionKicked,
+		Session: struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}{"rtspSession", id},
+	})
+
 	ctx.Status(http.StatusOK)
 }
 
@@ -476,6 +836,14 @@ func (a *api) onRTSPSSessionsKick(ctx *gin.Context) {
 		return
 	}
 
+	a.events.publish(event{
+		Type: eventSessionKicked,
+		Session: struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}{"rtspsSession", id},
+	})
+
 	ctx.Status(http.StatusOK)
 }
 
@@ -498,6 +866,14 @@ func (a *api) onRTMPConnsKick(ctx *gin.Context) {
 		return
 	}
 
+	a.events.publish(event{
+		Type: eventSessionKicked,
+		Session: struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		}{"rtmpConn", id},
+	})
+
 	ctx.Status(http.StatusOK)
 }
 
@@ -511,6 +887,132 @@ func (a *api) onHLSMuxersList(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, res.data)
 }
 
+func (a *api) onWebhooksDeliveriesList(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.webhookSender.deliveriesList())
+}
+
+func (a *api) onBansList(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, a.accessLimiter.bansList())
+}
+
+func (a *api) onBansRemove(ctx *gin.Context) {
+	err := a.accessLimiter.unban(ctx.Param("ip"))
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.Status(http.StatusOK)
+}
+
+func (a *api) onEvents(ctx *gin.Context) {
+	filter, ok := newEventFilter(ctx.Query("paths"), ctx.Query("types"))
+	if !ok {
+		ctx.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if websocket.IsWebSocketUpgrade(ctx.Request) {
+		a.onEventsWebSocket(ctx, filter)
+		return
+	}
+
+	sub := a.events.subscribe(filter)
+	defer a.events.unsubscribe(sub)
+
+	ctx.Writer.Header().Set("Content-Type", "text/event-stream")
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	ctx.Writer.WriteHeader(http.StatusOK)
+	ctx.Writer.Flush()
+
+	keepalive := time.NewTicker(apiEventsKeepalivePeriod)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case e := <-sub.queue:
+			byts, _ := json.Marshal(e)
+			_, err := fmt.Fprintf(ctx.Writer, "event: %s\ndata: %s\n\n", e.Type, byts)
+			if err != nil {
+				return
+			}
+			ctx.Writer.Flush()
+
+		case <-keepalive.C:
+			_, err := io.WriteString(ctx.Writer, ": keepalive\n\n")
+			if err != nil {
+				return
+			}
+			ctx.Writer.Flush()
+
+		case <-ctx.Request.Context().Done():
+			return
+
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
+func (a *api) onEventsWebSocket(ctx *gin.Context, filter eventFilter) {
+	a.mutex.Lock()
+	allowOrigin := a.conf.APIAllowOrigin
+	a.mutex.Unlock()
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return webSocketOriginAllowed(r, allowOrigin)
+		},
+	}
+
+	conn, err := upgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	sub := a.events.subscribe(filter)
+	defer a.events.unsubscribe(sub)
+
+	// read messages in order to detect when the connection is closed
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	keepalive := time.NewTicker(apiEventsKeepalivePeriod)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case e := <-sub.queue:
+			conn.SetWriteDeadline(time.Now().Add(apiEventsWriteTimeout))
+			err := conn.WriteJSON(e)
+			if err != nil {
+				return
+			}
+
+		case <-keepalive.C:
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(apiEventsWriteTimeout))
+			if err != nil {
+				return
+			}
+
+		case <-readDone:
+			return
+
+		case <-a.ctx.Done():
+			return
+		}
+	}
+}
+
 // onConfReload is called by core.
 func (a *api) onConfReload(conf *conf.Conf) {
 	a.mutex.Lock()
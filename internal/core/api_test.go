@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/aler9/gortsplib"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/require"
 )
 
@@ -55,7 +56,12 @@ func httpRequest(method string, ur string, in interface{}, out interface{}) erro
 }
 
 func TestAPIConfigGet(t *testing.T) {
-	p, ok := newInstance("api: yes\n")
+	p, ok := newInstance("api: yes\n" +
+		"jwtSecret: myjwtsecret\n" +
+		"urlSigningKey: mysigningkey\n" +
+		"webhookSecret: mywebhooksecret\n" +
+		"externalAuthenticationHeaders:\n" +
+		"  Authorization: Bearer mytoken\n")
 	require.Equal(t, true, ok)
 	defer p.close()
 
@@ -63,6 +69,43 @@ func TestAPIConfigGet(t *testing.T) {
 	err := httpRequest(http.MethodGet, "http://localhost:9997/v1/config/get", nil, &out)
 	require.NoError(t, err)
 	require.Equal(t, true, out["api"])
+
+	// secrets are not returned
+	require.Equal(t, "", out["jwtSecret"])
+	require.Equal(t, "", out["urlSigningKey"])
+	require.Equal(t, "", out["webhookSecret"])
+	require.Equal(t, map[string]interface{}{"Authorization": ""}, out["externalAuthenticationHeaders"])
+}
+
+func TestAPIEventsWebSocketOrigin(t *testing.T) {
+	p, ok := newInstance("api: yes\n" +
+		"apiAllowOrigin: http://allowed.example.com\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	for _, ca := range []struct {
+		name   string
+		origin string
+		ok     bool
+	}{
+		{"same host", "http://localhost:9997", true},
+		{"allowed", "http://allowed.example.com", true},
+		{"other", "http://evil.example.com", false},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			conn, res, err := websocket.DefaultDialer.Dial("ws://localhost:9997/v1/events", http.Header{
+				"Origin": []string{ca.origin},
+			})
+
+			if ca.ok {
+				require.NoError(t, err)
+				conn.Close()
+			} else {
+				require.Error(t, err)
+				require.Equal(t, http.StatusForbidden, res.StatusCode)
+			}
+		})
+	}
 }
 
 func TestAPIConfigSet(t *testing.T) {
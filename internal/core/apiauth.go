@@ -0,0 +1,80 @@
+package core
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+// apiListen opens the listener of the API, metrics or pprof.
+func apiListen(address string, encryption bool, serverKey string, serverCert string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if encryption {
+		cert, err := tls.LoadX509KeyPair(serverCert, serverKey)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	return ln, nil
+}
+
+func apiFindUser(users map[string]*conf.APIUser, r *http.Request) *conf.APIUser {
+	if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		token := h[len("Bearer "):]
+
+		for _, user := range users {
+			if user.Token != "" && checkCredential(user.Token, token) {
+				return user
+			}
+		}
+
+		return nil
+	}
+
+	name, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+
+	user, ok := users[name]
+	if !ok || user.Pass == "" || !checkCredential(user.Pass, pass) {
+		return nil
+	}
+
+	return user
+}
+
+// apiAuthenticate checks whether a request can access the API, metrics or pprof.
+// Read-only users can perform only GET requests, unless admin is false.
+// It writes an error into the response and returns false if the request is not allowed.
+func apiAuthenticate(users map[string]*conf.APIUser, w http.ResponseWriter, r *http.Request, admin bool) bool {
+	if len(users) == 0 {
+		return true
+	}
+
+	user := apiFindUser(users, r)
+	if user == nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="rtsp-simple-server"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	if (admin || (r.Method != http.MethodGet && r.Method != http.MethodHead)) &&
+		user.Role != conf.APIRoleAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestAPIAuthenticate(t *testing.T) {
+	users := map[string]*conf.APIUser{
+		"admin": {
+			Pass: "sha256:rl3rgi4NcZkpAEcacZnQ2VuOfJ0FxAqCRaKB/SwdZoQ=", // testuser
+			Role: conf.APIRoleAdmin,
+		},
+		"monitoring": {
+			Token: "mytoken",
+			Role:  conf.APIRoleRead,
+		},
+	}
+
+	for _, ca := range []struct {
+		name   string
+		method string
+		setup  func(r *http.Request)
+		admin  bool
+		status int
+	}{
+		{
+			"no credentials",
+			http.MethodGet,
+			func(r *http.Request) {},
+			false,
+			http.StatusUnauthorized,
+		},
+		{
+			"wrong pass",
+			http.MethodGet,
+			func(r *http.Request) { r.SetBasicAuth("admin", "wrong") },
+			false,
+			http.StatusUnauthorized,
+		},
+		{
+			"admin",
+			http.MethodPost,
+			func(r *http.Request) { r.SetBasicAuth("admin", "testuser") },
+			true,
+			http.StatusOK,
+		},
+		{
+			"read token",
+			http.MethodGet,
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer mytoken") },
+			false,
+			http.StatusOK,
+		},
+		{
+			"read token, write request",
+			http.MethodPost,
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer mytoken") },
+			false,
+			http.StatusForbidden,
+		},
+		{
+			"read token, admin request",
+			http.MethodGet,
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer mytoken") },
+			true,
+			http.StatusForbidden,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			r := httptest.NewRequest(ca.method, "/v1/paths/list", nil)
+			ca.setup(r)
+			w := httptest.NewRecorder()
+
+			ok := apiAuthenticate(users, w, r, ca.admin)
+			require.Equal(t, ca.status == http.StatusOK, ok)
+			require.Equal(t, ca.status, w.Code)
+		})
+	}
+}
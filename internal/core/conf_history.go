@@ -0,0 +1,102 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+const (
+	confHistorySize = 10
+)
+
+type confHistoryEntry struct {
+	Revision int       `json:"revision"`
+	Created  time.Time `json:"created"`
+	Origin   string    `json:"origin"`
+
+	conf *conf.Conf
+}
+
+type confHistoryListData struct {
+	Revision int                `json:"revision"`
+	Items    []confHistoryEntry `json:"items"`
+}
+
+// confHistory keeps the last revisions of the configuration.
+// It is shared between core and api, and survives the recreation of the api.
+type confHistory struct {
+	mutex   sync.Mutex
+	entries []*confHistoryEntry
+}
+
+func newConfHistory(initial *conf.Conf) *confHistory {
+	return &confHistory{
+		entries: []*confHistoryEntry{{
+			Revision: 1,
+			Created:  time.Now(),
+			Origin:   "file",
+			conf:     initial,
+		}},
+	}
+}
+
+// add adds a configuration and returns its revision.
+func (h *confHistory) add(c *conf.Conf, origin string) int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	e := &confHistoryEntry{
+		Revision: h.entries[len(h.entries)-1].Revision + 1,
+		Created:  time.Now(),
+		Origin:   origin,
+		conf:     c,
+	}
+
+	h.entries = append(h.entries, e)
+	if len(h.entries) > confHistorySize {
+		h.entries = h.entries[1:]
+	}
+
+	return e.Revision
+}
+
+// latest returns the last configuration and its revision.
+func (h *confHistory) latest() (*conf.Conf, int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	e := h.entries[len(h.entries)-1]
+	return e.conf, e.Revision
+}
+
+// get returns the configuration with the given revision.
+func (h *confHistory) get(revision int) (*conf.Conf, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, e := range h.entries {
+		if e.Revision == revision {
+			return e.conf, true
+		}
+	}
+
+	return nil, false
+}
+
+func (h *confHistory) list() confHistoryListData {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	data := confHistoryListData{
+		Revision: h.entries[len(h.entries)-1].Revision,
+		Items:    make([]confHistoryEntry, len(h.entries)),
+	}
+
+	for i, e := range h.entries {
+		data.Items[i] = *e
+	}
+
+	return data
+}
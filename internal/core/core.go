@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/signal"
 	"reflect"
+	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/gin-gonic/gin"
@@ -27,10 +28,19 @@ type Core struct {
 	confPath        string
 	conf            *conf.Conf
 	confFound       bool
+	confHistory     *confHistory
+	events          *eventBus
+	latencyTracer   *latencyTracer
 	logger          *logger.Logger
 	externalCmdPool *externalcmd.Pool
+	webhookSender   *webhookSender
+	jwtValidator    *jwtValidator
+	externalAuth    *externalAuthenticator
+	accessLimiter   *accessLimiter
 	metrics         *metrics
 	pprof           *pprof
+	recordCleaner   *recordCleaner
+	snapshotter     *snapshotter
 	pathManager     *pathManager
 	rtspServer      *rtspServer
 	rtspsServer     *rtspServer
@@ -88,6 +98,10 @@ func New(args []string) (*Core, bool) {
 		return nil, false
 	}
 
+	p.confHistory = newConfHistory(p.conf)
+	p.events = newEventBus()
+	p.latencyTracer = newLatencyTracer()
+
 	err = p.createResources(true)
 	if err != nil {
 		if p.logger != nil {
@@ -136,20 +150,34 @@ outer:
 	for {
 		select {
 		case <-confChanged:
-			p.Log(logger.Info, "reloading configuration (file changed)")
-
 			newConf, _, err := conf.Load(p.confPath)
 			if err != nil {
 				p.Log(logger.Error, "%s", err)
 				break outer
 			}
 
+			// the file has been written by the API
+			if latest, _ := p.confHistory.latest(); newConf.Equal(latest) {
+				p.Log(logger.Debug, "configuration file changed, but configuration is the same")
+				continue
+			}
+
+			p.Log(logger.Info, "reloading configuration (file changed)")
+
+			revision := p.confHistory.add(newConf, "file")
+
 			err = p.reloadConf(newConf, false)
 			if err != nil {
 				p.Log(logger.Error, "%s", err)
 				break outer
 			}
 
+			p.events.publish(event{
+				Type:     eventConfigReloaded,
+				Origin:   "file",
+				Revision: revision,
+			})
+
 		case newConf := <-p.apiConfigSet:
 			p.Log(logger.Info, "reloading configuration (API request)")
 
@@ -159,6 +187,13 @@ outer:
 				break outer
 			}
 
+			_, revision := p.confHistory.latest()
+			p.events.publish(event{
+				Type:     eventConfigReloaded,
+				Origin:   "api",
+				Revision: revision,
+			})
+
 		case <-interrupt:
 			p.Log(logger.Info, "shutting down gracefully")
 			break outer
@@ -197,10 +232,25 @@ func (p *Core) createResources(initial bool) error {
 		p.externalCmdPool = externalcmd.NewPool()
 	}
 
+	if p.conf.JWTSecret != "" || p.conf.JWTJWKS != "" {
+		if p.jwtValidator == nil {
+			p.jwtValidator, err = newJWTValidator(
+				p.conf.JWTSecret,
+				p.conf.JWTJWKS)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	if p.conf.Metrics {
 		if p.metrics == nil {
 			p.metrics, err = newMetrics(
 				p.conf.MetricsAddress,
+				p.conf.APIUsers,
+				p.conf.APIEncryption,
+				p.conf.APIServerKey,
+				p.conf.APIServerCert,
 				p)
 			if err != nil {
 				return err
@@ -208,10 +258,51 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	if p.conf.ExternalAuthenticationURL != "" {
+		if p.externalAuth == nil {
+			p.externalAuth = newExternalAuthenticator(
+				p.conf.ExternalAuthenticationURL,
+				p.conf.ExternalAuthenticationTimeout,
+				p.conf.ExternalAuthenticationCacheTTL,
+				p.conf.ExternalAuthenticationNegativeCacheTTL,
+				p.conf.ExternalAuthenticationHeaders,
+				p.metrics)
+		}
+	}
+
+	if p.webhookSender == nil {
+		p.webhookSender = newWebhookSender(
+			time.Duration(p.conf.WebhookTimeout),
+			p.conf.WebhookAttempts,
+			p.conf.WebhookSecret,
+			p.metrics,
+			p)
+	} else {
+		// metrics may have been recreated
+		p.webhookSender.onMetricsSet(p.metrics)
+	}
+
+	if p.accessLimiter == nil {
+		p.accessLimiter = newAccessLimiter(
+			p.conf.AuthBanThreshold,
+			p.conf.AuthBanDuration,
+			p.conf.MaxConns,
+			p.conf.MaxConnsPerIP,
+			p.metrics,
+			p)
+	} else {
+		// metrics may have been recreated
+		p.accessLimiter.onMetricsSet(p.metrics)
+	}
+
 	if p.conf.PPROF {
 		if p.pprof == nil {
 			p.pprof, err = newPPROF(
 				p.conf.PPROFAddress,
+				p.conf.APIUsers,
+				p.conf.APIEncryption,
+				p.conf.APIServerKey,
+				p.conf.APIServerCert,
 				p)
 			if err != nil {
 				return err
@@ -219,6 +310,25 @@ func (p *Core) createResources(initial bool) error {
 		}
 	}
 
+	if p.conf.RecordDeleteAfter != 0 {
+		if p.recordCleaner == nil {
+			p.recordCleaner = newRecordCleaner(
+				p.ctx,
+				p.conf.RecordPath,
+				p.conf.RecordDeleteAfter,
+				p)
+		}
+	}
+
+	if p.snapshotter == nil {
+		p.snapshotter = newSnapshotter(
+			p.ctx,
+			p.conf.SnapshotDecoder,
+			p.conf.SnapshotTimeout,
+			p.conf.SnapshotPath,
+			p)
+	}
+
 	if p.pathManager == nil {
 		p.pathManager = newPathManager(
 			p.ctx,
@@ -226,9 +336,17 @@ func (p *Core) createResources(initial bool) error {
 			p.conf.ReadTimeout,
 			p.conf.WriteTimeout,
 			p.conf.ReadBufferCount,
-			p.conf.Paths,
+			p.conf.RecordPath,
+			p.conf.RecordSegmentDuration,
+			p.conf.RecordSegmentMaxSize,
+			p.snapshotter,
+			p.conf.ResolvedPaths,
 			p.externalCmdPool,
+			p.webhookSender,
+			p.accessLimiter,
 			p.metrics,
+			p.events,
+			p.latencyTracer,
 			p)
 	}
 
@@ -240,12 +358,23 @@ func (p *Core) createResources(initial bool) error {
 			_, useMulticast := p.conf.Protocols[conf.Protocol(gortsplib.TransportUDPMulticast)]
 			p.rtspServer, err = newRTSPServer(
 				p.ctx,
-				p.conf.ExternalAuthenticationURL,
+				p.externalAuth,
+				p.jwtValidator,
+				p.conf.URLSigningKey,
+				p.conf.Users,
 				p.conf.RTSPAddress,
+				func() string {
+					if p.conf.RTSPTunnel {
+						return p.conf.RTSPTunnelAddress
+					}
+					return ""
+				}(),
+				p.conf.RTSPTunnelAllowOrigin,
 				p.conf.AuthMethods,
 				p.conf.ReadTimeout,
 				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
+				p.conf.RecordPath,
 				useUDP,
 				useMulticast,
 				p.conf.RTPAddress,
@@ -260,7 +389,10 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.Protocols,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
+				p.conf.WebhookOnConnect,
 				p.externalCmdPool,
+				p.webhookSender,
+				p.accessLimiter,
 				p.metrics,
 				p.pathManager,
 				p)
@@ -276,12 +408,18 @@ func (p *Core) createResources(initial bool) error {
 		if p.rtspsServer == nil {
 			p.rtspsServer, err = newRTSPServer(
 				p.ctx,
-				p.conf.ExternalAuthenticationURL,
+				p.externalAuth,
+				p.jwtValidator,
+				p.conf.URLSigningKey,
+				p.conf.Users,
 				p.conf.RTSPSAddress,
+				"",
+				"",
 				p.conf.AuthMethods,
 				p.conf.ReadTimeout,
 				p.conf.WriteTimeout,
 				p.conf.ReadBufferCount,
+				p.conf.RecordPath,
 				false,
 				false,
 				"",
@@ -296,7 +434,10 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.Protocols,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
+				p.conf.WebhookOnConnect,
 				p.externalCmdPool,
+				p.webhookSender,
+				p.accessLimiter,
 				p.metrics,
 				p.pathManager,
 				p)
@@ -310,7 +451,10 @@ func (p *Core) createResources(initial bool) error {
 		if p.rtmpServer == nil {
 			p.rtmpServer, err = newRTMPServer(
 				p.ctx,
-				p.conf.ExternalAuthenticationURL,
+				p.externalAuth,
+				p.jwtValidator,
+				p.conf.URLSigningKey,
+				p.conf.Users,
 				p.conf.RTMPAddress,
 				p.conf.ReadTimeout,
 				p.conf.WriteTimeout,
@@ -318,7 +462,10 @@ func (p *Core) createResources(initial bool) error {
 				p.conf.RTSPAddress,
 				p.conf.RunOnConnect,
 				p.conf.RunOnConnectRestart,
+				p.conf.WebhookOnConnect,
 				p.externalCmdPool,
+				p.webhookSender,
+				p.accessLimiter,
 				p.metrics,
 				p.pathManager,
 				p)
@@ -333,13 +480,21 @@ func (p *Core) createResources(initial bool) error {
 			p.hlsServer, err = newHLSServer(
 				p.ctx,
 				p.conf.HLSAddress,
-				p.conf.ExternalAuthenticationURL,
+				p.externalAuth,
+				p.jwtValidator,
+				p.conf.URLSigningKey,
+				p.conf.Users,
+				p.accessLimiter,
 				p.conf.HLSAlwaysRemux,
 				p.conf.HLSSegmentCount,
 				p.conf.HLSSegmentDuration,
 				p.conf.HLSSegmentMaxSize,
 				p.conf.HLSAllowOrigin,
+				p.conf.HLSDVRWindow,
+				p.conf.HLSDVRPath,
+				p.conf.DASHEnable,
 				p.conf.ReadBufferCount,
+				p.conf.RecordPath,
 				p.pathManager,
 				p.metrics,
 				p)
@@ -353,8 +508,18 @@ func (p *Core) createResources(initial bool) error {
 		if p.api == nil {
 			p.api, err = newAPI(
 				p.conf.APIAddress,
+				p.conf.APIUsers,
+				p.conf.APIEncryption,
+				p.conf.APIServerKey,
+				p.conf.APIServerCert,
 				p.conf,
+				p.confPath,
+				p.confHistory,
+				p.events,
+				p.webhookSender,
+				p.accessLimiter,
 				p.pathManager,
+				p.snapshotter,
 				p.rtspServer,
 				p.rtspsServer,
 				p.rtmpServer,
@@ -370,7 +535,7 @@ func (p *Core) createResources(initial bool) error {
 		p.cameraWsServer = RunCameraWebSocketServer(*p.conf, &FFHandler{
 			connect: make(map[string]*ffProcessor),
 			logger:  p.logger,
-		}, p.logger)
+		}, p.logger, p.latencyTracer)
 	}
 
 	if p.cpc2WsClient == nil {
@@ -406,11 +571,27 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		closeLogger = true
 	}
 
+	// API users and certificates are shared with the API,
+	// and are pushed into metrics and pprof without closing them,
+	// since closing metrics closes every other component.
+	apiAuthChanged := newConf != nil &&
+		(!reflect.DeepEqual(newConf.APIUsers, p.conf.APIUsers) ||
+			newConf.APIEncryption != p.conf.APIEncryption ||
+			newConf.APIServerKey != p.conf.APIServerKey ||
+			newConf.APIServerCert != p.conf.APIServerCert)
+
 	closeMetrics := false
 	if newConf == nil ||
 		newConf.Metrics != p.conf.Metrics ||
 		newConf.MetricsAddress != p.conf.MetricsAddress {
 		closeMetrics = true
+	} else if apiAuthChanged && p.metrics != nil {
+		err := p.metrics.onConfReload(newConf.APIUsers, newConf.APIEncryption,
+			newConf.APIServerKey, newConf.APIServerCert)
+		if err != nil {
+			p.Log(logger.Warn, "unable to reload metrics: %v", err)
+			closeMetrics = true
+		}
 	}
 
 	closePPROF := false
@@ -418,6 +599,70 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		newConf.PPROF != p.conf.PPROF ||
 		newConf.PPROFAddress != p.conf.PPROFAddress {
 		closePPROF = true
+	} else if apiAuthChanged && p.pprof != nil {
+		err := p.pprof.onConfReload(newConf.APIUsers, newConf.APIEncryption,
+			newConf.APIServerKey, newConf.APIServerCert)
+		if err != nil {
+			p.Log(logger.Warn, "unable to reload pprof: %v", err)
+			closePPROF = true
+		}
+	}
+
+	closeWebhookSender := false
+	if newConf == nil ||
+		newConf.WebhookTimeout != p.conf.WebhookTimeout ||
+		newConf.WebhookAttempts != p.conf.WebhookAttempts ||
+		newConf.WebhookSecret != p.conf.WebhookSecret {
+		closeWebhookSender = true
+	}
+
+	closeJWTValidator := false
+	if newConf == nil ||
+		newConf.JWTSecret != p.conf.JWTSecret ||
+		newConf.JWTJWKS != p.conf.JWTJWKS {
+		closeJWTValidator = true
+	}
+
+	closeExternalAuth := false
+	if newConf == nil ||
+		newConf.ExternalAuthenticationURL != p.conf.ExternalAuthenticationURL ||
+		newConf.ExternalAuthenticationTimeout != p.conf.ExternalAuthenticationTimeout ||
+		newConf.ExternalAuthenticationCacheTTL != p.conf.ExternalAuthenticationCacheTTL ||
+		newConf.ExternalAuthenticationNegativeCacheTTL != p.conf.ExternalAuthenticationNegativeCacheTTL ||
+		!reflect.DeepEqual(newConf.ExternalAuthenticationHeaders, p.conf.ExternalAuthenticationHeaders) ||
+		closeMetrics {
+		closeExternalAuth = true
+	}
+
+	// limits are updated without closing the access limiter,
+	// in order to preserve bans and connection counts.
+	closeAccessLimiter := false
+	if newConf == nil {
+		closeAccessLimiter = true
+	} else if newConf.AuthBanThreshold != p.conf.AuthBanThreshold ||
+		newConf.AuthBanDuration != p.conf.AuthBanDuration ||
+		newConf.MaxConns != p.conf.MaxConns ||
+		newConf.MaxConnsPerIP != p.conf.MaxConnsPerIP {
+		p.accessLimiter.onConfReload(
+			newConf.AuthBanThreshold,
+			newConf.AuthBanDuration,
+			newConf.MaxConns,
+			newConf.MaxConnsPerIP)
+	}
+
+	closeRecordCleaner := false
+	if newConf == nil ||
+		newConf.RecordPath != p.conf.RecordPath ||
+		newConf.RecordDeleteAfter != p.conf.RecordDeleteAfter {
+		closeRecordCleaner = true
+	}
+
+	closeSnapshotter := false
+	if newConf == nil ||
+		newConf.SnapshotDecoder != p.conf.SnapshotDecoder ||
+		newConf.SnapshotTimeout != p.conf.SnapshotTimeout ||
+		newConf.SnapshotPath != p.conf.SnapshotPath {
+		closeSnapshotter = true
 	}
 
 	closePathManager := false
@@ -426,22 +671,35 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.RecordPath != p.conf.RecordPath ||
+		newConf.RecordSegmentDuration != p.conf.RecordSegmentDuration ||
+		newConf.RecordSegmentMaxSize != p.conf.RecordSegmentMaxSize ||
+		closeSnapshotter ||
+		closeWebhookSender ||
+		closeAccessLimiter ||
 		closeMetrics {
 		closePathManager = true
-	} else if !reflect.DeepEqual(newConf.Paths, p.conf.Paths) {
-		p.pathManager.onConfReload(newConf.Paths)
+	} else if !reflect.DeepEqual(newConf.ResolvedPaths, p.conf.ResolvedPaths) {
+		p.pathManager.onConfReload(newConf.ResolvedPaths)
 	}
 
 	closeRTSPServer := false
 	if newConf == nil ||
 		newConf.RTSPDisable != p.conf.RTSPDisable ||
 		newConf.Encryption != p.conf.Encryption ||
-		newConf.ExternalAuthenticationURL != p.conf.ExternalAuthenticationURL ||
+		closeExternalAuth ||
+		closeJWTValidator ||
+		newConf.URLSigningKey != p.conf.URLSigningKey ||
+		!reflect.DeepEqual(newConf.Users, p.conf.Users) ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
+		newConf.RTSPTunnel != p.conf.RTSPTunnel ||
+		newConf.RTSPTunnelAddress != p.conf.RTSPTunnelAddress ||
+		newConf.RTSPTunnelAllowOrigin != p.conf.RTSPTunnelAllowOrigin ||
 		!reflect.DeepEqual(newConf.AuthMethods, p.conf.AuthMethods) ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.RecordPath != p.conf.RecordPath ||
 		!reflect.DeepEqual(newConf.Protocols, p.conf.Protocols) ||
 		newConf.RTPAddress != p.conf.RTPAddress ||
 		newConf.RTCPAddress != p.conf.RTCPAddress ||
@@ -452,6 +710,9 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		!reflect.DeepEqual(newConf.Protocols, p.conf.Protocols) ||
 		newConf.RunOnConnect != p.conf.RunOnConnect ||
 		newConf.RunOnConnectRestart != p.conf.RunOnConnectRestart ||
+		newConf.WebhookOnConnect != p.conf.WebhookOnConnect ||
+		closeWebhookSender ||
+		closeAccessLimiter ||
 		closeMetrics ||
 		closePathManager {
 		closeRTSPServer = true
@@ -461,18 +722,25 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 	if newConf == nil ||
 		newConf.RTSPDisable != p.conf.RTSPDisable ||
 		newConf.Encryption != p.conf.Encryption ||
-		newConf.ExternalAuthenticationURL != p.conf.ExternalAuthenticationURL ||
+		closeExternalAuth ||
+		closeJWTValidator ||
+		newConf.URLSigningKey != p.conf.URLSigningKey ||
+		!reflect.DeepEqual(newConf.Users, p.conf.Users) ||
 		newConf.RTSPSAddress != p.conf.RTSPSAddress ||
 		!reflect.DeepEqual(newConf.AuthMethods, p.conf.AuthMethods) ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.RecordPath != p.conf.RecordPath ||
 		newConf.ServerCert != p.conf.ServerCert ||
 		newConf.ServerKey != p.conf.ServerKey ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
 		!reflect.DeepEqual(newConf.Protocols, p.conf.Protocols) ||
 		newConf.RunOnConnect != p.conf.RunOnConnect ||
 		newConf.RunOnConnectRestart != p.conf.RunOnConnectRestart ||
+		newConf.WebhookOnConnect != p.conf.WebhookOnConnect ||
+		closeWebhookSender ||
+		closeAccessLimiter ||
 		closeMetrics ||
 		closePathManager {
 		closeRTSPSServer = true
@@ -482,13 +750,19 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 	if newConf == nil ||
 		newConf.RTMPDisable != p.conf.RTMPDisable ||
 		newConf.RTMPAddress != p.conf.RTMPAddress ||
-		newConf.ExternalAuthenticationURL != p.conf.ExternalAuthenticationURL ||
+		closeExternalAuth ||
+		closeJWTValidator ||
+		newConf.URLSigningKey != p.conf.URLSigningKey ||
+		!reflect.DeepEqual(newConf.Users, p.conf.Users) ||
 		newConf.ReadTimeout != p.conf.ReadTimeout ||
 		newConf.WriteTimeout != p.conf.WriteTimeout ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
 		newConf.RTSPAddress != p.conf.RTSPAddress ||
 		newConf.RunOnConnect != p.conf.RunOnConnect ||
 		newConf.RunOnConnectRestart != p.conf.RunOnConnectRestart ||
+		newConf.WebhookOnConnect != p.conf.WebhookOnConnect ||
+		closeWebhookSender ||
+		closeAccessLimiter ||
 		closeMetrics ||
 		closePathManager {
 		closeRTMPServer = true
@@ -498,13 +772,21 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 	if newConf == nil ||
 		newConf.HLSDisable != p.conf.HLSDisable ||
 		newConf.HLSAddress != p.conf.HLSAddress ||
-		newConf.ExternalAuthenticationURL != p.conf.ExternalAuthenticationURL ||
+		closeExternalAuth ||
+		closeJWTValidator ||
+		newConf.URLSigningKey != p.conf.URLSigningKey ||
+		!reflect.DeepEqual(newConf.Users, p.conf.Users) ||
 		newConf.HLSAlwaysRemux != p.conf.HLSAlwaysRemux ||
 		newConf.HLSSegmentCount != p.conf.HLSSegmentCount ||
 		newConf.HLSSegmentDuration != p.conf.HLSSegmentDuration ||
 		newConf.HLSSegmentMaxSize != p.conf.HLSSegmentMaxSize ||
 		newConf.HLSAllowOrigin != p.conf.HLSAllowOrigin ||
+		newConf.HLSDVRWindow != p.conf.HLSDVRWindow ||
+		newConf.HLSDVRPath != p.conf.HLSDVRPath ||
+		newConf.DASHEnable != p.conf.DASHEnable ||
 		newConf.ReadBufferCount != p.conf.ReadBufferCount ||
+		newConf.RecordPath != p.conf.RecordPath ||
+		closeAccessLimiter ||
 		closePathManager ||
 		closeMetrics {
 		closeHLSServer = true
@@ -514,6 +796,13 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 	if newConf == nil ||
 		newConf.API != p.conf.API ||
 		newConf.APIAddress != p.conf.APIAddress ||
+		!reflect.DeepEqual(newConf.APIUsers, p.conf.APIUsers) ||
+		newConf.APIEncryption != p.conf.APIEncryption ||
+		newConf.APIServerKey != p.conf.APIServerKey ||
+		newConf.APIServerCert != p.conf.APIServerCert ||
+		closeWebhookSender ||
+		closeAccessLimiter ||
+		closeSnapshotter ||
 		closePathManager ||
 		closeRTSPServer ||
 		closeRTSPSServer ||
@@ -561,16 +850,45 @@ func (p *Core) closeResources(newConf *conf.Conf, calledByAPI bool) {
 		p.rtmpServer = nil
 	}
 
+	if closeSnapshotter && p.snapshotter != nil {
+		p.snapshotter.close()
+		p.snapshotter = nil
+	}
+
+	if closeRecordCleaner && p.recordCleaner != nil {
+		p.recordCleaner.close()
+		p.recordCleaner = nil
+	}
+
 	if closePPROF && p.pprof != nil {
 		p.pprof.close()
 		p.pprof = nil
 	}
 
+	if closeAccessLimiter && p.accessLimiter != nil {
+		p.accessLimiter.close()
+		p.accessLimiter = nil
+	}
+
+	if closeExternalAuth && p.externalAuth != nil {
+		p.externalAuth.close()
+		p.externalAuth = nil
+	}
+
 	if closeMetrics && p.metrics != nil {
 		p.metrics.close()
 		p.metrics = nil
 	}
 
+	if closeJWTValidator {
+		p.jwtValidator = nil
+	}
+
+	if closeWebhookSender && p.webhookSender != nil {
+		p.webhookSender.close()
+		p.webhookSender = nil
+	}
+
 	if newConf == nil {
 		p.Log(logger.Info, "waiting for external commands")
 		p.externalCmdPool.Close()
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
-	"github.com/aler9/rtsp-simple-server/internal/util"
 	"log"
 	"os/exec"
 	"runtime"
@@ -31,7 +30,7 @@ type respJSON struct {
 }
 
 func (c CPC2Client) OnAnnounce(ctx *gortsplib.ServerHandlerOnAnnounceCtx) {
-	c.logger.Log(logger.Info, "OnAnnounce %s %s", ctx.Path, util.TimeUtil{}.GetTimeStr())
+	c.logger.Log(logger.Info, "OnAnnounce %s", ctx.Path)
 	// skip
 	if c.ws != nil && false {
 		url := ctx.Request.URL
@@ -52,7 +51,7 @@ func (c *CPC2Client) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) {
 		return
 	}
 	c.play = true
-	c.logger.Log(logger.Info, "OnSetup %s %s", ctx.Path, util.TimeUtil{}.GetTimeStr())
+	c.logger.Log(logger.Info, "OnSetup %s", ctx.Path)
 	// test
 	if runtime.GOOS != "windows" {
 		return
@@ -64,7 +63,7 @@ func (c *CPC2Client) OnSetup(ctx *gortsplib.ServerHandlerOnSetupCtx) {
 		go func() {
 			for sc.Scan() {
 				line := sc.Text()
-				c.logger.Log(logger.Info, "ffplay line %s %s", line, util.TimeUtil{}.GetTimeStr())
+				c.logger.Log(logger.Info, "ffplay line %s", line)
 			}
 		}()
 		err := cmd.Start()
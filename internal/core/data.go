@@ -12,4 +12,5 @@ type data struct {
 	ptsEqualsDTS bool
 	h264NALUs    [][]byte
 	h264PTS      time.Duration
+	ingestTime   time.Time
 }
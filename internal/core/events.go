@@ -0,0 +1,165 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventBusSubscriberQueueSize = 256
+)
+
+type eventType string
+
+// supported event types.
+const (
+	eventPathReady             eventType = "pathReady"
+	eventPathNotReady          eventType = "pathNotReady"
+	eventPublisherConnected    eventType = "publisherConnected"
+	eventPublisherDisconnected eventType = "publisherDisconnected"
+	eventPublisherKicked       eventType = "publisherKicked"
+	eventReaderAdded           eventType = "readerAdded"
+	eventReaderRemoved         eventType = "readerRemoved"
+	eventSessionKicked         eventType = "sessionKicked"
+	eventSourceError           eventType = "sourceError"
+	eventConfigReloaded        eventType = "configReloaded"
+)
+
+var eventTypes = []eventType{
+	eventPathReady,
+	eventPathNotReady,
+	eventPublisherConnected,
+	eventPublisherDisconnected,
+	eventPublisherKicked,
+	eventReaderAdded,
+	eventReaderRemoved,
+	eventSessionKicked,
+	eventSourceError,
+	eventConfigReloaded,
+}
+
+type event struct {
+	Type     eventType   `json:"type"`
+	Time     time.Time   `json:"time"`
+	Path     string      `json:"path,omitempty"`
+	Source   interface{} `json:"source,omitempty"`
+	Reader   interface{} `json:"reader,omitempty"`
+	Session  interface{} `json:"session,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Origin   string      `json:"origin,omitempty"`
+	Revision int         `json:"revision,omitempty"`
+}
+
+// eventFilter selects events by path and type.
+// The path filter is applied only to events that refer to a path.
+type eventFilter struct {
+	paths map[string]struct{}
+	types map[eventType]struct{}
+}
+
+func newEventFilter(paths string, types string) (eventFilter, bool) {
+	f := eventFilter{}
+
+	if paths != "" {
+		f.paths = make(map[string]struct{})
+		for _, p := range strings.Split(paths, ",") {
+			f.paths[p] = struct{}{}
+		}
+	}
+
+	if types != "" {
+		f.types = make(map[eventType]struct{})
+		for _, t := range strings.Split(types, ",") {
+			found := false
+			for _, et := range eventTypes {
+				if eventType(t) == et {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return eventFilter{}, false
+			}
+
+			f.types[eventType(t)] = struct{}{}
+		}
+	}
+
+	return f, true
+}
+
+func (f eventFilter) match(e event) bool {
+	if f.paths != nil && e.Path != "" {
+		if _, ok := f.paths[e.Path]; !ok {
+			return false
+		}
+	}
+
+	if f.types != nil {
+		if _, ok := f.types[e.Type]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+type eventSubscriber struct {
+	filter eventFilter
+	queue  chan event
+}
+
+// eventBus dispatches events to subscribers.
+// It is shared between core, paths and api, and survives the recreation of the api.
+type eventBus struct {
+	mutex       sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// publish sends an event to subscribers.
+// Events are discarded when the queue of a subscriber is full, in order not to block the caller.
+func (b *eventBus) publish(e event) {
+	e.Time = time.Now()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for s := range b.subscribers {
+		if !s.filter.match(e) {
+			continue
+		}
+
+		select {
+		case s.queue <- e:
+		default:
+		}
+	}
+}
+
+func (b *eventBus) subscribe(filter eventFilter) *eventSubscriber {
+	s := &eventSubscriber{
+		filter: filter,
+		queue:  make(chan event, eventBusSubscriberQueueSize),
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.subscribers[s] = struct{}{}
+
+	return s
+}
+
+func (b *eventBus) unsubscribe(s *eventSubscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.subscribers, s)
+}
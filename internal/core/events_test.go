@@ -0,0 +1,33 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventBus(t *testing.T) {
+	_, ok := newEventFilter("", "invalid")
+	require.Equal(t, false, ok)
+
+	f, ok := newEventFilter("mypath", "pathReady,configReloaded")
+	require.Equal(t, true, ok)
+
+	b := newEventBus()
+	sub := b.subscribe(f)
+	defer b.unsubscribe(sub)
+
+	b.publish(event{Type: eventPathReady, Path: "otherpath"})
+	b.publish(event{Type: eventReaderAdded, Path: "mypath"})
+	b.publish(event{Type: eventPathReady, Path: "mypath"})
+	b.publish(event{Type: eventConfigReloaded, Origin: "file"})
+
+	e := <-sub.queue
+	require.Equal(t, eventPathReady, e.Type)
+	require.Equal(t, "mypath", e.Path)
+
+	e = <-sub.queue
+	require.Equal(t, eventConfigReloaded, e.Type)
+
+	require.Equal(t, 0, len(sub.queue))
+}
@@ -2,13 +2,114 @@ package core
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 )
 
-func externalAuth(
+const (
+	// maximum size of the response body of the authentication server.
+	externalAuthMaxResponseSize = 64 * 1024
+
+	// maximum number of cached results. When the cache is full, expired
+	// entries are removed and, if there are none, a random entry is evicted.
+	externalAuthCacheMaxSize = 1024
+)
+
+type externalAuthRequest struct {
+	IP       string `json:"ip"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Path     string `json:"path"`
+	Action   string `json:"action"`
+	Query    string `json:"query"`
+}
+
+// externalAuthResponse is the optional body returned by the authentication server.
+type externalAuthResponse struct {
+	Permissions []authPermission `json:"permissions"`
+}
+
+type externalAuthCacheEntry struct {
+	err     error
+	expires time.Time
+}
+
+type externalAuthStats struct {
+	allowed       int64
+	denied        int64
+	errors        int64
+	cacheHits     int64
+	durationCount int64
+	durationSum   int64 // milliseconds
+}
+
+// externalAuthenticator sends authentication requests to an external HTTP server
+// and caches their results.
+type externalAuthenticator struct {
+	ur               string
+	headers          conf.HTTPHeaders
+	cacheTTL         time.Duration
+	negativeCacheTTL time.Duration
+	metrics          *metrics
+
+	client     *http.Client
+	cacheMutex sync.Mutex
+	cache      map[string]externalAuthCacheEntry
+	stats      externalAuthStats
+}
+
+func newExternalAuthenticator(
 	ur string,
+	timeout conf.StringDuration,
+	cacheTTL conf.StringDuration,
+	negativeCacheTTL conf.StringDuration,
+	headers conf.HTTPHeaders,
+	metrics *metrics,
+) *externalAuthenticator {
+	a := &externalAuthenticator{
+		ur:               ur,
+		headers:          headers,
+		cacheTTL:         time.Duration(cacheTTL),
+		negativeCacheTTL: time.Duration(negativeCacheTTL),
+		metrics:          metrics,
+		client: &http.Client{
+			Timeout: time.Duration(timeout),
+		},
+		cache: make(map[string]externalAuthCacheEntry),
+	}
+
+	if a.metrics != nil {
+		a.metrics.onExternalAuthenticatorSet(a)
+	}
+
+	return a
+}
+
+func (a *externalAuthenticator) close() {
+	if a.metrics != nil {
+		a.metrics.onExternalAuthenticatorSet(nil)
+	}
+}
+
+func externalAuthCacheKey(req externalAuthRequest) string {
+	// the password is hashed in order to avoid keeping it in memory
+	h := sha256.Sum256([]byte(req.Password))
+	return req.IP + "\x00" + req.User + "\x00" + hex.EncodeToString(h[:]) + "\x00" +
+		req.Path + "\x00" + req.Action + "\x00" + req.Query
+}
+
+// authenticate checks whether a user is allowed to perform an action on a path.
+func (a *externalAuthenticator) authenticate(
 	ip string,
 	user string,
 	password string,
@@ -16,30 +117,152 @@ func externalAuth(
 	action string,
 	query string,
 ) error {
-	enc, _ := json.Marshal(struct {
-		IP       string `json:"ip"`
-		User     string `json:"user"`
-		Password string `json:"password"`
-		Path     string `json:"path"`
-		Action   string `json:"action"`
-		Query    string `json:"query"`
-	}{
+	req := externalAuthRequest{
 		IP:       ip,
 		User:     user,
 		Password: password,
 		Path:     path,
 		Action:   action,
 		Query:    query,
-	})
-	res, err := http.Post(ur, "application/json", bytes.NewReader(enc))
+	}
+	key := externalAuthCacheKey(req)
+
+	if e, ok := a.cacheGet(key); ok {
+		atomic.AddInt64(&a.stats.cacheHits, 1)
+		return e.err
+	}
+
+	start := time.Now()
+	definitive, err := a.do(req)
+	atomic.AddInt64(&a.stats.durationCount, 1)
+	atomic.AddInt64(&a.stats.durationSum, int64(time.Since(start)/time.Millisecond))
+
+	switch {
+	case !definitive:
+		// errors of the authentication server are not cached
+		atomic.AddInt64(&a.stats.errors, 1)
+
+	case err == nil:
+		atomic.AddInt64(&a.stats.allowed, 1)
+		a.cacheSet(key, nil, a.cacheTTL)
+
+	default:
+		atomic.AddInt64(&a.stats.denied, 1)
+
+		// failures of requests with a query are not cached, since
+		// the query can be changed at will in order to fill the cache.
+		if query == "" {
+			a.cacheSet(key, err, a.negativeCacheTTL)
+		}
+	}
+
+	return err
+}
+
+// do performs a request. It returns whether the result was decided
+// by the authentication server, and the result.
+func (a *externalAuthenticator) do(req externalAuthRequest) (bool, error) {
+	enc, _ := json.Marshal(req)
+
+	hreq, err := http.NewRequest(http.MethodPost, a.ur, bytes.NewReader(enc))
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	hreq.Header.Set("Content-Type", "application/json")
+	for k, v := range a.headers {
+		hreq.Header.Set(k, v)
+	}
+
+	res, err := a.client.Do(hreq)
+	if err != nil {
+		return false, err
 	}
 	defer res.Body.Close()
 
+	if res.StatusCode >= 500 {
+		return false, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
 	if res.StatusCode < 200 || res.StatusCode > 299 {
-		return fmt.Errorf("bad status code: %d", res.StatusCode)
+		return true, fmt.Errorf("bad status code: %d", res.StatusCode)
 	}
 
-	return nil
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, externalAuthMaxResponseSize))
+	if err != nil {
+		return false, err
+	}
+
+	// the server can optionally return the permissions of the user
+	if len(bytes.TrimSpace(body)) != 0 {
+		var ares externalAuthResponse
+		err := json.Unmarshal(body, &ares)
+		if err == nil && ares.Permissions != nil &&
+			!authPermissionsAllow(ares.Permissions, req.Path, req.Action) {
+			return true, fmt.Errorf("user is not allowed to %s path '%s'", req.Action, req.Path)
+		}
+	}
+
+	return true, nil
+}
+
+func (a *externalAuthenticator) cacheGet(key string) (externalAuthCacheEntry, bool) {
+	a.cacheMutex.Lock()
+	defer a.cacheMutex.Unlock()
+
+	e, ok := a.cache[key]
+	if !ok {
+		return externalAuthCacheEntry{}, false
+	}
+
+	if time.Now().After(e.expires) {
+		delete(a.cache, key)
+		return externalAuthCacheEntry{}, false
+	}
+
+	return e, true
+}
+
+func (a *externalAuthenticator) cacheSet(key string, err error, ttl time.Duration) {
+	if ttl == 0 {
+		return
+	}
+
+	a.cacheMutex.Lock()
+	defer a.cacheMutex.Unlock()
+
+	now := time.Now()
+
+	if _, ok := a.cache[key]; !ok && len(a.cache) >= externalAuthCacheMaxSize {
+		for k, e := range a.cache {
+			if now.After(e.expires) {
+				delete(a.cache, k)
+			}
+		}
+
+		// map iteration order is random
+		if len(a.cache) >= externalAuthCacheMaxSize {
+			for k := range a.cache {
+				delete(a.cache, k)
+				break
+			}
+		}
+	}
+
+	a.cache[key] = externalAuthCacheEntry{
+		err:     err,
+		expires: now.Add(ttl),
+	}
+}
+
+// statsGet returns a snapshot of the statistics.
+func (a *externalAuthenticator) statsGet() externalAuthStats {
+	return externalAuthStats{
+		allowed:       atomic.LoadInt64(&a.stats.allowed),
+		denied:        atomic.LoadInt64(&a.stats.denied),
+		errors:        atomic.LoadInt64(&a.stats.errors),
+		cacheHits:     atomic.LoadInt64(&a.stats.cacheHits),
+		durationCount: atomic.LoadInt64(&a.stats.durationCount),
+		durationSum:   atomic.LoadInt64(&a.stats.durationSum),
+	}
 }
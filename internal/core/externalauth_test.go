@@ -0,0 +1,135 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestExternalAuthenticator(t *testing.T) {
+	calls := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+
+		require.Equal(t, "myvalue", r.Header.Get("X-My-Header"))
+
+		var req externalAuthRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		require.NoError(t, err)
+
+		if req.User != "myuser" || req.Password != "mypass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.Write([]byte(`{"permissions":[{"action":"read","path":"cam*"}]}`))
+	}))
+	defer ts.Close()
+
+	a := newExternalAuthenticator(
+		ts.URL,
+		conf.StringDuration(5*time.Second),
+		conf.StringDuration(time.Minute),
+		conf.StringDuration(time.Minute),
+		conf.HTTPHeaders{"X-My-Header": "myvalue"},
+		nil)
+	defer a.close()
+
+	err := a.authenticate("127.0.0.1", "myuser", "mypass", "cam1", "read", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	// positive cache
+	err = a.authenticate("127.0.0.1", "myuser", "mypass", "cam1", "read", "")
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+
+	// permissions returned by the server
+	err = a.authenticate("127.0.0.1", "myuser", "mypass", "cam1", "publish", "")
+	require.EqualError(t, err, "user is not allowed to publish path 'cam1'")
+	require.Equal(t, 2, calls)
+
+	// negative cache
+	err = a.authenticate("127.0.0.1", "myuser", "wrong", "cam1", "read", "")
+	require.EqualError(t, err, "bad status code: 401")
+	err = a.authenticate("127.0.0.1", "myuser", "wrong", "cam1", "read", "")
+	require.EqualError(t, err, "bad status code: 401")
+	require.Equal(t, 3, calls)
+
+	// failures of requests with a query are not cached
+	err = a.authenticate("127.0.0.1", "myuser", "wrong", "cam1", "read", "a=1")
+	require.EqualError(t, err, "bad status code: 401")
+	err = a.authenticate("127.0.0.1", "myuser", "wrong", "cam1", "read", "a=1")
+	require.EqualError(t, err, "bad status code: 401")
+	require.Equal(t, 5, calls)
+
+	stats := a.statsGet()
+	require.Equal(t, externalAuthStats{
+		allowed:       1,
+		denied:        4,
+		cacheHits:     2,
+		durationCount: 5,
+		durationSum:   stats.durationSum,
+	}, stats)
+}
+
+func TestExternalAuthenticatorCacheSize(t *testing.T) {
+	a := newExternalAuthenticator(
+		"http://localhost:9999",
+		conf.StringDuration(5*time.Second),
+		conf.StringDuration(time.Minute),
+		conf.StringDuration(time.Minute),
+		nil,
+		nil)
+	defer a.close()
+
+	for i := 0; i < externalAuthCacheMaxSize*2; i++ {
+		a.cacheSet(strconv.FormatInt(int64(i), 10), nil, time.Minute)
+	}
+	require.Equal(t, externalAuthCacheMaxSize, len(a.cache))
+
+	// the newest entry is always kept
+	_, ok := a.cacheGet(strconv.FormatInt(externalAuthCacheMaxSize*2-1, 10))
+	require.True(t, ok)
+}
+
+func TestExternalAuthenticatorTimeout(t *testing.T) {
+	done := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer ts.Close()
+	defer close(done)
+
+	a := newExternalAuthenticator(
+		ts.URL,
+		conf.StringDuration(200*time.Millisecond),
+		conf.StringDuration(time.Minute),
+		conf.StringDuration(time.Minute),
+		nil,
+		nil)
+	defer a.close()
+
+	err := a.authenticate("127.0.0.1", "myuser", "mypass", "cam1", "read", "")
+	require.Error(t, err)
+	require.Equal(t, int64(1), a.statsGet().errors)
+
+	// errors are not cached
+	_, ok := a.cacheGet(externalAuthCacheKey(externalAuthRequest{
+		IP:       "127.0.0.1",
+		User:     "myuser",
+		Password: "mypass",
+		Path:     "cam1",
+		Action:   "read",
+	}))
+	require.False(t, ok)
+}
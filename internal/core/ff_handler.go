@@ -3,7 +3,6 @@ package core
 import (
 	"fmt"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
-	"github.com/aler9/rtsp-simple-server/internal/util"
 	"io"
 	"log"
 	"os"
@@ -19,7 +18,7 @@ type FFHandler struct {
 
 //OnConnect imp WsStatusListener
 func (h *FFHandler) OnConnect(uuid string, kind string, dest string, ffmpegArgs string) {
-	h.logger.Log(logger.Info, "ff.connect %s %s", uuid, util.TimeUtil{}.GetTimeStr())
+	h.logger.Log(logger.Info, "ff.connect %s", uuid)
 	processor := &ffProcessor{
 		uuid:   uuid,
 		logger: h.logger,
@@ -42,7 +41,7 @@ func (h *FFHandler) OnMessage(uuid string, data []byte) {
 
 //OnDisconnect imp WsStatusListener
 func (h *FFHandler) OnDisconnect(uuid string) {
-	h.logger.Log(logger.Info, "ff disconnect %s %s", uuid, util.TimeUtil{}.GetTimeStr())
+	h.logger.Log(logger.Info, "ff disconnect %s", uuid)
 	h.connect[uuid].destroy()
 	delete(h.connect, uuid)
 }
@@ -56,7 +55,7 @@ type ffProcessor struct {
 }
 
 func (p *ffProcessor) init(uuid string, kind string, dest string, ffmpegArgs string) {
-	p.logger.Log(logger.Info, "ff.init %s %s", uuid, util.TimeUtil{}.GetTimeStr())
+	p.logger.Log(logger.Info, "ff.init %s", uuid)
 
 	var cmdName string
 	var cmdArgs []string
@@ -116,7 +115,7 @@ func (p *ffProcessor) init(uuid string, kind string, dest string, ffmpegArgs str
 
 	go func() {
 		_ = cmd.Wait()
-		p.logger.Log(logger.Info, "ff.processor.finish %s %s", uuid, util.TimeUtil{}.GetTimeStr())
+		p.logger.Log(logger.Info, "ff.processor.finish %s", uuid)
 	}()
 
 	p.cmd = cmd
@@ -0,0 +1,463 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/mp4"
+)
+
+const (
+	fileSourceRetryPause = 5 * time.Second
+
+	// distance between the last frame of a file and the first frame
+	// of the next loop, when it can't be computed.
+	fileSourceDefaultLoopStep = 40 * time.Millisecond
+)
+
+// fileSourceUnit is a unit of data read from a file.
+type fileSourceUnit struct {
+	h264NALUs [][]byte
+	aacAUs    [][]byte
+	pts       time.Duration
+	dts       time.Duration
+}
+
+// fileSourceDemuxer reads units of data from a file, in decoding order.
+type fileSourceDemuxer interface {
+	read() (*fileSourceUnit, error)
+	close()
+}
+
+type fileSourceMP4Demuxer struct {
+	f *os.File
+	r *mp4.Reader
+}
+
+func (d *fileSourceMP4Demuxer) read() (*fileSourceUnit, error) {
+	s, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &fileSourceUnit{
+		h264NALUs: s.H264NALUs,
+		pts:       s.PTS,
+		dts:       s.DTS,
+	}
+	if s.AACAU != nil {
+		u.aacAUs = [][]byte{s.AACAU}
+	}
+
+	return u, nil
+}
+
+func (d *fileSourceMP4Demuxer) close() {
+	d.f.Close()
+}
+
+type fileSourceTSDemuxer struct {
+	r *hls.SegmentReader
+}
+
+func (d *fileSourceTSDemuxer) read() (*fileSourceUnit, error) {
+	data, err := d.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &fileSourceUnit{
+		h264NALUs: data.H264NALUs,
+		pts:       data.PTS,
+		dts:       data.DTS,
+	}
+	for _, pkt := range data.AACPackets {
+		u.aacAUs = append(u.aacAUs, pkt.AU)
+	}
+
+	return u, nil
+}
+
+func (d *fileSourceTSDemuxer) close() {
+}
+
+// fileSourcePath returns the path of the file pointed by a file:// URL.
+func fileSourcePath(ur string) string {
+	return strings.TrimPrefix(ur, "file://")
+}
+
+// fileSourceOpen opens a MP4 or MPEG-TS file and returns its tracks and a demuxer.
+func fileSourceOpen(fpath string) (*gortsplib.TrackH264, *gortsplib.TrackAAC, fileSourceDemuxer, error) {
+	switch strings.ToLower(filepath.Ext(fpath)) {
+	case ".mp4", ".m4v", ".mov":
+		f, err := os.Open(fpath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+
+		r, err := mp4.NewReader(f, fi.Size())
+		if err != nil {
+			f.Close()
+			return nil, nil, nil, err
+		}
+
+		videoTrack, audioTrack := r.Tracks()
+		return videoTrack, audioTrack, &fileSourceMP4Demuxer{f: f, r: r}, nil
+
+	case ".ts":
+		byts, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		videoTrack, audioTrack, err := hls.SegmentTracks(byts)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		r, err := hls.NewSegmentReader(byts)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		return videoTrack, audioTrack, &fileSourceTSDemuxer{r: r}, nil
+	}
+
+	return nil, nil, nil, fmt.Errorf("unsupported file type: '%s'", filepath.Ext(fpath))
+}
+
+// fileSourceWriter encodes units into RTP packets and writes them to a stream.
+type fileSourceWriter struct {
+	stream       *stream
+	videoTrackID int
+	audioTrackID int
+	videoEnc     *rtph264.Encoder
+	audioEnc     *rtpaac.Encoder
+}
+
+// newFileSourceWriter allocates a fileSourceWriter and returns the tracks
+// of the stream. The stream must be set before writing.
+func newFileSourceWriter(
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackAAC,
+) (*fileSourceWriter, gortsplib.Tracks) {
+	w := &fileSourceWriter{
+		videoTrackID: -1,
+		audioTrackID: -1,
+	}
+	var tracks gortsplib.Tracks
+
+	if videoTrack != nil {
+		w.videoTrackID = len(tracks)
+		w.videoEnc = &rtph264.Encoder{PayloadType: 96}
+		w.videoEnc.Init()
+		tracks = append(tracks, videoTrack)
+	}
+
+	if audioTrack != nil {
+		w.audioTrackID = len(tracks)
+		w.audioEnc = &rtpaac.Encoder{
+			PayloadType:      97,
+			SampleRate:       audioTrack.ClockRate(),
+			SizeLength:       13,
+			IndexLength:      3,
+			IndexDeltaLength: 3,
+		}
+		w.audioEnc.Init()
+		tracks = append(tracks, audioTrack)
+	}
+
+	return w, tracks
+}
+
+func (w *fileSourceWriter) write(u *fileSourceUnit, pts time.Duration) {
+	if u.h264NALUs != nil {
+		if w.videoEnc == nil {
+			return
+		}
+
+		pkts, err := w.videoEnc.Encode(u.h264NALUs, pts)
+		if err != nil {
+			return
+		}
+
+		lastPkt := len(pkts) - 1
+		for i, pkt := range pkts {
+			if i != lastPkt {
+				w.stream.writeData(&data{
+					trackID:      w.videoTrackID,
+					rtp:          pkt,
+					ptsEqualsDTS: false,
+				})
+			} else {
+				w.stream.writeData(&data{
+					trackID:      w.videoTrackID,
+					rtp:          pkt,
+					ptsEqualsDTS: h264.IDRPresent(u.h264NALUs),
+					h264NALUs:    u.h264NALUs,
+					h264PTS:      pts,
+				})
+			}
+		}
+		return
+	}
+
+	if w.audioEnc == nil || len(u.aacAUs) == 0 {
+		return
+	}
+
+	pkts, err := w.audioEnc.Encode(u.aacAUs, pts)
+	if err != nil {
+		return
+	}
+
+	for _, pkt := range pkts {
+		w.stream.writeData(&data{
+			trackID:      w.audioTrackID,
+			rtp:          pkt,
+			ptsEqualsDTS: true,
+		})
+	}
+}
+
+type fileSourceParent interface {
+	log(logger.Level, string, ...interface{})
+	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
+}
+
+// fileSource reads a MP4 or MPEG-TS file and publishes its content,
+// at the pace given by its timestamps.
+type fileSource struct {
+	ur     string
+	loop   bool
+	wg     *sync.WaitGroup
+	parent fileSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newFileSource(
+	parentCtx context.Context,
+	ur string,
+	loop bool,
+	wg *sync.WaitGroup,
+	parent fileSourceParent,
+) *fileSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &fileSource{
+		ur:        ur,
+		loop:      loop,
+		wg:        wg,
+		parent:    parent,
+		ctx:       ctx,
+		ctxCancel: ctxCancel,
+	}
+
+	s.Log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *fileSource) close() {
+	s.Log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *fileSource) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.log(level, "[file source] "+format, args...)
+}
+
+func (s *fileSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(fileSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *fileSource) runInner() bool {
+	fpath := fileSourcePath(s.ur)
+
+	videoTrack, audioTrack, demuxer, err := fileSourceOpen(fpath)
+	if err != nil {
+		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
+		return true
+	}
+
+	w, tracks := newFileSourceWriter(videoTrack, audioTrack)
+
+	res := s.parent.onSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		source: s,
+		tracks: tracks,
+	})
+	if res.err != nil {
+		demuxer.close()
+		s.Log(logger.Info, "ERR: %v", res.err)
+		return true
+	}
+
+	s.Log(logger.Info, "ready")
+
+	stream := res.stream
+	w.stream = stream
+
+	defer func() {
+		if stream != nil {
+			s.parent.onSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{source: s})
+		}
+	}()
+
+	// timestamps are kept increasing between loops, by adding
+	// the duration of previous loops to the timestamps of the file.
+	wallStart := time.Now()
+	var loopOffset time.Duration
+
+	for {
+		err := s.play(demuxer, videoTrack != nil, wallStart, &loopOffset, w.write)
+		demuxer.close()
+
+		if err != io.EOF {
+			if s.ctx.Err() != nil {
+				return false
+			}
+
+			s.Log(logger.Info, "ERR: %v", err)
+			s.parent.onSourceStaticError(err)
+			return true
+		}
+
+		if !s.loop {
+			s.Log(logger.Info, "end of file reached")
+			s.parent.onSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{source: s})
+			stream = nil
+			<-s.ctx.Done()
+			return false
+		}
+
+		// the file is opened again, in order to pick up changes
+		_, _, demuxer, err = fileSourceOpen(fpath)
+		if err != nil {
+			s.Log(logger.Info, "ERR: %v", err)
+			s.parent.onSourceStaticError(err)
+			return true
+		}
+	}
+}
+
+// play writes all the units of a file, at the pace given by their timestamps.
+// It returns io.EOF when the end of the file is reached.
+func (s *fileSource) play(
+	demuxer fileSourceDemuxer,
+	hasVideo bool,
+	wallStart time.Time,
+	loopOffset *time.Duration,
+	writeUnit func(*fileSourceUnit, time.Duration),
+) error {
+	var dtsBase time.Duration
+	dtsBaseSet := false
+	var lastDTS time.Duration
+	var lastMainDTS time.Duration
+	lastMainDTSSet := false
+	step := fileSourceDefaultLoopStep
+
+	defer func() {
+		if dtsBaseSet {
+			*loopOffset += lastDTS - dtsBase + step
+		}
+	}()
+
+	for {
+		u, err := demuxer.read()
+		if err != nil {
+			return err
+		}
+
+		if !dtsBaseSet {
+			dtsBase = u.dts
+			lastDTS = u.dts
+			dtsBaseSet = true
+		}
+
+		// the distance between the first frame of the next loop and the
+		// last frame of this one is the duration of a frame of the main track.
+		if (u.h264NALUs != nil) == hasVideo {
+			if lastMainDTSSet && u.dts > lastMainDTS {
+				step = u.dts - lastMainDTS
+			}
+			lastMainDTS = u.dts
+			lastMainDTSSet = true
+		}
+		if u.dts > lastDTS {
+			lastDTS = u.dts
+		}
+
+		dts := *loopOffset + u.dts - dtsBase
+
+		wait := time.Until(wallStart.Add(dts))
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-s.ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("terminated")
+			}
+		} else if s.ctx.Err() != nil {
+			return fmt.Errorf("terminated")
+		}
+
+		writeUnit(u, *loopOffset+u.pts-dtsBase)
+	}
+}
+
+// onSourceAPIDescribe implements source.
+func (*fileSource) onSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"fileSource"}
+}
+
+// protocol implements source.
+func (*fileSource) protocol() string {
+	return "file"
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testFileSourceDemuxer struct {
+	units []*fileSourceUnit
+}
+
+func (d *testFileSourceDemuxer) read() (*fileSourceUnit, error) {
+	if len(d.units) == 0 {
+		return nil, io.EOF
+	}
+	u := d.units[0]
+	d.units = d.units[1:]
+	return u, nil
+}
+
+func (d *testFileSourceDemuxer) close() {
+}
+
+func TestFileSourcePlayLoop(t *testing.T) {
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	defer ctxCancel()
+
+	s := &fileSource{ctx: ctx}
+
+	newDemuxer := func() fileSourceDemuxer {
+		return &testFileSourceDemuxer{units: []*fileSourceUnit{
+			{h264NALUs: [][]byte{{0x65}}, pts: 1080 * time.Millisecond, dts: 1000 * time.Millisecond},
+			{aacAUs: [][]byte{{0x01}}, pts: 1010 * time.Millisecond, dts: 1010 * time.Millisecond},
+			{h264NALUs: [][]byte{{0x41}}, pts: 1040 * time.Millisecond, dts: 1040 * time.Millisecond},
+			{h264NALUs: [][]byte{{0x41}}, pts: 1120 * time.Millisecond, dts: 1080 * time.Millisecond},
+		}}
+	}
+
+	var pts []time.Duration
+	writeUnit := func(u *fileSourceUnit, p time.Duration) {
+		pts = append(pts, p)
+	}
+
+	// do not wait
+	wallStart := time.Now().Add(-time.Hour)
+	var loopOffset time.Duration
+
+	err := s.play(newDemuxer(), true, wallStart, &loopOffset, writeUnit)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 120*time.Millisecond, loopOffset)
+
+	err = s.play(newDemuxer(), true, wallStart, &loopOffset, writeUnit)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 240*time.Millisecond, loopOffset)
+
+	require.Equal(t, []time.Duration{
+		80 * time.Millisecond,
+		10 * time.Millisecond,
+		40 * time.Millisecond,
+		120 * time.Millisecond,
+		200 * time.Millisecond,
+		130 * time.Millisecond,
+		160 * time.Millisecond,
+		240 * time.Millisecond,
+	}, pts)
+}
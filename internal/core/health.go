@@ -0,0 +1,356 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/h264"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+const (
+	// conditions of paths are evaluated with this period.
+	healthCheckPeriod = 1 * time.Second
+
+	// a timestamp discontinuity is kept raised for this duration.
+	healthDiscontinuityHold = 10 * time.Second
+
+	// the average bitrate is computed over this duration.
+	healthBitrateAverageWindow = 1 * time.Minute
+
+	// bitrate drops are detected only after this duration from the start of the stream.
+	healthBitrateWarmup = 10 * time.Second
+
+	// maximum number of entries in the history of a path.
+	healthHistorySize = 20
+)
+
+type healthCondition string
+
+// supported health conditions.
+const (
+	healthConditionStalled                healthCondition = "stalled"
+	healthConditionNoKeyframe             healthCondition = "noKeyframe"
+	healthConditionBitrateDrop            healthCondition = "bitrateDrop"
+	healthConditionTimestampDiscontinuity healthCondition = "timestampDiscontinuity"
+)
+
+var healthConditions = []healthCondition{
+	healthConditionStalled,
+	healthConditionNoKeyframe,
+	healthConditionBitrateDrop,
+	healthConditionTimestampDiscontinuity,
+}
+
+type healthConditionKey struct {
+	condition healthCondition
+	track     int
+}
+
+// healthTrackData is the API representation of the state of a track.
+type healthTrackData struct {
+	LastDataTime *time.Time `json:"lastDataTime"`
+	FrameRate    float64    `json:"frameRate"`
+	Bitrate      float64    `json:"bitrate"`
+}
+
+type streamHealthTrack struct {
+	isH264    bool
+	clockRate int
+
+	lastData      time.Time
+	lastKeyFrame  time.Time
+	windowFrames  uint64
+	windowBytes   uint64
+	bitrate       float64
+	avgBitrate    float64
+	hasAvgBitrate bool
+	frameRate     float64
+	lastTimestamp uint32
+	lastTSTime    time.Time
+	maxTSJump     time.Duration
+	lastTSJump    time.Time
+}
+
+// streamHealth collects the state of every track of a stream, that is used
+// to detect conditions like stalls or missing keyframes.
+type streamHealth struct {
+	mutex     sync.Mutex
+	start     time.Time
+	lastCheck time.Time
+	tracks    []*streamHealthTrack
+}
+
+func newStreamHealth(tracks gortsplib.Tracks) *streamHealth {
+	now := time.Now()
+
+	h := &streamHealth{
+		start:     now,
+		lastCheck: now,
+		tracks:    make([]*streamHealthTrack, len(tracks)),
+	}
+
+	for i, track := range tracks {
+		_, isH264 := track.(*gortsplib.TrackH264)
+		h.tracks[i] = &streamHealthTrack{
+			isH264:    isH264,
+			clockRate: track.ClockRate(),
+		}
+	}
+
+	return h
+}
+
+// onData is called when a unit of data is written into the stream.
+func (h *streamHealth) onData(now time.Time, data *data) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if data.trackID >= len(h.tracks) {
+		return
+	}
+	t := h.tracks[data.trackID]
+
+	t.lastData = now
+	t.windowBytes += uint64(data.rtp.MarshalSize())
+
+	if t.isH264 {
+		if data.h264NALUs != nil {
+			t.windowFrames++
+
+			if h264.IDRPresent(data.h264NALUs) {
+				t.lastKeyFrame = now
+			}
+		}
+	} else if data.rtp.Marker {
+		t.windowFrames++
+	}
+
+	// compare the difference between timestamps with the elapsed time.
+	// Small differences are caused by jitter and B-frames.
+	if !t.lastTSTime.IsZero() && t.clockRate > 0 {
+		tsDiff := time.Duration(int32(data.rtp.Timestamp-t.lastTimestamp)) *
+			time.Second / time.Duration(t.clockRate)
+		jump := tsDiff - now.Sub(t.lastTSTime)
+		if jump < 0 {
+			jump = -jump
+		}
+		if jump > t.maxTSJump {
+			t.maxTSJump = jump
+		}
+	}
+	t.lastTimestamp = data.rtp.Timestamp
+	t.lastTSTime = now
+}
+
+// check updates rates and returns the conditions that are currently raised.
+func (h *streamHealth) check(now time.Time, pconf *conf.PathConf) ([]healthConditionKey, []healthTrackData) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	elapsed := now.Sub(h.lastCheck)
+	h.lastCheck = now
+
+	var conditions []healthConditionKey
+	tracks := make([]healthTrackData, len(h.tracks))
+
+	for i, t := range h.tracks {
+		if elapsed > 0 {
+			t.bitrate = float64(t.windowBytes*8) / elapsed.Seconds()
+			t.frameRate = float64(t.windowFrames) / elapsed.Seconds()
+
+			// exponential moving average
+			if !t.hasAvgBitrate {
+				t.avgBitrate = t.bitrate
+				t.hasAvgBitrate = true
+			} else {
+				alpha := elapsed.Seconds() / healthBitrateAverageWindow.Seconds()
+				if alpha > 1 {
+					alpha = 1
+				}
+				t.avgBitrate += (t.bitrate - t.avgBitrate) * alpha
+			}
+		}
+		t.windowBytes = 0
+		t.windowFrames = 0
+
+		if pconf.HealthTimestampJump != 0 && t.maxTSJump > time.Duration(pconf.HealthTimestampJump) {
+			t.lastTSJump = now
+		}
+		t.maxTSJump = 0
+
+		// tracks that never received data are stalled since the start of the stream
+		lastData := t.lastData
+		if lastData.IsZero() {
+			lastData = h.start
+		}
+
+		if pconf.HealthStalledTimeout != 0 &&
+			now.Sub(lastData) >= time.Duration(pconf.HealthStalledTimeout) {
+			conditions = append(conditions, healthConditionKey{healthConditionStalled, i})
+		}
+
+		if t.isH264 && pconf.HealthKeyframeTimeout != 0 {
+			lastKeyFrame := t.lastKeyFrame
+			if lastKeyFrame.IsZero() {
+				lastKeyFrame = h.start
+			}
+
+			if now.Sub(lastKeyFrame) >= time.Duration(pconf.HealthKeyframeTimeout) {
+				conditions = append(conditions, healthConditionKey{healthConditionNoKeyframe, i})
+			}
+		}
+
+		if pconf.HealthBitrateDrop != 0 && now.Sub(h.start) >= healthBitrateWarmup &&
+			t.bitrate < t.avgBitrate*float64(100-pconf.HealthBitrateDrop)/100 {
+			conditions = append(conditions, healthConditionKey{healthConditionBitrateDrop, i})
+		}
+
+		if !t.lastTSJump.IsZero() && now.Sub(t.lastTSJump) < healthDiscontinuityHold {
+			conditions = append(conditions, healthConditionKey{healthConditionTimestampDiscontinuity, i})
+		}
+
+		tracks[i] = healthTrackData{
+			LastDataTime: func() *time.Time {
+				if t.lastData.IsZero() {
+					return nil
+				}
+				v := t.lastData
+				return &v
+			}(),
+			FrameRate: t.frameRate,
+			Bitrate:   t.bitrate,
+		}
+	}
+
+	return conditions, tracks
+}
+
+// pathHealthCondition is the API representation of a raised condition.
+type pathHealthCondition struct {
+	Condition healthCondition `json:"condition"`
+	Track     int             `json:"track"`
+	Since     time.Time       `json:"since"`
+}
+
+// pathHealthEvent is the API representation of an entry of the history.
+type pathHealthEvent struct {
+	Condition healthCondition `json:"condition"`
+	Track     int             `json:"track"`
+	RaisedAt  time.Time       `json:"raisedAt"`
+	ClearedAt *time.Time      `json:"clearedAt"`
+}
+
+// pathHealthData is the API representation of pathHealth.
+type pathHealthData struct {
+	Conditions []pathHealthCondition `json:"conditions"`
+	History    []pathHealthEvent     `json:"history"`
+	Tracks     []healthTrackData     `json:"tracks"`
+
+	// used by metrics
+	alarms map[healthCondition]int64
+}
+
+// pathHealth keeps the conditions raised on a path and their history.
+// It is not thread safe.
+type pathHealth struct {
+	active  map[healthConditionKey]*pathHealthEvent
+	history []*pathHealthEvent
+	alarms  map[healthCondition]int64
+	tracks  []healthTrackData
+}
+
+func newPathHealth() *pathHealth {
+	return &pathHealth{
+		active: make(map[healthConditionKey]*pathHealthEvent),
+		alarms: make(map[healthCondition]int64),
+	}
+}
+
+// update sets the conditions that are currently raised, and returns the ones
+// that have been raised and cleared since the last update.
+func (h *pathHealth) update(now time.Time, current []healthConditionKey) ([]healthConditionKey, []healthConditionKey) {
+	var raised []healthConditionKey
+	var cleared []healthConditionKey
+
+	currentMap := make(map[healthConditionKey]struct{}, len(current))
+
+	for _, key := range current {
+		currentMap[key] = struct{}{}
+
+		if _, ok := h.active[key]; ok {
+			continue
+		}
+
+		e := &pathHealthEvent{
+			Condition: key.condition,
+			Track:     key.track,
+			RaisedAt:  now,
+		}
+		h.active[key] = e
+		h.history = append(h.history, e)
+		if len(h.history) > healthHistorySize {
+			h.history = h.history[len(h.history)-healthHistorySize:]
+		}
+		h.alarms[key.condition]++
+		raised = append(raised, key)
+	}
+
+	for key, e := range h.active {
+		if _, ok := currentMap[key]; ok {
+			continue
+		}
+
+		v := now
+		e.ClearedAt = &v
+		delete(h.active, key)
+		cleared = append(cleared, key)
+	}
+
+	return raised, cleared
+}
+
+func (h *pathHealth) get() pathHealthData {
+	ret := pathHealthData{
+		Conditions: []pathHealthCondition{},
+		History:    make([]pathHealthEvent, len(h.history)),
+		Tracks:     h.tracks,
+		alarms:     make(map[healthCondition]int64),
+	}
+
+	if ret.Tracks == nil {
+		ret.Tracks = []healthTrackData{}
+	}
+
+	for _, e := range h.active {
+		ret.Conditions = append(ret.Conditions, pathHealthCondition{
+			Condition: e.Condition,
+			Track:     e.Track,
+			Since:     e.RaisedAt,
+		})
+	}
+
+	sort.Slice(ret.Conditions, func(i, j int) bool {
+		a, b := ret.Conditions[i], ret.Conditions[j]
+		if !a.Since.Equal(b.Since) {
+			return a.Since.Before(b.Since)
+		}
+		if a.Track != b.Track {
+			return a.Track < b.Track
+		}
+		return a.Condition < b.Condition
+	})
+
+	for i, e := range h.history {
+		ret.History[i] = *e
+	}
+
+	for k, v := range h.alarms {
+		ret.alarms[k] = v
+	}
+
+	return ret
+}
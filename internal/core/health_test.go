@@ -0,0 +1,152 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestStreamHealth(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x01, 0x02, 0x03, 0x04}, []byte{0x01, 0x02}, nil)
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	h := newStreamHealth(gortsplib.Tracks{videoTrack, audioTrack})
+
+	pconf := &conf.PathConf{
+		HealthStalledTimeout:  conf.StringDuration(5 * time.Second),
+		HealthKeyframeTimeout: conf.StringDuration(10 * time.Second),
+		HealthBitrateDrop:     50,
+		HealthTimestampJump:   conf.StringDuration(1 * time.Second),
+	}
+
+	newData := func(trackID int, ts uint32, nalus [][]byte) *data {
+		return &data{
+			trackID: trackID,
+			rtp: &rtp.Packet{
+				Header: rtp.Header{
+					Version:   2,
+					Marker:    true,
+					Timestamp: ts,
+				},
+				Payload: make([]byte, 100),
+			},
+			h264NALUs: nalus,
+		}
+	}
+
+	idr := [][]byte{{0x05}}
+	nonIDR := [][]byte{{0x01}}
+
+	// 12 seconds of video and audio
+	now := h.start
+	for i := 0; i < 12; i++ {
+		for j := 0; j < 10; j++ {
+			now = now.Add(100 * time.Millisecond)
+			nalus := nonIDR
+			if j == 0 {
+				nalus = idr
+			}
+			h.onData(now, newData(0, uint32(i*10+j)*9000, nalus))
+			h.onData(now, newData(1, uint32(i*10+j)*4410, nil))
+		}
+
+		conditions, tracks := h.check(now, pconf)
+		require.Equal(t, []healthConditionKey(nil), conditions)
+		require.Equal(t, 10.0, tracks[0].FrameRate)
+	}
+
+	// audio only, with a lower bitrate
+	for i := 0; i < 10; i++ {
+		now = now.Add(1 * time.Second)
+		h.onData(now, newData(1, uint32(129+i*10)*4410, nil))
+		h.check(now, pconf)
+	}
+
+	conditions, tracks := h.check(now, pconf)
+	require.ElementsMatch(t, []healthConditionKey{
+		{healthConditionStalled, 0},
+		{healthConditionNoKeyframe, 0},
+		{healthConditionBitrateDrop, 0},
+		{healthConditionBitrateDrop, 1},
+	}, conditions)
+	require.Equal(t, 0.0, tracks[0].FrameRate)
+	require.Equal(t, 1.0, tracks[1].FrameRate)
+	require.Equal(t, now, *tracks[1].LastDataTime)
+}
+
+func TestStreamHealthTimestampJump(t *testing.T) {
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	h := newStreamHealth(gortsplib.Tracks{audioTrack})
+
+	pconf := &conf.PathConf{
+		HealthTimestampJump: conf.StringDuration(1 * time.Second),
+	}
+
+	now := h.start
+	h.onData(now, &data{rtp: &rtp.Packet{Header: rtp.Header{Timestamp: 0}}})
+
+	now = now.Add(100 * time.Millisecond)
+	h.onData(now, &data{rtp: &rtp.Packet{Header: rtp.Header{Timestamp: 4410}}})
+
+	conditions, _ := h.check(now, pconf)
+	require.Equal(t, []healthConditionKey(nil), conditions)
+
+	now = now.Add(100 * time.Millisecond)
+	h.onData(now, &data{rtp: &rtp.Packet{Header: rtp.Header{Timestamp: 4410 + 5*44100}}})
+
+	conditions, _ = h.check(now, pconf)
+	require.Equal(t, []healthConditionKey{{healthConditionTimestampDiscontinuity, 0}}, conditions)
+
+	// the condition is kept raised for a while
+	conditions, _ = h.check(now.Add(healthDiscontinuityHold-time.Second), pconf)
+	require.Equal(t, []healthConditionKey{{healthConditionTimestampDiscontinuity, 0}}, conditions)
+
+	conditions, _ = h.check(now.Add(healthDiscontinuityHold), pconf)
+	require.Equal(t, []healthConditionKey(nil), conditions)
+}
+
+func TestPathHealth(t *testing.T) {
+	h := newPathHealth()
+
+	now := time.Now()
+	stalled := healthConditionKey{healthConditionStalled, 0}
+	noKeyframe := healthConditionKey{healthConditionNoKeyframe, 0}
+
+	raised, cleared := h.update(now, []healthConditionKey{stalled, noKeyframe})
+	require.Equal(t, []healthConditionKey{stalled, noKeyframe}, raised)
+	require.Equal(t, []healthConditionKey(nil), cleared)
+
+	raised, cleared = h.update(now.Add(time.Second), []healthConditionKey{noKeyframe})
+	require.Equal(t, []healthConditionKey(nil), raised)
+	require.Equal(t, []healthConditionKey{stalled}, cleared)
+
+	data := h.get()
+	require.Equal(t, []pathHealthCondition{{
+		Condition: healthConditionNoKeyframe,
+		Track:     0,
+		Since:     now,
+	}}, data.Conditions)
+	require.Equal(t, 2, len(data.History))
+	require.Equal(t, now.Add(time.Second), *data.History[0].ClearedAt)
+	require.Nil(t, data.History[1].ClearedAt)
+	require.Equal(t, int64(1), data.alarms[healthConditionStalled])
+
+	for i := 0; i < healthHistorySize; i++ {
+		h.update(now, []healthConditionKey{stalled})
+		h.update(now, nil)
+	}
+
+	data = h.get()
+	require.Equal(t, healthHistorySize, len(data.History))
+	require.Equal(t, int64(healthHistorySize+1), data.alarms[healthConditionStalled])
+}
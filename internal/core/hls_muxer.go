@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -18,6 +20,7 @@ import (
 	"github.com/aler9/gortsplib/pkg/rtpaac"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/dash"
 	"github.com/aler9/rtsp-simple-server/internal/hls"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
@@ -120,17 +123,25 @@ type hlsMuxerParent interface {
 }
 
 type hlsMuxer struct {
-	name                      string
-	externalAuthenticationURL string
-	hlsAlwaysRemux            bool
-	hlsSegmentCount           int
-	hlsSegmentDuration        conf.StringDuration
-	hlsSegmentMaxSize         conf.StringSize
-	readBufferCount           int
-	wg                        *sync.WaitGroup
-	pathName                  string
-	pathManager               hlsMuxerPathManager
-	parent                    hlsMuxerParent
+	name               string
+	externalAuth       *externalAuthenticator
+	jwtValidator       *jwtValidator
+	urlSigningKey      string
+	users              map[string]*conf.User
+	accessLimiter      *accessLimiter
+	hlsAlwaysRemux     bool
+	hlsSegmentCount    int
+	hlsSegmentDuration conf.StringDuration
+	hlsSegmentMaxSize  conf.StringSize
+	hlsDVRWindow       conf.StringDuration
+	hlsDVRPath         string
+	dashEnable         bool
+	readBufferCount    int
+	wg                 *sync.WaitGroup
+	pathName           string
+	pathManager        hlsMuxerPathManager
+	metrics            *metrics
+	parent             hlsMuxerParent
 
 	ctx             context.Context
 	ctxCancel       func()
@@ -138,7 +149,9 @@ type hlsMuxer struct {
 	ringBuffer      *ringbuffer.RingBuffer
 	lastRequestTime *int64
 	muxer           *hls.Muxer
+	dashMuxer       *dash.Muxer
 	requests        []hlsMuxerRequest
+	stats           *trafficStats
 
 	// in
 	request                chan hlsMuxerRequest
@@ -148,37 +161,54 @@ type hlsMuxer struct {
 func newHLSMuxer(
 	parentCtx context.Context,
 	name string,
-	externalAuthenticationURL string,
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
+	accessLimiter *accessLimiter,
 	hlsAlwaysRemux bool,
 	hlsSegmentCount int,
 	hlsSegmentDuration conf.StringDuration,
 	hlsSegmentMaxSize conf.StringSize,
+	hlsDVRWindow conf.StringDuration,
+	hlsDVRPath string,
+	dashEnable bool,
 	readBufferCount int,
 	wg *sync.WaitGroup,
 	pathName string,
 	pathManager hlsMuxerPathManager,
+	metrics *metrics,
 	parent hlsMuxerParent,
 ) *hlsMuxer {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	m := &hlsMuxer{
-		name:                      name,
-		externalAuthenticationURL: externalAuthenticationURL,
-		hlsAlwaysRemux:            hlsAlwaysRemux,
-		hlsSegmentCount:           hlsSegmentCount,
-		hlsSegmentDuration:        hlsSegmentDuration,
-		hlsSegmentMaxSize:         hlsSegmentMaxSize,
-		readBufferCount:           readBufferCount,
-		wg:                        wg,
-		pathName:                  pathName,
-		pathManager:               pathManager,
-		parent:                    parent,
-		ctx:                       ctx,
-		ctxCancel:                 ctxCancel,
+		name:               name,
+		externalAuth:       externalAuth,
+		jwtValidator:       jwtValidator,
+		urlSigningKey:      urlSigningKey,
+		users:              users,
+		accessLimiter:      accessLimiter,
+		hlsAlwaysRemux:     hlsAlwaysRemux,
+		hlsSegmentCount:    hlsSegmentCount,
+		hlsSegmentDuration: hlsSegmentDuration,
+		hlsSegmentMaxSize:  hlsSegmentMaxSize,
+		hlsDVRWindow:       hlsDVRWindow,
+		hlsDVRPath:         hlsDVRPath,
+		dashEnable:         dashEnable,
+		readBufferCount:    readBufferCount,
+		wg:                 wg,
+		pathName:           pathName,
+		pathManager:        pathManager,
+		metrics:            metrics,
+		parent:             parent,
+		ctx:                ctx,
+		ctxCancel:          ctxCancel,
 		lastRequestTime: func() *int64 {
 			v := time.Now().Unix()
 			return &v
 		}(),
+		stats:                  newTrafficStats(),
 		request:                make(chan hlsMuxerRequest),
 		hlsServerAPIMuxersList: make(chan hlsServerAPIMuxersListSubReq),
 	}
@@ -233,7 +263,8 @@ func (m *hlsMuxer) run() {
 
 			case req := <-m.hlsServerAPIMuxersList:
 				req.data.Items[m.name] = hlsServerAPIMuxersListItem{
-					LastRequest: time.Unix(atomic.LoadInt64(m.lastRequestTime), 0).String(),
+					LastRequest:      time.Unix(atomic.LoadInt64(m.lastRequestTime), 0).String(),
+					trafficStatsData: m.stats.get(),
 				}
 				close(req.res)
 
@@ -315,19 +346,52 @@ func (m *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 		return fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
 	}
 
+	// reception times of access units that have been written into the
+	// current segment. Latency is measured when the segment is published.
+	var pendingIngestTimes []time.Time
+
+	addPendingIngestTime := func(data *data) {
+		if data.rtp.Marker && !data.ingestTime.IsZero() &&
+			len(pendingIngestTimes) < latencyStatsWindowSize {
+			pendingIngestTimes = append(pendingIngestTimes, data.ingestTime)
+		}
+	}
+
 	var err error
 	m.muxer, err = hls.NewMuxer(
 		m.hlsSegmentCount,
 		time.Duration(m.hlsSegmentDuration),
 		uint64(m.hlsSegmentMaxSize),
+		time.Duration(m.hlsDVRWindow),
+		m.hlsDVRPath,
 		videoTrack,
 		audioTrack,
+		func(d time.Duration) {
+			if m.metrics != nil {
+				m.metrics.onHLSSegmentGenerated(d)
+			}
+
+			now := time.Now()
+			for _, t := range pendingIngestTimes {
+				m.path.latency.onSent("hls", now, t)
+			}
+			pendingIngestTimes = pendingIngestTimes[:0]
+		},
 	)
 	if err != nil {
 		return err
 	}
 	defer m.muxer.Close()
 
+	if m.dashEnable {
+		m.dashMuxer = dash.NewMuxer(
+			m.hlsSegmentCount,
+			time.Duration(m.hlsSegmentDuration),
+			videoTrack,
+			audioTrack)
+		defer m.dashMuxer.Close()
+	}
+
 	innerReady <- struct{}{}
 
 	m.ringBuffer = ringbuffer.New(uint64(m.readBufferCount))
@@ -346,6 +410,8 @@ func (m *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 				}
 				data := item.(*data)
 
+				m.stats.onDataSent(data)
+
 				if videoTrack != nil && data.trackID == videoTrackID {
 					if data.h264NALUs == nil {
 						continue
@@ -365,6 +431,15 @@ func (m *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 						m.log(logger.Warn, "unable to write segment: %v", err)
 						continue
 					}
+
+					if m.dashMuxer != nil {
+						err = m.dashMuxer.WriteH264(pts, data.h264NALUs)
+						if err != nil {
+							m.log(logger.Warn, "unable to write DASH segment: %v", err)
+						}
+					}
+
+					addPendingIngestTime(data)
 				} else if audioTrack != nil && data.trackID == audioTrackID {
 					aus, pts, err := aacDecoder.Decode(data.rtp)
 					if err != nil {
@@ -379,6 +454,15 @@ func (m *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 						m.log(logger.Warn, "unable to write segment: %v", err)
 						continue
 					}
+
+					if m.dashMuxer != nil {
+						err = m.dashMuxer.WriteAAC(pts, aus)
+						if err != nil {
+							m.log(logger.Warn, "unable to write DASH segment: %v", err)
+						}
+					}
+
+					addPendingIngestTime(data)
 				}
 			}
 		}()
@@ -408,6 +492,33 @@ func (m *hlsMuxer) runInner(innerCtx context.Context, innerReady chan struct{})
 	}
 }
 
+// hlsPlaylistWithQuery adds the query of a signed playlist request to the URIs of the playlist,
+// in order to forward the signature to subsequent requests.
+// The signature has already been validated during authentication.
+func hlsPlaylistWithQuery(r io.Reader, urlSigningKey string, u *url.URL) io.Reader {
+	if r == nil || !isSignedURL(urlSigningKey, u.Query()) {
+		return r
+	}
+
+	byts, err := ioutil.ReadAll(r)
+	if err != nil {
+		return r
+	}
+
+	lines := strings.Split(string(byts), "\n")
+	for i, line := range lines {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			if strings.Contains(line, "?") {
+				lines[i] = line + "&" + u.RawQuery
+			} else {
+				lines[i] = line + "?" + u.RawQuery
+			}
+		}
+	}
+
+	return bytes.NewReader([]byte(strings.Join(lines, "\n")))
+}
+
 func (m *hlsMuxer) handleRequest(req hlsMuxerRequest) hlsMuxerResponse {
 	atomic.StoreInt64(m.lastRequestTime, time.Now().Unix())
 
@@ -415,6 +526,10 @@ func (m *hlsMuxer) handleRequest(req hlsMuxerRequest) hlsMuxerResponse {
 	if err != nil {
 		if terr, ok := err.(pathErrAuthCritical); ok {
 			m.log(logger.Info, "authentication error: %s", terr.message)
+
+			tmp, _, _ := net.SplitHostPort(req.req.RemoteAddr)
+			m.accessLimiter.onAuthFailure(net.ParseIP(tmp), "hls")
+
 			return hlsMuxerResponse{
 				status: http.StatusUnauthorized,
 			}
@@ -435,7 +550,7 @@ func (m *hlsMuxer) handleRequest(req hlsMuxerRequest) hlsMuxerResponse {
 			header: map[string]string{
 				"Content-Type": `application/x-mpegURL`,
 			},
-			body: m.muxer.PrimaryPlaylist(),
+			body: hlsPlaylistWithQuery(m.muxer.PrimaryPlaylist(), m.urlSigningKey, req.req.URL),
 		}
 
 	case req.file == "stream.m3u8":
@@ -444,7 +559,7 @@ func (m *hlsMuxer) handleRequest(req hlsMuxerRequest) hlsMuxerResponse {
 			header: map[string]string{
 				"Content-Type": `application/x-mpegURL`,
 			},
-			body: m.muxer.StreamPlaylist(),
+			body: hlsPlaylistWithQuery(m.muxer.StreamPlaylist(), m.urlSigningKey, req.req.URL),
 		}
 
 	case strings.HasSuffix(req.file, ".ts"):
@@ -461,6 +576,34 @@ func (m *hlsMuxer) handleRequest(req hlsMuxerRequest) hlsMuxerResponse {
 			body: r,
 		}
 
+	case m.dashMuxer != nil && req.file == "index.mpd":
+		return hlsMuxerResponse{
+			status: http.StatusOK,
+			header: map[string]string{
+				"Content-Type": `application/dash+xml`,
+			},
+			body: m.dashMuxer.MPD(req.req.URL.RawQuery),
+		}
+
+	case m.dashMuxer != nil && (strings.HasSuffix(req.file, ".mp4") || strings.HasSuffix(req.file, ".m4s")):
+		r := m.dashMuxer.File(req.file)
+		if r == nil {
+			return hlsMuxerResponse{status: http.StatusNotFound}
+		}
+
+		contentType := `video/mp4`
+		if strings.HasPrefix(req.file, "audio_") {
+			contentType = `audio/mp4`
+		}
+
+		return hlsMuxerResponse{
+			status: http.StatusOK,
+			header: map[string]string{
+				"Content-Type": contentType,
+			},
+			body: r,
+		}
+
 	case req.file == "":
 		return hlsMuxerResponse{
 			status: http.StatusOK,
@@ -477,21 +620,56 @@ func (m *hlsMuxer) handleRequest(req hlsMuxerRequest) hlsMuxerResponse {
 
 func (m *hlsMuxer) authenticate(req *http.Request) error {
 	pathConf := m.path.Conf()
-	pathIPs := pathConf.ReadIPs
-	pathUser := pathConf.ReadUser
-	pathPass := pathConf.ReadPass
 
-	if m.externalAuthenticationURL != "" {
+	return hlsAuthenticate(
+		m.externalAuth,
+		m.jwtValidator,
+		m.urlSigningKey,
+		m.users,
+		m.pathName,
+		pathConf.ReadIPs,
+		pathConf.ReadUser,
+		pathConf.ReadPass,
+		req)
+}
+
+// hlsAuthenticate authenticates a HTTP request that reads from a path.
+func hlsAuthenticate(
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
+	pathName string,
+	pathIPs []interface{},
+	pathUser conf.Credential,
+	pathPass conf.Credential,
+	req *http.Request,
+) error {
+	// signed URLs replace credentials
+	signed := false
+	if isSignedURL(urlSigningKey, req.URL.Query()) {
+		tmp, _, _ := net.SplitHostPort(req.RemoteAddr)
+
+		_, err := signedURLCheck(urlSigningKey, pathName, req.URL.Query(), net.ParseIP(tmp))
+		if err != nil {
+			return pathErrAuthCritical{
+				message: fmt.Sprintf("signed URL check failed: %s", err),
+			}
+		}
+
+		signed = true
+	}
+
+	if externalAuth != nil && !signed {
 		tmp, _, _ := net.SplitHostPort(req.RemoteAddr)
 		ip := net.ParseIP(tmp)
 		user, pass, _ := req.BasicAuth()
 
-		err := externalAuth(
-			m.externalAuthenticationURL,
+		err := externalAuth.authenticate(
 			ip.String(),
 			user,
 			pass,
-			m.pathName,
+			pathName,
 			"read",
 			req.URL.RawQuery)
 		if err != nil {
@@ -501,6 +679,21 @@ func (m *hlsMuxer) authenticate(req *http.Request) error {
 		}
 	}
 
+	if jwtValidator != nil && !signed {
+		// the token can be passed as query parameter or as bearer token
+		token := req.URL.Query().Get("jwt")
+		if h := req.Header.Get("Authorization"); token == "" && strings.HasPrefix(h, "Bearer ") {
+			token = h[len("Bearer "):]
+		}
+
+		_, err := jwtValidator.validate(token, pathName, "read")
+		if err != nil {
+			return pathErrAuthCritical{
+				message: fmt.Sprintf("JWT authentication failed: %s", err),
+			}
+		}
+	}
+
 	if pathIPs != nil {
 		tmp, _, _ := net.SplitHostPort(req.RemoteAddr)
 		ip := net.ParseIP(tmp)
@@ -512,7 +705,27 @@ func (m *hlsMuxer) authenticate(req *http.Request) error {
 		}
 	}
 
-	if pathUser != "" {
+	// users are checked before path credentials
+	userFound := false
+	if len(users) != 0 && !signed {
+		tmp, _, _ := net.SplitHostPort(req.RemoteAddr)
+		user, pass, _ := req.BasicAuth()
+
+		handled, err := usersAuthenticate(users, net.ParseIP(tmp), user, pass, pathName, "read")
+		if err != nil {
+			return pathErrAuthCritical{
+				message: fmt.Sprintf("authentication failed: %s", err),
+			}
+		}
+
+		if handled {
+			userFound = true
+		} else if pathUser == "" {
+			return pathErrAuthNotCritical{}
+		}
+	}
+
+	if pathUser != "" && !signed && !userFound {
 		user, pass, ok := req.BasicAuth()
 		if !ok {
 			return pathErrAuthNotCritical{}
@@ -547,6 +760,11 @@ func (m *hlsMuxer) onReaderData(data *data) {
 	m.ringBuffer.Push(data)
 }
 
+// trafficStats implements reader.
+func (m *hlsMuxer) trafficStats() *trafficStats {
+	return m.stats
+}
+
 // onReaderAPIDescribe implements reader.
 func (m *hlsMuxer) onReaderAPIDescribe() interface{} {
 	return struct {
@@ -554,6 +772,11 @@ func (m *hlsMuxer) onReaderAPIDescribe() interface{} {
 	}{"hlsMuxer"}
 }
 
+// protocol implements reader.
+func (*hlsMuxer) protocol() string {
+	return "hls"
+}
+
 // onAPIHLSMuxersList is called by api.
 func (m *hlsMuxer) onAPIHLSMuxersList(req hlsServerAPIMuxersListSubReq) {
 	req.res = make(chan struct{})
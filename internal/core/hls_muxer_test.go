@@ -0,0 +1,55 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHLSPlaylistWithQuery(t *testing.T) {
+	playlist := "#EXTM3U\n" +
+		"stream.m3u8\n" +
+		"segment.ts?a=1\n"
+
+	q := signedURLQuery("mykey", "mypath", time.Now().Add(time.Hour), "")
+
+	for _, ca := range []struct {
+		name     string
+		key      string
+		rawQuery string
+		out      string
+	}{
+		{
+			"signed",
+			"mykey",
+			q.Encode(),
+			"#EXTM3U\n" +
+				"stream.m3u8?" + q.Encode() + "\n" +
+				"segment.ts?a=1&" + q.Encode() + "\n",
+		},
+		{
+			"not signed",
+			"mykey",
+			"jwt=mytoken",
+			playlist,
+		},
+		{
+			"signing disabled",
+			"",
+			q.Encode(),
+			playlist,
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			r := hlsPlaylistWithQuery(bytes.NewReader([]byte(playlist)), ca.key,
+				&url.URL{Path: "/mypath/index.m3u8", RawQuery: ca.rawQuery})
+			byts, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, ca.out, string(byts))
+		})
+	}
+}
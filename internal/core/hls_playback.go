@@ -0,0 +1,143 @@
+package core
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	hlsPlaybackPlaylist      = "playback.m3u8"
+	hlsPlaybackSegmentPrefix = "playback_"
+)
+
+// handlePlayback serves recordings of a path, through a VOD playlist
+// that lists the segments between the "start" and "end" query parameters.
+func (s *hlsServer) handlePlayback(req hlsMuxerRequest) hlsMuxerResponse {
+	res := s.pathManager.onPlayback(pathPlaybackReq{
+		pathName: req.dir,
+		authenticate: func(
+			pathIPs []interface{},
+			pathUser conf.Credential,
+			pathPass conf.Credential,
+		) error {
+			return hlsAuthenticate(
+				s.externalAuth,
+				s.jwtValidator,
+				s.urlSigningKey,
+				s.users,
+				req.dir,
+				pathIPs,
+				pathUser,
+				pathPass,
+				req.req)
+		},
+	})
+
+	if res.err != nil {
+		switch terr := res.err.(type) {
+		case pathErrAuthNotCritical:
+			return hlsMuxerResponse{
+				status: http.StatusUnauthorized,
+				header: map[string]string{
+					"WWW-Authenticate": `Basic realm="rtsp-simple-server"`,
+				},
+			}
+
+		case pathErrAuthCritical:
+			s.log(logger.Info, "[playback %s] authentication error: %s", req.dir, terr.message)
+
+			tmp, _, _ := net.SplitHostPort(req.req.RemoteAddr)
+			s.accessLimiter.onAuthFailure(net.ParseIP(tmp), "hls")
+
+			return hlsMuxerResponse{status: http.StatusUnauthorized}
+
+		default:
+			return hlsMuxerResponse{status: http.StatusNotFound}
+		}
+	}
+
+	if req.file == hlsPlaybackPlaylist {
+		return s.handlePlaybackPlaylist(req)
+	}
+
+	return s.handlePlaybackSegment(req)
+}
+
+func (s *hlsServer) handlePlaybackPlaylist(req hlsMuxerRequest) hlsMuxerResponse {
+	start, end, err := recordingRangeFromQuery(req.req.URL.Query())
+	if err != nil {
+		s.log(logger.Debug, "[playback %s] %v", req.dir, err)
+		return hlsMuxerResponse{status: http.StatusBadRequest}
+	}
+
+	segs, err := recordingSegments(s.recordPath, req.dir)
+	if err != nil {
+		s.log(logger.Warn, "[playback %s] %v", req.dir, err)
+		return hlsMuxerResponse{status: http.StatusInternalServerError}
+	}
+
+	segs = recordingSegmentsInRange(segs, start, end)
+	if len(segs) == 0 {
+		return hlsMuxerResponse{status: http.StatusNotFound}
+	}
+
+	vodSegs := make([]hls.VODSegment, len(segs))
+	for i, seg := range segs {
+		vodSegs[i] = hls.VODSegment{
+			StartTime:     seg.start,
+			Duration:      seg.duration,
+			URI:           hlsPlaybackSegmentPrefix + filepath.Base(seg.fpath),
+			Discontinuity: i != 0 && !recordingContiguous(segs[i-1], seg),
+		}
+	}
+
+	var startOffset time.Duration
+	if start.After(segs[0].start) {
+		startOffset = start.Sub(segs[0].start)
+	}
+
+	return hlsMuxerResponse{
+		status: http.StatusOK,
+		header: map[string]string{
+			"Content-Type": `application/x-mpegURL`,
+		},
+		body: hlsPlaylistWithQuery(bytes.NewReader(hls.VODPlaylist(vodSegs, startOffset)),
+			s.urlSigningKey, req.req.URL),
+	}
+}
+
+func (s *hlsServer) handlePlaybackSegment(req hlsMuxerRequest) hlsMuxerResponse {
+	name := req.file[len(hlsPlaybackSegmentPrefix):]
+
+	// the name is validated in order to serve segments only
+	_, _, err := recordingSegmentParse(name)
+	if err != nil {
+		return hlsMuxerResponse{status: http.StatusNotFound}
+	}
+
+	dir, err := recordingDir(s.recordPath, req.dir)
+	if err != nil {
+		return hlsMuxerResponse{status: http.StatusNotFound}
+	}
+
+	byts, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return hlsMuxerResponse{status: http.StatusNotFound}
+	}
+
+	return hlsMuxerResponse{
+		status: http.StatusOK,
+		header: map[string]string{
+			"Content-Type": `video/MP2T`,
+		},
+		body: bytes.NewReader(byts),
+	}
+}
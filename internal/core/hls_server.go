@@ -6,7 +6,7 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"net/http/httputil"
+	"os"
 	gopath "path"
 	"strings"
 	"sync"
@@ -19,6 +19,7 @@ import (
 
 type hlsServerAPIMuxersListItem struct {
 	LastRequest string `json:"lastRequest"`
+	trafficStatsData
 }
 
 type hlsServerAPIMuxersListData struct {
@@ -45,16 +46,24 @@ type hlsServerParent interface {
 }
 
 type hlsServer struct {
-	externalAuthenticationURL string
-	hlsAlwaysRemux            bool
-	hlsSegmentCount           int
-	hlsSegmentDuration        conf.StringDuration
-	hlsSegmentMaxSize         conf.StringSize
-	hlsAllowOrigin            string
-	readBufferCount           int
-	pathManager               *pathManager
-	metrics                   *metrics
-	parent                    hlsServerParent
+	externalAuth       *externalAuthenticator
+	jwtValidator       *jwtValidator
+	urlSigningKey      string
+	users              map[string]*conf.User
+	accessLimiter      *accessLimiter
+	hlsAlwaysRemux     bool
+	hlsSegmentCount    int
+	hlsSegmentDuration conf.StringDuration
+	hlsSegmentMaxSize  conf.StringSize
+	hlsAllowOrigin     string
+	hlsDVRWindow       conf.StringDuration
+	hlsDVRPath         string
+	dashEnable         bool
+	readBufferCount    int
+	recordPath         string
+	pathManager        *pathManager
+	metrics            *metrics
+	parent             hlsServerParent
 
 	ctx       context.Context
 	ctxCancel func()
@@ -66,18 +75,27 @@ type hlsServer struct {
 	pathSourceReady chan *path
 	request         chan hlsMuxerRequest
 	muxerClose      chan *hlsMuxer
+	apiMuxersList   chan hlsServerAPIMuxersListReq
 }
 
 func newHLSServer(
 	parentCtx context.Context,
 	address string,
-	externalAuthenticationURL string,
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
+	accessLimiter *accessLimiter,
 	hlsAlwaysRemux bool,
 	hlsSegmentCount int,
 	hlsSegmentDuration conf.StringDuration,
 	hlsSegmentMaxSize conf.StringSize,
 	hlsAllowOrigin string,
+	hlsDVRWindow conf.StringDuration,
+	hlsDVRPath string,
+	dashEnable bool,
 	readBufferCount int,
+	recordPath string,
 	pathManager *pathManager,
 	metrics *metrics,
 	parent hlsServerParent,
@@ -86,27 +104,37 @@ func newHLSServer(
 	if err != nil {
 		return nil, err
 	}
+	ln = accessLimiter.listener(ln)
 
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &hlsServer{
-		externalAuthenticationURL: externalAuthenticationURL,
-		hlsAlwaysRemux:            hlsAlwaysRemux,
-		hlsSegmentCount:           hlsSegmentCount,
-		hlsSegmentDuration:        hlsSegmentDuration,
-		hlsSegmentMaxSize:         hlsSegmentMaxSize,
-		hlsAllowOrigin:            hlsAllowOrigin,
-		readBufferCount:           readBufferCount,
-		pathManager:               pathManager,
-		parent:                    parent,
-		metrics:                   metrics,
-		ctx:                       ctx,
-		ctxCancel:                 ctxCancel,
-		ln:                        ln,
-		muxers:                    make(map[string]*hlsMuxer),
-		pathSourceReady:           make(chan *path),
-		request:                   make(chan hlsMuxerRequest),
-		muxerClose:                make(chan *hlsMuxer),
+		externalAuth:       externalAuth,
+		jwtValidator:       jwtValidator,
+		urlSigningKey:      urlSigningKey,
+		users:              users,
+		accessLimiter:      accessLimiter,
+		hlsAlwaysRemux:     hlsAlwaysRemux,
+		hlsSegmentCount:    hlsSegmentCount,
+		hlsSegmentDuration: hlsSegmentDuration,
+		hlsSegmentMaxSize:  hlsSegmentMaxSize,
+		hlsAllowOrigin:     hlsAllowOrigin,
+		hlsDVRWindow:       hlsDVRWindow,
+		hlsDVRPath:         hlsDVRPath,
+		dashEnable:         dashEnable,
+		readBufferCount:    readBufferCount,
+		recordPath:         recordPath,
+		pathManager:        pathManager,
+		parent:             parent,
+		metrics:            metrics,
+		ctx:                ctx,
+		ctxCancel:          ctxCancel,
+		ln:                 ln,
+		muxers:             make(map[string]*hlsMuxer),
+		pathSourceReady:    make(chan *path),
+		request:            make(chan hlsMuxerRequest),
+		muxerClose:         make(chan *hlsMuxer),
+		apiMuxersList:      make(chan hlsServerAPIMuxersListReq),
 	}
 
 	s.log(logger.Info, "listener opened on "+address)
@@ -160,6 +188,18 @@ outer:
 				continue
 			}
 			delete(s.muxers, c.PathName())
+
+		case req := <-s.apiMuxersList:
+			muxers := make(map[string]*hlsMuxer)
+
+			for name, m := range s.muxers {
+				muxers[name] = m
+			}
+
+			req.res <- hlsServerAPIMuxersListRes{
+				muxers: muxers,
+			}
+
 		case <-s.ctx.Done():
 			break outer
 		}
@@ -179,8 +219,7 @@ outer:
 func (s *hlsServer) onRequest(ctx *gin.Context) {
 	s.log(logger.Info, "[conn %v] %s %s", ctx.Request.RemoteAddr, ctx.Request.Method, ctx.Request.URL.Path)
 
-	byts, _ := httputil.DumpRequest(ctx.Request, true)
-	s.log(logger.Debug, "[conn %v] [c->s] %s", ctx.Request.RemoteAddr, string(byts))
+	s.log(logger.Debug, "[conn %v] [c->s] %s", ctx.Request.RemoteAddr, httpDumpRequest(ctx.Request))
 
 	logw := &httpLogWriter{ResponseWriter: ctx.Writer}
 	ctx.Writer = logw
@@ -189,6 +228,13 @@ func (s *hlsServer) onRequest(ctx *gin.Context) {
 	ctx.Writer.Header().Set("Access-Control-Allow-Origin", s.hlsAllowOrigin)
 	ctx.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
+	// connections opened before a ban are kept alive by clients
+	tmp, _, _ := net.SplitHostPort(ctx.Request.RemoteAddr)
+	if s.accessLimiter.isBanned(net.ParseIP(tmp)) {
+		ctx.Writer.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	switch ctx.Request.Method {
 	case http.MethodGet:
 
@@ -212,14 +258,21 @@ func (s *hlsServer) onRequest(ctx *gin.Context) {
 		return
 	}
 
+	mpegts := isMPEGTSReaderRequest(pa)
+
 	dir, fname := func() (string, string) {
-		if strings.HasSuffix(pa, ".ts") || strings.HasSuffix(pa, ".m3u8") {
+		if mpegts {
+			return strings.TrimSuffix(pa, ".ts"), ""
+		}
+		if strings.HasSuffix(pa, ".ts") || strings.HasSuffix(pa, ".m3u8") ||
+			strings.HasSuffix(pa, ".mpd") || strings.HasSuffix(pa, ".m4s") || strings.HasSuffix(pa, ".mp4") ||
+			strings.HasSuffix(pa, ".mjpeg") {
 			return gopath.Dir(pa), gopath.Base(pa)
 		}
 		return pa, ""
 	}()
 
-	if fname == "" && !strings.HasSuffix(dir, "/") {
+	if fname == "" && !mpegts && !strings.HasSuffix(dir, "/") {
 		ctx.Writer.Header().Set("Location", "/"+dir+"/")
 		ctx.Writer.WriteHeader(http.StatusMovedPermanently)
 		return
@@ -235,23 +288,106 @@ func (s *hlsServer) onRequest(ctx *gin.Context) {
 		res:  cres,
 	}
 
-	select {
-	case s.request <- hreq:
-		res := <-cres
+	switch {
+	case fname == hlsPlaybackPlaylist || strings.HasPrefix(fname, hlsPlaybackSegmentPrefix):
+		s.writeResponse(ctx, s.handlePlayback(hreq))
+
+	case fname == mjpegReaderFile:
+		s.handleMJPEG(ctx, hreq)
+
+	case mpegts:
+		s.handleMPEGTS(ctx, hreq)
+
+	default:
+		select {
+		case s.request <- hreq:
+			s.writeResponse(ctx, <-cres)
 
-		for k, v := range res.header {
-			ctx.Writer.Header().Set(k, v)
+		case <-s.ctx.Done():
 		}
-		ctx.Writer.WriteHeader(res.status)
+	}
+
+	s.log(logger.Debug, "[conn %v] [s->c] %s", ctx.Request.RemoteAddr, logw.dump())
+}
+
+func (s *hlsServer) writeResponse(ctx *gin.Context, res hlsMuxerResponse) {
+	for k, v := range res.header {
+		ctx.Writer.Header().Set(k, v)
+	}
+
+	// files on disk (i.e. segments of the DVR) are streamed
+	// instead of being loaded into memory.
+	if f, ok := res.body.(*os.File); ok {
+		defer f.Close()
 
-		if res.body != nil {
-			io.Copy(ctx.Writer, res.body)
+		if res.status == http.StatusOK {
+			if fi, err := f.Stat(); err == nil {
+				http.ServeContent(ctx.Writer, ctx.Request, "", fi.ModTime(), f)
+				return
+			}
 		}
+	}
 
-	case <-s.ctx.Done():
+	ctx.Writer.WriteHeader(res.status)
+
+	if res.body != nil {
+		io.Copy(ctx.Writer, res.body)
+	}
+}
+
+// readerSetupPlay adds a reader that is served directly by the server
+// (i.e. a MJPEG or MPEG-TS client) to a path.
+// If the path can't be read, a response is written and false is returned.
+func (s *hlsServer) readerSetupPlay(
+	ctx *gin.Context,
+	req hlsMuxerRequest,
+	r reader,
+) (pathReaderSetupPlayRes, bool) {
+	res := s.pathManager.onReaderSetupPlay(pathReaderSetupPlayReq{
+		author:   r,
+		pathName: req.dir,
+		authenticate: func(
+			pathIPs []interface{},
+			pathUser conf.Credential,
+			pathPass conf.Credential,
+		) error {
+			return hlsAuthenticate(
+				s.externalAuth,
+				s.jwtValidator,
+				s.urlSigningKey,
+				s.users,
+				req.dir,
+				pathIPs,
+				pathUser,
+				pathPass,
+				req.req)
+		},
+	})
+	if res.err != nil {
+		switch terr := res.err.(type) {
+		case pathErrAuthNotCritical:
+			s.writeResponse(ctx, hlsMuxerResponse{
+				status: http.StatusUnauthorized,
+				header: map[string]string{
+					"WWW-Authenticate": `Basic realm="rtsp-simple-server"`,
+				},
+			})
+
+		case pathErrAuthCritical:
+			s.log(logger.Info, "[conn %v] authentication error: %s", req.req.RemoteAddr, terr.message)
+
+			tmp, _, _ := net.SplitHostPort(req.req.RemoteAddr)
+			s.accessLimiter.onAuthFailure(net.ParseIP(tmp), "hls")
+
+			s.writeResponse(ctx, hlsMuxerResponse{status: http.StatusUnauthorized})
+
+		default:
+			s.writeResponse(ctx, hlsMuxerResponse{status: http.StatusNotFound})
+		}
+		return res, false
 	}
 
-	s.log(logger.Debug, "[conn %v] [s->c] %s", ctx.Request.RemoteAddr, logw.dump())
+	return res, true
 }
 
 func (s *hlsServer) findOrCreateMuxer(pathName string) *hlsMuxer {
@@ -260,15 +396,23 @@ func (s *hlsServer) findOrCreateMuxer(pathName string) *hlsMuxer {
 		r = newHLSMuxer(
 			s.ctx,
 			pathName,
-			s.externalAuthenticationURL,
+			s.externalAuth,
+			s.jwtValidator,
+			s.urlSigningKey,
+			s.users,
+			s.accessLimiter,
 			s.hlsAlwaysRemux,
 			s.hlsSegmentCount,
 			s.hlsSegmentDuration,
 			s.hlsSegmentMaxSize,
+			s.hlsDVRWindow,
+			s.hlsDVRPath,
+			s.dashEnable,
 			s.readBufferCount,
 			&s.wg,
 			pathName,
 			s.pathManager,
+			s.metrics,
 			s)
 		s.muxers[pathName] = r
 	}
@@ -295,6 +439,19 @@ func (s *hlsServer) onPathSourceReady(pa *path) {
 func (s *hlsServer) onAPIHLSMuxersList(req hlsServerAPIMuxersListReq) hlsServerAPIMuxersListRes {
 	req.res = make(chan hlsServerAPIMuxersListRes)
 	select {
+	case s.apiMuxersList <- req:
+		res := <-req.res
+
+		res.data = &hlsServerAPIMuxersListData{
+			Items: make(map[string]hlsServerAPIMuxersListItem),
+		}
+
+		for _, m := range res.muxers {
+			m.onAPIHLSMuxersList(hlsServerAPIMuxersListSubReq{data: res.data})
+		}
+
+		return res
+
 	case <-s.ctx.Done():
 		return hlsServerAPIMuxersListRes{err: fmt.Errorf("terminated")}
 	}
@@ -22,6 +22,7 @@ type hlsSourceParent interface {
 	log(logger.Level, string, ...interface{})
 	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
 	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
 }
 
 type hlsSource struct {
@@ -199,12 +200,14 @@ func (s *hlsSource) runInner() bool {
 	)
 	if err != nil {
 		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
 		return true
 	}
 
 	select {
 	case err := <-c.Wait():
 		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
 		return true
 
 	case <-s.ctx.Done():
@@ -220,3 +223,8 @@ func (*hlsSource) onSourceAPIDescribe() interface{} {
 		Type string `json:"type"`
 	}{"hlsSource"}
 }
+
+// protocol implements source.
+func (*hlsSource) protocol() string {
+	return "hls"
+}
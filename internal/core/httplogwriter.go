@@ -4,22 +4,59 @@ import (
 	"bytes"
 	"fmt"
 	"net/http"
+	"net/http/httputil"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// query parameters that contain credentials: JWTs and URL signatures.
+var httpLogRedactedParams = []string{"jwt", "sig"}
+
+// httpDumpRequest returns the dump of a request, without credentials.
+func httpDumpRequest(r *http.Request) string {
+	r2 := r.Clone(r.Context())
+
+	if v := r2.Header.Get("Authorization"); v != "" {
+		r2.Header.Set("Authorization", strings.SplitN(v, " ", 2)[0]+" [redacted]")
+	}
+
+	query := r2.URL.Query()
+	redacted := false
+	for _, key := range httpLogRedactedParams {
+		if _, ok := query[key]; ok {
+			query.Set(key, "[redacted]")
+			redacted = true
+		}
+	}
+	if redacted {
+		r2.URL.RawQuery = query.Encode()
+		r2.RequestURI = r2.URL.RequestURI()
+	}
+
+	byts, _ := httputil.DumpRequest(r2, true)
+
+	// the body has been read from the clone, give it back to the request
+	r.Body = r2.Body
+
+	return string(byts)
+}
+
 type httpLogWriter struct {
 	gin.ResponseWriter
-	buf bytes.Buffer
+
+	// only the size of the body is logged, therefore the body is not stored,
+	// since it can be a stream of indefinite length.
+	size int
 }
 
 func (w *httpLogWriter) Write(b []byte) (int, error) {
-	w.buf.Write(b)
+	w.size += len(b)
 	return w.ResponseWriter.Write(b)
 }
 
 func (w *httpLogWriter) WriteString(s string) (int, error) {
-	w.buf.WriteString(s)
+	w.size += len(s)
 	return w.ResponseWriter.WriteString(s)
 }
 
@@ -28,8 +65,8 @@ func (w *httpLogWriter) dump() string {
 	fmt.Fprintf(&buf, "%s %d %s\n", "HTTP/1.1", w.ResponseWriter.Status(), http.StatusText(w.ResponseWriter.Status()))
 	w.ResponseWriter.Header().Write(&buf)
 	buf.Write([]byte("\n"))
-	if w.buf.Len() > 0 {
-		fmt.Fprintf(&buf, "(body of %d bytes)", w.buf.Len())
+	if w.size > 0 {
+		fmt.Fprintf(&buf, "(body of %d bytes)", w.size)
 	}
 	return buf.String()
 }
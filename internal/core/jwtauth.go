@@ -0,0 +1,177 @@
+package core
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	gopath "path"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// authPermission grants an action on the paths that match a glob pattern.
+type authPermission struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+}
+
+// authPermissionsAllow returns whether a list of permissions grants an action on a path.
+func authPermissionsAllow(perms []authPermission, pathName string, action string) bool {
+	for _, p := range perms {
+		if p.Action != action {
+			continue
+		}
+
+		if p.Path == "" {
+			return true
+		}
+
+		if ok, _ := gopath.Match(p.Path, pathName); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Permissions []authPermission `json:"permissions"`
+}
+
+type jwtJWKS struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func loadJWKS(fpath string) (map[string]*rsa.PublicKey, error) {
+	byts, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks jwtJWKS
+	err = json.Unmarshal(byts, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %s", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS: key '%s': %s", k.Kid, err)
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS: key '%s': %s", k.Kid, err)
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS does not contain any RSA key")
+	}
+
+	return keys, nil
+}
+
+// jwtValidator validates JSON Web Tokens signed with HS256 or RS256.
+type jwtValidator struct {
+	secret []byte
+	keys   map[string]*rsa.PublicKey
+}
+
+func newJWTValidator(secret string, jwksPath string) (*jwtValidator, error) {
+	v := &jwtValidator{}
+
+	if secret != "" {
+		v.secret = []byte(secret)
+	}
+
+	if jwksPath != "" {
+		var err error
+		v.keys, err = loadJWKS(jwksPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return v, nil
+}
+
+func (v *jwtValidator) keyFunc(t *jwt.Token) (interface{}, error) {
+	switch t.Method.Alg() {
+	case "HS256":
+		if v.secret == nil {
+			return nil, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		return v.secret, nil
+
+	case "RS256":
+		if v.keys == nil {
+			return nil, fmt.Errorf("RS256 tokens are not accepted")
+		}
+
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" && len(v.keys) == 1 {
+			for _, k := range v.keys {
+				return k, nil
+			}
+		}
+
+		k, ok := v.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("key '%s' not found", kid)
+		}
+		return k, nil
+	}
+
+	return nil, fmt.Errorf("unsupported algorithm: %s", t.Method.Alg())
+}
+
+// validate checks that a token grants an action on a path.
+// It returns the expiration time of the token, that is zero if the token doesn't expire.
+func (v *jwtValidator) validate(token string, pathName string, action string) (time.Time, error) {
+	if token == "" {
+		return time.Time{}, fmt.Errorf("token not provided")
+	}
+
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, v.keyFunc,
+		jwt.WithValidMethods([]string{"HS256", "RS256"}))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if authPermissionsAllow(claims.Permissions, pathName, action) {
+		return jwtExpiry(&claims), nil
+	}
+
+	return time.Time{}, fmt.Errorf("token does not allow to %s path '%s'", action, pathName)
+}
+
+func jwtExpiry(claims *jwtClaims) time.Time {
+	if claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+	return claims.ExpiresAt.Time
+}
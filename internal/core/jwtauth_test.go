@@ -0,0 +1,91 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJWTValidatorHS256(t *testing.T) {
+	v, err := newJWTValidator("mysecret", "")
+	require.NoError(t, err)
+
+	sign := func(claims jwtClaims) string {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("mysecret"))
+		require.NoError(t, err)
+		return tok
+	}
+
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	tok := sign(jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(exp)},
+		Permissions: []authPermission{
+			{Action: "publish", Path: "cam*"},
+			{Action: "read", Path: ""},
+		},
+	})
+
+	ret, err := v.validate(tok, "cam1", "publish")
+	require.NoError(t, err)
+	require.True(t, exp.Equal(ret))
+
+	_, err = v.validate(tok, "other", "publish")
+	require.EqualError(t, err, "token does not allow to publish path 'other'")
+
+	_, err = v.validate(tok, "other", "read")
+	require.NoError(t, err)
+
+	expired := sign(jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Hour))},
+		Permissions:      []authPermission{{Action: "read"}},
+	})
+	_, err = v.validate(expired, "cam1", "read")
+	require.Error(t, err)
+
+	_, err = v.validate("", "cam1", "read")
+	require.EqualError(t, err, "token not provided")
+}
+
+func TestJWTValidatorRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := `{"keys":[{"kty":"RSA","kid":"mykey","n":"` +
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()) + `","e":"` +
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()) + `"}]}`
+
+	fpath, err := writeTempFile([]byte(jwks))
+	require.NoError(t, err)
+	defer os.Remove(fpath)
+
+	v, err := newJWTValidator("", fpath)
+	require.NoError(t, err)
+
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, jwtClaims{
+		Permissions: []authPermission{{Action: "read", Path: "cam1"}},
+	})
+	tok.Header["kid"] = "mykey"
+	signed, err := tok.SignedString(key)
+	require.NoError(t, err)
+
+	ret, err := v.validate(signed, "cam1", "read")
+	require.NoError(t, err)
+	require.True(t, ret.IsZero())
+
+	// HS256 tokens are rejected when a secret is not set
+	hs, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims{
+		Permissions: []authPermission{{Action: "read"}},
+	}).SignedString([]byte("mysecret"))
+	require.NoError(t, err)
+
+	_, err = v.validate(hs, "cam1", "read")
+	require.Error(t, err)
+}
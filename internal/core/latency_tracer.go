@@ -0,0 +1,208 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// percentiles are computed over the latest samples.
+	latencyStatsWindowSize = 1024
+
+	// protocol used for the camera WebSocket pipeline.
+	latencyProtocolCamera = "camera"
+)
+
+// latencyTracer keeps the time in which cameras started sending data through
+// the camera WebSocket server, by path name.
+// It is shared by all paths and survives configuration reloads.
+type latencyTracer struct {
+	mutex   sync.Mutex
+	cameras map[string]time.Time
+}
+
+func newLatencyTracer() *latencyTracer {
+	return &latencyTracer{
+		cameras: make(map[string]time.Time),
+	}
+}
+
+// onCameraStart is called when the first message of a camera is received.
+func (t *latencyTracer) onCameraStart(pathName string, now time.Time) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.cameras[pathName] = now
+}
+
+// onCameraStop is called when a camera disconnects.
+func (t *latencyTracer) onCameraStop(pathName string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.cameras, pathName)
+}
+
+func (t *latencyTracer) cameraStart(pathName string) (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	start, ok := t.cameras[pathName]
+	return start, ok
+}
+
+// latencyStatsData is the API representation of the latency of a protocol.
+// Durations are in seconds.
+type latencyStatsData struct {
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+
+	// used by metrics
+	sum float64
+}
+
+// latencySamples stores the latest samples of a protocol.
+// It can be written by multiple readers concurrently without locks.
+type latencySamples struct {
+	count  uint64
+	sum    int64
+	values [latencyStatsWindowSize]int64
+}
+
+func (s *latencySamples) add(v time.Duration) {
+	n := atomic.AddUint64(&s.count, 1)
+	atomic.StoreInt64(&s.values[(n-1)%latencyStatsWindowSize], int64(v))
+	atomic.AddInt64(&s.sum, int64(v))
+}
+
+func (s *latencySamples) get() latencyStatsData {
+	count := atomic.LoadUint64(&s.count)
+
+	size := count
+	if size > latencyStatsWindowSize {
+		size = latencyStatsWindowSize
+	}
+
+	sorted := make([]time.Duration, size)
+	for i := range sorted {
+		sorted[i] = time.Duration(atomic.LoadInt64(&s.values[i]))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	percentile := func(p float64) float64 {
+		if len(sorted) == 0 {
+			return 0
+		}
+		return sorted[int(p*float64(len(sorted)-1))].Seconds()
+	}
+
+	return latencyStatsData{
+		Count: count,
+		P50:   percentile(0.5),
+		P90:   percentile(0.9),
+		P99:   percentile(0.99),
+		Max:   percentile(1),
+		sum:   time.Duration(atomic.LoadInt64(&s.sum)).Seconds(),
+	}
+}
+
+// latencyStats collects the latency of a path, that is the time between the
+// reception of an access unit by the server and its delivery to readers,
+// by protocol. Access units are stamped with the reception time by sources,
+// and are measured when the last packet of the access unit is written to
+// the connection of the reader or, with RTSP readers, when it is handed to
+// gortsplib, that writes it to the connection asynchronously.
+//
+// If the path is fed by the camera WebSocket pipeline, the latency of the
+// pipeline is estimated too, with the time in which the camera started
+// sending data and the timestamps of H264 access units, since FFmpeg uses the
+// wallclock of incoming data as timestamps.
+type latencyStats struct {
+	pathName string
+	tracer   *latencyTracer
+
+	// samples are stored without locks, since they are written by readers
+	protocols sync.Map // map[string]*latencySamples
+
+	mutex          sync.Mutex
+	cameraStart    time.Time
+	cameraFirstPTS *time.Duration
+}
+
+func newLatencyStats(pathName string, tracer *latencyTracer) *latencyStats {
+	return &latencyStats{
+		pathName: pathName,
+		tracer:   tracer,
+	}
+}
+
+func (s *latencyStats) add(protocol string, v time.Duration) {
+	if v < 0 {
+		v = 0
+	}
+
+	samples, ok := s.protocols.Load(protocol)
+	if !ok {
+		samples, _ = s.protocols.LoadOrStore(protocol, &latencySamples{})
+	}
+	samples.(*latencySamples).add(v)
+}
+
+// onSourceReady is called when a source starts publishing to the path.
+func (s *latencyStats) onSourceReady() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.cameraStart, _ = s.tracer.cameraStart(s.pathName)
+	s.cameraFirstPTS = nil
+}
+
+// onDataReceived is called when a unit of data is written into the stream.
+func (s *latencyStats) onDataReceived(data *data) {
+	if data.h264NALUs == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.cameraStart.IsZero() {
+		return
+	}
+
+	if s.cameraFirstPTS == nil {
+		v := data.h264PTS
+		s.cameraFirstPTS = &v
+	}
+
+	received := s.cameraStart.Add(data.h264PTS - *s.cameraFirstPTS)
+	s.add(latencyProtocolCamera, data.ingestTime.Sub(received))
+}
+
+// onDataSent is called when a unit of data has been written to the connection of a reader.
+func (s *latencyStats) onDataSent(protocol string, data *data) {
+	if !data.rtp.Marker || data.ingestTime.IsZero() {
+		return
+	}
+
+	s.onSent(protocol, time.Now(), data.ingestTime)
+}
+
+// onSent is called when an access unit received at the given time is sent.
+func (s *latencyStats) onSent(protocol string, now time.Time, ingestTime time.Time) {
+	s.add(protocol, now.Sub(ingestTime))
+}
+
+// get returns the latency of every protocol.
+func (s *latencyStats) get() map[string]latencyStatsData {
+	ret := make(map[string]latencyStatsData)
+	s.protocols.Range(func(key, value interface{}) bool {
+		ret[key.(string)] = value.(*latencySamples).get()
+		return true
+	})
+	return ret
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyStats(t *testing.T) {
+	tracer := newLatencyTracer()
+	s := newLatencyStats("cam1", tracer)
+
+	now := time.Now()
+	for i := 1; i <= 100; i++ {
+		s.onSent("rtsp", now.Add(time.Duration(i)*time.Millisecond), now)
+	}
+	s.onSent("hls", now.Add(2*time.Second), now)
+
+	ld := s.get()
+	require.Equal(t, uint64(100), ld["rtsp"].Count)
+	require.Equal(t, 0.05, ld["rtsp"].P50)
+	require.Equal(t, 0.09, ld["rtsp"].P90)
+	require.Equal(t, 0.099, ld["rtsp"].P99)
+	require.Equal(t, 0.1, ld["rtsp"].Max)
+	require.InDelta(t, 5.05, ld["rtsp"].sum, 0.000001)
+	require.Equal(t, uint64(1), ld["hls"].Count)
+	require.Equal(t, 2.0, ld["hls"].P50)
+
+	// only the last packet of an access unit is measured
+	d := &data{
+		rtp:        &rtp.Packet{Header: rtp.Header{Marker: false}},
+		ingestTime: now,
+	}
+	s.onDataSent("rtmp", d)
+	require.NotContains(t, s.get(), "rtmp")
+
+	d.rtp.Marker = true
+	s.onDataSent("rtmp", d)
+	require.Equal(t, uint64(1), s.get()["rtmp"].Count)
+}
+
+func TestLatencyStatsWindow(t *testing.T) {
+	s := newLatencyStats("cam1", newLatencyTracer())
+
+	now := time.Now()
+	for i := 0; i < latencyStatsWindowSize; i++ {
+		s.onSent("rtsp", now.Add(time.Second), now)
+	}
+	for i := 0; i < latencyStatsWindowSize; i++ {
+		s.onSent("rtsp", now.Add(time.Millisecond), now)
+	}
+
+	// percentiles are computed over the latest samples
+	ld := s.get()["rtsp"]
+	require.Equal(t, uint64(2*latencyStatsWindowSize), ld.Count)
+	require.Equal(t, 0.001, ld.Max)
+}
+
+func TestLatencyStatsConcurrent(t *testing.T) {
+	s := newLatencyStats("cam1", newLatencyTracer())
+
+	var wg sync.WaitGroup
+	now := time.Now()
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.onSent("rtmp", now.Add(time.Millisecond), now)
+				s.get()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	ld := s.get()["rtmp"]
+	require.Equal(t, uint64(4000), ld.Count)
+	require.Equal(t, 0.001, ld.Max)
+}
+
+func TestLatencyStatsCamera(t *testing.T) {
+	tracer := newLatencyTracer()
+	s := newLatencyStats("cam1", tracer)
+
+	start := time.Now().Add(-500 * time.Millisecond)
+	tracer.onCameraStart("cam1", start)
+	s.onSourceReady()
+
+	s.onDataReceived(&data{
+		rtp:        &rtp.Packet{Header: rtp.Header{Marker: true}},
+		h264NALUs:  [][]byte{{0x05}},
+		h264PTS:    10 * time.Second,
+		ingestTime: time.Now(),
+	})
+
+	// the first access unit is received 500ms after the first message
+	ld := s.get()[latencyProtocolCamera]
+	require.Equal(t, uint64(1), ld.Count)
+	require.InDelta(t, 0.5, ld.P50, 0.1)
+
+	tracer.onCameraStop("cam1")
+	s.onSourceReady()
+
+	s.onDataReceived(&data{
+		rtp:        &rtp.Packet{Header: rtp.Header{Marker: true}},
+		h264NALUs:  [][]byte{{0x05}},
+		ingestTime: time.Now(),
+	})
+	require.Equal(t, uint64(1), s.get()[latencyProtocolCamera].Count)
+}
@@ -5,17 +5,20 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"strconv"
 	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
-func metric(key string, value int64) string {
-	return key + " " + strconv.FormatInt(value, 10) + "\n"
-}
+// buckets of session durations, in seconds.
+var metricsSessionDurationBuckets = []float64{1, 10, 60, 300, 900, 3600, 4 * 3600, 24 * 3600}
+
+// buckets of HLS segment generation times, in seconds.
+var metricsHLSSegmentBuckets = []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32}
 
 type metricsPathManager interface {
 	onAPIPathsList(req pathAPIPathsListReq) pathAPIPathsListRes
@@ -33,45 +36,80 @@ type metricsHLSServer interface {
 	onAPIHLSMuxersList(req hlsServerAPIMuxersListReq) hlsServerAPIMuxersListRes
 }
 
+type metricsExternalAuthenticator interface {
+	statsGet() externalAuthStats
+}
+
+type metricsAccessLimiter interface {
+	statsGet() accessLimiterStats
+}
+
+type metricsWebhookSender interface {
+	statsGet() webhookSenderStats
+}
+
 type metricsParent interface {
 	Log(logger.Level, string, ...interface{})
 }
 
 type metrics struct {
-	parent metricsParent
-
-	ln          net.Listener
-	server      *http.Server
-	mutex       sync.Mutex
-	pathManager metricsPathManager
-	rtspServer  metricsRTSPServer
-	rtspsServer metricsRTSPServer
-	rtmpServer  metricsRTMPServer
-	hlsServer   metricsHLSServer
+	address    string
+	users      map[string]*conf.APIUser
+	encryption bool
+	serverKey  string
+	serverCert string
+	parent     metricsParent
+
+	server        *http.Server
+	mutex         sync.Mutex
+	pathManager   metricsPathManager
+	rtspServer    metricsRTSPServer
+	rtspsServer   metricsRTSPServer
+	rtmpServer    metricsRTMPServer
+	hlsServer     metricsHLSServer
+	externalAuth  metricsExternalAuthenticator
+	accessLimiter metricsAccessLimiter
+	webhookSender metricsWebhookSender
+
+	statsMutex                sync.Mutex
+	readerSessionDurations    map[string]*metricsHistogram
+	publisherSessionDurations map[string]*metricsHistogram
+	hlsSegmentDuration        *metricsHistogram
 }
 
 func newMetrics(
 	address string,
+	users map[string]*conf.APIUser,
+	encryption bool,
+	serverKey string,
+	serverCert string,
 	parent metricsParent,
 ) (*metrics, error) {
-	ln, err := net.Listen("tcp", address)
+	ln, err := apiListen(address, encryption, serverKey, serverCert)
 	if err != nil {
 		return nil, err
 	}
 
 	m := &metrics{
-		parent: parent,
-		ln:     ln,
+		address:                   address,
+		users:                     users,
+		encryption:                encryption,
+		serverKey:                 serverKey,
+		serverCert:                serverCert,
+		parent:                    parent,
+		readerSessionDurations:    make(map[string]*metricsHistogram),
+		publisherSessionDurations: make(map[string]*metricsHistogram),
+		hlsSegmentDuration:        newMetricsHistogram(metricsHLSSegmentBuckets),
 	}
 
 	router := gin.New()
-	router.GET("/metrics", m.onMetrics)
+	router.GET("/metrics", m.mwAuth, m.onMetrics)
 
 	m.server = &http.Server{Handler: router}
 
 	m.log(logger.Info, "listener opened on "+address)
 
-	go m.run()
+	go m.run(m.server, ln)
 
 	return m, nil
 }
@@ -85,119 +123,260 @@ func (m *metrics) log(level logger.Level, format string, args ...interface{}) {
 	m.parent.Log(level, "[metrics] "+format, args...)
 }
 
-func (m *metrics) run() {
-	err := m.server.Serve(m.ln)
+func (m *metrics) run(server *http.Server, ln net.Listener) {
+	err := server.Serve(ln)
 	if err != http.ErrServerClosed {
 		panic(err)
 	}
 }
 
+// onConfReload is called by core.
+// Users are replaced in place, while the listener is reopened only when
+// the TLS configuration changes.
+func (m *metrics) onConfReload(
+	users map[string]*conf.APIUser,
+	encryption bool,
+	serverKey string,
+	serverCert string,
+) error {
+	m.mutex.Lock()
+	m.users = users
+	m.mutex.Unlock()
+
+	if encryption == m.encryption && serverKey == m.serverKey && serverCert == m.serverCert {
+		return nil
+	}
+
+	m.server.Shutdown(context.Background())
+
+	ln, err := apiListen(m.address, encryption, serverKey, serverCert)
+	if err != nil {
+		return err
+	}
+
+	m.encryption = encryption
+	m.serverKey = serverKey
+	m.serverCert = serverCert
+	m.server = &http.Server{Handler: m.server.Handler}
+
+	m.log(logger.Info, "listener reopened on "+m.address)
+
+	go m.run(m.server, ln)
+
+	return nil
+}
+
+func (m *metrics) mwAuth(ctx *gin.Context) {
+	m.mutex.Lock()
+	users := m.users
+	m.mutex.Unlock()
+
+	if !apiAuthenticate(users, ctx.Writer, ctx.Request, false) {
+		ctx.Abort()
+	}
+}
+
 func (m *metrics) onMetrics(ctx *gin.Context) {
-	out := ""
+	w := newMetricsWriter()
 
 	res := m.pathManager.onAPIPathsList(pathAPIPathsListReq{})
 	if res.err == nil {
-		for name, p := range res.data.Items {
+		for _, name := range sortedKeys(res.data.Items) {
+			p := res.data.Items[name]
+			label := metricsLabel("name", name)
+
 			if p.SourceReady {
-				out += metric("paths{name=\""+name+"\",state=\"ready\"}", 1)
+				w.int("paths", label+",state=\"ready\"", 1)
 			} else {
-				out += metric("paths{name=\""+name+"\",state=\"notReady\"}", 1)
+				w.int("paths", label+",state=\"notReady\"", 1)
 			}
-		}
-	}
 
-	if !interfaceIsEmpty(m.rtspServer) {
-		res := m.rtspServer.onAPISessionsList(rtspServerAPISessionsListReq{})
-		if res.err == nil {
-			idleCount := int64(0)
-			readCount := int64(0)
-			publishCount := int64(0)
+			w.trafficStats("path", label, p.trafficStatsData)
 
-			for _, i := range res.data.Items {
-				switch i.State {
-				case "idle":
-					idleCount++
-				case "read":
-					readCount++
-				case "publish":
-					publishCount++
-				}
+			for _, protocol := range sortedKeys(p.trafficByProtocol) {
+				st := p.trafficByProtocol[protocol]
+				labels := metricsLabel("path", name) + "," + metricsLabel("protocol", protocol)
+				w.int("traffic_bytes_received_total", labels, int64(st.BytesReceived))
+				w.int("traffic_bytes_sent_total", labels, int64(st.BytesSent))
+				w.int("traffic_packets_received_total", labels, int64(st.PacketsReceived))
+				w.int("traffic_packets_sent_total", labels, int64(st.PacketsSent))
 			}
 
-			out += metric("rtsp_sessions{state=\"idle\"}",
-				idleCount)
-			out += metric("rtsp_sessions{state=\"read\"}",
-				readCount)
-			out += metric("rtsp_sessions{state=\"publish\"}",
-				publishCount)
-		}
-	}
-
-	if !interfaceIsEmpty(m.rtspsServer) {
-		res := m.rtspsServer.onAPISessionsList(rtspServerAPISessionsListReq{})
-		if res.err == nil {
-			idleCount := int64(0)
-			readCount := int64(0)
-			publishCount := int64(0)
+			if p.hasStaticSource {
+				w.int("source_reconnects_total", label, p.sourceReconnects)
+			}
 
-			for _, i := range res.data.Items {
-				switch i.State {
-				case "idle":
-					idleCount++
-				case "read":
-					readCount++
-				case "publish":
-					publishCount++
+			if p.SourceReady {
+				counts := make(map[healthCondition]int64)
+				for _, c := range p.Health.Conditions {
+					counts[c.Condition]++
+				}
+				for _, c := range healthConditions {
+					w.int("health_conditions", metricsLabel("path", name)+","+metricsLabel("condition", string(c)), counts[c])
 				}
 			}
 
-			out += metric("rtsps_sessions{state=\"idle\"}",
-				idleCount)
-			out += metric("rtsps_sessions{state=\"read\"}",
-				readCount)
-			out += metric("rtsps_sessions{state=\"publish\"}",
-				publishCount)
+			for _, c := range healthConditions {
+				w.int("health_alarms_total", metricsLabel("path", name)+","+metricsLabel("condition", string(c)), p.Health.alarms[c])
+			}
+
+			for _, protocol := range sortedKeys(p.Latency) {
+				labels := metricsLabel("path", name) + "," + metricsLabel("protocol", protocol)
+				w.latencyStats("latency_seconds", labels, p.Latency[protocol])
+			}
 		}
 	}
 
+	if !interfaceIsEmpty(m.rtspServer) {
+		m.writeRTSPSessions(w, "rtsp", m.rtspServer)
+	}
+
+	if !interfaceIsEmpty(m.rtspsServer) {
+		m.writeRTSPSessions(w, "rtsps", m.rtspsServer)
+	}
+
 	if !interfaceIsEmpty(m.rtmpServer) {
 		res := m.rtmpServer.onAPIConnsList(rtmpServerAPIConnsListReq{})
 		if res.err == nil {
-			idleCount := int64(0)
-			readCount := int64(0)
-			publishCount := int64(0)
-
+			counts := make(map[string]int64)
 			for _, i := range res.data.Items {
-				switch i.State {
-				case "idle":
-					idleCount++
-				case "read":
-					readCount++
-				case "publish":
-					publishCount++
-				}
+				counts[i.State]++
+			}
+
+			for _, state := range []string{"idle", "read", "publish"} {
+				w.int("rtmp_conns", "state=\""+state+"\"", counts[state])
 			}
 
-			out += metric("rtmp_conns{state=\"idle\"}",
-				idleCount)
-			out += metric("rtmp_conns{state=\"read\"}",
-				readCount)
-			out += metric("rtmp_conns{state=\"publish\"}",
-				publishCount)
+			for _, id := range sortedKeys(res.data.Items) {
+				i := res.data.Items[id]
+				w.trafficStats("rtmp_conn",
+					metricsLabel("id", id)+",state=\""+i.State+"\"", i.trafficStatsData)
+			}
 		}
 	}
 
 	if !interfaceIsEmpty(m.hlsServer) {
 		res := m.hlsServer.onAPIHLSMuxersList(hlsServerAPIMuxersListReq{})
 		if res.err == nil {
-			for name := range res.data.Items {
-				out += metric("hls_muxers{name=\""+name+"\"}", 1)
+			for _, name := range sortedKeys(res.data.Items) {
+				w.int("hls_muxers", metricsLabel("name", name), 1)
+				w.trafficStats("hls_muxer", metricsLabel("name", name), res.data.Items[name].trafficStatsData)
 			}
 		}
 	}
 
+	if !interfaceIsEmpty(m.externalAuth) {
+		stats := m.externalAuth.statsGet()
+		w.int("external_auth_requests", "result=\"allowed\"", stats.allowed)
+		w.int("external_auth_requests", "result=\"denied\"", stats.denied)
+		w.int("external_auth_requests", "result=\"error\"", stats.errors)
+		w.int("external_auth_cache_hits", "", stats.cacheHits)
+		w.summary("external_auth_duration_milliseconds", "",
+			float64(stats.durationSum), uint64(stats.durationCount))
+	}
+
+	if !interfaceIsEmpty(m.accessLimiter) {
+		stats := m.accessLimiter.statsGet()
+		w.int("conns", "", stats.conns)
+		w.int("conns_rejected", "reason=\"banned\"", stats.rejectedBanned)
+		w.int("conns_rejected", "reason=\"maxConns\"", stats.rejectedMaxConns)
+		w.int("conns_rejected", "reason=\"maxConnsPerIP\"", stats.rejectedMaxConnsIP)
+		for _, protocol := range sortedKeys(stats.authFailures) {
+			w.int("auth_failures", metricsLabel("protocol", protocol), stats.authFailures[protocol])
+		}
+		w.int("bans", "", stats.bans)
+		w.int("readers_rejected", "", stats.rejectedReaders)
+		w.int("publishers_rejected", "", stats.rejectedPublishers)
+	}
+
+	if !interfaceIsEmpty(m.webhookSender) {
+		stats := m.webhookSender.statsGet()
+		w.int("webhook_deliveries", "result=\"delivered\"", stats.delivered)
+		w.int("webhook_deliveries", "result=\"failed\"", stats.failed)
+		w.int("webhook_deliveries_dropped", "", stats.dropped)
+	}
+
+	m.writeHistograms(w)
+	metricsWriteRuntime(w)
+	metricsWriteProcess(w)
+
+	ctx.Writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
 	ctx.Writer.WriteHeader(http.StatusOK)
-	io.WriteString(ctx.Writer, out)
+	io.WriteString(ctx.Writer, w.String())
+}
+
+func (m *metrics) writeRTSPSessions(w *metricsWriter, prefix string, s metricsRTSPServer) {
+	res := s.onAPISessionsList(rtspServerAPISessionsListReq{})
+	if res.err != nil {
+		return
+	}
+
+	counts := make(map[string]int64)
+	for _, i := range res.data.Items {
+		counts[i.State]++
+	}
+
+	for _, state := range []string{"idle", "read", "publish"} {
+		w.int(prefix+"_sessions", "state=\""+state+"\"", counts[state])
+	}
+
+	for _, id := range sortedKeys(res.data.Items) {
+		i := res.data.Items[id]
+		w.trafficStats(prefix+"_session",
+			metricsLabel("id", id)+",state=\""+i.State+"\"", i.trafficStatsData)
+	}
+}
+
+func (m *metrics) writeHistograms(w *metricsWriter) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+
+	for _, protocol := range sortedKeys(m.readerSessionDurations) {
+		w.histogram("reader_session_duration_seconds", metricsLabel("protocol", protocol),
+			m.readerSessionDurations[protocol].get())
+	}
+
+	for _, protocol := range sortedKeys(m.publisherSessionDurations) {
+		w.histogram("publisher_session_duration_seconds", metricsLabel("protocol", protocol),
+			m.publisherSessionDurations[protocol].get())
+	}
+
+	if m.hlsSegmentDuration.count != 0 {
+		w.histogram("hls_segment_duration_seconds", "", m.hlsSegmentDuration.get())
+	}
+}
+
+// onReaderSessionEnd is called by path.
+func (m *metrics) onReaderSessionEnd(protocol string, d time.Duration) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+
+	h, ok := m.readerSessionDurations[protocol]
+	if !ok {
+		h = newMetricsHistogram(metricsSessionDurationBuckets)
+		m.readerSessionDurations[protocol] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// onPublisherSessionEnd is called by path.
+func (m *metrics) onPublisherSessionEnd(protocol string, d time.Duration) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+
+	h, ok := m.publisherSessionDurations[protocol]
+	if !ok {
+		h = newMetricsHistogram(metricsSessionDurationBuckets)
+		m.publisherSessionDurations[protocol] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// onHLSSegmentGenerated is called by hlsMuxer.
+func (m *metrics) onHLSSegmentGenerated(d time.Duration) {
+	m.statsMutex.Lock()
+	defer m.statsMutex.Unlock()
+	m.hlsSegmentDuration.observe(d.Seconds())
 }
 
 // onPathManagerSet is called by pathManager.
@@ -234,3 +413,24 @@ func (m *metrics) onHLSServerSet(s metricsHLSServer) {
 	defer m.mutex.Unlock()
 	m.hlsServer = s
 }
+
+// onExternalAuthenticatorSet is called by externalAuthenticator.
+func (m *metrics) onExternalAuthenticatorSet(a metricsExternalAuthenticator) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.externalAuth = a
+}
+
+// onAccessLimiterSet is called by accessLimiter.
+func (m *metrics) onAccessLimiterSet(l metricsAccessLimiter) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.accessLimiter = l
+}
+
+// onWebhookSenderSet is called by webhookSender.
+func (m *metrics) onWebhookSenderSet(s metricsWebhookSender) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.webhookSender = s
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// processStartTime is the time at which the process started.
+var processStartTime = time.Now()
+
+// ticks per second of values in /proc/self/stat.
+const metricsUserHZ = 100
+
+func metricsWriteRuntime(w *metricsWriter) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	w.int("go_info", metricsLabel("version", runtime.Version()), 1)
+	w.int("go_goroutines", "", int64(runtime.NumGoroutine()))
+	w.int("go_threads", "", int64(rpprof.Lookup("threadcreate").Count()))
+	w.int("go_memstats_alloc_bytes", "", int64(ms.Alloc))
+	w.int("go_memstats_alloc_bytes_total", "", int64(ms.TotalAlloc))
+	w.int("go_memstats_sys_bytes", "", int64(ms.Sys))
+	w.int("go_memstats_heap_inuse_bytes", "", int64(ms.HeapInuse))
+	w.int("go_memstats_heap_objects", "", int64(ms.HeapObjects))
+	w.float("go_memstats_last_gc_time_seconds", "", float64(ms.LastGC)/1e9)
+	w.int("go_gc_cycles_total", "", int64(ms.NumGC))
+}
+
+// metricsWriteProcess writes metrics of the process.
+// Metrics that are read from /proc are available on Linux only.
+func metricsWriteProcess(w *metricsWriter) {
+	w.float("process_start_time_seconds", "", float64(processStartTime.UnixNano())/1e9)
+
+	byts, err := ioutil.ReadFile("/proc/self/stat")
+	if err == nil {
+		// the process name can contain spaces, therefore fields are
+		// read after its end.
+		str := string(byts)
+		fields := strings.Fields(str[strings.LastIndexByte(str, ')')+1:])
+
+		if len(fields) >= 22 {
+			utime, _ := strconv.ParseFloat(fields[11], 64)
+			stime, _ := strconv.ParseFloat(fields[12], 64)
+			vsize, _ := strconv.ParseInt(fields[20], 10, 64)
+			rss, _ := strconv.ParseInt(fields[21], 10, 64)
+
+			w.float("process_cpu_seconds_total", "", (utime+stime)/metricsUserHZ)
+			w.int("process_virtual_memory_bytes", "", vsize)
+			w.int("process_resident_memory_bytes", "", rss*int64(os.Getpagesize()))
+		}
+	}
+
+	entries, err := ioutil.ReadDir("/proc/self/fd")
+	if err == nil {
+		w.int("process_open_fds", "", int64(len(entries)))
+	}
+}
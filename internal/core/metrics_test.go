@@ -4,6 +4,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -69,25 +71,134 @@ func TestMetrics(t *testing.T) {
 	bo, err := ioutil.ReadAll(res.Body)
 	require.NoError(t, err)
 
+	idRegexp := regexp.MustCompile(`id="[^"]+"`)
+
+	// these values depend on timing or on the runtime, therefore they are masked
+	variableRegexp := regexp.MustCompile(`^(go_|process_|` +
+		`path_(bytes_received|packets_received|bitrate|frame_rate|keyframe_interval_seconds)\{name="rtmp_path"|` +
+		`rtmp_conn_(bytes_received|packets_received|bitrate|frame_rate|keyframe_interval_seconds)\{|` +
+		`traffic_(bytes|packets)_received_total\{path="rtmp_path")`)
+
 	vals := make(map[string]string)
 	lines := strings.Split(string(bo), "\n")
 	for _, l := range lines[:len(lines)-1] {
+		if strings.HasPrefix(l, "#") {
+			continue
+		}
 		fields := strings.Split(l, " ")
-		vals[fields[0]] = fields[1]
+		k := idRegexp.ReplaceAllString(fields[0], `id="*"`)
+		if variableRegexp.MatchString(k) {
+			vals[k] = "*"
+		} else {
+			vals[k] = fields[1]
+		}
 	}
 
 	require.Equal(t, map[string]string{
-		"hls_muxers{name=\"rtsp_path\"}":            "1",
-		"paths{name=\"rtsp_path\",state=\"ready\"}": "1",
-		"paths{name=\"rtmp_path\",state=\"ready\"}": "1",
-		"rtmp_conns{state=\"idle\"}":                "0",
-		"rtmp_conns{state=\"publish\"}":             "1",
-		"rtmp_conns{state=\"read\"}":                "0",
-		"rtsp_sessions{state=\"idle\"}":             "0",
-		"rtsp_sessions{state=\"publish\"}":          "1",
-		"rtsp_sessions{state=\"read\"}":             "0",
-		"rtsps_sessions{state=\"idle\"}":            "0",
-		"rtsps_sessions{state=\"publish\"}":         "0",
-		"rtsps_sessions{state=\"read\"}":            "0",
+		"paths{name=\"rtmp_path\",state=\"ready\"}":                                    "1",
+		"paths{name=\"rtsp_path\",state=\"ready\"}":                                    "1",
+		"path_bytes_received{name=\"rtmp_path\"}":                                      "*",
+		"path_bytes_received{name=\"rtsp_path\"}":                                      "0",
+		"path_bytes_sent{name=\"rtmp_path\"}":                                          "0",
+		"path_bytes_sent{name=\"rtsp_path\"}":                                          "0",
+		"path_packets_received{name=\"rtmp_path\"}":                                    "*",
+		"path_packets_received{name=\"rtsp_path\"}":                                    "0",
+		"path_packets_sent{name=\"rtmp_path\"}":                                        "0",
+		"path_packets_sent{name=\"rtsp_path\"}":                                        "0",
+		"path_packets_lost{name=\"rtmp_path\"}":                                        "0",
+		"path_packets_lost{name=\"rtsp_path\"}":                                        "0",
+		"path_bitrate{name=\"rtmp_path\"}":                                             "*",
+		"path_bitrate{name=\"rtsp_path\"}":                                             "0",
+		"path_frame_rate{name=\"rtmp_path\"}":                                          "*",
+		"path_frame_rate{name=\"rtsp_path\"}":                                          "0",
+		"path_keyframe_interval_seconds{name=\"rtmp_path\"}":                           "*",
+		"path_keyframe_interval_seconds{name=\"rtsp_path\"}":                           "0",
+		"traffic_bytes_received_total{path=\"rtmp_path\",protocol=\"rtmp\"}":           "*",
+		"traffic_bytes_received_total{path=\"rtsp_path\",protocol=\"hls\"}":            "0",
+		"traffic_bytes_received_total{path=\"rtsp_path\",protocol=\"rtsp\"}":           "0",
+		"traffic_bytes_sent_total{path=\"rtmp_path\",protocol=\"rtmp\"}":               "0",
+		"traffic_bytes_sent_total{path=\"rtsp_path\",protocol=\"hls\"}":                "0",
+		"traffic_bytes_sent_total{path=\"rtsp_path\",protocol=\"rtsp\"}":               "0",
+		"traffic_packets_received_total{path=\"rtmp_path\",protocol=\"rtmp\"}":         "*",
+		"traffic_packets_received_total{path=\"rtsp_path\",protocol=\"hls\"}":          "0",
+		"traffic_packets_received_total{path=\"rtsp_path\",protocol=\"rtsp\"}":         "0",
+		"traffic_packets_sent_total{path=\"rtmp_path\",protocol=\"rtmp\"}":             "0",
+		"traffic_packets_sent_total{path=\"rtsp_path\",protocol=\"hls\"}":              "0",
+		"traffic_packets_sent_total{path=\"rtsp_path\",protocol=\"rtsp\"}":             "0",
+		"health_conditions{path=\"rtmp_path\",condition=\"stalled\"}":                  "0",
+		"health_conditions{path=\"rtmp_path\",condition=\"noKeyframe\"}":               "0",
+		"health_conditions{path=\"rtmp_path\",condition=\"bitrateDrop\"}":              "0",
+		"health_conditions{path=\"rtmp_path\",condition=\"timestampDiscontinuity\"}":   "0",
+		"health_conditions{path=\"rtsp_path\",condition=\"stalled\"}":                  "0",
+		"health_conditions{path=\"rtsp_path\",condition=\"noKeyframe\"}":               "0",
+		"health_conditions{path=\"rtsp_path\",condition=\"bitrateDrop\"}":              "0",
+		"health_conditions{path=\"rtsp_path\",condition=\"timestampDiscontinuity\"}":   "0",
+		"health_alarms_total{path=\"rtmp_path\",condition=\"stalled\"}":                "0",
+		"health_alarms_total{path=\"rtmp_path\",condition=\"noKeyframe\"}":             "0",
+		"health_alarms_total{path=\"rtmp_path\",condition=\"bitrateDrop\"}":            "0",
+		"health_alarms_total{path=\"rtmp_path\",condition=\"timestampDiscontinuity\"}": "0",
+		"health_alarms_total{path=\"rtsp_path\",condition=\"stalled\"}":                "0",
+		"health_alarms_total{path=\"rtsp_path\",condition=\"noKeyframe\"}":             "0",
+		"health_alarms_total{path=\"rtsp_path\",condition=\"bitrateDrop\"}":            "0",
+		"health_alarms_total{path=\"rtsp_path\",condition=\"timestampDiscontinuity\"}": "0",
+		"rtsp_sessions{state=\"idle\"}":                                                "0",
+		"rtsp_sessions{state=\"read\"}":                                                "0",
+		"rtsp_sessions{state=\"publish\"}":                                             "1",
+		"rtsp_session_bytes_received{id=\"*\",state=\"publish\"}":                      "0",
+		"rtsp_session_bytes_sent{id=\"*\",state=\"publish\"}":                          "0",
+		"rtsp_session_packets_received{id=\"*\",state=\"publish\"}":                    "0",
+		"rtsp_session_packets_sent{id=\"*\",state=\"publish\"}":                        "0",
+		"rtsp_session_packets_lost{id=\"*\",state=\"publish\"}":                        "0",
+		"rtsp_session_bitrate{id=\"*\",state=\"publish\"}":                             "0",
+		"rtsp_session_frame_rate{id=\"*\",state=\"publish\"}":                          "0",
+		"rtsp_session_keyframe_interval_seconds{id=\"*\",state=\"publish\"}":           "0",
+		"rtsps_sessions{state=\"idle\"}":                                               "0",
+		"rtsps_sessions{state=\"read\"}":                                               "0",
+		"rtsps_sessions{state=\"publish\"}":                                            "0",
+		"rtmp_conns{state=\"idle\"}":                                                   "0",
+		"rtmp_conns{state=\"read\"}":                                                   "0",
+		"rtmp_conns{state=\"publish\"}":                                                "1",
+		"rtmp_conn_bytes_received{id=\"*\",state=\"publish\"}":                         "*",
+		"rtmp_conn_bytes_sent{id=\"*\",state=\"publish\"}":                             "0",
+		"rtmp_conn_packets_received{id=\"*\",state=\"publish\"}":                       "*",
+		"rtmp_conn_packets_sent{id=\"*\",state=\"publish\"}":                           "0",
+		"rtmp_conn_packets_lost{id=\"*\",state=\"publish\"}":                           "0",
+		"rtmp_conn_bitrate{id=\"*\",state=\"publish\"}":                                "*",
+		"rtmp_conn_frame_rate{id=\"*\",state=\"publish\"}":                             "*",
+		"rtmp_conn_keyframe_interval_seconds{id=\"*\",state=\"publish\"}":              "*",
+		"hls_muxers{name=\"rtsp_path\"}":                                               "1",
+		"hls_muxer_bytes_received{name=\"rtsp_path\"}":                                 "0",
+		"hls_muxer_bytes_sent{name=\"rtsp_path\"}":                                     "0",
+		"hls_muxer_packets_received{name=\"rtsp_path\"}":                               "0",
+		"hls_muxer_packets_sent{name=\"rtsp_path\"}":                                   "0",
+		"hls_muxer_packets_lost{name=\"rtsp_path\"}":                                   "0",
+		"hls_muxer_bitrate{name=\"rtsp_path\"}":                                        "0",
+		"hls_muxer_frame_rate{name=\"rtsp_path\"}":                                     "0",
+		"hls_muxer_keyframe_interval_seconds{name=\"rtsp_path\"}":                      "0",
+		"conns":                                    "3",
+		"conns_rejected{reason=\"banned\"}":        "0",
+		"conns_rejected{reason=\"maxConns\"}":      "0",
+		"conns_rejected{reason=\"maxConnsPerIP\"}": "0",
+		"bans":                "0",
+		"readers_rejected":    "0",
+		"publishers_rejected": "0",
+		"webhook_deliveries{result=\"delivered\"}":       "0",
+		"webhook_deliveries{result=\"failed\"}":          "0",
+		"webhook_deliveries_dropped":                     "0",
+		"go_info{version=\"" + runtime.Version() + "\"}": "*",
+		"go_goroutines":                    "*",
+		"go_threads":                       "*",
+		"go_memstats_alloc_bytes":          "*",
+		"go_memstats_alloc_bytes_total":    "*",
+		"go_memstats_sys_bytes":            "*",
+		"go_memstats_heap_inuse_bytes":     "*",
+		"go_memstats_heap_objects":         "*",
+		"go_memstats_last_gc_time_seconds": "*",
+		"go_gc_cycles_total":               "*",
+		"process_start_time_seconds":       "*",
+		"process_cpu_seconds_total":        "*",
+		"process_virtual_memory_bytes":     "*",
+		"process_resident_memory_bytes":    "*",
+		"process_open_fds":                 "*",
 	}, vals)
 }
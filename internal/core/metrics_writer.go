@@ -0,0 +1,266 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type metricsFamilyDesc struct {
+	typ  string
+	help string
+}
+
+// metricsFamilies contains the type and description of every metric family.
+var metricsFamilies = map[string]metricsFamilyDesc{
+	"paths":          {"gauge", "Paths, by state."},
+	"rtsp_sessions":  {"gauge", "RTSP sessions, by state."},
+	"rtsps_sessions": {"gauge", "RTSPS sessions, by state."},
+	"rtmp_conns":     {"gauge", "RTMP connections, by state."},
+	"hls_muxers":     {"gauge", "HLS muxers."},
+
+	"external_auth_requests":              {"counter", "Requests to the external authentication server, by result."},
+	"external_auth_cache_hits":            {"counter", "Authentications whose result has been taken from the cache."},
+	"external_auth_duration_milliseconds": {"summary", "Duration of requests to the external authentication server."},
+
+	"conns":               {"gauge", "Open RTSP, RTMP and HLS connections."},
+	"conns_rejected":      {"counter", "Rejected connections, by reason."},
+	"auth_failures":       {"counter", "Authentication failures, by protocol."},
+	"bans":                {"gauge", "IPs that are currently banned."},
+	"readers_rejected":    {"counter", "Readers rejected because of maxReaders."},
+	"publishers_rejected": {"counter", "Publishers rejected because of maxPublishers."},
+
+	"webhook_deliveries":         {"counter", "Completed webhook deliveries, by result."},
+	"webhook_deliveries_dropped": {"counter", "Webhook deliveries dropped because their queue was full."},
+
+	"traffic_bytes_received_total":   {"counter", "Bytes received by paths, by protocol of the source."},
+	"traffic_bytes_sent_total":       {"counter", "Bytes sent by paths, by protocol of the readers."},
+	"traffic_packets_received_total": {"counter", "Packets received by paths, by protocol of the source."},
+	"traffic_packets_sent_total":     {"counter", "Packets sent by paths, by protocol of the readers."},
+
+	"reader_session_duration_seconds":    {"histogram", "Duration of reading sessions, by protocol."},
+	"publisher_session_duration_seconds": {"histogram", "Duration of publishing sessions, by protocol."},
+	"hls_segment_duration_seconds":       {"histogram", "Duration of generated HLS segments."},
+	"source_reconnects_total":            {"counter", "Reconnections of static sources, by path."},
+	"health_conditions":                  {"gauge", "Tracks of ready paths on which a health condition is raised."},
+	"health_alarms_total":                {"counter", "Health conditions raised on paths."},
+	"latency_seconds":                    {"summary", "Time between the reception of access units and their delivery, by path and protocol."},
+
+	"go_info":                          {"gauge", "Information about the Go environment."},
+	"go_goroutines":                    {"gauge", "Number of goroutines that currently exist."},
+	"go_threads":                       {"gauge", "Number of OS threads created."},
+	"go_memstats_alloc_bytes":          {"gauge", "Number of bytes allocated and still in use."},
+	"go_memstats_alloc_bytes_total":    {"counter", "Total number of bytes allocated, even if freed."},
+	"go_memstats_sys_bytes":            {"gauge", "Number of bytes obtained from system."},
+	"go_memstats_heap_inuse_bytes":     {"gauge", "Number of heap bytes that are in use."},
+	"go_memstats_heap_objects":         {"gauge", "Number of allocated objects."},
+	"go_memstats_last_gc_time_seconds": {"gauge", "Number of seconds since 1970 of last garbage collection."},
+	"go_gc_cycles_total":               {"counter", "Number of completed GC cycles."},
+
+	"process_start_time_seconds":    {"gauge", "Start time of the process since unix epoch in seconds."},
+	"process_cpu_seconds_total":     {"counter", "Total user and system CPU time spent in seconds."},
+	"process_open_fds":              {"gauge", "Number of open file descriptors."},
+	"process_resident_memory_bytes": {"gauge", "Resident memory size in bytes."},
+	"process_virtual_memory_bytes":  {"gauge", "Virtual memory size in bytes."},
+}
+
+// metricsTrafficFamilies contains the type and description of families
+// generated from traffic statistics, by suffix.
+var metricsTrafficFamilies = map[string]metricsFamilyDesc{
+	"_bytes_received":            {"counter", "Bytes received."},
+	"_bytes_sent":                {"counter", "Bytes sent."},
+	"_packets_received":          {"counter", "Packets received."},
+	"_packets_sent":              {"counter", "Packets sent."},
+	"_packets_lost":              {"counter", "Packets lost."},
+	"_bitrate":                   {"gauge", "Bitrate, in bits per second."},
+	"_frame_rate":                {"gauge", "Frame rate."},
+	"_keyframe_interval_seconds": {"gauge", "Interval between key frames."},
+}
+
+type metricsFamily struct {
+	name    string
+	samples []string
+}
+
+// metricsWriter groups samples by family and writes them in the
+// Prometheus text exposition format.
+type metricsWriter struct {
+	families []*metricsFamily
+	byName   map[string]*metricsFamily
+}
+
+func newMetricsWriter() *metricsWriter {
+	return &metricsWriter{
+		byName: make(map[string]*metricsFamily),
+	}
+}
+
+func (w *metricsWriter) sample(family string, suffix string, labels string, value string) {
+	f, ok := w.byName[family]
+	if !ok {
+		f = &metricsFamily{name: family}
+		w.families = append(w.families, f)
+		w.byName[family] = f
+	}
+
+	key := family + suffix
+	if labels != "" {
+		key += "{" + labels + "}"
+	}
+
+	f.samples = append(f.samples, key+" "+value)
+}
+
+func (w *metricsWriter) int(name string, labels string, value int64) {
+	w.sample(name, "", labels, strconv.FormatInt(value, 10))
+}
+
+func (w *metricsWriter) float(name string, labels string, value float64) {
+	w.sample(name, "", labels, metricsFormatFloat(value))
+}
+
+func (w *metricsWriter) histogram(name string, labels string, h metricsHistogramData) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+
+	for i, le := range h.buckets {
+		w.sample(name, "_bucket", prefix+"le=\""+metricsFormatFloat(le)+"\"",
+			strconv.FormatUint(h.counts[i], 10))
+	}
+	w.sample(name, "_bucket", prefix+"le=\"+Inf\"", strconv.FormatUint(h.count, 10))
+	w.sample(name, "_sum", labels, metricsFormatFloat(h.sum))
+	w.sample(name, "_count", labels, strconv.FormatUint(h.count, 10))
+}
+
+// summary writes a summary without quantiles.
+func (w *metricsWriter) summary(name string, labels string, sum float64, count uint64) {
+	w.sample(name, "_sum", labels, metricsFormatFloat(sum))
+	w.sample(name, "_count", labels, strconv.FormatUint(count, 10))
+}
+
+func (w *metricsWriter) trafficStats(prefix string, labels string, s trafficStatsData) {
+	w.int(prefix+"_bytes_received", labels, int64(s.BytesReceived))
+	w.int(prefix+"_bytes_sent", labels, int64(s.BytesSent))
+	w.int(prefix+"_packets_received", labels, int64(s.PacketsReceived))
+	w.int(prefix+"_packets_sent", labels, int64(s.PacketsSent))
+	w.int(prefix+"_packets_lost", labels, int64(s.PacketsLost))
+	w.float(prefix+"_bitrate", labels, s.Bitrate)
+	w.float(prefix+"_frame_rate", labels, s.FrameRate)
+	w.float(prefix+"_keyframe_interval_seconds", labels, s.KeyFrameInterval)
+}
+
+func (w *metricsWriter) latencyStats(name string, labels string, s latencyStatsData) {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+
+	w.sample(name, "", prefix+"quantile=\"0.5\"", metricsFormatFloat(s.P50))
+	w.sample(name, "", prefix+"quantile=\"0.9\"", metricsFormatFloat(s.P90))
+	w.sample(name, "", prefix+"quantile=\"0.99\"", metricsFormatFloat(s.P99))
+	w.sample(name, "", prefix+"quantile=\"1\"", metricsFormatFloat(s.Max))
+	w.sample(name, "_sum", labels, metricsFormatFloat(s.sum))
+	w.sample(name, "_count", labels, strconv.FormatUint(s.Count, 10))
+}
+
+func (w *metricsWriter) describe(family string) (metricsFamilyDesc, bool) {
+	if desc, ok := metricsFamilies[family]; ok {
+		return desc, true
+	}
+
+	for suffix, desc := range metricsTrafficFamilies {
+		if strings.HasSuffix(family, suffix) {
+			return desc, true
+		}
+	}
+
+	return metricsFamilyDesc{}, false
+}
+
+func (w *metricsWriter) String() string {
+	var b strings.Builder
+
+	for _, f := range w.families {
+		if desc, ok := w.describe(f.name); ok {
+			b.WriteString("# HELP " + f.name + " " + desc.help + "\n")
+			b.WriteString("# TYPE " + f.name + " " + desc.typ + "\n")
+		}
+
+		for _, s := range f.samples {
+			b.WriteString(s + "\n")
+		}
+	}
+
+	return b.String()
+}
+
+// sortedKeys returns the keys of a map with string keys, in alphabetical order.
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	ret := make([]string, len(keys))
+	for i, k := range keys {
+		ret[i] = k.String()
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func metricsFormatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// metricsLabel returns a label with an escaped value.
+func metricsLabel(key string, value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	value = strings.ReplaceAll(value, "\n", "\\n")
+	return key + "=\"" + value + "\""
+}
+
+// metricsHistogramData is a snapshot of a metricsHistogram.
+type metricsHistogramData struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+// metricsHistogram is a histogram with cumulative buckets.
+// It is not thread safe.
+type metricsHistogram struct {
+	metricsHistogramData
+}
+
+func newMetricsHistogram(buckets []float64) *metricsHistogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return &metricsHistogram{
+		metricsHistogramData: metricsHistogramData{
+			buckets: sorted,
+			counts:  make([]uint64, len(sorted)),
+		},
+	}
+}
+
+func (h *metricsHistogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *metricsHistogram) get() metricsHistogramData {
+	return metricsHistogramData{
+		buckets: h.buckets,
+		counts:  append([]uint64(nil), h.counts...),
+		count:   h.count,
+		sum:     h.sum,
+	}
+}
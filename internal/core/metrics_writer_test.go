@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	w := newMetricsWriter()
+
+	w.int("paths", metricsLabel("name", "cam1")+",state=\"ready\"", 1)
+	w.int("rtsp_sessions", "state=\"idle\"", 2)
+	w.int("paths", metricsLabel("name", "my\"cam")+",state=\"notReady\"", 1)
+
+	h := newMetricsHistogram([]float64{10, 1})
+	h.observe(0.5)
+	h.observe(5)
+	h.observe(20)
+	w.histogram("reader_session_duration_seconds", metricsLabel("protocol", "rtsp"), h.get())
+
+	w.summary("external_auth_duration_milliseconds", "", 120, 12)
+
+	w.float("unknown_metric", "", 1.5)
+
+	require.Equal(t, "# HELP paths Paths, by state.\n"+
+		"# TYPE paths gauge\n"+
+		"paths{name=\"cam1\",state=\"ready\"} 1\n"+
+		"paths{name=\"my\\\"cam\",state=\"notReady\"} 1\n"+
+		"# HELP rtsp_sessions RTSP sessions, by state.\n"+
+		"# TYPE rtsp_sessions gauge\n"+
+		"rtsp_sessions{state=\"idle\"} 2\n"+
+		"# HELP reader_session_duration_seconds Duration of reading sessions, by protocol.\n"+
+		"# TYPE reader_session_duration_seconds histogram\n"+
+		"reader_session_duration_seconds_bucket{protocol=\"rtsp\",le=\"1\"} 1\n"+
+		"reader_session_duration_seconds_bucket{protocol=\"rtsp\",le=\"10\"} 2\n"+
+		"reader_session_duration_seconds_bucket{protocol=\"rtsp\",le=\"+Inf\"} 3\n"+
+		"reader_session_duration_seconds_sum{protocol=\"rtsp\"} 25.5\n"+
+		"reader_session_duration_seconds_count{protocol=\"rtsp\"} 3\n"+
+		"# HELP external_auth_duration_milliseconds Duration of requests to the external authentication server.\n"+
+		"# TYPE external_auth_duration_milliseconds summary\n"+
+		"external_auth_duration_milliseconds_sum 120\n"+
+		"external_auth_duration_milliseconds_count 12\n"+
+		"unknown_metric 1.5\n", w.String())
+}
@@ -0,0 +1,154 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/gin-gonic/gin"
+
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtpjpeg"
+)
+
+const (
+	mjpegReaderFile     = "stream.mjpeg"
+	mjpegReaderBoundary = "frame"
+)
+
+// mjpegReader serves a path that contains a JPEG track to a HTTP client,
+// as a multipart/x-mixed-replace MJPEG stream.
+type mjpegReader struct {
+	pathName   string
+	remoteAddr string
+	ringBuffer *ringbuffer.RingBuffer
+	stats      *trafficStats
+	parent     *hlsServer
+}
+
+// close implements reader.
+func (r *mjpegReader) close() {
+	r.ringBuffer.Close()
+}
+
+// onReaderAccepted implements reader.
+func (r *mjpegReader) onReaderAccepted() {
+	r.log(logger.Info, "is reading from path '%s'", r.pathName)
+}
+
+// onReaderData implements reader.
+func (r *mjpegReader) onReaderData(data *data) {
+	r.ringBuffer.Push(data)
+}
+
+// onReaderAPIDescribe implements reader.
+func (r *mjpegReader) onReaderAPIDescribe() interface{} {
+	return struct {
+		Type       string `json:"type"`
+		RemoteAddr string `json:"remoteAddr"`
+	}{"mjpegReader", r.remoteAddr}
+}
+
+// protocol implements reader.
+func (*mjpegReader) protocol() string {
+	return "mjpeg"
+}
+
+// trafficStats implements reader.
+func (r *mjpegReader) trafficStats() *trafficStats {
+	return r.stats
+}
+
+func (r *mjpegReader) log(level logger.Level, format string, args ...interface{}) {
+	r.parent.log(level, "[mjpeg %v] "+format, append([]interface{}{r.remoteAddr}, args...)...)
+}
+
+// handleMJPEG serves a path as MJPEG, until the client disconnects or
+// the path is closed.
+func (s *hlsServer) handleMJPEG(ctx *gin.Context, req hlsMuxerRequest) {
+	r := &mjpegReader{
+		pathName:   req.dir,
+		remoteAddr: req.req.RemoteAddr,
+		ringBuffer: ringbuffer.New(uint64(s.readBufferCount)),
+		stats:      newTrafficStats(),
+		parent:     s,
+	}
+
+	res, ok := s.readerSetupPlay(ctx, req, r)
+	if !ok {
+		return
+	}
+
+	defer res.path.onReaderRemove(pathReaderRemoveReq{author: r})
+
+	trackID := -1
+	for i, track := range res.stream.tracks() {
+		if rtpjpeg.IsTrack(track) {
+			trackID = i
+			break
+		}
+	}
+
+	if trackID < 0 {
+		r.log(logger.Info, "ERR: the stream doesn't contain a JPEG track")
+		s.writeResponse(ctx, hlsMuxerResponse{status: http.StatusNotFound})
+		return
+	}
+
+	res.path.onReaderPlay(pathReaderPlayReq{author: r})
+
+	// stop reading when the client disconnects or the server is closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Request.Context().Done():
+		case <-s.ctx.Done():
+		case <-done:
+		}
+		r.ringBuffer.Close()
+	}()
+
+	ctx.Writer.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mjpegReaderBoundary)
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	ctx.Writer.WriteHeader(http.StatusOK)
+	ctx.Writer.Flush()
+
+	dec := &rtpjpeg.Decoder{}
+	dec.Init()
+
+	for {
+		item, ok := r.ringBuffer.Pull()
+		if !ok {
+			return
+		}
+		data := item.(*data)
+
+		if data.trackID != trackID {
+			continue
+		}
+
+		r.stats.onDataSent(data)
+
+		image, _, err := dec.Decode(data.rtp)
+		if err != nil {
+			if err != rtpjpeg.ErrMorePacketsNeeded && err != rtpjpeg.ErrNonStartingPacketAndNoPrevious {
+				r.log(logger.Warn, "unable to decode image: %v", err)
+			}
+			continue
+		}
+
+		_, err = fmt.Fprintf(ctx.Writer, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n",
+			mjpegReaderBoundary, len(image))
+		if err != nil {
+			return
+		}
+
+		_, err = ctx.Writer.Write(append(image, '\r', '\n'))
+		if err != nil {
+			return
+		}
+
+		ctx.Writer.Flush()
+	}
+}
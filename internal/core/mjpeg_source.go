@@ -0,0 +1,262 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/rtpjpeg"
+)
+
+const (
+	mjpegSourceRetryPause = 5 * time.Second
+
+	// maximum size of a JPEG image received from a MJPEG source.
+	mjpegSourceMaxImageSize = 10 * 1024 * 1024
+)
+
+type mjpegSourceParent interface {
+	log(logger.Level, string, ...interface{})
+	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
+}
+
+// mjpegSource reads a multipart/x-mixed-replace MJPEG stream from a HTTP server,
+// and publishes it as a RTP/JPEG track.
+type mjpegSource struct {
+	ur          string
+	fingerprint string
+	readTimeout conf.StringDuration
+	wg          *sync.WaitGroup
+	parent      mjpegSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newMJPEGSource(
+	parentCtx context.Context,
+	ur string,
+	fingerprint string,
+	readTimeout conf.StringDuration,
+	wg *sync.WaitGroup,
+	parent mjpegSourceParent,
+) *mjpegSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &mjpegSource{
+		ur:          strings.TrimPrefix(ur, "mjpeg+"),
+		fingerprint: fingerprint,
+		readTimeout: readTimeout,
+		wg:          wg,
+		parent:      parent,
+		ctx:         ctx,
+		ctxCancel:   ctxCancel,
+	}
+
+	s.Log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *mjpegSource) close() {
+	s.Log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *mjpegSource) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.log(level, "[mjpeg source] "+format, args...)
+}
+
+func (s *mjpegSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		err := s.runInner()
+		if err == nil {
+			break outer
+		}
+
+		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
+
+		select {
+		case <-time.After(mjpegSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *mjpegSource) httpClient() *http.Client {
+	var tlsConfig *tls.Config
+
+	if s.fingerprint != "" {
+		tlsConfig = &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection: func(cs tls.ConnectionState) error {
+				h := sha256.New()
+				h.Write(cs.PeerCertificates[0].Raw)
+				hstr := hex.EncodeToString(h.Sum(nil))
+				fingerprintLower := strings.ToLower(s.fingerprint)
+
+				if hstr != fingerprintLower {
+					return fmt.Errorf("server fingerprint do not match: expected %s, got %s",
+						fingerprintLower, hstr)
+				}
+
+				return nil
+			},
+		}
+	}
+
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+}
+
+// runInner returns nil when the source is closed, or an error.
+func (s *mjpegSource) runInner() error {
+	innerCtx, innerCtxCancel := context.WithCancel(s.ctx)
+	defer innerCtxCancel()
+
+	// the request is interrupted when no image is received within readTimeout.
+	readTimer := time.AfterFunc(time.Duration(s.readTimeout), innerCtxCancel)
+	defer readTimer.Stop()
+
+	// wrapErr returns nil if the error was caused by the closure of the source.
+	wrapErr := func(err error) error {
+		select {
+		case <-s.ctx.Done():
+			return nil
+		default:
+		}
+
+		if innerCtx.Err() != nil {
+			return fmt.Errorf("no image received within %v", time.Duration(s.readTimeout))
+		}
+
+		if err == io.EOF {
+			return fmt.Errorf("stream ended")
+		}
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(innerCtx, http.MethodGet, s.ur, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.httpClient().Do(req)
+	if err != nil {
+		return wrapErr(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return fmt.Errorf("the server doesn't provide a MJPEG stream (content type is '%s')",
+			res.Header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(res.Body, params["boundary"])
+
+	track := rtpjpeg.NewTrack()
+	enc := &rtpjpeg.Encoder{}
+	enc.Init()
+
+	var stream *stream
+	var startTime time.Time
+
+	defer func() {
+		if stream != nil {
+			s.parent.onSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{source: s})
+		}
+	}()
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		image, err := ioutil.ReadAll(io.LimitReader(part, mjpegSourceMaxImageSize+1))
+		if err != nil {
+			return wrapErr(err)
+		}
+
+		if len(image) > mjpegSourceMaxImageSize {
+			return fmt.Errorf("image size exceeds maximum size (%d)", mjpegSourceMaxImageSize)
+		}
+
+		readTimer.Reset(time.Duration(s.readTimeout))
+
+		if stream == nil {
+			res := s.parent.onSourceStaticSetReady(pathSourceStaticSetReadyReq{
+				source: s,
+				tracks: gortsplib.Tracks{track},
+			})
+			if res.err != nil {
+				return res.err
+			}
+
+			s.Log(logger.Info, "ready")
+
+			stream = res.stream
+			startTime = time.Now()
+		}
+
+		pkts, err := enc.Encode(image, time.Since(startTime))
+		if err != nil {
+			s.Log(logger.Warn, "unable to encode image: %v", err)
+			continue
+		}
+
+		for _, pkt := range pkts {
+			stream.writeData(&data{
+				trackID:      0,
+				rtp:          pkt,
+				ptsEqualsDTS: true,
+			})
+		}
+	}
+}
+
+// onSourceAPIDescribe implements source.
+func (*mjpegSource) onSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"mjpegSource"}
+}
+
+// protocol implements source.
+func (*mjpegSource) protocol() string {
+	return "mjpeg"
+}
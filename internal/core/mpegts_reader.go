@@ -0,0 +1,206 @@
+package core
+
+import (
+	"net/http"
+	gopath "path"
+	"strings"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/gin-gonic/gin"
+
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+// isMPEGTSReaderRequest checks whether a request is directed to a continuous
+// MPEG-TS stream ([path].ts) instead of a HLS segment ([path]/[timestamp].ts).
+func isMPEGTSReaderRequest(pa string) bool {
+	if !strings.HasSuffix(pa, ".ts") {
+		return false
+	}
+
+	base := strings.TrimSuffix(gopath.Base(pa), ".ts")
+	if base == "" || strings.HasPrefix(base, hlsPlaybackSegmentPrefix) {
+		return false
+	}
+
+	return !hls.IsSegmentName(base)
+}
+
+// mpegtsReader serves a path that contains a H264 and/or an AAC track
+// to a HTTP client, as a continuous MPEG-TS stream.
+type mpegtsReader struct {
+	pathName   string
+	remoteAddr string
+	ringBuffer *ringbuffer.RingBuffer
+	stats      *trafficStats
+	parent     *hlsServer
+}
+
+// close implements reader.
+func (r *mpegtsReader) close() {
+	r.ringBuffer.Close()
+}
+
+// onReaderAccepted implements reader.
+func (r *mpegtsReader) onReaderAccepted() {
+	r.log(logger.Info, "is reading from path '%s'", r.pathName)
+}
+
+// onReaderData implements reader.
+func (r *mpegtsReader) onReaderData(data *data) {
+	r.ringBuffer.Push(data)
+}
+
+// onReaderAPIDescribe implements reader.
+func (r *mpegtsReader) onReaderAPIDescribe() interface{} {
+	return struct {
+		Type       string `json:"type"`
+		RemoteAddr string `json:"remoteAddr"`
+	}{"mpegtsReader", r.remoteAddr}
+}
+
+// protocol implements reader.
+func (*mpegtsReader) protocol() string {
+	return "mpegts"
+}
+
+// trafficStats implements reader.
+func (r *mpegtsReader) trafficStats() *trafficStats {
+	return r.stats
+}
+
+func (r *mpegtsReader) log(level logger.Level, format string, args ...interface{}) {
+	r.parent.log(level, "[mpegts %v] "+format, append([]interface{}{r.remoteAddr}, args...)...)
+}
+
+// handleMPEGTS serves a path as a continuous MPEG-TS stream, until the client
+// disconnects or the path is closed.
+func (s *hlsServer) handleMPEGTS(ctx *gin.Context, req hlsMuxerRequest) {
+	r := &mpegtsReader{
+		pathName:   req.dir,
+		remoteAddr: req.req.RemoteAddr,
+		ringBuffer: ringbuffer.New(uint64(s.readBufferCount)),
+		stats:      newTrafficStats(),
+		parent:     s,
+	}
+
+	res, ok := s.readerSetupPlay(ctx, req, r)
+	if !ok {
+		return
+	}
+
+	defer res.path.onReaderRemove(pathReaderRemoveReq{author: r})
+
+	var videoTrack *gortsplib.TrackH264
+	videoTrackID := -1
+	var audioTrack *gortsplib.TrackAAC
+	audioTrackID := -1
+	var aacDecoder *rtpaac.Decoder
+
+	for i, track := range res.stream.tracks() {
+		switch tt := track.(type) {
+		case *gortsplib.TrackH264:
+			if videoTrack == nil {
+				videoTrack = tt
+				videoTrackID = i
+			}
+
+		case *gortsplib.TrackAAC:
+			if audioTrack == nil {
+				audioTrack = tt
+				audioTrackID = i
+				aacDecoder = &rtpaac.Decoder{
+					SampleRate:       tt.ClockRate(),
+					SizeLength:       tt.SizeLength(),
+					IndexLength:      tt.IndexLength(),
+					IndexDeltaLength: tt.IndexDeltaLength(),
+				}
+				aacDecoder.Init()
+			}
+		}
+	}
+
+	if videoTrack == nil && audioTrack == nil {
+		r.log(logger.Info, "ERR: the stream doesn't contain an H264 track or an AAC track")
+		s.writeResponse(ctx, hlsMuxerResponse{status: http.StatusNotFound})
+		return
+	}
+
+	res.path.onReaderPlay(pathReaderPlayReq{author: r})
+
+	// stop reading when the client disconnects or the server is closed
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Request.Context().Done():
+		case <-s.ctx.Done():
+		case <-done:
+		}
+		r.ringBuffer.Close()
+	}()
+
+	ctx.Writer.Header().Set("Content-Type", "video/MP2T")
+	ctx.Writer.Header().Set("Cache-Control", "no-cache")
+	ctx.Writer.WriteHeader(http.StatusOK)
+	ctx.Writer.Flush()
+
+	streamer := hls.NewStreamer(videoTrack, audioTrack, ctx.Writer)
+
+	var videoInitialPTS *time.Duration
+
+	for {
+		item, ok := r.ringBuffer.Pull()
+		if !ok {
+			return
+		}
+		data := item.(*data)
+
+		if videoTrack != nil && data.trackID == videoTrackID {
+			if data.h264NALUs == nil {
+				continue
+			}
+
+			r.stats.onDataSent(data)
+
+			// video is decoded in another routine,
+			// while audio is decoded in this routine:
+			// we have to sync their PTS.
+			if videoInitialPTS == nil {
+				v := data.h264PTS
+				videoInitialPTS = &v
+			}
+			pts := data.h264PTS - *videoInitialPTS
+
+			err := streamer.WriteH264(pts, data.h264NALUs)
+			if err != nil {
+				r.log(logger.Info, "ERR: %v", err)
+				return
+			}
+		} else if audioTrack != nil && data.trackID == audioTrackID {
+			r.stats.onDataSent(data)
+
+			aus, pts, err := aacDecoder.Decode(data.rtp)
+			if err != nil {
+				if err != rtpaac.ErrMorePacketsNeeded {
+					r.log(logger.Warn, "unable to decode audio track: %v", err)
+				}
+				continue
+			}
+
+			err = streamer.WriteAAC(pts, aus)
+			if err != nil {
+				r.log(logger.Info, "ERR: %v", err)
+				return
+			}
+		} else {
+			continue
+		}
+
+		ctx.Writer.Flush()
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -62,10 +63,7 @@ type pathParent interface {
 	log(logger.Level, string, ...interface{})
 	onPathSourceReady(*path)
 	onPathClose(*path)
-}
-
-type pathRTSPSession interface {
-	IsRTSPSession()
+	onReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
 }
 
 type pathReaderState int
@@ -175,12 +173,41 @@ type pathPublisherPauseReq struct {
 	res    chan struct{}
 }
 
+type pathPlaybackRes struct {
+	err error
+}
+
+type pathPlaybackReq struct {
+	pathName     string
+	authenticate authenticateFunc
+	res          chan pathPlaybackRes
+}
+
+type pathAPISnapshotRes struct {
+	path  *path
+	frame *snapshotFrame
+	err   error
+}
+
+type pathAPISnapshotReq struct {
+	pathName string
+	res      chan pathAPISnapshotRes
+}
+
 type pathAPIPathsListItem struct {
 	ConfName    string         `json:"confName"`
 	Conf        *conf.PathConf `json:"conf"`
 	Source      interface{}    `json:"source"`
 	SourceReady bool           `json:"sourceReady"`
 	Readers     []interface{}  `json:"readers"`
+	trafficStatsData
+	Latency map[string]latencyStatsData `json:"latency"`
+	Health  pathHealthData              `json:"health"`
+
+	// used by metrics
+	trafficByProtocol map[string]trafficStatsData
+	hasStaticSource   bool
+	sourceReconnects  int64
 }
 
 type pathAPIPathsListData struct {
@@ -203,17 +230,26 @@ type pathAPIPathsListSubReq struct {
 }
 
 type path struct {
-	rtspAddress     string
-	readTimeout     conf.StringDuration
-	writeTimeout    conf.StringDuration
-	readBufferCount int
-	confName        string
-	conf            *conf.PathConf
-	name            string
-	matches         []string
-	wg              *sync.WaitGroup
-	externalCmdPool *externalcmd.Pool
-	parent          pathParent
+	rtspAddress           string
+	readTimeout           conf.StringDuration
+	writeTimeout          conf.StringDuration
+	readBufferCount       int
+	recordPath            string
+	recordSegmentDuration conf.StringDuration
+	recordSegmentMaxSize  conf.StringSize
+	snapshotter           *snapshotter
+	confName              string
+	confMutex             sync.RWMutex
+	conf                  *conf.PathConf
+	name                  string
+	matches               []string
+	wg                    *sync.WaitGroup
+	externalCmdPool       *externalcmd.Pool
+	webhookSender         *webhookSender
+	accessLimiter         *accessLimiter
+	events                *eventBus
+	metrics               *metrics
+	parent                pathParent
 
 	ctx                            context.Context
 	ctxCancel                      func()
@@ -221,6 +257,7 @@ type path struct {
 	sourceReady                    bool
 	sourceStaticWg                 sync.WaitGroup
 	stream                         *stream
+	recorder                       *recorder
 	readers                        map[reader]pathReaderState
 	describeRequestsOnHold         []pathDescribeReq
 	setupPlayRequestsOnHold        []pathReaderSetupPlayReq
@@ -232,6 +269,14 @@ type path struct {
 	onDemandPublisherState         pathOnDemandState
 	onDemandPublisherReadyTimer    *time.Timer
 	onDemandPublisherCloseTimer    *time.Timer
+	publishing                     int32 // accessed atomically by pathManager
+	sourceReadyTime                time.Time
+	sourceReconnects               int64 // accessed atomically
+	readerPlayTimes                map[reader]time.Time
+	closedTraffic                  map[string]trafficStatsData
+	latency                        *latencyStats
+	health                         *pathHealth
+	healthCmds                     map[healthConditionKey]*externalcmd.Cmd
 
 	// in
 	sourceStaticSetReady    chan pathSourceStaticSetReadyReq
@@ -246,6 +291,8 @@ type path struct {
 	readerPlay              chan pathReaderPlayReq
 	readerPause             chan pathReaderPauseReq
 	apiPathsList            chan pathAPIPathsListSubReq
+	apiSnapshot             chan pathAPISnapshotReq
+	playlistReload          chan *conf.PathConf
 }
 
 func newPath(
@@ -254,12 +301,21 @@ func newPath(
 	readTimeout conf.StringDuration,
 	writeTimeout conf.StringDuration,
 	readBufferCount int,
+	recordPath string,
+	recordSegmentDuration conf.StringDuration,
+	recordSegmentMaxSize conf.StringSize,
+	snapshotter *snapshotter,
 	confName string,
-	conf *conf.PathConf,
+	pathConf *conf.PathConf,
 	name string,
 	matches []string,
 	wg *sync.WaitGroup,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
+	events *eventBus,
+	metrics *metrics,
+	latencyTracer *latencyTracer,
 	parent pathParent,
 ) *path {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
@@ -269,16 +325,29 @@ func newPath(
 		readTimeout:                    readTimeout,
 		writeTimeout:                   writeTimeout,
 		readBufferCount:                readBufferCount,
+		recordPath:                     recordPath,
+		recordSegmentDuration:          recordSegmentDuration,
+		recordSegmentMaxSize:           recordSegmentMaxSize,
+		snapshotter:                    snapshotter,
 		confName:                       confName,
-		conf:                           conf,
+		conf:                           pathConf,
 		name:                           name,
 		matches:                        matches,
 		wg:                             wg,
 		externalCmdPool:                externalCmdPool,
+		webhookSender:                  webhookSender,
+		accessLimiter:                  accessLimiter,
+		events:                         events,
+		metrics:                        metrics,
 		parent:                         parent,
 		ctx:                            ctx,
 		ctxCancel:                      ctxCancel,
 		readers:                        make(map[reader]pathReaderState),
+		readerPlayTimes:                make(map[reader]time.Time),
+		closedTraffic:                  make(map[string]trafficStatsData),
+		latency:                        newLatencyStats(name, latencyTracer),
+		health:                         newPathHealth(),
+		healthCmds:                     make(map[healthConditionKey]*externalcmd.Cmd),
 		onDemandStaticSourceReadyTimer: newEmptyTimer(),
 		onDemandStaticSourceCloseTimer: newEmptyTimer(),
 		onDemandPublisherReadyTimer:    newEmptyTimer(),
@@ -295,6 +364,8 @@ func newPath(
 		readerPlay:                     make(chan pathReaderPlayReq),
 		readerPause:                    make(chan pathReaderPauseReq),
 		apiPathsList:                   make(chan pathAPIPathsListSubReq),
+		apiSnapshot:                    make(chan pathAPISnapshotReq),
+		playlistReload:                 make(chan *conf.PathConf, 1),
 	}
 
 	pa.log(logger.Debug, "created")
@@ -321,6 +392,8 @@ func (pa *path) ConfName() string {
 
 // Conf returns the configuration of this path.
 func (pa *path) Conf() *conf.PathConf {
+	pa.confMutex.RLock()
+	defer pa.confMutex.RUnlock()
 	return pa.conf
 }
 
@@ -329,12 +402,22 @@ func (pa *path) Name() string {
 	return pa.name
 }
 
+// isPublishing returns whether a publisher is assigned to the path.
+func (pa *path) isPublishing() bool {
+	return atomic.LoadInt32(&pa.publishing) == 1
+}
+
 func (pa *path) hasStaticSource() bool {
 	return strings.HasPrefix(pa.conf.Source, "rtsp://") ||
 		strings.HasPrefix(pa.conf.Source, "rtsps://") ||
 		strings.HasPrefix(pa.conf.Source, "rtmp://") ||
 		strings.HasPrefix(pa.conf.Source, "http://") ||
-		strings.HasPrefix(pa.conf.Source, "https://")
+		strings.HasPrefix(pa.conf.Source, "https://") ||
+		strings.HasPrefix(pa.conf.Source, "mjpeg+http://") ||
+		strings.HasPrefix(pa.conf.Source, "mjpeg+https://") ||
+		strings.HasPrefix(pa.conf.Source, "file://") ||
+		pa.conf.Source == "playlist" ||
+		pa.conf.Source == "testpattern"
 }
 
 func (pa *path) hasOnDemandStaticSource() bool {
@@ -342,7 +425,7 @@ func (pa *path) hasOnDemandStaticSource() bool {
 }
 
 func (pa *path) hasOnDemandPublisher() bool {
-	return pa.conf.RunOnDemand != ""
+	return pa.conf.RunOnDemand != "" || pa.conf.WebhookOnDemand != ""
 }
 
 func (pa *path) run() {
@@ -367,9 +450,27 @@ func (pa *path) run() {
 			})
 	}
 
+	pa.sendWebhook(pa.conf.WebhookOnInit, "init", "start")
+
+	healthTicker := time.NewTicker(healthCheckPeriod)
+	defer healthTicker.Stop()
+
+	var snapshotTickerC <-chan time.Time
+	if pa.conf.SnapshotPeriod != 0 {
+		snapshotTicker := time.NewTicker(time.Duration(pa.conf.SnapshotPeriod))
+		defer snapshotTicker.Stop()
+		snapshotTickerC = snapshotTicker.C
+	}
+
 	err := func() error {
 		for {
 			select {
+			case <-healthTicker.C:
+				pa.healthCheck()
+
+			case <-snapshotTickerC:
+				pa.snapshotSave()
+
 			case <-pa.onDemandStaticSourceReadyTimer.C:
 				for _, req := range pa.describeRequestsOnHold {
 					req.res <- pathDescribeRes{err: fmt.Errorf("source of path '%s' has timed out", pa.name)}
@@ -506,6 +607,12 @@ func (pa *path) run() {
 			case req := <-pa.apiPathsList:
 				pa.handleAPIPathsList(req)
 
+			case req := <-pa.apiSnapshot:
+				pa.handleAPISnapshot(req)
+
+			case newConf := <-pa.playlistReload:
+				pa.handlePlaylistReload(newConf)
+
 			case <-pa.ctx.Done():
 				return fmt.Errorf("terminated")
 			}
@@ -524,6 +631,8 @@ func (pa *path) run() {
 		pa.log(logger.Info, "runOnInit command stopped")
 	}
 
+	pa.sendWebhook(pa.conf.WebhookOnInit, "init", "stop")
+
 	for _, req := range pa.describeRequestsOnHold {
 		req.res <- pathDescribeRes{err: fmt.Errorf("terminated")}
 	}
@@ -572,11 +681,29 @@ func (pa *path) externalCmdEnv() externalcmd.Environment {
 		for i, ma := range pa.matches[1:] {
 			env["G"+strconv.FormatInt(int64(i+1), 10)] = ma
 		}
+
+		for i, name := range pa.Conf().Regexp.SubexpNames() {
+			if name != "" && i < len(pa.matches) {
+				env[name] = pa.matches[i]
+			}
+		}
 	}
 
 	return env
 }
 
+func (pa *path) sendWebhook(url string, event string, action string) {
+	if url == "" {
+		return
+	}
+
+	pa.webhookSender.send(url, webhookPayload{
+		Event:  event,
+		Action: action,
+		Env:    pa.externalCmdEnv(),
+	})
+}
+
 func (pa *path) onDemandStaticSourceStart() {
 	pa.staticSourceCreate()
 
@@ -603,18 +730,23 @@ func (pa *path) onDemandStaticSourceStop() {
 
 	pa.source.(sourceStatic).close()
 	pa.source = nil
+	atomic.StoreInt32(&pa.publishing, 0)
 }
 
 func (pa *path) onDemandPublisherStart() {
-	pa.log(logger.Info, "runOnDemand command started")
-	pa.onDemandCmd = externalcmd.NewCmd(
-		pa.externalCmdPool,
-		pa.conf.RunOnDemand,
-		pa.conf.RunOnDemandRestart,
-		pa.externalCmdEnv(),
-		func(co int) {
-			pa.log(logger.Info, "runOnDemand command exited with code %d", co)
-		})
+	if pa.conf.RunOnDemand != "" {
+		pa.log(logger.Info, "runOnDemand command started")
+		pa.onDemandCmd = externalcmd.NewCmd(
+			pa.externalCmdPool,
+			pa.conf.RunOnDemand,
+			pa.conf.RunOnDemandRestart,
+			pa.externalCmdEnv(),
+			func(co int) {
+				pa.log(logger.Info, "runOnDemand command exited with code %d", co)
+			})
+	}
+
+	pa.sendWebhook(pa.conf.WebhookOnDemand, "demand", "start")
 
 	pa.onDemandPublisherReadyTimer.Stop()
 	pa.onDemandPublisherReadyTimer = time.NewTimer(time.Duration(pa.conf.RunOnDemandStartTimeout))
@@ -648,14 +780,42 @@ func (pa *path) onDemandPublisherStop() {
 		pa.onDemandCmd = nil
 		pa.log(logger.Info, "runOnDemand command stopped")
 	}
+
+	pa.sendWebhook(pa.conf.WebhookOnDemand, "demand", "stop")
 }
 
 func (pa *path) sourceSetReady(tracks gortsplib.Tracks) {
 	pa.sourceReady = true
-	pa.stream = newStream(tracks)
+	pa.sourceReadyTime = time.Now()
+	pa.latency.onSourceReady()
+	pa.stream = newStream(tracks, pa.latency)
+
+	if pa.conf.Record {
+		r, err := newRecorder(
+			pa.recordPath,
+			pa.recordSegmentDuration,
+			pa.recordSegmentMaxSize,
+			pa.readBufferCount,
+			pa.name,
+			tracks,
+			pa)
+		if err != nil {
+			pa.log(logger.Warn, "unable to record: %v", err)
+		} else {
+			pa.recorder = r
+			pa.stream.readerAdd(r)
+			r.onReaderAccepted()
+		}
+	}
 
 	pa.parent.onPathSourceReady(pa)
 
+	pa.events.publish(event{
+		Type:   eventPathReady,
+		Path:   pa.name,
+		Source: pa.source.onSourceAPIDescribe(),
+	})
+
 	if pa.conf.RunOnReady != "" {
 		pa.log(logger.Info, "runOnReady command started")
 		pa.onReadyCmd = externalcmd.NewCmd(
@@ -667,6 +827,8 @@ func (pa *path) sourceSetReady(tracks gortsplib.Tracks) {
 				pa.log(logger.Info, "runOnReady command exited with code %d", co)
 			})
 	}
+
+	pa.sendWebhook(pa.conf.WebhookOnReady, "ready", "start")
 }
 
 func (pa *path) sourceSetNotReady() {
@@ -681,9 +843,33 @@ func (pa *path) sourceSetNotReady() {
 		pa.log(logger.Info, "runOnReady command stopped")
 	}
 
+	if pa.sourceReady {
+		pa.sendWebhook(pa.conf.WebhookOnReady, "ready", "stop")
+
+		pa.events.publish(event{
+			Type: eventPathNotReady,
+			Path: pa.name,
+		})
+	}
+
 	pa.sourceReady = false
 
+	pa.healthUpdate(nil, nil)
+
+	if pa.recorder != nil {
+		pa.stream.readerRemove(pa.recorder)
+		pa.recorder.close()
+		pa.recorder = nil
+	}
+
 	if pa.stream != nil {
+		protocol := pa.source.protocol()
+		pa.closedTraffic[protocol] = trafficStatsAdd(pa.closedTraffic[protocol], pa.stream.stats.get())
+
+		if _, ok := pa.source.(publisher); ok && pa.metrics != nil {
+			pa.metrics.onPublisherSessionEnd(protocol, time.Since(pa.sourceReadyTime))
+		}
+
 		pa.stream.close()
 		pa.stream = nil
 	}
@@ -722,6 +908,43 @@ func (pa *path) staticSourceCreate() {
 			pa.conf.SourceFingerprint,
 			&pa.sourceStaticWg,
 			pa)
+
+	case strings.HasPrefix(pa.conf.Source, "mjpeg+http://") ||
+		strings.HasPrefix(pa.conf.Source, "mjpeg+https://"):
+		pa.source = newMJPEGSource(
+			pa.ctx,
+			pa.conf.Source,
+			pa.conf.SourceFingerprint,
+			pa.readTimeout,
+			&pa.sourceStaticWg,
+			pa)
+
+	case strings.HasPrefix(pa.conf.Source, "file://"):
+		pa.source = newFileSource(
+			pa.ctx,
+			pa.conf.Source,
+			pa.conf.SourceLoop,
+			&pa.sourceStaticWg,
+			pa)
+
+	case pa.conf.Source == "playlist":
+		pa.source = newPlaylistSource(
+			pa.ctx,
+			pa.name,
+			pa.conf.Playlist,
+			pa.readBufferCount,
+			pa.parent,
+			&pa.sourceStaticWg,
+			pa)
+
+	case pa.conf.Source == "testpattern":
+		pa.source = newTestPatternSource(
+			pa.ctx,
+			pa.conf.TestPatternResolution,
+			pa.conf.TestPatternFPS,
+			pa.conf.TestPatternBitrate,
+			&pa.sourceStaticWg,
+			pa)
 	}
 }
 
@@ -733,9 +956,31 @@ func (pa *path) doReaderRemove(r reader) {
 	}
 
 	delete(pa.readers, r)
+
+	protocol := r.protocol()
+	pa.closedTraffic[protocol] = trafficStatsAdd(pa.closedTraffic[protocol], r.trafficStats().get())
+
+	if t, ok := pa.readerPlayTimes[r]; ok {
+		delete(pa.readerPlayTimes, r)
+		if pa.metrics != nil {
+			pa.metrics.onReaderSessionEnd(protocol, time.Since(t))
+		}
+	}
+
+	pa.events.publish(event{
+		Type:   eventReaderRemoved,
+		Path:   pa.name,
+		Reader: r.onReaderAPIDescribe(),
+	})
 }
 
 func (pa *path) doPublisherRemove() {
+	pa.events.publish(event{
+		Type:   eventPublisherDisconnected,
+		Path:   pa.name,
+		Source: pa.source.onSourceAPIDescribe(),
+	})
+
 	if pa.sourceReady {
 		if pa.hasOnDemandPublisher() && pa.onDemandPublisherState != pathOnDemandStateInitial {
 			pa.onDemandPublisherStop()
@@ -820,11 +1065,17 @@ func (pa *path) handlePublisherAnnounce(req pathPublisherAnnounceReq) {
 		}
 
 		pa.log(logger.Info, "closing existing publisher")
+		pa.events.publish(event{
+			Type:   eventPublisherKicked,
+			Path:   pa.name,
+			Source: pa.source.onSourceAPIDescribe(),
+		})
 		pa.source.(publisher).close()
 		pa.doPublisherRemove()
 	}
 
 	pa.source = req.author
+	atomic.StoreInt32(&pa.publishing, 1)
 
 	req.res <- pathPublisherAnnounceRes{path: pa}
 }
@@ -837,6 +1088,12 @@ func (pa *path) handlePublisherRecord(req pathPublisherRecordReq) {
 
 	req.author.onPublisherAccepted(len(req.tracks))
 
+	pa.events.publish(event{
+		Type:   eventPublisherConnected,
+		Path:   pa.name,
+		Source: req.author.onSourceAPIDescribe(),
+	})
+
 	pa.sourceSetReady(req.tracks)
 
 	if pa.hasOnDemandPublisher() {
@@ -917,6 +1174,15 @@ func (pa *path) handleReaderSetupPlay(req pathReaderSetupPlayReq) {
 }
 
 func (pa *path) handleReaderSetupPlayPost(req pathReaderSetupPlayReq) {
+	if _, ok := pa.readers[req.author]; !ok &&
+		pa.conf.MaxReaders != 0 && len(pa.readers) >= pa.conf.MaxReaders {
+		pa.accessLimiter.onReaderRejected()
+		req.res <- pathReaderSetupPlayRes{
+			err: fmt.Errorf("maximum number of readers of path '%s' reached", pa.name),
+		}
+		return
+	}
+
 	pa.readers[req.author] = pathReaderStatePrePlay
 
 	if pa.hasOnDemandStaticSource() {
@@ -942,10 +1208,20 @@ func (pa *path) handleReaderSetupPlayPost(req pathReaderSetupPlayReq) {
 func (pa *path) handleReaderPlay(req pathReaderPlayReq) {
 	pa.readers[req.author] = pathReaderStatePlay
 
+	if _, ok := pa.readerPlayTimes[req.author]; !ok {
+		pa.readerPlayTimes[req.author] = time.Now()
+	}
+
 	pa.stream.readerAdd(req.author)
 
 	req.author.onReaderAccepted()
 
+	pa.events.publish(event{
+		Type:   eventReaderAdded,
+		Path:   pa.name,
+		Reader: req.author.onReaderAPIDescribe(),
+	})
+
 	close(req.res)
 }
 
@@ -975,10 +1251,147 @@ func (pa *path) handleAPIPathsList(req pathAPIPathsListSubReq) {
 			}
 			return ret
 		}(),
+		trafficStatsData:  pa.trafficStats(),
+		Latency:           pa.latency.get(),
+		Health:            pa.health.get(),
+		trafficByProtocol: pa.trafficByProtocol(),
+		hasStaticSource:   pa.hasStaticSource(),
+		sourceReconnects:  atomic.LoadInt64(&pa.sourceReconnects),
 	}
 	close(req.res)
 }
 
+// trafficStats returns the statistics of the stream, that are collected on
+// incoming data, and the sum of data sent to readers.
+func (pa *path) trafficStats() trafficStatsData {
+	var ret trafficStatsData
+
+	if pa.stream != nil {
+		ret = pa.stream.stats.get()
+	}
+
+	for r := range pa.readers {
+		rs := r.trafficStats().get()
+		ret.BytesSent += rs.BytesSent
+		ret.PacketsSent += rs.PacketsSent
+	}
+
+	return ret
+}
+
+// trafficByProtocol returns the total traffic of the path, grouped by the
+// protocol of the source and of readers.
+func (pa *path) trafficByProtocol() map[string]trafficStatsData {
+	ret := make(map[string]trafficStatsData)
+
+	for protocol, st := range pa.closedTraffic {
+		ret[protocol] = st
+	}
+
+	if pa.recorder != nil {
+		pa.stream.readerRemove(pa.recorder)
+		pa.recorder.close()
+		pa.recorder = nil
+	}
+
+	if pa.stream != nil {
+		protocol := pa.source.protocol()
+		ret[protocol] = trafficStatsAdd(ret[protocol], pa.stream.stats.get())
+	}
+
+	for r := range pa.readers {
+		protocol := r.protocol()
+		ret[protocol] = trafficStatsAdd(ret[protocol], r.trafficStats().get())
+	}
+
+	return ret
+}
+
+func (pa *path) handleAPISnapshot(req pathAPISnapshotReq) {
+	if pa.stream == nil {
+		req.res <- pathAPISnapshotRes{err: pathErrNoOnePublishing{pathName: pa.name}}
+		return
+	}
+
+	frame := pa.stream.snapshot.get()
+	if frame == nil {
+		req.res <- pathAPISnapshotRes{err: fmt.Errorf("no keyframe received yet")}
+		return
+	}
+
+	req.res <- pathAPISnapshotRes{frame: frame}
+}
+
+// handlePlaylistReload applies a configuration that differs from
+// the current one in the playlist only, without restarting the source.
+func (pa *path) handlePlaylistReload(newConf *conf.PathConf) {
+	pa.confMutex.Lock()
+	pa.conf = newConf
+	pa.confMutex.Unlock()
+
+	if source, ok := pa.source.(*playlistSource); ok {
+		source.onReload(newConf.Playlist)
+	}
+}
+
+// snapshotSave saves the last keyframe of the stream to disk.
+func (pa *path) snapshotSave() {
+	if pa.stream == nil {
+		return
+	}
+
+	frame := pa.stream.snapshot.get()
+	if frame == nil {
+		return
+	}
+
+	pa.snapshotter.onSave(pa.name, frame)
+}
+
+// healthCheck evaluates the conditions of the stream.
+func (pa *path) healthCheck() {
+	if pa.stream == nil {
+		return
+	}
+
+	conditions, tracks := pa.stream.health.check(time.Now(), pa.conf)
+	pa.healthUpdate(conditions, tracks)
+}
+
+func (pa *path) healthUpdate(conditions []healthConditionKey, tracks []healthTrackData) {
+	pa.health.tracks = tracks
+	raised, cleared := pa.health.update(time.Now(), conditions)
+
+	for _, key := range raised {
+		pa.log(logger.Warn, "health condition '%s' raised on track %d", key.condition, key.track+1)
+
+		if pa.conf.RunOnHealthAlarm != "" {
+			pa.log(logger.Info, "runOnHealthAlarm command started")
+			env := pa.externalCmdEnv()
+			env["RTSP_HEALTH_CONDITION"] = string(key.condition)
+			env["RTSP_HEALTH_TRACK"] = strconv.FormatInt(int64(key.track), 10)
+			pa.healthCmds[key] = externalcmd.NewCmd(
+				pa.externalCmdPool,
+				pa.conf.RunOnHealthAlarm,
+				pa.conf.RunOnHealthAlarmRestart,
+				env,
+				func(co int) {
+					pa.log(logger.Info, "runOnHealthAlarm command exited with code %d", co)
+				})
+		}
+	}
+
+	for _, key := range cleared {
+		pa.log(logger.Info, "health condition '%s' cleared on track %d", key.condition, key.track+1)
+
+		if cmd, ok := pa.healthCmds[key]; ok {
+			cmd.Close()
+			delete(pa.healthCmds, key)
+			pa.log(logger.Info, "runOnHealthAlarm command stopped")
+		}
+	}
+}
+
 // onSourceStaticSetReady is called by a sourceStatic.
 func (pa *path) onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes {
 	req.res = make(chan pathSourceStaticSetReadyRes)
@@ -990,6 +1403,17 @@ func (pa *path) onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSour
 	}
 }
 
+// onSourceStaticError is called by a sourceStatic.
+func (pa *path) onSourceStaticError(err error) {
+	atomic.AddInt64(&pa.sourceReconnects, 1)
+
+	pa.events.publish(event{
+		Type:  eventSourceError,
+		Path:  pa.name,
+		Error: err.Error(),
+	})
+}
+
 // onSourceStaticSetNotReady is called by a sourceStatic.
 func (pa *path) onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq) {
 	req.res = make(chan struct{})
@@ -1101,3 +1525,32 @@ func (pa *path) onAPIPathsList(req pathAPIPathsListSubReq) {
 	case <-pa.ctx.Done():
 	}
 }
+
+// onAPISnapshot is called by api.
+func (pa *path) onAPISnapshot(req pathAPISnapshotReq) pathAPISnapshotRes {
+	select {
+	case pa.apiSnapshot <- req:
+		return <-req.res
+
+	case <-pa.ctx.Done():
+		return pathAPISnapshotRes{err: fmt.Errorf("terminated")}
+	}
+}
+
+// onPlaylistReload is called by pathManager.
+// It doesn't block, since the path may be waiting for pathManager.
+func (pa *path) onPlaylistReload(newConf *conf.PathConf) {
+	for {
+		select {
+		case pa.playlistReload <- newConf:
+			return
+
+		default:
+			// replace the pending configuration
+			select {
+			case <-pa.playlistReload:
+			default:
+			}
+		}
+	}
+}
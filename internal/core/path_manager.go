@@ -19,14 +19,22 @@ type pathManagerParent interface {
 }
 
 type pathManager struct {
-	rtspAddress     string
-	readTimeout     conf.StringDuration
-	writeTimeout    conf.StringDuration
-	readBufferCount int
-	pathConfs       map[string]*conf.PathConf
-	externalCmdPool *externalcmd.Pool
-	metrics         *metrics
-	parent          pathManagerParent
+	rtspAddress           string
+	readTimeout           conf.StringDuration
+	writeTimeout          conf.StringDuration
+	readBufferCount       int
+	recordPath            string
+	recordSegmentDuration conf.StringDuration
+	recordSegmentMaxSize  conf.StringSize
+	snapshotter           *snapshotter
+	pathConfs             map[string]*conf.PathConf
+	externalCmdPool       *externalcmd.Pool
+	webhookSender         *webhookSender
+	accessLimiter         *accessLimiter
+	metrics               *metrics
+	events                *eventBus
+	latencyTracer         *latencyTracer
+	parent                pathManagerParent
 
 	ctx       context.Context
 	ctxCancel func()
@@ -41,6 +49,8 @@ type pathManager struct {
 	describe          chan pathDescribeReq
 	readerSetupPlay   chan pathReaderSetupPlayReq
 	publisherAnnounce chan pathPublisherAnnounceReq
+	playback          chan pathPlaybackReq
+	apiSnapshot       chan pathAPISnapshotReq
 	hlsServerSet      chan pathManagerHLSServer
 	apiPathsList      chan pathAPIPathsListReq
 }
@@ -51,33 +61,51 @@ func newPathManager(
 	readTimeout conf.StringDuration,
 	writeTimeout conf.StringDuration,
 	readBufferCount int,
+	recordPath string,
+	recordSegmentDuration conf.StringDuration,
+	recordSegmentMaxSize conf.StringSize,
+	snapshotter *snapshotter,
 	pathConfs map[string]*conf.PathConf,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
 	metrics *metrics,
+	events *eventBus,
+	latencyTracer *latencyTracer,
 	parent pathManagerParent,
 ) *pathManager {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	pm := &pathManager{
-		rtspAddress:       rtspAddress,
-		readTimeout:       readTimeout,
-		writeTimeout:      writeTimeout,
-		readBufferCount:   readBufferCount,
-		pathConfs:         pathConfs,
-		externalCmdPool:   externalCmdPool,
-		metrics:           metrics,
-		parent:            parent,
-		ctx:               ctx,
-		ctxCancel:         ctxCancel,
-		paths:             make(map[string]*path),
-		confReload:        make(chan map[string]*conf.PathConf),
-		pathClose:         make(chan *path),
-		pathSourceReady:   make(chan *path),
-		describe:          make(chan pathDescribeReq),
-		readerSetupPlay:   make(chan pathReaderSetupPlayReq),
-		publisherAnnounce: make(chan pathPublisherAnnounceReq),
-		hlsServerSet:      make(chan pathManagerHLSServer),
-		apiPathsList:      make(chan pathAPIPathsListReq),
+		rtspAddress:           rtspAddress,
+		readTimeout:           readTimeout,
+		writeTimeout:          writeTimeout,
+		readBufferCount:       readBufferCount,
+		recordPath:            recordPath,
+		recordSegmentDuration: recordSegmentDuration,
+		recordSegmentMaxSize:  recordSegmentMaxSize,
+		snapshotter:           snapshotter,
+		pathConfs:             pathConfs,
+		externalCmdPool:       externalCmdPool,
+		webhookSender:         webhookSender,
+		accessLimiter:         accessLimiter,
+		metrics:               metrics,
+		events:                events,
+		latencyTracer:         latencyTracer,
+		parent:                parent,
+		ctx:                   ctx,
+		ctxCancel:             ctxCancel,
+		paths:                 make(map[string]*path),
+		confReload:            make(chan map[string]*conf.PathConf),
+		pathClose:             make(chan *path),
+		pathSourceReady:       make(chan *path),
+		describe:              make(chan pathDescribeReq),
+		readerSetupPlay:       make(chan pathReaderSetupPlayReq),
+		publisherAnnounce:     make(chan pathPublisherAnnounceReq),
+		playback:              make(chan pathPlaybackReq),
+		apiSnapshot:           make(chan pathAPISnapshotReq),
+		hlsServerSet:          make(chan pathManagerHLSServer),
+		apiPathsList:          make(chan pathAPIPathsListReq),
 	}
 
 	for pathConfName, pathConf := range pm.pathConfs {
@@ -140,10 +168,27 @@ outer:
 			// remove paths associated with a conf which doesn't exist anymore
 			// or has changed
 			for _, pa := range pm.paths {
-				if pathConf, ok := pm.pathConfs[pa.ConfName()]; !ok || pathConf != pa.Conf() {
+				pathConf, ok := pm.pathConfs[pa.ConfName()]
+				if !ok {
 					delete(pm.paths, pa.Name())
 					pa.close()
+					continue
+				}
+
+				newConf := pathConf.Expand(pa.matches)
+				if newConf.Equal(pa.Conf()) {
+					continue
+				}
+
+				// playlists are reloaded without closing the path,
+				// in order not to disconnect readers.
+				if newConf.EqualIgnoringPlaylist(pa.Conf()) {
+					pa.onPlaylistReload(newConf)
+					continue
 				}
+
+				delete(pm.paths, pa.Name())
+				pa.close()
 			}
 
 			// add new paths
@@ -229,6 +274,15 @@ outer:
 				continue
 			}
 
+			if pathConf.MaxPublishers != 0 &&
+				pm.publishersCount(pathConfName, req.pathName) >= pathConf.MaxPublishers {
+				pm.accessLimiter.onPublisherRejected()
+				req.res <- pathPublisherAnnounceRes{
+					err: fmt.Errorf("maximum number of publishers of configuration '%s' reached", pathConfName),
+				}
+				continue
+			}
+
 			// create path if it doesn't exist
 			if _, ok := pm.paths[req.pathName]; !ok {
 				pm.createPath(pathConfName, pathConf, req.pathName, pathMatches)
@@ -236,6 +290,33 @@ outer:
 
 			req.res <- pathPublisherAnnounceRes{path: pm.paths[req.pathName]}
 
+		case req := <-pm.playback:
+			_, pathConf, _, err := pm.findPathConf(req.pathName)
+			if err != nil {
+				req.res <- pathPlaybackRes{err: err}
+				continue
+			}
+
+			err = req.authenticate(
+				pathConf.ReadIPs,
+				pathConf.ReadUser,
+				pathConf.ReadPass)
+			if err != nil {
+				req.res <- pathPlaybackRes{err: err}
+				continue
+			}
+
+			req.res <- pathPlaybackRes{}
+
+		case req := <-pm.apiSnapshot:
+			pa, ok := pm.paths[req.pathName]
+			if !ok {
+				req.res <- pathAPISnapshotRes{err: pathErrNoOnePublishing{pathName: req.pathName}}
+				continue
+			}
+
+			req.res <- pathAPISnapshotRes{path: pa}
+
 		case s := <-pm.hlsServerSet:
 			pm.hlsServer = s
 
@@ -274,15 +355,36 @@ func (pm *pathManager) createPath(
 		pm.readTimeout,
 		pm.writeTimeout,
 		pm.readBufferCount,
+		pm.recordPath,
+		pm.recordSegmentDuration,
+		pm.recordSegmentMaxSize,
+		pm.snapshotter,
 		pathConfName,
 		pathConf,
 		name,
 		matches,
 		&pm.wg,
 		pm.externalCmdPool,
+		pm.webhookSender,
+		pm.accessLimiter,
+		pm.events,
+		pm.metrics,
+		pm.latencyTracer,
 		pm)
 }
 
+// publishersCount returns the number of paths that share a configuration and
+// have a publisher, excluding the given path.
+func (pm *pathManager) publishersCount(confName string, excludedName string) int {
+	n := 0
+	for name, pa := range pm.paths {
+		if name != excludedName && pa.ConfName() == confName && pa.isPublishing() {
+			n++
+		}
+	}
+	return n
+}
+
 func (pm *pathManager) findPathConf(name string) (string, *conf.PathConf, []string, error) {
 	err := conf.IsValidPathName(name)
 	if err != nil {
@@ -299,7 +401,7 @@ func (pm *pathManager) findPathConf(name string) (string, *conf.PathConf, []stri
 		if pathConf.Regexp != nil {
 			m := pathConf.Regexp.FindStringSubmatch(name)
 			if m != nil {
-				return pathConfName, pathConf, m, nil
+				return pathConfName, pathConf.Expand(m), m, nil
 			}
 		}
 	}
@@ -382,6 +484,37 @@ func (pm *pathManager) onReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderS
 	}
 }
 
+// onPlayback is called by a reader of recordings.
+// Paths are not created, since recordings are read from disk.
+func (pm *pathManager) onPlayback(req pathPlaybackReq) pathPlaybackRes {
+	req.res = make(chan pathPlaybackRes)
+	select {
+	case pm.playback <- req:
+		return <-req.res
+
+	case <-pm.ctx.Done():
+		return pathPlaybackRes{err: fmt.Errorf("terminated")}
+	}
+}
+
+// onAPISnapshot is called by api.
+func (pm *pathManager) onAPISnapshot(req pathAPISnapshotReq) pathAPISnapshotRes {
+	req.res = make(chan pathAPISnapshotRes)
+	select {
+	case pm.apiSnapshot <- req:
+		res := <-req.res
+		if res.err != nil {
+			return res
+		}
+
+		req.res = make(chan pathAPISnapshotRes)
+		return res.path.onAPISnapshot(req)
+
+	case <-pm.ctx.Done():
+		return pathAPISnapshotRes{err: fmt.Errorf("terminated")}
+	}
+}
+
 // onHLSServerSet is called by hlsServer.
 func (pm *pathManager) onHLSServerSet(s pathManagerHLSServer) {
 	select {
@@ -0,0 +1,43 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestPathManagerFindPathConf(t *testing.T) {
+	c := &conf.Conf{
+		Paths: map[string]*conf.PathConf{
+			"mypath": {},
+			"~^cam(\\d+)$": {
+				Source:         "rtsp://10.0.0.$G1/stream",
+				SourceOnDemand: true,
+				RunOnReady:     "ffmpeg -i rtsp://localhost:$RTSP_PORT/$RTSP_PATH -f null $G1",
+			},
+		},
+	}
+	err := c.CheckAndFillMissing()
+	require.NoError(t, err)
+
+	pm := &pathManager{pathConfs: c.Paths}
+
+	name, pathConf, matches, err := pm.findPathConf("mypath")
+	require.NoError(t, err)
+	require.Equal(t, "mypath", name)
+	require.Same(t, c.Paths["mypath"], pathConf)
+	require.Nil(t, matches)
+
+	name, pathConf, matches, err = pm.findPathConf("cam15")
+	require.NoError(t, err)
+	require.Equal(t, "~^cam(\\d+)$", name)
+	require.Equal(t, []string{"cam15", "15"}, matches)
+	require.Equal(t, "rtsp://10.0.0.15/stream", pathConf.Source)
+	require.Equal(t, "ffmpeg -i rtsp://localhost:$RTSP_PORT/$RTSP_PATH -f null 15", pathConf.RunOnReady)
+	require.Equal(t, "rtsp://10.0.0.$G1/stream", c.Paths["~^cam(\\d+)$"].Source)
+
+	_, _, _, err = pm.findPathConf("other")
+	require.EqualError(t, err, "path 'other' is not configured")
+}
@@ -0,0 +1,740 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	playlistSourceRetryPause = 5 * time.Second
+)
+
+// playlistSourceReader reads another path, in order to play it
+// as an item of a playlist.
+type playlistSourceReader struct {
+	source     *playlistSource
+	pathName   string
+	ringBuffer *ringbuffer.RingBuffer
+	stats      *trafficStats
+}
+
+// close implements reader.
+func (r *playlistSourceReader) close() {
+	r.ringBuffer.Close()
+}
+
+// onReaderAccepted implements reader.
+func (r *playlistSourceReader) onReaderAccepted() {
+	r.source.Log(logger.Info, "is reading from path '%s'", r.pathName)
+}
+
+// onReaderData implements reader.
+func (r *playlistSourceReader) onReaderData(data *data) {
+	r.ringBuffer.Push(data)
+}
+
+// onReaderAPIDescribe implements reader.
+func (r *playlistSourceReader) onReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	}{"playlistSource", r.source.pathName}
+}
+
+// protocol implements reader.
+func (*playlistSourceReader) protocol() string {
+	return "playlist"
+}
+
+// trafficStats implements reader.
+func (r *playlistSourceReader) trafficStats() *trafficStats {
+	return r.stats
+}
+
+// playlistSourcePathDemuxer reads units of data from another path.
+type playlistSourcePathDemuxer struct {
+	r               *playlistSourceReader
+	path            *path
+	videoTrackID    int
+	audioTrackID    int
+	aacDecoder      *rtpaac.Decoder
+	videoInitialPTS *time.Duration
+	videoDTSEst     *h264.DTSEstimator
+}
+
+func (d *playlistSourcePathDemuxer) read() (*fileSourceUnit, error) {
+	for {
+		item, ok := d.r.ringBuffer.Pull()
+		if !ok {
+			// the path is not ready anymore, or the item has been interrupted
+			return nil, io.EOF
+		}
+		data := item.(*data)
+
+		d.r.stats.onDataSent(data)
+
+		switch data.trackID {
+		case d.videoTrackID:
+			if data.h264NALUs == nil {
+				continue
+			}
+
+			if d.videoInitialPTS == nil {
+				v := data.h264PTS
+				d.videoInitialPTS = &v
+			}
+			pts := data.h264PTS - *d.videoInitialPTS
+
+			// DTS can be estimated only starting from an IDR
+			if d.videoDTSEst == nil {
+				if !h264.IDRPresent(data.h264NALUs) {
+					continue
+				}
+				d.videoDTSEst = h264.NewDTSEstimator()
+			}
+
+			return &fileSourceUnit{
+				h264NALUs: data.h264NALUs,
+				pts:       pts,
+				dts:       d.videoDTSEst.Feed(pts),
+			}, nil
+
+		case d.audioTrackID:
+			aus, pts, err := d.aacDecoder.Decode(data.rtp)
+			if err != nil {
+				continue
+			}
+
+			return &fileSourceUnit{
+				aacAUs: aus,
+				pts:    pts,
+				dts:    pts,
+			}, nil
+		}
+	}
+}
+
+func (d *playlistSourcePathDemuxer) close() {
+	d.path.onReaderRemove(pathReaderRemoveReq{author: d.r})
+	d.r.ringBuffer.Close()
+}
+
+// playlistSourceItem is an item of a playlist that is being played.
+type playlistSourceItem struct {
+	videoTrack *gortsplib.TrackH264
+	audioTrack *gortsplib.TrackAAC
+	demuxer    fileSourceDemuxer
+
+	// live items are not paced, since they are received in real time.
+	live bool
+}
+
+// playlistSourceIndex returns the position of an item inside a playlist, or -1.
+func playlistSourceIndex(playlist conf.Playlist, item conf.PlaylistItem) int {
+	for i, cur := range playlist {
+		if cur == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// playlistSourceScheduled returns the item with a start time that has
+// been reached most recently, if there's one.
+func playlistSourceScheduled(playlist conf.Playlist, now time.Time) (int, bool) {
+	y, m, d := now.Date()
+	elapsed := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+
+	ret := -1
+	var retDist time.Duration
+
+	for i, item := range playlist {
+		offset, ok := item.StartOffset()
+		if !ok {
+			continue
+		}
+
+		dist := elapsed - offset
+		if dist < 0 {
+			dist += 24 * time.Hour
+		}
+
+		if ret < 0 || dist < retDist {
+			ret = i
+			retDist = dist
+		}
+	}
+
+	return ret, ret >= 0
+}
+
+// playlistSourceNextStart returns the item with the nearest start time
+// in the future, and the time in which it must be started.
+func playlistSourceNextStart(playlist conf.Playlist, now time.Time) (int, time.Time, bool) {
+	y, m, d := now.Date()
+	elapsed := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+
+	ret := -1
+	var retDist time.Duration
+
+	for i, item := range playlist {
+		offset, ok := item.StartOffset()
+		if !ok {
+			continue
+		}
+
+		dist := offset - elapsed
+		if dist <= 0 {
+			dist += 24 * time.Hour
+		}
+
+		if ret < 0 || dist < retDist {
+			ret = i
+			retDist = dist
+		}
+	}
+
+	return ret, now.Add(retDist), ret >= 0
+}
+
+// playlistSourcePrependParameters adds SPS and PPS before IDRs that are not
+// preceded by them, in order to allow the stream to pick up the parameters
+// of the item.
+func playlistSourcePrependParameters(nalus [][]byte, track *gortsplib.TrackH264) [][]byte {
+	if !h264.IDRPresent(nalus) || track.SPS() == nil || track.PPS() == nil {
+		return nalus
+	}
+
+	for _, nalu := range nalus {
+		if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeSPS {
+			return nalus
+		}
+	}
+
+	return append([][]byte{track.SPS(), track.PPS()}, nalus...)
+}
+
+type playlistSourcePathManager interface {
+	onReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+}
+
+type playlistSourceParent interface {
+	log(logger.Level, string, ...interface{})
+	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
+}
+
+// playlistSource plays the items of a playlist, that can be files or other paths,
+// one after the other. Timestamps are kept continuous between items,
+// therefore readers are not disconnected when switching item.
+type playlistSource struct {
+	pathName        string
+	readBufferCount int
+	wg              *sync.WaitGroup
+	pathManager     playlistSourcePathManager
+	parent          playlistSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+
+	// owned by run()
+	writer     *fileSourceWriter
+	videoTrack *gortsplib.TrackH264
+	audioTrack *gortsplib.TrackAAC
+	wallStart  time.Time
+	nextDTS    time.Duration
+
+	mutex       sync.Mutex
+	playlist    conf.Playlist
+	currentItem int
+
+	// in
+	reload chan struct{}
+}
+
+func newPlaylistSource(
+	parentCtx context.Context,
+	pathName string,
+	playlist conf.Playlist,
+	readBufferCount int,
+	pathManager playlistSourcePathManager,
+	wg *sync.WaitGroup,
+	parent playlistSourceParent,
+) *playlistSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &playlistSource{
+		pathName:        pathName,
+		readBufferCount: readBufferCount,
+		wg:              wg,
+		pathManager:     pathManager,
+		parent:          parent,
+		ctx:             ctx,
+		ctxCancel:       ctxCancel,
+		playlist:        playlist,
+		currentItem:     -1,
+		reload:          make(chan struct{}, 1),
+	}
+
+	s.Log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *playlistSource) close() {
+	s.Log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *playlistSource) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.log(level, "[playlist source] "+format, args...)
+}
+
+func (s *playlistSource) getPlaylist() conf.Playlist {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.playlist
+}
+
+func (s *playlistSource) setCurrentItem(i int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.currentItem = i
+}
+
+func (s *playlistSource) run() {
+	defer s.wg.Done()
+
+	defer func() {
+		if s.writer != nil {
+			s.parent.onSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{source: s})
+		}
+	}()
+
+	var prev *conf.PlaylistItem
+	cur := 0
+	failures := 0
+
+	// at startup, the item whose start time has been reached most recently
+	// is played.
+	var jumpTo *conf.PlaylistItem
+	playlist := s.getPlaylist()
+	if i, ok := playlistSourceScheduled(playlist, time.Now()); ok {
+		jumpTo = &playlist[i]
+	}
+
+	for {
+		playlist := s.getPlaylist()
+
+		switch {
+		case jumpTo != nil && playlistSourceIndex(playlist, *jumpTo) >= 0:
+			cur = playlistSourceIndex(playlist, *jumpTo)
+
+		case prev != nil && playlistSourceIndex(playlist, *prev) >= 0:
+			cur = (playlistSourceIndex(playlist, *prev) + 1) % len(playlist)
+
+		case prev != nil:
+			// the previous item has been removed from the playlist:
+			// play the item that took its place.
+			cur %= len(playlist)
+		}
+
+		item := playlist[cur]
+		s.setCurrentItem(cur)
+		s.Log(logger.Info, "playing item %d (%s)", cur+1, item.Source)
+
+		itemCtx, itemCtxCancel := context.WithCancel(s.ctx)
+		watchDone := make(chan *conf.PlaylistItem)
+		go func() {
+			watchDone <- s.watchItem(itemCtx, itemCtxCancel, playlist, item)
+		}()
+
+		err := s.playItem(itemCtx, item)
+		itemCtxCancel()
+		jumpTo = <-watchDone
+
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		prev = &item
+
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		s.Log(logger.Info, "ERR: item %d: %v", cur+1, err)
+		failures++
+
+		// all items are failing
+		if failures >= len(playlist) {
+			failures = 0
+
+			if s.writer == nil {
+				s.parent.onSourceStaticError(err)
+			}
+
+			select {
+			case <-time.After(playlistSourceRetryPause):
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// watchItem interrupts the current item when the start time of another item
+// is reached, or when the current item is removed from the playlist.
+// It returns the item that must be played next, if there's one.
+func (s *playlistSource) watchItem(
+	ctx context.Context,
+	ctxCancel func(),
+	playlist conf.Playlist,
+	item conf.PlaylistItem,
+) *conf.PlaylistItem {
+	for {
+		timer := newEmptyTimer()
+		next, t, ok := playlistSourceNextStart(playlist, time.Now())
+		if ok {
+			timer = time.NewTimer(time.Until(t))
+		}
+
+		select {
+		case <-timer.C:
+			s.Log(logger.Info, "start time of item %d reached", next+1)
+			ctxCancel()
+			return &playlist[next]
+
+		case <-s.reload:
+			timer.Stop()
+			playlist = s.getPlaylist()
+			s.Log(logger.Info, "playlist reloaded")
+
+			if playlistSourceIndex(playlist, item) < 0 {
+				ctxCancel()
+				return nil
+			}
+
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		}
+	}
+}
+
+func (s *playlistSource) openItem(ctx context.Context, item conf.PlaylistItem) (*playlistSourceItem, error) {
+	if item.IsFile() {
+		videoTrack, audioTrack, demuxer, err := fileSourceOpen(fileSourcePath(item.Source))
+		if err != nil {
+			return nil, err
+		}
+
+		return &playlistSourceItem{
+			videoTrack: videoTrack,
+			audioTrack: audioTrack,
+			demuxer:    demuxer,
+		}, nil
+	}
+
+	r := &playlistSourceReader{
+		source:     s,
+		pathName:   item.Source,
+		ringBuffer: ringbuffer.New(uint64(s.readBufferCount)),
+		stats:      newTrafficStats(),
+	}
+
+	res := s.pathManager.onReaderSetupPlay(pathReaderSetupPlayReq{
+		author:   r,
+		pathName: item.Source,
+	})
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	d := &playlistSourcePathDemuxer{
+		r:            r,
+		path:         res.path,
+		videoTrackID: -1,
+		audioTrackID: -1,
+	}
+	it := &playlistSourceItem{
+		demuxer: d,
+		live:    true,
+	}
+
+	for i, track := range res.stream.tracks() {
+		switch tt := track.(type) {
+		case *gortsplib.TrackH264:
+			if it.videoTrack == nil {
+				it.videoTrack = tt
+				d.videoTrackID = i
+			}
+
+		case *gortsplib.TrackAAC:
+			if it.audioTrack == nil {
+				it.audioTrack = tt
+				d.audioTrackID = i
+				d.aacDecoder = &rtpaac.Decoder{
+					SampleRate:       tt.ClockRate(),
+					SizeLength:       tt.SizeLength(),
+					IndexLength:      tt.IndexLength(),
+					IndexDeltaLength: tt.IndexDeltaLength(),
+				}
+				d.aacDecoder.Init()
+			}
+		}
+	}
+
+	if it.videoTrack == nil && it.audioTrack == nil {
+		d.close()
+		return nil, fmt.Errorf("path '%s' doesn't contain an H264 track or an AAC track", item.Source)
+	}
+
+	go func() {
+		<-ctx.Done()
+		r.ringBuffer.Close()
+	}()
+
+	res.path.onReaderPlay(pathReaderPlayReq{author: r})
+
+	return it, nil
+}
+
+// setReady creates the stream, by using the tracks of the first item.
+// Tracks are copied, since their parameters are updated by the stream.
+func (s *playlistSource) setReady(it *playlistSourceItem) error {
+	var videoTrack *gortsplib.TrackH264
+	var audioTrack *gortsplib.TrackAAC
+
+	if it.videoTrack != nil {
+		videoTrack, _ = gortsplib.NewTrackH264(96, it.videoTrack.SPS(), it.videoTrack.PPS(), nil)
+	}
+
+	if it.audioTrack != nil {
+		var err error
+		audioTrack, err = gortsplib.NewTrackAAC(97,
+			it.audioTrack.Type(),
+			it.audioTrack.ClockRate(),
+			it.audioTrack.ChannelCount(),
+			it.audioTrack.AOTSpecificConfig(),
+			13,
+			3,
+			3)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, tracks := newFileSourceWriter(videoTrack, audioTrack)
+
+	res := s.parent.onSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		source: s,
+		tracks: tracks,
+	})
+	if res.err != nil {
+		return res.err
+	}
+
+	s.Log(logger.Info, "ready")
+
+	w.stream = res.stream
+	s.writer = w
+	s.videoTrack = videoTrack
+	s.audioTrack = audioTrack
+	s.wallStart = time.Now()
+
+	return nil
+}
+
+// playItem plays an item until its duration elapses, its file or path ends,
+// or it is interrupted. It returns an error if the item couldn't be played.
+func (s *playlistSource) playItem(ctx context.Context, item conf.PlaylistItem) error {
+	it, err := s.openItem(ctx, item)
+	if err != nil {
+		return err
+	}
+	demuxer := it.demuxer
+	defer func() {
+		if demuxer != nil {
+			demuxer.close()
+		}
+	}()
+
+	if s.writer == nil {
+		err := s.setReady(it)
+		if err != nil {
+			return err
+		}
+	}
+
+	// tracks of the item are used only if they are compatible with the stream.
+	hasVideo := it.videoTrack != nil && s.videoTrack != nil
+	hasAudio := it.audioTrack != nil && s.audioTrack != nil &&
+		it.audioTrack.ClockRate() == s.audioTrack.ClockRate() &&
+		it.audioTrack.ChannelCount() == s.audioTrack.ChannelCount()
+	if !hasVideo && !hasAudio {
+		return fmt.Errorf("item doesn't contain tracks compatible with the playlist")
+	}
+
+	// the item starts after the end of the previous one,
+	// or now, if the previous one ended early.
+	outStart := s.nextDTS
+	if now := time.Since(s.wallStart); now > outStart {
+		outStart = now
+	}
+
+	// files shorter than the duration of the item are played again.
+	var passOffset time.Duration
+	var passBase time.Duration
+	passBaseSet := false
+
+	var lastRel time.Duration
+	var lastMainDTS time.Duration
+	lastMainDTSSet := false
+	step := fileSourceDefaultLoopStep
+	written := false
+
+	defer func() {
+		if written {
+			s.nextDTS = outStart + lastRel + step
+		}
+	}()
+
+	for {
+		u, err := demuxer.read()
+		if err != nil {
+			if err == io.EOF && !it.live && item.Duration > 0 && written && ctx.Err() == nil {
+				demuxer.close()
+
+				_, _, demuxer, err = fileSourceOpen(fileSourcePath(item.Source))
+				if err != nil {
+					demuxer = nil
+					s.Log(logger.Info, "ERR: %v", err)
+					return nil
+				}
+
+				passOffset = lastRel + step
+				passBaseSet = false
+				lastMainDTSSet = false
+				continue
+			}
+
+			if written || ctx.Err() != nil {
+				if err != io.EOF {
+					s.Log(logger.Info, "ERR: %v", err)
+				}
+				return nil
+			}
+
+			if err == io.EOF {
+				return fmt.Errorf("item doesn't contain any frame")
+			}
+			return err
+		}
+
+		isVideo := u.h264NALUs != nil
+		if (isVideo && !hasVideo) || (!isVideo && !hasAudio) {
+			continue
+		}
+
+		// each pass starts from an IDR
+		if !passBaseSet {
+			if hasVideo && (!isVideo || !h264.IDRPresent(u.h264NALUs)) {
+				continue
+			}
+			passBase = u.dts
+			passBaseSet = true
+		}
+
+		if u.dts < passBase {
+			continue
+		}
+
+		rel := passOffset + u.dts - passBase
+		if item.Duration > 0 && rel >= time.Duration(item.Duration) {
+			return nil
+		}
+
+		// the distance between the first frame of the next item and the
+		// last frame of this one is the duration of a frame of the main track.
+		if isVideo == hasVideo {
+			if lastMainDTSSet && u.dts > lastMainDTS {
+				step = u.dts - lastMainDTS
+			}
+			lastMainDTS = u.dts
+			lastMainDTSSet = true
+		}
+
+		if !it.live {
+			wait := time.Until(s.wallStart.Add(outStart + rel))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return nil
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if isVideo {
+			u.h264NALUs = playlistSourcePrependParameters(u.h264NALUs, it.videoTrack)
+		}
+
+		s.writer.write(u, outStart+passOffset+u.pts-passBase)
+		written = true
+
+		if rel > lastRel {
+			lastRel = rel
+		}
+	}
+}
+
+// onReload is called by path.
+func (s *playlistSource) onReload(playlist conf.Playlist) {
+	s.mutex.Lock()
+	s.playlist = playlist
+	s.mutex.Unlock()
+
+	select {
+	case s.reload <- struct{}{}:
+	default:
+	}
+}
+
+// onSourceAPIDescribe implements source.
+func (s *playlistSource) onSourceAPIDescribe() interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return struct {
+		Type        string `json:"type"`
+		CurrentItem int    `json:"currentItem"`
+	}{"playlistSource", s.currentItem}
+}
+
+// protocol implements source.
+func (*playlistSource) protocol() string {
+	return "playlist"
+}
@@ -0,0 +1,77 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestPlaylistSourceSchedule(t *testing.T) {
+	playlist := conf.Playlist{
+		{Source: "file:///news.mp4", Start: "08:00"},
+		{Source: "cam1"},
+		{Source: "file:///movie.mp4", Start: "20:30"},
+	}
+
+	for _, ca := range []struct {
+		name      string
+		now       time.Time
+		scheduled int
+		next      int
+		nextTime  time.Time
+	}{
+		{
+			"morning",
+			time.Date(2022, 5, 10, 9, 0, 0, 0, time.UTC),
+			0,
+			2,
+			time.Date(2022, 5, 10, 20, 30, 0, 0, time.UTC),
+		},
+		{
+			"night",
+			time.Date(2022, 5, 10, 23, 0, 0, 0, time.UTC),
+			2,
+			0,
+			time.Date(2022, 5, 11, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			"after midnight",
+			time.Date(2022, 5, 10, 1, 0, 0, 0, time.UTC),
+			2,
+			0,
+			time.Date(2022, 5, 10, 8, 0, 0, 0, time.UTC),
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			scheduled, ok := playlistSourceScheduled(playlist, ca.now)
+			require.Equal(t, true, ok)
+			require.Equal(t, ca.scheduled, scheduled)
+
+			next, nextTime, ok := playlistSourceNextStart(playlist, ca.now)
+			require.Equal(t, true, ok)
+			require.Equal(t, ca.next, next)
+			require.Equal(t, ca.nextTime, nextTime)
+		})
+	}
+
+	_, ok := playlistSourceScheduled(conf.Playlist{{Source: "cam1"}}, time.Now())
+	require.Equal(t, false, ok)
+}
+
+func TestPlaylistSourcePrependParameters(t *testing.T) {
+	track, err := gortsplib.NewTrackH264(96, []byte{0x67, 0x01}, []byte{0x68, 0x02}, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, [][]byte{{0x67, 0x01}, {0x68, 0x02}, {0x65, 0x03}},
+		playlistSourcePrependParameters([][]byte{{0x65, 0x03}}, track))
+
+	require.Equal(t, [][]byte{{0x67, 0x04}, {0x68, 0x05}, {0x65, 0x03}},
+		playlistSourcePrependParameters([][]byte{{0x67, 0x04}, {0x68, 0x05}, {0x65, 0x03}}, track))
+
+	require.Equal(t, [][]byte{{0x41, 0x03}},
+		playlistSourcePrependParameters([][]byte{{0x41, 0x03}}, track))
+}
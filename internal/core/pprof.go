@@ -4,10 +4,12 @@ import (
 	"context"
 	"net"
 	"net/http"
+	"sync"
 
 	// start pprof
 	_ "net/http/pprof"
 
+	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/logger"
 )
 
@@ -16,33 +18,46 @@ type pprofParent interface {
 }
 
 type pprof struct {
-	parent pprofParent
+	address    string
+	users      map[string]*conf.APIUser
+	encryption bool
+	serverKey  string
+	serverCert string
+	parent     pprofParent
 
-	ln     net.Listener
+	mutex  sync.Mutex
 	server *http.Server
 }
 
 func newPPROF(
 	address string,
+	users map[string]*conf.APIUser,
+	encryption bool,
+	serverKey string,
+	serverCert string,
 	parent pprofParent,
 ) (*pprof, error) {
-	ln, err := net.Listen("tcp", address)
+	ln, err := apiListen(address, encryption, serverKey, serverCert)
 	if err != nil {
 		return nil, err
 	}
 
 	pp := &pprof{
-		parent: parent,
-		ln:     ln,
+		address:    address,
+		users:      users,
+		encryption: encryption,
+		serverKey:  serverKey,
+		serverCert: serverCert,
+		parent:     parent,
 	}
 
 	pp.server = &http.Server{
-		Handler: http.DefaultServeMux,
+		Handler: http.HandlerFunc(pp.onRequest),
 	}
 
 	pp.log(logger.Info, "listener opened on "+address)
 
-	go pp.run()
+	go pp.run(pp.server, ln)
 
 	return pp, nil
 }
@@ -56,8 +71,57 @@ func (pp *pprof) log(level logger.Level, format string, args ...interface{}) {
 	pp.parent.Log(level, "[pprof] "+format, args...)
 }
 
-func (pp *pprof) run() {
-	err := pp.server.Serve(pp.ln)
+// onConfReload is called by core.
+// Users are replaced in place, while the listener is reopened only when
+// the TLS configuration changes.
+func (pp *pprof) onConfReload(
+	users map[string]*conf.APIUser,
+	encryption bool,
+	serverKey string,
+	serverCert string,
+) error {
+	pp.mutex.Lock()
+	pp.users = users
+	pp.mutex.Unlock()
+
+	if encryption == pp.encryption && serverKey == pp.serverKey && serverCert == pp.serverCert {
+		return nil
+	}
+
+	pp.server.Shutdown(context.Background())
+
+	ln, err := apiListen(pp.address, encryption, serverKey, serverCert)
+	if err != nil {
+		return err
+	}
+
+	pp.encryption = encryption
+	pp.serverKey = serverKey
+	pp.serverCert = serverCert
+	pp.server = &http.Server{Handler: pp.server.Handler}
+
+	pp.log(logger.Info, "listener reopened on "+pp.address)
+
+	go pp.run(pp.server, ln)
+
+	return nil
+}
+
+func (pp *pprof) onRequest(w http.ResponseWriter, r *http.Request) {
+	pp.mutex.Lock()
+	users := pp.users
+	pp.mutex.Unlock()
+
+	// profiles can be requested only by admins
+	if !apiAuthenticate(users, w, r, true) {
+		return
+	}
+
+	http.DefaultServeMux.ServeHTTP(w, r)
+}
+
+func (pp *pprof) run(server *http.Server, ln net.Listener) {
+	err := server.Serve(ln)
 	if err != http.ErrServerClosed {
 		panic(err)
 	}
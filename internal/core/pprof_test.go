@@ -0,0 +1,59 @@
+package core
+
+import (
+	"crypto/tls"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestPPROFConfReload(t *testing.T) {
+	serverCertFpath, err := writeTempFile(serverCert)
+	require.NoError(t, err)
+	defer os.Remove(serverCertFpath)
+
+	serverKeyFpath, err := writeTempFile(serverKey)
+	require.NoError(t, err)
+	defer os.Remove(serverKeyFpath)
+
+	pp, err := newPPROF("localhost:9999", nil, false, "", "", nilLogger{})
+	require.NoError(t, err)
+	defer pp.close()
+
+	hc := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	get := func(ur string, token string) int {
+		req, err := http.NewRequest(http.MethodGet, ur, nil)
+		require.NoError(t, err)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := hc.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, get("http://localhost:9999/debug/pprof/", ""))
+
+	users := map[string]*conf.APIUser{
+		"admin": {Token: "mytoken", Role: conf.APIRoleAdmin},
+	}
+
+	// users are replaced without reopening the listener
+	err = pp.onConfReload(users, false, "", "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, get("http://localhost:9999/debug/pprof/", ""))
+	require.Equal(t, http.StatusOK, get("http://localhost:9999/debug/pprof/", "mytoken"))
+
+	err = pp.onConfReload(users, true, serverKeyFpath, serverCertFpath)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, get("https://localhost:9999/debug/pprof/", "mytoken"))
+}
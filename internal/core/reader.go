@@ -6,4 +6,6 @@ type reader interface {
 	onReaderAccepted()
 	onReaderData(*data)
 	onReaderAPIDescribe() interface{}
+	trafficStats() *trafficStats
+	protocol() string
 }
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	recordCleanerPeriod = 1 * time.Minute
+)
+
+type recordCleanerParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// recordCleaner periodically deletes recorded segments that are older than
+// a given duration.
+type recordCleaner struct {
+	recordPath  string
+	deleteAfter time.Duration
+	parent      recordCleanerParent
+
+	ctx       context.Context
+	ctxCancel func()
+	done      chan struct{}
+}
+
+func newRecordCleaner(
+	parentCtx context.Context,
+	recordPath string,
+	deleteAfter conf.StringDuration,
+	parent recordCleanerParent,
+) *recordCleaner {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	c := &recordCleaner{
+		recordPath:  recordPath,
+		deleteAfter: time.Duration(deleteAfter),
+		parent:      parent,
+		ctx:         ctx,
+		ctxCancel:   ctxCancel,
+		done:        make(chan struct{}),
+	}
+
+	go c.run()
+
+	return c
+}
+
+func (c *recordCleaner) close() {
+	c.ctxCancel()
+	<-c.done
+}
+
+func (c *recordCleaner) log(level logger.Level, format string, args ...interface{}) {
+	c.parent.Log(level, "[record cleaner] "+format, args...)
+}
+
+func (c *recordCleaner) run() {
+	defer close(c.done)
+
+	c.clean()
+
+	t := time.NewTicker(recordCleanerPeriod)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			c.clean()
+
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *recordCleaner) clean() {
+	list, err := recordingList(c.recordPath)
+	if err != nil {
+		c.log(logger.Warn, "unable to list recordings: %v", err)
+		return
+	}
+
+	limit := time.Now().Add(-c.deleteAfter)
+
+	for pathName, segs := range list {
+		deleted := 0
+
+		for _, seg := range segs {
+			if seg.end().After(limit) {
+				break
+			}
+
+			err := os.Remove(seg.fpath)
+			if err != nil {
+				c.log(logger.Warn, "unable to delete segment: %v", err)
+				continue
+			}
+			deleted++
+		}
+
+		if deleted == 0 {
+			continue
+		}
+
+		c.log(logger.Debug, "deleted %d segments of path '%s'", deleted, pathName)
+
+		if deleted == len(segs) {
+			if dir, err := recordingDir(c.recordPath, pathName); err == nil {
+				c.removeEmptyDirs(dir)
+			}
+		}
+	}
+}
+
+// removeEmptyDirs removes a directory and its parents, until a non-empty
+// directory or the record path is found.
+func (c *recordCleaner) removeEmptyDirs(dir string) {
+	root := filepath.Clean(c.recordPath)
+
+	for dir != root && dir != "." && dir != string(filepath.Separator) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil || len(files) != 0 {
+			return
+		}
+
+		err = os.Remove(dir)
+		if err != nil {
+			return
+		}
+
+		dir = filepath.Dir(dir)
+	}
+}
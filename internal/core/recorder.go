@@ -0,0 +1,236 @@
+package core
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/ringbuffer"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type recorderParent interface {
+	log(logger.Level, string, ...interface{})
+}
+
+// recorder is a reader that writes a stream to disk, into MPEG-TS segments.
+type recorder struct {
+	recordPath string
+	pathName   string
+	parent     recorderParent
+
+	ringBuffer *ringbuffer.RingBuffer
+	stats      *trafficStats
+
+	done chan struct{}
+}
+
+func newRecorder(
+	recordPath string,
+	segmentDuration conf.StringDuration,
+	segmentMaxSize conf.StringSize,
+	readBufferCount int,
+	pathName string,
+	tracks gortsplib.Tracks,
+	parent recorderParent,
+) (*recorder, error) {
+	var videoTrack *gortsplib.TrackH264
+	videoTrackID := -1
+	var audioTrack *gortsplib.TrackAAC
+	audioTrackID := -1
+	var aacDecoder *rtpaac.Decoder
+
+	for i, track := range tracks {
+		switch tt := track.(type) {
+		case *gortsplib.TrackH264:
+			if videoTrack != nil {
+				return nil, fmt.Errorf("can't record track %d: too many tracks", i+1)
+			}
+
+			videoTrack = tt
+			videoTrackID = i
+
+		case *gortsplib.TrackAAC:
+			if audioTrack != nil {
+				return nil, fmt.Errorf("can't record track %d: too many tracks", i+1)
+			}
+
+			audioTrack = tt
+			audioTrackID = i
+			aacDecoder = &rtpaac.Decoder{
+				SampleRate:       tt.ClockRate(),
+				SizeLength:       tt.SizeLength(),
+				IndexLength:      tt.IndexLength(),
+				IndexDeltaLength: tt.IndexDeltaLength(),
+			}
+			aacDecoder.Init()
+		}
+	}
+
+	if videoTrack == nil && audioTrack == nil {
+		return nil, fmt.Errorf("the stream doesn't contain an H264 track or an AAC track")
+	}
+
+	r := &recorder{
+		recordPath: recordPath,
+		pathName:   pathName,
+		parent:     parent,
+		ringBuffer: ringbuffer.New(uint64(readBufferCount)),
+		stats:      newTrafficStats(),
+		done:       make(chan struct{}),
+	}
+
+	hr := hls.NewRecorder(
+		time.Duration(segmentDuration),
+		uint64(segmentMaxSize),
+		videoTrack,
+		audioTrack,
+		func(seg *hls.RecorderSegment) {
+			err := r.writeSegment(seg)
+			if err != nil {
+				r.log(logger.Warn, "unable to save segment: %v", err)
+			}
+		})
+
+	go r.run(hr, videoTrackID, audioTrackID, aacDecoder)
+
+	return r, nil
+}
+
+// close implements reader.
+// The last segment is saved before returning.
+func (r *recorder) close() {
+	r.ringBuffer.Close()
+	<-r.done
+}
+
+func (r *recorder) log(level logger.Level, format string, args ...interface{}) {
+	r.parent.log(level, "[recorder] "+format, args...)
+}
+
+func (r *recorder) run(
+	hr *hls.Recorder,
+	videoTrackID int,
+	audioTrackID int,
+	aacDecoder *rtpaac.Decoder,
+) {
+	defer close(r.done)
+	defer hr.Close()
+
+	var videoInitialPTS *time.Duration
+
+	for {
+		item, ok := r.ringBuffer.Pull()
+		if !ok {
+			return
+		}
+		data := item.(*data)
+
+		r.stats.onDataSent(data)
+
+		if data.trackID == videoTrackID {
+			if data.h264NALUs == nil {
+				continue
+			}
+
+			// video is decoded in another routine,
+			// while audio is decoded in this routine:
+			// we have to sync their PTS.
+			if videoInitialPTS == nil {
+				v := data.h264PTS
+				videoInitialPTS = &v
+			}
+			pts := data.h264PTS - *videoInitialPTS
+
+			err := hr.WriteH264(pts, data.h264NALUs)
+			if err != nil {
+				r.log(logger.Warn, "unable to write segment: %v", err)
+			}
+		} else if data.trackID == audioTrackID {
+			aus, pts, err := aacDecoder.Decode(data.rtp)
+			if err != nil {
+				if err != rtpaac.ErrMorePacketsNeeded {
+					r.log(logger.Warn, "unable to decode audio track: %v", err)
+				}
+				continue
+			}
+
+			err = hr.WriteAAC(pts, aus)
+			if err != nil {
+				r.log(logger.Warn, "unable to write segment: %v", err)
+			}
+		}
+	}
+}
+
+func (r *recorder) writeSegment(seg *hls.RecorderSegment) error {
+	dir, err := recordingDir(r.recordPath, r.pathName)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	fpath := filepath.Join(dir, recordingSegmentName(seg.StartTime, seg.Duration))
+
+	// write into a temporary file, in order to prevent
+	// incomplete segments from being listed or served.
+	tmpPath := fpath + ".tmp"
+
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, seg.Content)
+	f.Close()
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, fpath)
+}
+
+// onReaderAccepted implements reader.
+func (r *recorder) onReaderAccepted() {
+	dir, err := recordingDir(r.recordPath, r.pathName)
+	if err != nil {
+		r.log(logger.Warn, "unable to record: %v", err)
+		return
+	}
+
+	r.log(logger.Info, "is recording into %s", dir)
+}
+
+// onReaderData implements reader.
+func (r *recorder) onReaderData(data *data) {
+	r.ringBuffer.Push(data)
+}
+
+// trafficStats implements reader.
+func (r *recorder) trafficStats() *trafficStats {
+	return r.stats
+}
+
+// onReaderAPIDescribe implements reader.
+func (r *recorder) onReaderAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"recorder"}
+}
+
+// protocol implements reader.
+func (*recorder) protocol() string {
+	return "recorder"
+}
@@ -0,0 +1,245 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// segments whose distance is lower than this are considered contiguous.
+	recordingMaxGap = 1 * time.Second
+
+	recordingTimeFormat = "2006-01-02_15-04-05.000000"
+)
+
+// recordingSegment is a recorded segment stored on disk.
+// Segments are stored in <recordPath>/<pathName>/<start>_<durationMs>.ts
+type recordingSegment struct {
+	fpath    string
+	start    time.Time
+	duration time.Duration
+}
+
+func (s *recordingSegment) end() time.Time {
+	return s.start.Add(s.duration)
+}
+
+// joinInsideDir joins a directory and a relative path, and checks that
+// the result is inside the directory.
+func joinInsideDir(dir string, rel string) (string, error) {
+	root := filepath.Clean(dir)
+	ret := filepath.Join(root, rel)
+
+	if ret == root || !strings.HasPrefix(ret, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("path '%s' is outside '%s'", rel, dir)
+	}
+
+	return ret, nil
+}
+
+// recordingDir returns the directory that contains the segments of a path.
+func recordingDir(recordPath string, pathName string) (string, error) {
+	return joinInsideDir(recordPath, filepath.FromSlash(pathName))
+}
+
+func recordingSegmentName(start time.Time, duration time.Duration) string {
+	return start.UTC().Format(recordingTimeFormat) + "_" +
+		strconv.FormatInt(duration.Milliseconds(), 10) + ".ts"
+}
+
+func recordingSegmentParse(name string) (time.Time, time.Duration, error) {
+	if !strings.HasSuffix(name, ".ts") {
+		return time.Time{}, 0, fmt.Errorf("invalid segment name")
+	}
+	name = name[:len(name)-len(".ts")]
+
+	i := strings.LastIndex(name, "_")
+	if i < 0 {
+		return time.Time{}, 0, fmt.Errorf("invalid segment name")
+	}
+
+	start, err := time.ParseInLocation(recordingTimeFormat, name[:i], time.UTC)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid segment name")
+	}
+
+	ms, err := strconv.ParseUint(name[i+1:], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("invalid segment name")
+	}
+
+	return start, time.Duration(ms) * time.Millisecond, nil
+}
+
+// recordingSegments returns the segments of a path, sorted by start time.
+func recordingSegments(recordPath string, pathName string) ([]*recordingSegment, error) {
+	dir, err := recordingDir(recordPath, pathName)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var segs []*recordingSegment
+
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		start, duration, err := recordingSegmentParse(f.Name())
+		if err != nil {
+			continue
+		}
+
+		segs = append(segs, &recordingSegment{
+			fpath:    filepath.Join(dir, f.Name()),
+			start:    start,
+			duration: duration,
+		})
+	}
+
+	sort.Slice(segs, func(i, j int) bool {
+		return segs[i].start.Before(segs[j].start)
+	})
+
+	return segs, nil
+}
+
+// recordingList returns the segments of all paths, grouped by path name.
+func recordingList(recordPath string) (map[string][]*recordingSegment, error) {
+	ret := make(map[string][]*recordingSegment)
+
+	err := filepath.Walk(recordPath, func(fpath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && fpath == recordPath {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		start, duration, err := recordingSegmentParse(info.Name())
+		if err != nil {
+			return nil
+		}
+
+		rel, err := filepath.Rel(recordPath, filepath.Dir(fpath))
+		if err != nil || rel == "." {
+			return nil
+		}
+		pathName := filepath.ToSlash(rel)
+
+		ret[pathName] = append(ret[pathName], &recordingSegment{
+			fpath:    fpath,
+			start:    start,
+			duration: duration,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, segs := range ret {
+		sort.Slice(segs, func(i, j int) bool {
+			return segs[i].start.Before(segs[j].start)
+		})
+	}
+
+	return ret, nil
+}
+
+// recordingContiguous checks whether a segment follows another one without gaps.
+func recordingContiguous(prev *recordingSegment, cur *recordingSegment) bool {
+	return cur.start.Sub(prev.end()) <= recordingMaxGap
+}
+
+type recordingSpan struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// recordingSpans merges contiguous segments into spans.
+func recordingSpans(segs []*recordingSegment) []recordingSpan {
+	ret := []recordingSpan{}
+
+	for i, seg := range segs {
+		if i == 0 || !recordingContiguous(segs[i-1], seg) {
+			ret = append(ret, recordingSpan{
+				Start: seg.start,
+				End:   seg.end(),
+			})
+			continue
+		}
+
+		if seg.end().After(ret[len(ret)-1].End) {
+			ret[len(ret)-1].End = seg.end()
+		}
+	}
+
+	return ret
+}
+
+// recordingSegmentsInRange returns the segments that overlap the given range.
+// A zero end means that the range is open.
+func recordingSegmentsInRange(segs []*recordingSegment, start time.Time, end time.Time) []*recordingSegment {
+	var ret []*recordingSegment
+
+	for _, seg := range segs {
+		if !seg.end().After(start) {
+			continue
+		}
+		if !end.IsZero() && !seg.start.Before(end) {
+			break
+		}
+		ret = append(ret, seg)
+	}
+
+	return ret
+}
+
+// recordingRangeFromQuery reads the optional "start" and "end" query parameters,
+// expressed in RFC3339 format.
+func recordingRangeFromQuery(query url.Values) (time.Time, time.Time, error) {
+	var start time.Time
+	var end time.Time
+
+	if v := query.Get("start"); v != "" {
+		var err error
+		start, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid start: %s", err)
+		}
+	}
+
+	if v := query.Get("end"); v != "" {
+		var err error
+		end, err = time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid end: %s", err)
+		}
+
+		if !end.After(start) {
+			return time.Time{}, time.Time{}, fmt.Errorf("end must be after start")
+		}
+	}
+
+	return start, end, nil
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingSegmentName(t *testing.T) {
+	start := time.Date(2022, 5, 10, 14, 5, 3, 250000000, time.UTC)
+
+	name := recordingSegmentName(start, 10500*time.Millisecond)
+	require.Equal(t, "2022-05-10_14-05-03.250000_10500.ts", name)
+
+	start2, duration, err := recordingSegmentParse(name)
+	require.NoError(t, err)
+	require.True(t, start.Equal(start2))
+	require.Equal(t, 10500*time.Millisecond, duration)
+
+	for _, name := range []string{
+		"2022-05-10_14-05-03.250000_10500.ts.tmp",
+		"2022-05-10_14-05-03.250000.ts",
+		"invalid_10500.ts",
+		"../2022-05-10_14-05-03.250000_10500",
+	} {
+		_, _, err := recordingSegmentParse(name)
+		require.Error(t, err, name)
+	}
+}
+
+func TestRecordingDir(t *testing.T) {
+	dir, err := recordingDir("/recordings", "my/path")
+	require.NoError(t, err)
+	require.Equal(t, filepath.FromSlash("/recordings/my/path"), dir)
+
+	for _, pathName := range []string{
+		"../path",
+		"my/../../path",
+		"..",
+		".",
+	} {
+		_, err := recordingDir("/recordings", pathName)
+		require.Error(t, err, pathName)
+	}
+}
+
+func TestRecordingSpans(t *testing.T) {
+	start := time.Date(2022, 5, 10, 14, 5, 0, 0, time.UTC)
+
+	segs := []*recordingSegment{
+		{start: start, duration: 10 * time.Second},
+		{start: start.Add(10*time.Second + 500*time.Millisecond), duration: 10 * time.Second},
+		{start: start.Add(time.Minute), duration: 5 * time.Second},
+	}
+
+	require.Equal(t, []recordingSpan{
+		{Start: start, End: start.Add(20*time.Second + 500*time.Millisecond)},
+		{Start: start.Add(time.Minute), End: start.Add(time.Minute + 5*time.Second)},
+	}, recordingSpans(segs))
+
+	require.Equal(t, segs[1:], recordingSegmentsInRange(segs, start.Add(15*time.Second), time.Time{}))
+	require.Equal(t, segs[:2], recordingSegmentsInRange(segs, start.Add(5*time.Second), start.Add(time.Minute)))
+	require.Equal(t, 0, len(recordingSegmentsInRange(segs, start.Add(2*time.Minute), time.Time{})))
+}
+
+func TestRecordingList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-recordings")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2022, 5, 10, 14, 5, 0, 0, time.UTC)
+
+	for _, fpath := range []string{
+		filepath.Join("mypath", recordingSegmentName(start.Add(10*time.Second), 10*time.Second)),
+		filepath.Join("mypath", recordingSegmentName(start, 10*time.Second)),
+		filepath.Join("mypath", "other.txt"),
+		filepath.Join("nested", "path", recordingSegmentName(start, 5*time.Second)),
+	} {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(fpath)), 0o755)
+		require.NoError(t, err)
+		err = ioutil.WriteFile(filepath.Join(dir, fpath), []byte{}, 0o644)
+		require.NoError(t, err)
+	}
+
+	list, err := recordingList(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(list))
+	require.Equal(t, 2, len(list["mypath"]))
+	require.True(t, list["mypath"][0].start.Equal(start))
+	require.Equal(t, 1, len(list["nested/path"]))
+
+	segs, err := recordingSegments(dir, "mypath")
+	require.NoError(t, err)
+	require.Equal(t, list["mypath"], segs)
+
+	segs, err = recordingSegments(dir, "missing")
+	require.NoError(t, err)
+	require.Equal(t, 0, len(segs))
+
+	list, err = recordingList(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	require.Equal(t, 0, len(list))
+}
+
+func TestRecordingRangeFromQuery(t *testing.T) {
+	start, end, err := recordingRangeFromQuery(url.Values{
+		"start": []string{"2022-05-10T14:05:00Z"},
+		"end":   []string{"2022-05-10T14:06:00Z"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, end.Sub(start))
+
+	_, _, err = recordingRangeFromQuery(url.Values{
+		"start": []string{"2022-05-10T14:05:00Z"},
+		"end":   []string{"2022-05-10T14:04:00Z"},
+	})
+	require.Error(t, err)
+
+	_, _, err = recordingRangeFromQuery(url.Values{
+		"start": []string{"invalid"},
+	})
+	require.Error(t, err)
+}
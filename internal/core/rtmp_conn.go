@@ -56,62 +56,83 @@ type rtmpConnParent interface {
 }
 
 type rtmpConn struct {
-	id                        string
-	externalAuthenticationURL string
-	rtspAddress               string
-	readTimeout               conf.StringDuration
-	writeTimeout              conf.StringDuration
-	readBufferCount           int
-	runOnConnect              string
-	runOnConnectRestart       bool
-	wg                        *sync.WaitGroup
-	conn                      *rtmp.Conn
-	externalCmdPool           *externalcmd.Pool
-	pathManager               rtmpConnPathManager
-	parent                    rtmpConnParent
+	id                  string
+	externalAuth        *externalAuthenticator
+	jwtValidator        *jwtValidator
+	urlSigningKey       string
+	users               map[string]*conf.User
+	rtspAddress         string
+	readTimeout         conf.StringDuration
+	writeTimeout        conf.StringDuration
+	readBufferCount     int
+	runOnConnect        string
+	runOnConnectRestart bool
+	webhookOnConnect    string
+	wg                  *sync.WaitGroup
+	conn                *rtmp.Conn
+	externalCmdPool     *externalcmd.Pool
+	webhookSender       *webhookSender
+	accessLimiter       *accessLimiter
+	pathManager         rtmpConnPathManager
+	parent              rtmpConnParent
 
 	ctx        context.Context
 	ctxCancel  func()
 	path       *path
 	ringBuffer *ringbuffer.RingBuffer // read
+	authExpiry time.Time
 	state      rtmpConnState
 	stateMutex sync.Mutex
+	stats      *trafficStats
 }
 
 func newRTMPConn(
 	parentCtx context.Context,
 	id string,
-	externalAuthenticationURL string,
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
 	rtspAddress string,
 	readTimeout conf.StringDuration,
 	writeTimeout conf.StringDuration,
 	readBufferCount int,
 	runOnConnect string,
 	runOnConnectRestart bool,
+	webhookOnConnect string,
 	wg *sync.WaitGroup,
 	nconn net.Conn,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
 	pathManager rtmpConnPathManager,
 	parent rtmpConnParent,
 ) *rtmpConn {
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	c := &rtmpConn{
-		id:                        id,
-		externalAuthenticationURL: externalAuthenticationURL,
-		rtspAddress:               rtspAddress,
-		readTimeout:               readTimeout,
-		writeTimeout:              writeTimeout,
-		readBufferCount:           readBufferCount,
-		runOnConnect:              runOnConnect,
-		runOnConnectRestart:       runOnConnectRestart,
-		wg:                        wg,
-		conn:                      rtmp.NewServerConn(nconn),
-		externalCmdPool:           externalCmdPool,
-		pathManager:               pathManager,
-		parent:                    parent,
-		ctx:                       ctx,
-		ctxCancel:                 ctxCancel,
+		id:                  id,
+		externalAuth:        externalAuth,
+		jwtValidator:        jwtValidator,
+		urlSigningKey:       urlSigningKey,
+		users:               users,
+		rtspAddress:         rtspAddress,
+		readTimeout:         readTimeout,
+		writeTimeout:        writeTimeout,
+		readBufferCount:     readBufferCount,
+		runOnConnect:        runOnConnect,
+		runOnConnectRestart: runOnConnectRestart,
+		webhookOnConnect:    webhookOnConnect,
+		wg:                  wg,
+		conn:                rtmp.NewServerConn(nconn),
+		externalCmdPool:     externalCmdPool,
+		webhookSender:       webhookSender,
+		accessLimiter:       accessLimiter,
+		pathManager:         pathManager,
+		parent:              parent,
+		ctx:                 ctx,
+		ctxCancel:           ctxCancel,
+		stats:               newTrafficStats(),
 	}
 
 	c.log(logger.Info, "opened")
@@ -151,21 +172,51 @@ func (c *rtmpConn) safeState() rtmpConnState {
 	return c.state
 }
 
+// startAuthExpiryTimer closes the connection when the token used to authenticate expires.
+func (c *rtmpConn) startAuthExpiryTimer() *time.Timer {
+	if c.authExpiry.IsZero() {
+		return nil
+	}
+
+	return time.AfterFunc(time.Until(c.authExpiry), func() {
+		c.log(logger.Info, "closing since the token has expired")
+		c.close()
+	})
+}
+
+func (c *rtmpConn) externalCmdEnv() externalcmd.Environment {
+	_, port, _ := net.SplitHostPort(c.rtspAddress)
+	return externalcmd.Environment{
+		"RTSP_PATH": "",
+		"RTSP_PORT": port,
+	}
+}
+
+func (c *rtmpConn) sendWebhook(url string, event string, action string, env externalcmd.Environment) {
+	c.webhookSender.send(url, webhookPayload{
+		Event:  event,
+		Action: action,
+		Env:    env,
+		Session: &webhookSession{
+			Type:       "rtmpConn",
+			ID:         c.id,
+			Protocol:   "rtmp",
+			RemoteAddr: c.conn.RemoteAddr().String(),
+		},
+	})
+}
+
 func (c *rtmpConn) run() {
 	defer c.wg.Done()
 
 	err := func() error {
 		if c.runOnConnect != "" {
 			c.log(logger.Info, "runOnConnect command started")
-			_, port, _ := net.SplitHostPort(c.rtspAddress)
 			onConnectCmd := externalcmd.NewCmd(
 				c.externalCmdPool,
 				c.runOnConnect,
 				c.runOnConnectRestart,
-				externalcmd.Environment{
-					"RTSP_PATH": "",
-					"RTSP_PORT": port,
-				},
+				c.externalCmdEnv(),
 				func(co int) {
 					c.log(logger.Info, "runOnConnect command exited with code %d", co)
 				})
@@ -176,6 +227,11 @@ func (c *rtmpConn) run() {
 			}()
 		}
 
+		if c.webhookOnConnect != "" {
+			c.sendWebhook(c.webhookOnConnect, "connect", "start", c.externalCmdEnv())
+			defer c.sendWebhook(c.webhookOnConnect, "connect", "stop", c.externalCmdEnv())
+		}
+
 		ctx, cancel := context.WithCancel(c.ctx)
 		runErr := make(chan error)
 		go func() {
@@ -237,6 +293,8 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 
 	if res.err != nil {
 		if terr, ok := res.err.(pathErrAuthCritical); ok {
+			c.accessLimiter.onAuthFailure(c.ip(), "rtmp")
+
 			// wait some seconds to stop brute force attacks
 			<-time.After(rtmpConnPauseAfterAuthError)
 			return errors.New(terr.message)
@@ -308,6 +366,10 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 		author: c,
 	})
 
+	if t := c.startAuthExpiryTimer(); t != nil {
+		defer t.Stop()
+	}
+
 	if c.path.Conf().RunOnRead != "" {
 		c.log(logger.Info, "runOnRead command started")
 		onReadCmd := externalcmd.NewCmd(
@@ -324,6 +386,11 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 		}()
 	}
 
+	if c.path.Conf().WebhookOnRead != "" {
+		c.sendWebhook(c.path.Conf().WebhookOnRead, "read", "start", c.path.externalCmdEnv())
+		defer c.sendWebhook(c.path.Conf().WebhookOnRead, "read", "stop", c.path.externalCmdEnv())
+	}
+
 	// disable read deadline
 	c.conn.SetReadDeadline(time.Time{})
 
@@ -339,6 +406,8 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 		}
 		data := item.(*data)
 
+		c.stats.onDataSent(data)
+
 		if videoTrack != nil && data.trackID == videoTrackID {
 			if data.h264NALUs == nil {
 				continue
@@ -405,6 +474,8 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 			if err != nil {
 				return err
 			}
+
+			c.path.latency.onDataSent("rtmp", data)
 		} else if audioTrack != nil && data.trackID == audioTrackID {
 			aus, pts, err := aacDecoder.Decode(data.rtp)
 			if err != nil {
@@ -434,6 +505,8 @@ func (c *rtmpConn) runRead(ctx context.Context) error {
 					return err
 				}
 			}
+
+			c.path.latency.onDataSent("rtmp", data)
 		}
 	}
 }
@@ -487,6 +560,8 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 
 	if res.err != nil {
 		if terr, ok := res.err.(pathErrAuthCritical); ok {
+			c.accessLimiter.onAuthFailure(c.ip(), "rtmp")
+
 			// wait some seconds to stop brute force attacks
 			<-time.After(rtmpConnPauseAfterAuthError)
 			return errors.New(terr.message)
@@ -515,12 +590,17 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 		return rres.err
 	}
 
+	if t := c.startAuthExpiryTimer(); t != nil {
+		defer t.Stop()
+	}
+
 	for {
 		c.conn.SetReadDeadline(time.Now().Add(time.Duration(c.readTimeout)))
 		pkt, err := c.conn.ReadPacket()
 		if err != nil {
 			return err
 		}
+		received := time.Now()
 
 		switch pkt.Type {
 		case av.H264DecoderConfig:
@@ -543,18 +623,20 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 			lastPkt := len(pkts) - 1
 			for i, pkt := range pkts {
 				if i != lastPkt {
-					rres.stream.writeData(&data{
+					c.writeData(rres.stream, &data{
 						trackID:      videoTrackID,
 						rtp:          pkt,
 						ptsEqualsDTS: false,
+						ingestTime:   received,
 					})
 				} else {
-					rres.stream.writeData(&data{
+					c.writeData(rres.stream, &data{
 						trackID:      videoTrackID,
 						rtp:          pkt,
 						ptsEqualsDTS: h264.IDRPresent(nalus),
 						h264NALUs:    nalus,
 						h264PTS:      pts,
+						ingestTime:   received,
 					})
 				}
 			}
@@ -579,18 +661,20 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 			lastPkt := len(pkts) - 1
 			for i, pkt := range pkts {
 				if i != lastPkt {
-					rres.stream.writeData(&data{
+					c.writeData(rres.stream, &data{
 						trackID:      videoTrackID,
 						rtp:          pkt,
 						ptsEqualsDTS: false,
+						ingestTime:   received,
 					})
 				} else {
-					rres.stream.writeData(&data{
+					c.writeData(rres.stream, &data{
 						trackID:      videoTrackID,
 						rtp:          pkt,
 						ptsEqualsDTS: h264.IDRPresent(nalus),
 						h264NALUs:    nalus,
 						h264PTS:      pts,
+						ingestTime:   received,
 					})
 				}
 			}
@@ -606,16 +690,22 @@ func (c *rtmpConn) runPublish(ctx context.Context) error {
 			}
 
 			for _, pkt := range pkts {
-				rres.stream.writeData(&data{
+				c.writeData(rres.stream, &data{
 					trackID:      audioTrackID,
 					rtp:          pkt,
 					ptsEqualsDTS: true,
+					ingestTime:   received,
 				})
 			}
 		}
 	}
 }
 
+func (c *rtmpConn) writeData(stream *stream, data *data) {
+	c.stats.onDataReceived(data)
+	stream.writeData(data)
+}
+
 func (c *rtmpConn) authenticate(
 	pathName string,
 	pathIPs []interface{},
@@ -625,9 +715,24 @@ func (c *rtmpConn) authenticate(
 	query url.Values,
 	rawQuery string,
 ) error {
-	if c.externalAuthenticationURL != "" {
-		err := externalAuth(
-			c.externalAuthenticationURL,
+	c.authExpiry = time.Time{}
+
+	// signed URLs replace credentials
+	signed := false
+	if action == "read" && isSignedURL(c.urlSigningKey, query) {
+		expiry, err := signedURLCheck(c.urlSigningKey, pathName, query, c.ip())
+		if err != nil {
+			return pathErrAuthCritical{
+				message: fmt.Sprintf("signed URL check failed: %s", err),
+			}
+		}
+
+		signed = true
+		c.authExpiry = expiry
+	}
+
+	if c.externalAuth != nil && !signed {
+		err := c.externalAuth.authenticate(
 			c.ip().String(),
 			query.Get("user"),
 			query.Get("pass"),
@@ -641,6 +746,17 @@ func (c *rtmpConn) authenticate(
 		}
 	}
 
+	if c.jwtValidator != nil && !signed {
+		expiry, err := c.jwtValidator.validate(query.Get("jwt"), pathName, action)
+		if err != nil {
+			return pathErrAuthCritical{
+				message: fmt.Sprintf("JWT authentication failed: %s", err),
+			}
+		}
+
+		c.authExpiry = expiry
+	}
+
 	if pathIPs != nil {
 		ip := c.ip()
 		if !ipEqualOrInRange(ip, pathIPs) {
@@ -650,7 +766,26 @@ func (c *rtmpConn) authenticate(
 		}
 	}
 
-	if pathUser != "" {
+	// users are checked before path credentials
+	userFound := false
+	if len(c.users) != 0 && !signed {
+		handled, err := usersAuthenticate(c.users, c.ip(), query.Get("user"), query.Get("pass"), pathName, action)
+		if err != nil {
+			return pathErrAuthCritical{
+				message: fmt.Sprintf("authentication failed: %s", err),
+			}
+		}
+
+		if handled {
+			userFound = true
+		} else if pathUser == "" {
+			return pathErrAuthCritical{
+				message: "authentication required",
+			}
+		}
+	}
+
+	if pathUser != "" && !signed && !userFound {
 		if query.Get("user") != string(pathUser) ||
 			query.Get("pass") != string(pathPass) {
 			return pathErrAuthCritical{
@@ -672,6 +807,11 @@ func (c *rtmpConn) onReaderData(data *data) {
 	c.ringBuffer.Push(data)
 }
 
+// trafficStats implements reader.
+func (c *rtmpConn) trafficStats() *trafficStats {
+	return c.stats
+}
+
 // onReaderAPIDescribe implements reader.
 func (c *rtmpConn) onReaderAPIDescribe() interface{} {
 	return struct {
@@ -688,6 +828,11 @@ func (c *rtmpConn) onSourceAPIDescribe() interface{} {
 	}{"rtmpConn", c.id}
 }
 
+// protocol implements reader and source.
+func (*rtmpConn) protocol() string {
+	return "rtmp"
+}
+
 // onPublisherAccepted implements publisher.
 func (c *rtmpConn) onPublisherAccepted(tracksLen int) {
 	c.log(logger.Info, "is publishing to path '%s', %d %s",
@@ -17,6 +17,7 @@ import (
 type rtmpServerAPIConnsListItem struct {
 	RemoteAddr string `json:"remoteAddr"`
 	State      string `json:"state"`
+	trafficStatsData
 }
 
 type rtmpServerAPIConnsListData struct {
@@ -46,17 +47,23 @@ type rtmpServerParent interface {
 }
 
 type rtmpServer struct {
-	externalAuthenticationURL string
-	readTimeout               conf.StringDuration
-	writeTimeout              conf.StringDuration
-	readBufferCount           int
-	rtspAddress               string
-	runOnConnect              string
-	runOnConnectRestart       bool
-	externalCmdPool           *externalcmd.Pool
-	metrics                   *metrics
-	pathManager               *pathManager
-	parent                    rtmpServerParent
+	externalAuth        *externalAuthenticator
+	jwtValidator        *jwtValidator
+	urlSigningKey       string
+	users               map[string]*conf.User
+	readTimeout         conf.StringDuration
+	writeTimeout        conf.StringDuration
+	readBufferCount     int
+	rtspAddress         string
+	runOnConnect        string
+	runOnConnectRestart bool
+	webhookOnConnect    string
+	externalCmdPool     *externalcmd.Pool
+	webhookSender       *webhookSender
+	accessLimiter       *accessLimiter
+	metrics             *metrics
+	pathManager         *pathManager
+	parent              rtmpServerParent
 
 	ctx       context.Context
 	ctxCancel func()
@@ -72,7 +79,10 @@ type rtmpServer struct {
 
 func newRTMPServer(
 	parentCtx context.Context,
-	externalAuthenticationURL string,
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
 	address string,
 	readTimeout conf.StringDuration,
 	writeTimeout conf.StringDuration,
@@ -80,7 +90,10 @@ func newRTMPServer(
 	rtspAddress string,
 	runOnConnect string,
 	runOnConnectRestart bool,
+	webhookOnConnect string,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
 	metrics *metrics,
 	pathManager *pathManager,
 	parent rtmpServerParent,
@@ -89,28 +102,35 @@ func newRTMPServer(
 	if err != nil {
 		return nil, err
 	}
+	l = accessLimiter.listener(l)
 
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &rtmpServer{
-		externalAuthenticationURL: externalAuthenticationURL,
-		readTimeout:               readTimeout,
-		writeTimeout:              writeTimeout,
-		readBufferCount:           readBufferCount,
-		rtspAddress:               rtspAddress,
-		runOnConnect:              runOnConnect,
-		runOnConnectRestart:       runOnConnectRestart,
-		externalCmdPool:           externalCmdPool,
-		metrics:                   metrics,
-		pathManager:               pathManager,
-		parent:                    parent,
-		ctx:                       ctx,
-		ctxCancel:                 ctxCancel,
-		l:                         l,
-		conns:                     make(map[*rtmpConn]struct{}),
-		connClose:                 make(chan *rtmpConn),
-		apiConnsList:              make(chan rtmpServerAPIConnsListReq),
-		apiConnsKick:              make(chan rtmpServerAPIConnsKickReq),
+		externalAuth:        externalAuth,
+		jwtValidator:        jwtValidator,
+		urlSigningKey:       urlSigningKey,
+		users:               users,
+		readTimeout:         readTimeout,
+		writeTimeout:        writeTimeout,
+		readBufferCount:     readBufferCount,
+		rtspAddress:         rtspAddress,
+		runOnConnect:        runOnConnect,
+		runOnConnectRestart: runOnConnectRestart,
+		webhookOnConnect:    webhookOnConnect,
+		externalCmdPool:     externalCmdPool,
+		webhookSender:       webhookSender,
+		accessLimiter:       accessLimiter,
+		metrics:             metrics,
+		pathManager:         pathManager,
+		parent:              parent,
+		ctx:                 ctx,
+		ctxCancel:           ctxCancel,
+		l:                   l,
+		conns:               make(map[*rtmpConn]struct{}),
+		connClose:           make(chan *rtmpConn),
+		apiConnsList:        make(chan rtmpServerAPIConnsListReq),
+		apiConnsKick:        make(chan rtmpServerAPIConnsKickReq),
 	}
 
 	s.log(logger.Info, "listener opened on %s", address)
@@ -177,16 +197,22 @@ outer:
 			c := newRTMPConn(
 				s.ctx,
 				id,
-				s.externalAuthenticationURL,
+				s.externalAuth,
+				s.jwtValidator,
+				s.urlSigningKey,
+				s.users,
 				s.rtspAddress,
 				s.readTimeout,
 				s.writeTimeout,
 				s.readBufferCount,
 				s.runOnConnect,
 				s.runOnConnectRestart,
+				s.webhookOnConnect,
 				&s.wg,
 				nconn,
 				s.externalCmdPool,
+				s.webhookSender,
+				s.accessLimiter,
 				s.pathManager,
 				s)
 			s.conns[c] = struct{}{}
@@ -215,6 +241,7 @@ outer:
 						}
 						return "idle"
 					}(),
+					trafficStatsData: c.stats.get(),
 				}
 			}
 
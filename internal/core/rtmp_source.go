@@ -25,6 +25,7 @@ type rtmpSourceParent interface {
 	log(logger.Level, string, ...interface{})
 	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
 	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
 }
 
 type rtmpSource struct {
@@ -175,6 +176,7 @@ func (s *rtmpSource) runInner() bool {
 						if err != nil {
 							return err
 						}
+						received := time.Now()
 
 						switch pkt.Type {
 						case av.H264:
@@ -201,6 +203,7 @@ func (s *rtmpSource) runInner() bool {
 										trackID:      videoTrackID,
 										rtp:          pkt,
 										ptsEqualsDTS: false,
+										ingestTime:   received,
 									})
 								} else {
 									res.stream.writeData(&data{
@@ -209,6 +212,7 @@ func (s *rtmpSource) runInner() bool {
 										ptsEqualsDTS: h264.IDRPresent(nalus),
 										h264NALUs:    nalus,
 										h264PTS:      pts,
+										ingestTime:   received,
 									})
 								}
 							}
@@ -228,6 +232,7 @@ func (s *rtmpSource) runInner() bool {
 									trackID:      audioTrackID,
 									rtp:          pkt,
 									ptsEqualsDTS: true,
+									ingestTime:   received,
 								})
 							}
 						}
@@ -252,6 +257,7 @@ func (s *rtmpSource) runInner() bool {
 	case err := <-runErr:
 		innerCtxCancel()
 		s.log(logger.Info, "ERR: %s", err)
+		s.parent.onSourceStaticError(err)
 		return true
 
 	case <-s.ctx.Done():
@@ -267,3 +273,8 @@ func (*rtmpSource) onSourceAPIDescribe() interface{} {
 		Type string `json:"type"`
 	}{"rtmpSource"}
 }
+
+// protocol implements source.
+func (*rtmpSource) protocol() string {
+	return "rtmp"
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/url"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -25,70 +26,126 @@ type rtspConnParent interface {
 }
 
 type rtspConn struct {
-	externalAuthenticationURL string
-	rtspAddress               string
-	authMethods               []headers.AuthMethod
-	readTimeout               conf.StringDuration
-	runOnConnect              string
-	runOnConnectRestart       bool
-	externalCmdPool           *externalcmd.Pool
-	pathManager               *pathManager
-	conn                      *gortsplib.ServerConn
-	parent                    rtspConnParent
+	externalAuth        *externalAuthenticator
+	jwtValidator        *jwtValidator
+	urlSigningKey       string
+	users               map[string]*conf.User
+	rtspAddress         string
+	authMethods         []headers.AuthMethod
+	readTimeout         conf.StringDuration
+	runOnConnect        string
+	runOnConnectRestart bool
+	webhookOnConnect    string
+	isTLS               bool
+	recordPath          string
+	externalCmdPool     *externalcmd.Pool
+	webhookSender       *webhookSender
+	accessLimiter       *accessLimiter
+	pathManager         *pathManager
+	conn                *gortsplib.ServerConn
+	parent              rtspConnParent
 
 	onConnectCmd  *externalcmd.Cmd
 	authUser      string
 	authPass      string
 	authValidator *auth.Validator
 	authFailures  int
+	authExpiry    time.Time
 }
 
 func newRTSPConn(
-	externalAuthenticationURL string,
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
 	rtspAddress string,
 	authMethods []headers.AuthMethod,
 	readTimeout conf.StringDuration,
 	runOnConnect string,
 	runOnConnectRestart bool,
+	webhookOnConnect string,
+	isTLS bool,
+	recordPath string,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
 	pathManager *pathManager,
 	conn *gortsplib.ServerConn,
 	parent rtspConnParent,
 ) *rtspConn {
 	c := &rtspConn{
-		externalAuthenticationURL: externalAuthenticationURL,
-		rtspAddress:               rtspAddress,
-		authMethods:               authMethods,
-		readTimeout:               readTimeout,
-		runOnConnect:              runOnConnect,
-		runOnConnectRestart:       runOnConnectRestart,
-		externalCmdPool:           externalCmdPool,
-		pathManager:               pathManager,
-		conn:                      conn,
-		parent:                    parent,
+		externalAuth:        externalAuth,
+		jwtValidator:        jwtValidator,
+		urlSigningKey:       urlSigningKey,
+		users:               users,
+		rtspAddress:         rtspAddress,
+		authMethods:         authMethods,
+		readTimeout:         readTimeout,
+		runOnConnect:        runOnConnect,
+		runOnConnectRestart: runOnConnectRestart,
+		webhookOnConnect:    webhookOnConnect,
+		isTLS:               isTLS,
+		recordPath:          recordPath,
+		externalCmdPool:     externalCmdPool,
+		webhookSender:       webhookSender,
+		accessLimiter:       accessLimiter,
+		pathManager:         pathManager,
+		conn:                conn,
+		parent:              parent,
 	}
 
 	c.log(logger.Info, "opened")
 
 	if c.runOnConnect != "" {
 		c.log(logger.Info, "runOnConnect command started")
-		_, port, _ := net.SplitHostPort(c.rtspAddress)
 		c.onConnectCmd = externalcmd.NewCmd(
 			c.externalCmdPool,
 			c.runOnConnect,
 			c.runOnConnectRestart,
-			externalcmd.Environment{
-				"RTSP_PATH": "",
-				"RTSP_PORT": port,
-			},
+			c.externalCmdEnv(),
 			func(co int) {
 				c.log(logger.Info, "runOnInit command exited with code %d", co)
 			})
 	}
 
+	c.sendConnectWebhook("start")
+
 	return c
 }
 
+func (c *rtspConn) externalCmdEnv() externalcmd.Environment {
+	_, port, _ := net.SplitHostPort(c.rtspAddress)
+	return externalcmd.Environment{
+		"RTSP_PATH": "",
+		"RTSP_PORT": port,
+	}
+}
+
+// protocol returns the name of the protocol used by the connection.
+func (c *rtspConn) protocol() string {
+	if c.isTLS {
+		return "rtsps"
+	}
+	return "rtsp"
+}
+
+func (c *rtspConn) sendConnectWebhook(action string) {
+	if c.webhookOnConnect == "" {
+		return
+	}
+
+	c.webhookSender.send(c.webhookOnConnect, webhookPayload{
+		Event:  "connect",
+		Action: action,
+		Env:    c.externalCmdEnv(),
+		Session: &webhookSession{
+			Type:       "rtspConn",
+			Protocol:   c.protocol(),
+			RemoteAddr: c.conn.NetConn().RemoteAddr().String(),
+		},
+	})
+}
+
 func (c *rtspConn) log(level logger.Level, format string, args ...interface{}) {
 	c.parent.log(level, "[conn %v] "+format, append([]interface{}{c.conn.NetConn().RemoteAddr()}, args...)...)
 }
@@ -102,6 +159,65 @@ func (c *rtspConn) ip() net.IP {
 	return c.conn.NetConn().RemoteAddr().(*net.TCPAddr).IP
 }
 
+// basicCredentials returns the credentials of a request that uses basic authentication.
+func basicCredentials(req *base.Request) (string, string) {
+	var auth headers.Authorization
+	err := auth.Read(req.Header["Authorization"])
+	if err != nil || auth.Method != headers.AuthBasic {
+		return "", ""
+	}
+
+	return auth.BasicUser, auth.BasicPass
+}
+
+// authError returns the error of a failed authentication,
+// that can be retried by using the given WWW-Authenticate header.
+func (c *rtspConn) authError(req *base.Request, err error, authHeader base.HeaderValue) error {
+	c.authFailures++
+
+	// VLC with login prompt sends 4 requests:
+	// 1) without credentials
+	// 2) with password but without username
+	// 3) without credentials
+	// 4) with password and username
+	// therefore we must allow up to 3 failures
+	if c.authFailures > 3 {
+		return pathErrAuthCritical{
+			message: "unauthorized: " + err.Error(),
+			response: &base.Response{
+				StatusCode: base.StatusUnauthorized,
+			},
+		}
+	}
+
+	// critical errors are reported by the caller; wrong credentials
+	// must be reported here too, since clients can open a new connection
+	// for every attempt.
+	if _, ok := req.Header["Authorization"]; ok {
+		c.accessLimiter.onAuthFailure(c.ip(), c.protocol())
+	}
+
+	return pathErrAuthNotCritical{
+		message: "unauthorized: " + err.Error(),
+		response: &base.Response{
+			StatusCode: base.StatusUnauthorized,
+			Header: base.Header{
+				"WWW-Authenticate": authHeader,
+			},
+		},
+	}
+}
+
+// basicAuthError returns the error of a failed authentication that
+// can be retried with basic credentials.
+func (c *rtspConn) basicAuthError(req *base.Request, err error) error {
+	v := "IPCAM"
+	return c.authError(req, err, headers.Authenticate{
+		Method: headers.AuthBasic,
+		Realm:  &v,
+	}.Write())
+}
+
 func (c *rtspConn) authenticate(
 	pathName string,
 	pathIPs []interface{},
@@ -111,19 +227,31 @@ func (c *rtspConn) authenticate(
 	req *base.Request,
 	query string,
 ) error {
-	if c.externalAuthenticationURL != "" {
-		username := ""
-		password := ""
-
-		var auth headers.Authorization
-		err := auth.Read(req.Header["Authorization"])
-		if err == nil && auth.Method == headers.AuthBasic {
-			username = auth.BasicUser
-			password = auth.BasicPass
+	c.authExpiry = time.Time{}
+
+	// signed URLs replace credentials
+	signed := false
+	if action == "read" {
+		if v, err := url.ParseQuery(query); err == nil && isSignedURL(c.urlSigningKey, v) {
+			expiry, err := signedURLCheck(c.urlSigningKey, pathName, v, c.ip())
+			if err != nil {
+				return pathErrAuthCritical{
+					message: "unauthorized: " + err.Error(),
+					response: &base.Response{
+						StatusCode: base.StatusUnauthorized,
+					},
+				}
+			}
+
+			signed = true
+			c.authExpiry = expiry
 		}
+	}
 
-		err = externalAuth(
-			c.externalAuthenticationURL,
+	if c.externalAuth != nil && !signed {
+		username, password := basicCredentials(req)
+
+		err := c.externalAuth.authenticate(
 			c.ip().String(),
 			username,
 			password,
@@ -131,37 +259,30 @@ func (c *rtspConn) authenticate(
 			action,
 			query)
 		if err != nil {
-			c.authFailures++
-
-			// VLC with login prompt sends 4 requests:
-			// 1) without credentials
-			// 2) with password but without username
-			// 3) without credentials
-			// 4) with password and username
-			// therefore we must allow up to 3 failures
-			if c.authFailures > 3 {
-				return pathErrAuthCritical{
-					message: "unauthorized: " + err.Error(),
-					response: &base.Response{
-						StatusCode: base.StatusUnauthorized,
-					},
-				}
-			}
+			return c.basicAuthError(req, err)
+		}
+	}
 
-			v := "IPCAM"
-			return pathErrAuthNotCritical{
-				message: "unauthorized: " + err.Error(),
-				response: &base.Response{
-					StatusCode: base.StatusUnauthorized,
-					Header: base.Header{
-						"WWW-Authenticate": headers.Authenticate{
-							Method: headers.AuthBasic,
-							Realm:  &v,
-						}.Write(),
-					},
-				},
+	if c.jwtValidator != nil && !signed {
+		// the token can be passed as query parameter or as password
+		token := ""
+		if v, err := url.ParseQuery(query); err == nil {
+			token = v.Get("jwt")
+		}
+		if token == "" {
+			var auth headers.Authorization
+			err := auth.Read(req.Header["Authorization"])
+			if err == nil && auth.Method == headers.AuthBasic {
+				token = auth.BasicPass
 			}
 		}
+
+		expiry, err := c.jwtValidator.validate(token, pathName, action)
+		if err != nil {
+			return c.basicAuthError(req, err)
+		}
+
+		c.authExpiry = expiry
 	}
 
 	if pathIPs != nil {
@@ -176,7 +297,24 @@ func (c *rtspConn) authenticate(
 		}
 	}
 
-	if pathUser != "" {
+	// users are checked before path credentials
+	userFound := false
+	if len(c.users) != 0 && !signed {
+		username, password := basicCredentials(req)
+
+		handled, err := usersAuthenticate(c.users, c.ip(), username, password, pathName, action)
+		if err != nil {
+			return c.basicAuthError(req, err)
+		}
+
+		if handled {
+			userFound = true
+		} else if pathUser == "" {
+			return c.basicAuthError(req, fmt.Errorf("authentication required"))
+		}
+	}
+
+	if pathUser != "" && !signed && !userFound {
 		// reset authValidator every time the credentials change
 		if c.authValidator == nil || c.authUser != string(pathUser) || c.authPass != string(pathPass) {
 			c.authUser = string(pathUser)
@@ -186,31 +324,7 @@ func (c *rtspConn) authenticate(
 
 		err := c.authValidator.ValidateRequest(req)
 		if err != nil {
-			c.authFailures++
-
-			// VLC with login prompt sends 4 requests:
-			// 1) without credentials
-			// 2) with password but without username
-			// 3) without credentials
-			// 4) with password and username
-			// therefore we must allow up to 3 failures
-			if c.authFailures > 3 {
-				return pathErrAuthCritical{
-					message: "unauthorized: " + err.Error(),
-					response: &base.Response{
-						StatusCode: base.StatusUnauthorized,
-					},
-				}
-			}
-
-			return pathErrAuthNotCritical{
-				response: &base.Response{
-					StatusCode: base.StatusUnauthorized,
-					Header: base.Header{
-						"WWW-Authenticate": c.authValidator.Header(),
-					},
-				},
-			}
+			return c.authError(req, err, c.authValidator.Header())
 		}
 
 		// login successful, reset authFailures
@@ -228,6 +342,8 @@ func (c *rtspConn) onClose(err error) {
 		c.onConnectCmd.Close()
 		c.log(logger.Info, "runOnConnect command stopped")
 	}
+
+	c.sendConnectWebhook("stop")
 }
 
 // onRequest is called by rtspServer.
@@ -243,6 +359,10 @@ func (c *rtspConn) OnResponse(res *base.Response) {
 // onDescribe is called by rtspServer.
 func (c *rtspConn) onDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx,
 ) (*base.Response, *gortsplib.ServerStream, error) {
+	if isRTSPPlayback(ctx.Query) {
+		return c.onDescribePlayback(ctx)
+	}
+
 	res := c.pathManager.onDescribe(pathDescribeReq{
 		pathName: ctx.Path,
 		url:      ctx.Request.URL,
@@ -262,6 +382,8 @@ func (c *rtspConn) onDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx,
 			return terr.response, nil, nil
 
 		case pathErrAuthCritical:
+			c.accessLimiter.onAuthFailure(c.ip(), c.protocol())
+
 			// wait some seconds to stop brute force attacks
 			<-time.After(rtspConnPauseAfterAuthError)
 
@@ -292,3 +414,49 @@ func (c *rtspConn) onDescribe(ctx *gortsplib.ServerHandlerOnDescribeCtx,
 		StatusCode: base.StatusOK,
 	}, res.stream.rtspStream, nil
 }
+
+func (c *rtspConn) onDescribePlayback(ctx *gortsplib.ServerHandlerOnDescribeCtx,
+) (*base.Response, *gortsplib.ServerStream, error) {
+	res := c.pathManager.onPlayback(pathPlaybackReq{
+		pathName: ctx.Path,
+		authenticate: func(
+			pathIPs []interface{},
+			pathUser conf.Credential,
+			pathPass conf.Credential,
+		) error {
+			return c.authenticate(ctx.Path, pathIPs, pathUser, pathPass, "read", ctx.Request, ctx.Query)
+		},
+	})
+
+	if res.err != nil {
+		switch terr := res.err.(type) {
+		case pathErrAuthNotCritical:
+			c.log(logger.Debug, "non-critical authentication error: %s", terr.message)
+			return terr.response, nil, nil
+
+		case pathErrAuthCritical:
+			c.accessLimiter.onAuthFailure(c.ip(), c.protocol())
+
+			// wait some seconds to stop brute force attacks
+			<-time.After(rtspConnPauseAfterAuthError)
+
+			return terr.response, nil, errors.New(terr.message)
+
+		default:
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, nil, res.err
+		}
+	}
+
+	tracks, err := rtspPlaybackTracks(c.recordPath, ctx.Path, ctx.Query)
+	if err != nil {
+		return &base.Response{
+			StatusCode: base.StatusNotFound,
+		}, nil, err
+	}
+
+	return &base.Response{
+		StatusCode: base.StatusOK,
+	}, gortsplib.NewServerStream(tracks), nil
+}
@@ -0,0 +1,465 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/aler9/gortsplib/pkg/rtpaac"
+	"github.com/aler9/gortsplib/pkg/rtph264"
+
+	"github.com/aler9/rtsp-simple-server/internal/hls"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	// query parameter that enables the playback of recordings.
+	rtspPlaybackQueryKey = "playback"
+
+	// above this scale, only IDR frames are sent.
+	rtspPlaybackKeyframesOnlyScale = 4
+
+	// distance between the last timestamp before a gap and the first one after it.
+	rtspPlaybackGapStep = 100 * time.Millisecond
+)
+
+func isRTSPPlayback(rawQuery string) bool {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return false
+	}
+
+	_, ok := q[rtspPlaybackQueryKey]
+	return ok
+}
+
+// rtspPlaybackSegments returns the segments that overlap the range in the query.
+func rtspPlaybackSegments(recordPath string, pathName string, rawQuery string,
+) ([]*recordingSegment, time.Time, time.Time, error) {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	start, end, err := recordingRangeFromQuery(q)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	segs, err := recordingSegments(recordPath, pathName)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, err
+	}
+
+	segs = recordingSegmentsInRange(segs, start, end)
+	if len(segs) == 0 {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("no recordings found in the requested range")
+	}
+
+	if start.Before(segs[0].start) {
+		start = segs[0].start
+	}
+
+	return segs, start, end, nil
+}
+
+// rtspPlaybackTracks returns the tracks of the recordings in the range of the query.
+// Tracks are read from the first segment.
+func rtspPlaybackTracks(recordPath string, pathName string, rawQuery string) (gortsplib.Tracks, error) {
+	segs, _, _, err := rtspPlaybackSegments(recordPath, pathName, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	byts, err := ioutil.ReadFile(segs[0].fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	videoTrack, audioTrack, err := hls.SegmentTracks(byts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks gortsplib.Tracks
+	if videoTrack != nil {
+		tracks = append(tracks, videoTrack)
+	}
+	if audioTrack != nil {
+		tracks = append(tracks, audioTrack)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("recordings don't contain any track")
+	}
+
+	return tracks, nil
+}
+
+// rtspPlaybackReadSegment reads all the data of a segment, sorted by DTS.
+func rtspPlaybackReadSegment(fpath string) ([]*hls.SegmentData, error) {
+	byts, err := ioutil.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := hls.NewSegmentReader(byts)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []*hls.SegmentData
+
+	for {
+		data, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+
+		if data.H264NALUs != nil {
+			// remove AUDs, that are added by the segment generator
+			n := 0
+			for _, nalu := range data.H264NALUs {
+				if len(nalu) != 0 && h264.NALUType(nalu[0]&0x1F) != h264.NALUTypeAccessUnitDelimiter {
+					data.H264NALUs[n] = nalu
+					n++
+				}
+			}
+			data.H264NALUs = data.H264NALUs[:n]
+
+			if n == 0 {
+				continue
+			}
+		}
+
+		ret = append(ret, data)
+	}
+
+	// data of different tracks is not necessarily sorted
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].DTS < ret[j].DTS
+	})
+
+	return ret, nil
+}
+
+type rtspPlaybackParent interface {
+	log(logger.Level, string, ...interface{})
+}
+
+// rtspPlayback reads recordings from disk and writes them into a
+// gortsplib.ServerStream, at the pace and from the position requested
+// by the client.
+type rtspPlayback struct {
+	recordPath string
+	pathName   string
+	parent     rtspPlaybackParent
+
+	start        time.Time
+	end          time.Time
+	stream       *gortsplib.ServerStream
+	videoTrackID int
+	audioTrackID int
+	h264Encoder  *rtph264.Encoder
+	aacEncoder   *rtpaac.Encoder
+
+	// written by the routine, read when the routine is not running
+	position  time.Time
+	lastPTS   time.Duration
+	lastWrite time.Time
+
+	ctxCancel func()
+	done      chan struct{}
+}
+
+func newRTSPPlayback(
+	recordPath string,
+	pathName string,
+	rawQuery string,
+	parent rtspPlaybackParent,
+) (*rtspPlayback, error) {
+	_, start, end, err := rtspPlaybackSegments(recordPath, pathName, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks, err := rtspPlaybackTracks(recordPath, pathName, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &rtspPlayback{
+		recordPath:   recordPath,
+		pathName:     pathName,
+		parent:       parent,
+		start:        start,
+		end:          end,
+		stream:       gortsplib.NewServerStream(tracks),
+		videoTrackID: -1,
+		audioTrackID: -1,
+		position:     start,
+	}
+
+	for i, track := range tracks {
+		switch tt := track.(type) {
+		case *gortsplib.TrackH264:
+			p.videoTrackID = i
+			p.h264Encoder = &rtph264.Encoder{PayloadType: 96}
+			p.h264Encoder.Init()
+
+		case *gortsplib.TrackAAC:
+			p.audioTrackID = i
+			p.aacEncoder = &rtpaac.Encoder{
+				PayloadType:      97,
+				SampleRate:       tt.ClockRate(),
+				SizeLength:       tt.SizeLength(),
+				IndexLength:      tt.IndexLength(),
+				IndexDeltaLength: tt.IndexDeltaLength(),
+			}
+			p.aacEncoder.Init()
+		}
+	}
+
+	return p, nil
+}
+
+func (p *rtspPlayback) close() {
+	p.stop()
+	p.stream.Close()
+}
+
+func (p *rtspPlayback) log(level logger.Level, format string, args ...interface{}) {
+	p.parent.log(level, "[playback] "+format, args...)
+}
+
+// play starts writing recordings, from start (or from the current position
+// if start is nil) until end (or until the end requested in the query if end is nil),
+// with the given speed. It returns the starting position.
+func (p *rtspPlayback) play(start *time.Time, end *time.Time, scale float64) time.Time {
+	p.stop()
+
+	if start != nil {
+		p.position = *start
+	}
+	if end != nil {
+		p.end = *end
+	}
+
+	ctx, ctxCancel := context.WithCancel(context.Background())
+	p.ctxCancel = ctxCancel
+	p.done = make(chan struct{})
+
+	go p.run(ctx, p.position, p.end, scale)
+
+	return p.position
+}
+
+// pause stops writing recordings. Position is preserved.
+func (p *rtspPlayback) pause() {
+	p.stop()
+}
+
+func (p *rtspPlayback) stop() {
+	if p.ctxCancel == nil {
+		return
+	}
+
+	p.ctxCancel()
+	<-p.done
+	p.ctxCancel = nil
+}
+
+func (p *rtspPlayback) run(ctx context.Context, from time.Time, end time.Time, scale float64) {
+	defer close(p.done)
+
+	err := p.runInner(ctx, from, end, scale)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.log(logger.Warn, "%v", err)
+		}
+		return
+	}
+
+	p.log(logger.Debug, "end of recordings reached")
+}
+
+func (p *rtspPlayback) runInner(ctx context.Context, from time.Time, end time.Time, scale float64) error {
+	segs, err := recordingSegments(p.recordPath, p.pathName)
+	if err != nil {
+		return err
+	}
+
+	segs = recordingSegmentsInRange(segs, from, end)
+
+	// output timestamps are kept contiguous between PLAY requests,
+	// and are in sync with the RTP-Info header computed by gortsplib.
+	outStart := time.Duration(0)
+	if !p.lastWrite.IsZero() {
+		outStart = p.lastPTS + time.Since(p.lastWrite)
+	}
+	wallStart := time.Now()
+	outBase := outStart
+
+	var mediaBase time.Time
+	var prevMedia time.Time
+
+	keyframesOnly := scale > rtspPlaybackKeyframesOnlyScale
+
+	for i, seg := range segs {
+		units, err := rtspPlaybackReadSegment(seg.fpath)
+		if err != nil {
+			p.log(logger.Warn, "unable to read segment %s: %v", seg.fpath, err)
+			continue
+		}
+
+		if len(units) == 0 {
+			continue
+		}
+
+		// the first unit is at the start of the segment
+		dtsBase := units[0].DTS
+
+		startIndex := 0
+		if i == 0 {
+			startIndex = rtspPlaybackStartIndex(units, seg.start, dtsBase, from)
+		}
+
+		for _, u := range units[startIndex:] {
+			t := seg.start.Add(u.DTS - dtsBase)
+
+			if !end.IsZero() && !t.Before(end) {
+				return nil
+			}
+
+			// skip gaps between recordings
+			if mediaBase.IsZero() {
+				mediaBase = t
+			} else if d := t.Sub(prevMedia); d > recordingMaxGap || d < -recordingMaxGap {
+				outBase = p.lastPTS + rtspPlaybackGapStep
+				mediaBase = t
+			}
+			prevMedia = t
+
+			isVideo := u.H264NALUs != nil
+
+			if isVideo {
+				if keyframesOnly && !h264.IDRPresent(u.H264NALUs) {
+					continue
+				}
+			} else if scale != 1 || t.Before(from) || p.aacEncoder == nil {
+				continue
+			}
+
+			pts := outBase + time.Duration(float64(t.Sub(mediaBase))/scale)
+
+			wait := time.Until(wallStart.Add(pts - outStart))
+			if wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return fmt.Errorf("terminated")
+				}
+			} else if ctx.Err() != nil {
+				return fmt.Errorf("terminated")
+			}
+
+			err := p.writeUnit(u, pts, scale)
+			if err != nil {
+				return err
+			}
+
+			p.position = t
+			p.lastPTS = pts
+			p.lastWrite = time.Now()
+		}
+	}
+
+	return nil
+}
+
+func (p *rtspPlayback) writeUnit(u *hls.SegmentData, pts time.Duration, scale float64) error {
+	if u.H264NALUs != nil {
+		if p.h264Encoder == nil {
+			return nil
+		}
+
+		pkts, err := p.h264Encoder.Encode(u.H264NALUs,
+			pts+time.Duration(float64(u.PTS-u.DTS)/scale))
+		if err != nil {
+			return err
+		}
+
+		for _, pkt := range pkts {
+			p.stream.WritePacketRTP(p.videoTrackID, pkt, u.PTS == u.DTS)
+		}
+		return nil
+	}
+
+	aus := make([][]byte, len(u.AACPackets))
+	for i, pkt := range u.AACPackets {
+		aus[i] = pkt.AU
+	}
+
+	pkts, err := p.aacEncoder.Encode(aus, pts)
+	if err != nil {
+		return err
+	}
+
+	for _, pkt := range pkts {
+		p.stream.WritePacketRTP(p.audioTrackID, pkt, true)
+	}
+	return nil
+}
+
+// rtspPlaybackStartIndex returns the index of the unit from which a segment
+// must be played in order to start from the given position:
+// the last IDR before the position, or the first unit after it if there's no video.
+func rtspPlaybackStartIndex(units []*hls.SegmentData, segStart time.Time, dtsBase time.Duration,
+	position time.Time,
+) int {
+	hasVideo := false
+	for _, u := range units {
+		if u.H264NALUs != nil {
+			hasVideo = true
+			break
+		}
+	}
+
+	if !hasVideo {
+		for i, u := range units {
+			if !segStart.Add(u.DTS - dtsBase).Before(position) {
+				return i
+			}
+		}
+		return len(units)
+	}
+
+	ret := 0
+
+	for i, u := range units {
+		if u.H264NALUs == nil {
+			continue
+		}
+
+		if segStart.Add(u.DTS - dtsBase).After(position) {
+			break
+		}
+
+		if h264.IDRPresent(u.H264NALUs) {
+			ret = i
+		}
+	}
+
+	return ret
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/tls"
 	"encoding/binary"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,6 +25,7 @@ import (
 type rtspServerAPISessionsListItem struct {
 	RemoteAddr string `json:"remoteAddr"`
 	State      string `json:"state"`
+	trafficStatsData
 }
 
 type rtspServerAPISessionsListData struct {
@@ -50,18 +52,25 @@ type rtspServerParent interface {
 }
 
 type rtspServer struct {
-	externalAuthenticationURL string
-	authMethods               []headers.AuthMethod
-	readTimeout               conf.StringDuration
-	isTLS                     bool
-	rtspAddress               string
-	protocols                 map[conf.Protocol]struct{}
-	runOnConnect              string
-	runOnConnectRestart       bool
-	externalCmdPool           *externalcmd.Pool
-	metrics                   *metrics
-	pathManager               *pathManager
-	parent                    rtspServerParent
+	externalAuth        *externalAuthenticator
+	jwtValidator        *jwtValidator
+	urlSigningKey       string
+	users               map[string]*conf.User
+	authMethods         []headers.AuthMethod
+	readTimeout         conf.StringDuration
+	isTLS               bool
+	recordPath          string
+	rtspAddress         string
+	protocols           map[conf.Protocol]struct{}
+	runOnConnect        string
+	runOnConnectRestart bool
+	webhookOnConnect    string
+	externalCmdPool     *externalcmd.Pool
+	webhookSender       *webhookSender
+	accessLimiter       *accessLimiter
+	metrics             *metrics
+	pathManager         *pathManager
+	parent              rtspServerParent
 
 	ctx       context.Context
 	ctxCancel func()
@@ -88,12 +97,18 @@ func (s *rtspServer) OnRpcReqDisconnect(uuid string) string {
 
 func newRTSPServer(
 	parentCtx context.Context,
-	externalAuthenticationURL string,
+	externalAuth *externalAuthenticator,
+	jwtValidator *jwtValidator,
+	urlSigningKey string,
+	users map[string]*conf.User,
 	address string,
+	tunnelAddress string,
+	tunnelAllowOrigin string,
 	authMethods []headers.AuthMethod,
 	readTimeout conf.StringDuration,
 	writeTimeout conf.StringDuration,
 	readBufferCount int,
+	recordPath string,
 	useUDP bool,
 	useMulticast bool,
 	rtpAddress string,
@@ -108,7 +123,10 @@ func newRTSPServer(
 	protocols map[conf.Protocol]struct{},
 	runOnConnect string,
 	runOnConnectRestart bool,
+	webhookOnConnect string,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
+	accessLimiter *accessLimiter,
 	metrics *metrics,
 	pathManager *pathManager,
 	parent rtspServerParent,
@@ -116,20 +134,29 @@ func newRTSPServer(
 	ctx, ctxCancel := context.WithCancel(parentCtx)
 
 	s := &rtspServer{
-		externalAuthenticationURL: externalAuthenticationURL,
-		authMethods:               authMethods,
-		readTimeout:               readTimeout,
-		isTLS:                     isTLS,
-		rtspAddress:               rtspAddress,
-		protocols:                 protocols,
-		externalCmdPool:           externalCmdPool,
-		metrics:                   metrics,
-		pathManager:               pathManager,
-		parent:                    parent,
-		ctx:                       ctx,
-		ctxCancel:                 ctxCancel,
-		conns:                     make(map[*gortsplib.ServerConn]*rtspConn),
-		sessions:                  make(map[*gortsplib.ServerSession]*rtspSession),
+		externalAuth:        externalAuth,
+		jwtValidator:        jwtValidator,
+		urlSigningKey:       urlSigningKey,
+		users:               users,
+		authMethods:         authMethods,
+		readTimeout:         readTimeout,
+		isTLS:               isTLS,
+		recordPath:          recordPath,
+		rtspAddress:         rtspAddress,
+		protocols:           protocols,
+		runOnConnect:        runOnConnect,
+		runOnConnectRestart: runOnConnectRestart,
+		webhookOnConnect:    webhookOnConnect,
+		externalCmdPool:     externalCmdPool,
+		webhookSender:       webhookSender,
+		accessLimiter:       accessLimiter,
+		metrics:             metrics,
+		pathManager:         pathManager,
+		parent:              parent,
+		ctx:                 ctx,
+		ctxCancel:           ctxCancel,
+		conns:               make(map[*gortsplib.ServerConn]*rtspConn),
+		sessions:            make(map[*gortsplib.ServerSession]*rtspSession),
 	}
 
 	s.srv = &gortsplib.Server{
@@ -139,6 +166,29 @@ func newRTSPServer(
 		ReadBufferCount:  readBufferCount,
 		WriteBufferCount: readBufferCount,
 		RTSPAddress:      address,
+		Listen: func(network string, address string) (net.Listener, error) {
+			ln, err := net.Listen(network, address)
+			if err != nil {
+				return nil, err
+			}
+
+			// limits are applied to raw connections, in order to count
+			// HTTP connections that are not paired yet too.
+			ln = accessLimiter.listener(ln)
+
+			if tunnelAddress != "" {
+				httpLn, err := net.Listen("tcp", tunnelAddress)
+				if err != nil {
+					ln.Close()
+					return nil, err
+				}
+
+				ln = newRTSPTunnelListener(ln, accessLimiter.listener(httpLn),
+					tunnelAllowOrigin, readTimeout, s)
+			}
+
+			return ln, nil
+		},
 	}
 
 	if useUDP {
@@ -170,6 +220,10 @@ func newRTSPServer(
 
 	temp = append(temp, fmt.Sprintf("%s (TCP)", address))
 
+	if tunnelAddress != "" {
+		temp = append(temp, fmt.Sprintf("%s (HTTP/WebSocket tunnel)", tunnelAddress))
+	}
+
 	if s.srv.UDPRTPAddress != "" {
 		temp = append(temp, fmt.Sprintf("%s (UDP/RTP)", s.srv.UDPRTPAddress))
 	}
@@ -272,13 +326,21 @@ func (s *rtspServer) newSessionID() (string, error) {
 // OnConnOpen implements gortsplib.ServerHandlerOnConnOpen.
 func (s *rtspServer) OnConnOpen(ctx *gortsplib.ServerHandlerOnConnOpenCtx) {
 	c := newRTSPConn(
-		s.externalAuthenticationURL,
+		s.externalAuth,
+		s.jwtValidator,
+		s.urlSigningKey,
+		s.users,
 		s.rtspAddress,
 		s.authMethods,
 		s.readTimeout,
 		s.runOnConnect,
 		s.runOnConnectRestart,
+		s.webhookOnConnect,
+		s.isTLS,
+		s.recordPath,
 		s.externalCmdPool,
+		s.webhookSender,
+		s.accessLimiter,
 		s.pathManager,
 		ctx.Conn,
 		s)
@@ -328,7 +390,9 @@ func (s *rtspServer) OnSessionOpen(ctx *gortsplib.ServerHandlerOnSessionOpenCtx)
 		id,
 		ctx.Session,
 		ctx.Conn,
+		s.recordPath,
 		s.externalCmdPool,
+		s.webhookSender,
 		s.pathManager,
 		s)
 
@@ -443,6 +507,7 @@ func (s *rtspServer) onAPISessionsList(req rtspServerAPISessionsListReq) rtspSer
 				}
 				return "idle"
 			}(),
+			trafficStatsData: s.stats.get(),
 		}
 	}
 
@@ -4,11 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/base"
+	"github.com/aler9/gortsplib/pkg/headers"
 
 	"github.com/aler9/rtsp-simple-server/internal/conf"
 	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
@@ -22,6 +25,7 @@ const (
 type rtspSessionPathManager interface {
 	onPublisherAnnounce(req pathPublisherAnnounceReq) pathPublisherAnnounceRes
 	onReaderSetupPlay(req pathReaderSetupPlayReq) pathReaderSetupPlayRes
+	onPlayback(req pathPlaybackReq) pathPlaybackRes
 }
 
 type rtspSessionParent interface {
@@ -34,7 +38,9 @@ type rtspSession struct {
 	id              string
 	ss              *gortsplib.ServerSession
 	author          *gortsplib.ServerConn
+	recordPath      string
 	externalCmdPool *externalcmd.Pool
+	webhookSender   *webhookSender
 	pathManager     rtspSessionPathManager
 	parent          rtspSessionParent
 
@@ -42,8 +48,12 @@ type rtspSession struct {
 	state           gortsplib.ServerSessionState
 	stateMutex      sync.Mutex
 	onReadCmd       *externalcmd.Cmd // read
+	authExpiry      time.Time
+	authExpiryTimer *time.Timer
 	announcedTracks gortsplib.Tracks // publish
 	stream          *stream          // publish
+	playback        *rtspPlayback    // playback
+	stats           *trafficStats
 }
 
 func newRTSPSession(
@@ -52,7 +62,9 @@ func newRTSPSession(
 	id string,
 	ss *gortsplib.ServerSession,
 	sc *gortsplib.ServerConn,
+	recordPath string,
 	externalCmdPool *externalcmd.Pool,
+	webhookSender *webhookSender,
 	pathManager rtspSessionPathManager,
 	parent rtspSessionParent,
 ) *rtspSession {
@@ -62,9 +74,12 @@ func newRTSPSession(
 		id:              id,
 		ss:              ss,
 		author:          sc,
+		recordPath:      recordPath,
 		externalCmdPool: externalCmdPool,
+		webhookSender:   webhookSender,
 		pathManager:     pathManager,
 		parent:          parent,
+		stats:           newTrafficStats(),
 	}
 
 	s.log(logger.Info, "created by %v", s.author.NetConn().RemoteAddr())
@@ -77,9 +92,6 @@ func (s *rtspSession) close() {
 	s.ss.Close()
 }
 
-// IsRTSPSession implements pathRTSPSession.
-func (s *rtspSession) IsRTSPSession() {}
-
 // ID returns the public ID of the session.
 func (s *rtspSession) ID() string {
 	return s.id
@@ -100,14 +112,64 @@ func (s *rtspSession) log(level logger.Level, format string, args ...interface{}
 	s.parent.log(level, "[session %s] "+format, append([]interface{}{s.id}, args...)...)
 }
 
+func (s *rtspSession) sendReadWebhook(action string) {
+	if s.path.Conf().WebhookOnRead == "" {
+		return
+	}
+
+	typ := "rtspSession"
+	protocol := "rtsp"
+	if s.isTLS {
+		typ = "rtspsSession"
+		protocol = "rtsps"
+	}
+
+	s.webhookSender.send(s.path.Conf().WebhookOnRead, webhookPayload{
+		Event:  "read",
+		Action: action,
+		Env:    s.path.externalCmdEnv(),
+		Session: &webhookSession{
+			Type:       typ,
+			ID:         s.id,
+			Protocol:   protocol,
+			RemoteAddr: s.RemoteAddr().String(),
+		},
+	})
+}
+
+// startAuthExpiryTimer closes the session when the token used to authenticate expires.
+func (s *rtspSession) startAuthExpiryTimer() {
+	if s.authExpiry.IsZero() || s.authExpiryTimer != nil {
+		return
+	}
+
+	s.authExpiryTimer = time.AfterFunc(time.Until(s.authExpiry), func() {
+		s.log(logger.Info, "closing since the token has expired")
+		s.close()
+	})
+}
+
 // onClose is called by rtspServer.
 func (s *rtspSession) onClose(err error) {
+	if s.authExpiryTimer != nil {
+		s.authExpiryTimer.Stop()
+	}
+
+	if s.playback != nil {
+		s.playback.close()
+		s.playback = nil
+		s.log(logger.Info, "destroyed (%v)", err)
+		return
+	}
+
 	if s.ss.State() == gortsplib.ServerSessionStatePlay {
 		if s.onReadCmd != nil {
 			s.onReadCmd.Close()
 			s.onReadCmd = nil
 			s.log(logger.Info, "runOnRead command stopped")
 		}
+
+		s.sendReadWebhook("stop")
 	}
 
 	switch s.ss.State() {
@@ -133,7 +195,9 @@ func (s *rtspSession) onAnnounce(c *rtspConn, ctx *gortsplib.ServerHandlerOnAnno
 			pathUser conf.Credential,
 			pathPass conf.Credential,
 		) error {
-			return c.authenticate(ctx.Path, pathIPs, pathUser, pathPass, "publish", ctx.Request, ctx.Query)
+			err := c.authenticate(ctx.Path, pathIPs, pathUser, pathPass, "publish", ctx.Request, ctx.Query)
+			s.authExpiry = c.authExpiry
+			return err
 		},
 	})
 
@@ -144,6 +208,8 @@ func (s *rtspSession) onAnnounce(c *rtspConn, ctx *gortsplib.ServerHandlerOnAnno
 			return terr.response, nil
 
 		case pathErrAuthCritical:
+			c.accessLimiter.onAuthFailure(c.ip(), c.protocol())
+
 			// wait some seconds to stop brute force attacks
 			<-time.After(pauseAfterAuthError)
 
@@ -185,6 +251,10 @@ func (s *rtspSession) onSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 
 	switch s.ss.State() {
 	case gortsplib.ServerSessionStateInitial, gortsplib.ServerSessionStatePrePlay: // play
+		if isRTSPPlayback(ctx.Query) {
+			return s.onSetupPlayback(c, ctx)
+		}
+
 		res := s.pathManager.onReaderSetupPlay(pathReaderSetupPlayReq{
 			author:   s,
 			pathName: ctx.Path,
@@ -193,7 +263,9 @@ func (s *rtspSession) onSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 				pathUser conf.Credential,
 				pathPass conf.Credential,
 			) error {
-				return c.authenticate(ctx.Path, pathIPs, pathUser, pathPass, "read", ctx.Request, ctx.Query)
+				err := c.authenticate(ctx.Path, pathIPs, pathUser, pathPass, "read", ctx.Request, ctx.Query)
+				s.authExpiry = c.authExpiry
+				return err
 			},
 		})
 
@@ -204,6 +276,8 @@ func (s *rtspSession) onSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 				return terr.response, nil, nil
 
 			case pathErrAuthCritical:
+				c.accessLimiter.onAuthFailure(c.ip(), c.protocol())
+
 				// wait some seconds to stop brute force attacks
 				<-time.After(pauseAfterAuthError)
 
@@ -246,6 +320,10 @@ func (s *rtspSession) onSetup(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCt
 
 // onPlay is called by rtspServer.
 func (s *rtspSession) onPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	if s.playback != nil {
+		return s.onPlayPlayback(ctx)
+	}
+
 	h := make(base.Header)
 
 	if s.ss.State() == gortsplib.ServerSessionStatePrePlay {
@@ -263,6 +341,10 @@ func (s *rtspSession) onPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Respo
 				})
 		}
 
+		s.sendReadWebhook("start")
+
+		s.startAuthExpiryTimer()
+
 		s.stateMutex.Lock()
 		s.state = gortsplib.ServerSessionStatePlay
 		s.stateMutex.Unlock()
@@ -274,6 +356,160 @@ func (s *rtspSession) onPlay(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Respo
 	}, nil
 }
 
+func (s *rtspSession) onSetupPlayback(c *rtspConn, ctx *gortsplib.ServerHandlerOnSetupCtx,
+) (*base.Response, *gortsplib.ServerStream, error) {
+	if s.playback == nil {
+		res := s.pathManager.onPlayback(pathPlaybackReq{
+			pathName: ctx.Path,
+			authenticate: func(
+				pathIPs []interface{},
+				pathUser conf.Credential,
+				pathPass conf.Credential,
+			) error {
+				err := c.authenticate(ctx.Path, pathIPs, pathUser, pathPass, "read", ctx.Request, ctx.Query)
+				s.authExpiry = c.authExpiry
+				return err
+			},
+		})
+
+		if res.err != nil {
+			switch terr := res.err.(type) {
+			case pathErrAuthNotCritical:
+				s.log(logger.Debug, "non-critical authentication error: %s", terr.message)
+				return terr.response, nil, nil
+
+			case pathErrAuthCritical:
+				c.accessLimiter.onAuthFailure(c.ip(), c.protocol())
+
+				// wait some seconds to stop brute force attacks
+				<-time.After(pauseAfterAuthError)
+
+				return terr.response, nil, errors.New(terr.message)
+
+			default:
+				return &base.Response{
+					StatusCode: base.StatusBadRequest,
+				}, nil, res.err
+			}
+		}
+
+		pb, err := newRTSPPlayback(s.recordPath, ctx.Path, ctx.Query, s)
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusNotFound,
+			}, nil, err
+		}
+
+		s.playback = pb
+	}
+
+	if ctx.TrackID >= len(s.playback.stream.Tracks()) {
+		return &base.Response{
+			StatusCode: base.StatusBadRequest,
+		}, nil, fmt.Errorf("track %d does not exist", ctx.TrackID)
+	}
+
+	s.stateMutex.Lock()
+	s.state = gortsplib.ServerSessionStatePrePlay
+	s.stateMutex.Unlock()
+
+	return &base.Response{
+		StatusCode: base.StatusOK,
+	}, s.playback.stream, nil
+}
+
+func (s *rtspSession) onPlayPlayback(ctx *gortsplib.ServerHandlerOnPlayCtx) (*base.Response, error) {
+	var start *time.Time
+	var end *time.Time
+	npt := false
+
+	if v, ok := ctx.Request.Header["Range"]; ok {
+		var ra headers.Range
+		err := ra.Read(v)
+		if err != nil {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, fmt.Errorf("invalid Range header: %s", err)
+		}
+
+		switch rv := ra.Value.(type) {
+		case *headers.RangeUTC:
+			t := time.Time(rv.Start)
+			start = &t
+			if rv.End != nil {
+				t := time.Time(*rv.End)
+				end = &t
+			}
+
+		case *headers.RangeNPT:
+			// NPT is relative to the start of the requested range
+			npt = true
+			t := s.playback.start.Add(time.Duration(rv.Start))
+			start = &t
+			if rv.End != nil {
+				t := s.playback.start.Add(time.Duration(*rv.End))
+				end = &t
+			}
+
+		default:
+			return &base.Response{
+				StatusCode: base.StatusNotImplemented,
+			}, fmt.Errorf("unsupported Range unit")
+		}
+	}
+
+	scale := float64(1)
+	if v, ok := ctx.Request.Header["Scale"]; ok && len(v) == 1 {
+		tmp, err := strconv.ParseFloat(strings.TrimSpace(v[0]), 64)
+		if err != nil || tmp <= 0 {
+			return &base.Response{
+				StatusCode: base.StatusBadRequest,
+			}, fmt.Errorf("invalid Scale header (%v)", v[0])
+		}
+		scale = tmp
+	}
+
+	if s.ss.State() == gortsplib.ServerSessionStatePrePlay {
+		s.log(logger.Info, "is playing back recordings of path '%s', %d %s with %s",
+			ctx.Path,
+			len(s.ss.SetuppedTracks()),
+			func() string {
+				if len(s.ss.SetuppedTracks()) == 1 {
+					return "track"
+				}
+				return "tracks"
+			}(),
+			s.ss.SetuppedTransport())
+
+		s.startAuthExpiryTimer()
+
+		s.stateMutex.Lock()
+		s.state = gortsplib.ServerSessionStatePlay
+		s.stateMutex.Unlock()
+	}
+
+	position := s.playback.play(start, end, scale)
+
+	var rv headers.RangeValue
+	if npt {
+		rv = &headers.RangeNPT{
+			Start: headers.RangeNPTTime(position.Sub(s.playback.start)),
+		}
+	} else {
+		rv = &headers.RangeUTC{
+			Start: headers.RangeUTCTime(position),
+		}
+	}
+
+	return &base.Response{
+		StatusCode: base.StatusOK,
+		Header: base.Header{
+			"Range": headers.Range{Value: rv}.Write(),
+			"Scale": base.HeaderValue{strconv.FormatFloat(scale, 'f', -1, 64)},
+		},
+	}, nil
+}
+
 // onRecord is called by rtspServer.
 func (s *rtspSession) onRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.Response, error) {
 	res := s.path.onPublisherRecord(pathPublisherRecordReq{
@@ -288,6 +524,8 @@ func (s *rtspSession) onRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.R
 
 	s.stream = res.stream
 
+	s.startAuthExpiryTimer()
+
 	s.stateMutex.Lock()
 	s.state = gortsplib.ServerSessionStateRecord
 	s.stateMutex.Unlock()
@@ -299,6 +537,18 @@ func (s *rtspSession) onRecord(ctx *gortsplib.ServerHandlerOnRecordCtx) (*base.R
 
 // onPause is called by rtspServer.
 func (s *rtspSession) onPause(ctx *gortsplib.ServerHandlerOnPauseCtx) (*base.Response, error) {
+	if s.playback != nil {
+		s.playback.pause()
+
+		s.stateMutex.Lock()
+		s.state = gortsplib.ServerSessionStatePrePlay
+		s.stateMutex.Unlock()
+
+		return &base.Response{
+			StatusCode: base.StatusOK,
+		}, nil
+	}
+
 	switch s.ss.State() {
 	case gortsplib.ServerSessionStatePlay:
 		if s.onReadCmd != nil {
@@ -306,6 +556,8 @@ func (s *rtspSession) onPause(ctx *gortsplib.ServerHandlerOnPauseCtx) (*base.Res
 			s.onReadCmd.Close()
 		}
 
+		s.sendReadWebhook("stop")
+
 		s.path.onReaderPause(pathReaderPauseReq{author: s})
 
 		s.stateMutex.Lock()
@@ -343,7 +595,19 @@ func (s *rtspSession) onReaderAccepted() {
 
 // onReaderData implements reader.
 func (s *rtspSession) onReaderData(data *data) {
-	// packets are routed to the session by gortsplib.ServerStream.
+	// packets are routed to the session by gortsplib.ServerStream,
+	// therefore they are only counted. This is called by stream.writeData
+	// after the packet has been handed to gortsplib, that is when
+	// latency is measured.
+	if _, ok := s.ss.SetuppedTracks()[data.trackID]; ok {
+		s.stats.onDataSent(data)
+		s.path.latency.onDataSent(s.protocol(), data)
+	}
+}
+
+// trafficStats implements reader.
+func (s *rtspSession) trafficStats() *trafficStats {
+	return s.stats
 }
 
 // onReaderAPIDescribe implements reader.
@@ -376,6 +640,14 @@ func (s *rtspSession) onSourceAPIDescribe() interface{} {
 	}{typ, s.id}
 }
 
+// protocol implements reader and source.
+func (s *rtspSession) protocol() string {
+	if s.isTLS {
+		return "rtsps"
+	}
+	return "rtsp"
+}
+
 // onPublisherAccepted implements publisher.
 func (s *rtspSession) onPublisherAccepted(tracksLen int) {
 	s.log(logger.Info, "is publishing to path '%s', %d %s with %s",
@@ -392,19 +664,27 @@ func (s *rtspSession) onPublisherAccepted(tracksLen int) {
 
 // onPacketRTP is called by rtspServer.
 func (s *rtspSession) onPacketRTP(ctx *gortsplib.ServerHandlerOnPacketRTPCtx) {
+	received := time.Now()
+
+	var d *data
 	if ctx.H264NALUs != nil {
-		s.stream.writeData(&data{
+		d = &data{
 			trackID:      ctx.TrackID,
 			rtp:          ctx.Packet,
 			ptsEqualsDTS: ctx.PTSEqualsDTS,
 			h264NALUs:    append([][]byte(nil), ctx.H264NALUs...),
 			h264PTS:      ctx.H264PTS,
-		})
+			ingestTime:   received,
+		}
 	} else {
-		s.stream.writeData(&data{
+		d = &data{
 			trackID:      ctx.TrackID,
 			rtp:          ctx.Packet,
 			ptsEqualsDTS: ctx.PTSEqualsDTS,
-		})
+			ingestTime:   received,
+		}
 	}
+
+	s.stats.onDataReceived(d)
+	s.stream.writeData(d)
 }
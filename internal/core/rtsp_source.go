@@ -25,6 +25,7 @@ type rtspSourceParent interface {
 	log(logger.Level, string, ...interface{})
 	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
 	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
 }
 
 type rtspSource struct {
@@ -154,12 +155,14 @@ func (s *rtspSource) runInner() bool {
 	u, err := base.ParseURL(s.ur)
 	if err != nil {
 		s.log(logger.Info, "ERR: %s", err)
+		s.parent.onSourceStaticError(err)
 		return true
 	}
 
 	err = c.Start(u.Scheme, u.Host)
 	if err != nil {
 		s.log(logger.Info, "ERR: %s", err)
+		s.parent.onSourceStaticError(err)
 		return true
 	}
 	defer c.Close()
@@ -194,6 +197,8 @@ func (s *rtspSource) runInner() bool {
 			}()
 
 			c.OnPacketRTP = func(ctx *gortsplib.ClientOnPacketRTPCtx) {
+				received := time.Now()
+
 				if ctx.H264NALUs != nil {
 					res.stream.writeData(&data{
 						trackID:      ctx.TrackID,
@@ -201,12 +206,14 @@ func (s *rtspSource) runInner() bool {
 						ptsEqualsDTS: ctx.PTSEqualsDTS,
 						h264NALUs:    append([][]byte(nil), ctx.H264NALUs...),
 						h264PTS:      ctx.H264PTS,
+						ingestTime:   received,
 					})
 				} else {
 					res.stream.writeData(&data{
 						trackID:      ctx.TrackID,
 						rtp:          ctx.Packet,
 						ptsEqualsDTS: ctx.PTSEqualsDTS,
+						ingestTime:   received,
 					})
 				}
 			}
@@ -223,6 +230,7 @@ func (s *rtspSource) runInner() bool {
 	select {
 	case err := <-readErr:
 		s.log(logger.Info, "ERR: %s", err)
+		s.parent.onSourceStaticError(err)
 		return true
 
 	case <-s.ctx.Done():
@@ -238,3 +246,8 @@ func (*rtspSource) onSourceAPIDescribe() interface{} {
 		Type string `json:"type"`
 	}{"rtspSource"}
 }
+
+// protocol implements source.
+func (*rtspSource) protocol() string {
+	return "rtsp"
+}
@@ -0,0 +1,491 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	// time allowed to a client to open the POST connection of a HTTP tunnel,
+	// after the GET connection.
+	rtspTunnelPairTimeout = 10 * time.Second
+
+	// maximum number of GET connections that are waiting for the
+	// corresponding POST connection.
+	rtspTunnelMaxPending = 64
+)
+
+// rtspTunnelPending is a GET connection of a HTTP tunnel
+// that is waiting for the corresponding POST connection.
+type rtspTunnelPending struct {
+	conn net.Conn
+	ip   string
+}
+
+type rtspTunnelListenerParent interface {
+	log(logger.Level, string, ...interface{})
+}
+
+// rtspTunnelListener is a net.Listener that returns the connections accepted by a
+// TCP listener and RTSP connections that are tunneled through HTTP
+// (Apple QuickTime tunneling) or WebSocket, received by a HTTP listener.
+type rtspTunnelListener struct {
+	ln          net.Listener
+	httpLn      net.Listener
+	allowOrigin string
+	server      *http.Server
+	parent      rtspTunnelListenerParent
+
+	mutex   sync.Mutex
+	pending map[string]rtspTunnelPending
+
+	conns     chan net.Conn
+	tcpErr    error
+	tcpDone   chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newRTSPTunnelListener(
+	ln net.Listener,
+	httpLn net.Listener,
+	allowOrigin string,
+	readTimeout conf.StringDuration,
+	parent rtspTunnelListenerParent,
+) *rtspTunnelListener {
+	l := &rtspTunnelListener{
+		ln:          ln,
+		httpLn:      httpLn,
+		allowOrigin: allowOrigin,
+		parent:      parent,
+		pending:     make(map[string]rtspTunnelPending),
+		conns:       make(chan net.Conn),
+		tcpDone:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	l.server = &http.Server{
+		Handler:           l,
+		ReadHeaderTimeout: time.Duration(readTimeout),
+		IdleTimeout:       time.Duration(readTimeout),
+	}
+
+	go l.runTCP()
+	go l.server.Serve(l.httpLn)
+
+	return l
+}
+
+func (l *rtspTunnelListener) runTCP() {
+	defer close(l.tcpDone)
+
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			l.tcpErr = err
+			return
+		}
+
+		if !l.push(conn) {
+			return
+		}
+	}
+}
+
+func (l *rtspTunnelListener) push(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+
+	case <-l.done:
+		conn.Close()
+		return false
+	}
+}
+
+// Accept implements net.Listener.
+func (l *rtspTunnelListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+
+	case <-l.tcpDone:
+		return nil, l.tcpErr
+	}
+}
+
+// Close implements net.Listener.
+func (l *rtspTunnelListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.server.Shutdown(context.Background())
+
+		l.mutex.Lock()
+		for cookie, pe := range l.pending {
+			pe.conn.Close()
+			delete(l.pending, cookie)
+		}
+		l.mutex.Unlock()
+	})
+
+	return l.ln.Close()
+}
+
+// Addr implements net.Listener.
+func (l *rtspTunnelListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// ServeHTTP implements http.Handler.
+func (l *rtspTunnelListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.parent.log(logger.Debug, "[tunnel] [conn %v] %s %s", r.RemoteAddr, r.Method, r.URL.Path)
+
+	if websocket.IsWebSocketUpgrade(r) {
+		l.onWebSocket(w, r)
+		return
+	}
+
+	cookie := r.Header.Get("x-sessioncookie")
+	if cookie == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		l.onTunnelGet(w, r, cookie)
+
+	case http.MethodPost:
+		l.onTunnelPost(w, r, cookie)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (l *rtspTunnelListener) onWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{"rtsp"},
+		CheckOrigin: func(r *http.Request) bool {
+			return webSocketOriginAllowed(r, l.allowOrigin)
+		},
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	l.push(&rtspTunnelWebSocketConn{conn: conn})
+}
+
+// webSocketOriginAllowed accepts WebSocket requests that are not sent by browsers,
+// requests coming from a page served by the same host and requests coming
+// from the allowed origin, in order to prevent arbitrary web pages from
+// using the server through the browsers of visitors.
+func webSocketOriginAllowed(r *http.Request, allowOrigin string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if allowOrigin == "*" || origin == allowOrigin {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// remoteIP returns the IP of the client that sent a request.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func (l *rtspTunnelListener) onTunnelGet(w http.ResponseWriter, r *http.Request, cookie string) {
+	l.mutex.Lock()
+	_, ok := l.pending[cookie]
+	full := len(l.pending) >= rtspTunnelMaxPending
+	l.mutex.Unlock()
+	if ok {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if full {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+
+	_, err = io.WriteString(conn, "HTTP/1.0 200 OK\r\n"+
+		"Server: rtsp-simple-server\r\n"+
+		"Connection: close\r\n"+
+		"Cache-Control: no-store\r\n"+
+		"Pragma: no-cache\r\n"+
+		"Content-Type: application/x-rtsp-tunnelled\r\n"+
+		"\r\n")
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	l.mutex.Lock()
+	if _, ok := l.pending[cookie]; ok || len(l.pending) >= rtspTunnelMaxPending {
+		l.mutex.Unlock()
+		conn.Close()
+		return
+	}
+	l.pending[cookie] = rtspTunnelPending{
+		conn: conn,
+		ip:   remoteIP(r),
+	}
+	l.mutex.Unlock()
+
+	time.AfterFunc(rtspTunnelPairTimeout, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if l.pending[cookie].conn == conn {
+			l.parent.log(logger.Debug, "[tunnel] [conn %v] POST connection not received", conn.RemoteAddr())
+			conn.Close()
+			delete(l.pending, cookie)
+		}
+	})
+}
+
+func (l *rtspTunnelListener) onTunnelPost(w http.ResponseWriter, r *http.Request, cookie string) {
+	l.mutex.Lock()
+	pe, ok := l.pending[cookie]
+	if !ok {
+		l.mutex.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// the cookie is not a secret, therefore the POST connection
+	// must come from the same client of the GET connection.
+	if pe.ip != remoteIP(r) {
+		l.mutex.Unlock()
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	delete(l.pending, cookie)
+	l.mutex.Unlock()
+	getConn := pe.conn
+
+	postConn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		getConn.Close()
+		return
+	}
+
+	l.push(&rtspTunnelHTTPConn{
+		getConn:  getConn,
+		postConn: postConn,
+		r:        &rtspTunnelBase64Reader{r: rw.Reader},
+	})
+}
+
+// rtspTunnelHTTPConn is a RTSP connection tunneled through HTTP.
+// Requests are received base64-encoded on the POST connection,
+// while responses are sent without encoding on the GET connection.
+type rtspTunnelHTTPConn struct {
+	getConn  net.Conn
+	postConn net.Conn
+	r        io.Reader
+}
+
+// Read implements net.Conn.
+func (c *rtspTunnelHTTPConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write implements net.Conn.
+func (c *rtspTunnelHTTPConn) Write(p []byte) (int, error) {
+	return c.getConn.Write(p)
+}
+
+// Close implements net.Conn.
+func (c *rtspTunnelHTTPConn) Close() error {
+	c.postConn.Close()
+	return c.getConn.Close()
+}
+
+// LocalAddr implements net.Conn.
+func (c *rtspTunnelHTTPConn) LocalAddr() net.Addr {
+	return c.getConn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *rtspTunnelHTTPConn) RemoteAddr() net.Addr {
+	return c.getConn.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *rtspTunnelHTTPConn) SetDeadline(t time.Time) error {
+	c.postConn.SetDeadline(t)
+	return c.getConn.SetDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *rtspTunnelHTTPConn) SetReadDeadline(t time.Time) error {
+	return c.postConn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *rtspTunnelHTTPConn) SetWriteDeadline(t time.Time) error {
+	return c.getConn.SetWriteDeadline(t)
+}
+
+// rtspTunnelBase64Reader decodes the body of the POST connection.
+// Every message is encoded separately, therefore padding can be found
+// in the middle of the stream and groups are decoded one by one.
+type rtspTunnelBase64Reader struct {
+	r   *bufio.Reader
+	in  []byte
+	out []byte
+	err error
+}
+
+func (r *rtspTunnelBase64Reader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+
+		buf := make([]byte, 4096)
+		n, err := r.r.Read(buf)
+		r.err = err
+
+		for _, b := range buf[:n] {
+			switch b {
+			case '\r', '\n', ' ', '\t':
+			default:
+				r.in = append(r.in, b)
+			}
+		}
+
+		dec := make([]byte, 3)
+		i := 0
+		for ; (i + 4) <= len(r.in); i += 4 {
+			n, err := base64.StdEncoding.Decode(dec, r.in[i:i+4])
+			if err != nil {
+				r.err = fmt.Errorf("invalid base64 data: %v", err)
+				break
+			}
+			r.out = append(r.out, dec[:n]...)
+		}
+		r.in = append(r.in[:0], r.in[i:]...)
+	}
+
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// rtspTunnelWebSocketConn is a RTSP connection tunneled through WebSocket.
+// Data is exchanged in binary messages; messages received from the client
+// are concatenated.
+type rtspTunnelWebSocketConn struct {
+	conn       *websocket.Conn
+	reader     io.Reader
+	writeMutex sync.Mutex
+}
+
+// Read implements net.Conn.
+func (c *rtspTunnelWebSocketConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			typ, reader, err := c.conn.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			if typ != websocket.BinaryMessage && typ != websocket.TextMessage {
+				continue
+			}
+
+			c.reader = reader
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+// Write implements net.Conn.
+func (c *rtspTunnelWebSocketConn) Write(p []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	err := c.conn.WriteMessage(websocket.BinaryMessage, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close implements net.Conn.
+func (c *rtspTunnelWebSocketConn) Close() error {
+	return c.conn.Close()
+}
+
+// LocalAddr implements net.Conn.
+func (c *rtspTunnelWebSocketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr implements net.Conn.
+func (c *rtspTunnelWebSocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetDeadline implements net.Conn.
+func (c *rtspTunnelWebSocketConn) SetDeadline(t time.Time) error {
+	c.conn.SetReadDeadline(t)
+	return c.conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *rtspTunnelWebSocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *rtspTunnelWebSocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
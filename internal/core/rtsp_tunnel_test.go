@@ -0,0 +1,252 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type rtspTunnelTestParent struct{}
+
+func (rtspTunnelTestParent) log(logger.Level, string, ...interface{}) {}
+
+func TestRTSPTunnelBase64Reader(t *testing.T) {
+	enc := base64.StdEncoding.EncodeToString([]byte("OPTIONS")) + "\r\n" +
+		base64.StdEncoding.EncodeToString([]byte(" rtsp://"))
+
+	r := &rtspTunnelBase64Reader{r: bufio.NewReader(bytes.NewReader([]byte(enc)))}
+	byts, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, []byte("OPTIONS rtsp://"), byts)
+}
+
+func TestRTSPTunnelListener(t *testing.T) {
+	tcpLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	httpLn, err := net.Listen("tcp", "127.0.0.1:9555")
+	require.NoError(t, err)
+
+	ln := newRTSPTunnelListener(tcpLn, httpLn, "http://allowed.example.com",
+		conf.StringDuration(10*time.Second), rtspTunnelTestParent{})
+	defer ln.Close()
+
+	t.Run("tcp", func(t *testing.T) {
+		conn, err := net.Dial("tcp", tcpLn.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		defer sconn.Close()
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(sconn, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte("ping"), buf)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		getConn, err := net.Dial("tcp", "127.0.0.1:9555")
+		require.NoError(t, err)
+		defer getConn.Close()
+
+		_, err = getConn.Write([]byte("GET /mystream HTTP/1.0\r\n" +
+			"x-sessioncookie: abcd\r\n" +
+			"Accept: application/x-rtsp-tunnelled\r\n" +
+			"\r\n"))
+		require.NoError(t, err)
+
+		br := bufio.NewReader(getConn)
+		res, err := http.ReadResponse(br, nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, "application/x-rtsp-tunnelled", res.Header.Get("Content-Type"))
+
+		postConn, err := net.Dial("tcp", "127.0.0.1:9555")
+		require.NoError(t, err)
+		defer postConn.Close()
+
+		_, err = postConn.Write([]byte("POST /mystream HTTP/1.0\r\n" +
+			"x-sessioncookie: abcd\r\n" +
+			"Content-Type: application/x-rtsp-tunnelled\r\n" +
+			"Content-Length: 32767\r\n" +
+			"\r\n" +
+			base64.StdEncoding.EncodeToString([]byte("OPTIONS"))))
+		require.NoError(t, err)
+
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		defer sconn.Close()
+
+		buf := make([]byte, 7)
+		_, err = io.ReadFull(sconn, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte("OPTIONS"), buf)
+
+		_, err = sconn.Write([]byte("RTSP/1.0 200 OK\r\n"))
+		require.NoError(t, err)
+
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		require.Equal(t, "RTSP/1.0 200 OK\r\n", line)
+
+		_, ok := sconn.RemoteAddr().(*net.TCPAddr)
+		require.Equal(t, true, ok)
+	})
+
+	t.Run("http without post", func(t *testing.T) {
+		res, err := http.Post("http://127.0.0.1:9555/mystream", "application/x-rtsp-tunnelled", nil)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("http post from another ip", func(t *testing.T) {
+		getConn, err := net.Dial("tcp", "127.0.0.1:9555")
+		require.NoError(t, err)
+		defer getConn.Close()
+
+		_, err = getConn.Write([]byte("GET /mystream HTTP/1.0\r\n" +
+			"x-sessioncookie: efgh\r\n" +
+			"\r\n"))
+		require.NoError(t, err)
+
+		res, err := http.ReadResponse(bufio.NewReader(getConn), nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: net.ParseIP("127.0.0.2")}}
+		postConn, err := dialer.Dial("tcp", "127.0.0.1:9555")
+		require.NoError(t, err)
+		defer postConn.Close()
+
+		_, err = postConn.Write([]byte("POST /mystream HTTP/1.0\r\n" +
+			"x-sessioncookie: efgh\r\n" +
+			"Content-Length: 32767\r\n" +
+			"\r\n"))
+		require.NoError(t, err)
+
+		res, err = http.ReadResponse(bufio.NewReader(postConn), nil)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+		// the GET connection can still be paired by its client
+		postConn2, err := net.Dial("tcp", "127.0.0.1:9555")
+		require.NoError(t, err)
+		defer postConn2.Close()
+
+		_, err = postConn2.Write([]byte("POST /mystream HTTP/1.0\r\n" +
+			"x-sessioncookie: efgh\r\n" +
+			"Content-Length: 32767\r\n" +
+			"\r\n"))
+		require.NoError(t, err)
+
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		sconn.Close()
+	})
+
+	t.Run("http pending limit", func(t *testing.T) {
+		get := func(cookie string) (net.Conn, int) {
+			conn, err := net.Dial("tcp", "127.0.0.1:9555")
+			require.NoError(t, err)
+
+			_, err = conn.Write([]byte("GET /mystream HTTP/1.0\r\n" +
+				"x-sessioncookie: " + cookie + "\r\n" +
+				"\r\n"))
+			require.NoError(t, err)
+
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			require.NoError(t, err)
+			return conn, res.StatusCode
+		}
+
+		for i := 0; i < rtspTunnelMaxPending; i++ {
+			conn, code := get("pending" + strconv.FormatInt(int64(i), 10))
+			defer conn.Close()
+			require.Equal(t, http.StatusOK, code)
+		}
+
+		conn, code := get("exceeding")
+		defer conn.Close()
+		require.Equal(t, http.StatusServiceUnavailable, code)
+	})
+
+	t.Run("websocket", func(t *testing.T) {
+		conn, res, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:9555/mystream", http.Header{
+			"Sec-WebSocket-Protocol": []string{"rtsp"},
+		})
+		require.NoError(t, err)
+		defer conn.Close()
+		require.Equal(t, "rtsp", res.Header.Get("Sec-WebSocket-Protocol"))
+
+		sconn, err := ln.Accept()
+		require.NoError(t, err)
+		defer sconn.Close()
+
+		err = conn.WriteMessage(websocket.BinaryMessage, []byte("OPT"))
+		require.NoError(t, err)
+
+		err = conn.WriteMessage(websocket.BinaryMessage, []byte("IONS"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 7)
+		_, err = io.ReadFull(sconn, buf)
+		require.NoError(t, err)
+		require.Equal(t, []byte("OPTIONS"), buf)
+
+		_, err = sconn.Write([]byte("RTSP/1.0 200 OK\r\n"))
+		require.NoError(t, err)
+
+		typ, byts, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, websocket.BinaryMessage, typ)
+		require.Equal(t, []byte("RTSP/1.0 200 OK\r\n"), byts)
+	})
+	t.Run("websocket origin", func(t *testing.T) {
+		for _, ca := range []struct {
+			name   string
+			origin string
+			ok     bool
+		}{
+			{"same host", "http://127.0.0.1:9555", true},
+			{"allowed", "http://allowed.example.com", true},
+			{"other", "http://evil.example.com", false},
+		} {
+			t.Run(ca.name, func(t *testing.T) {
+				conn, res, err := websocket.DefaultDialer.Dial("ws://127.0.0.1:9555/mystream", http.Header{
+					"Origin": []string{ca.origin},
+				})
+
+				if ca.ok {
+					require.NoError(t, err)
+					defer conn.Close()
+
+					sconn, err := ln.Accept()
+					require.NoError(t, err)
+					sconn.Close()
+				} else {
+					require.Error(t, err)
+					require.Equal(t, http.StatusForbidden, res.StatusCode)
+				}
+			})
+		}
+	})
+}
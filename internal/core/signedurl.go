@@ -0,0 +1,60 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// signedURLSignature returns the signature of a URL that allows to read a path
+// until a given time, optionally from a single IP.
+func signedURLSignature(key string, pathName string, expires int64, ip string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(pathName + "|" + strconv.FormatInt(expires, 10) + "|" + ip))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedURLQuery returns the query parameters of a signed URL.
+func signedURLQuery(key string, pathName string, expires time.Time, ip string) url.Values {
+	v := url.Values{}
+	v.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	if ip != "" {
+		v.Set("ip", ip)
+	}
+	v.Set("sig", signedURLSignature(key, pathName, expires.Unix(), ip))
+	return v
+}
+
+// isSignedURL returns whether the query of a URL contains a signature.
+func isSignedURL(key string, query url.Values) bool {
+	return key != "" && query.Get("sig") != ""
+}
+
+// signedURLCheck checks the signature of a URL and returns its expiration time.
+func signedURLCheck(key string, pathName string, query url.Values, ip net.IP) (time.Time, error) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration")
+	}
+
+	sig := signedURLSignature(key, pathName, expires, query.Get("ip"))
+	if !hmac.Equal([]byte(sig), []byte(query.Get("sig"))) {
+		return time.Time{}, fmt.Errorf("invalid signature")
+	}
+
+	expiry := time.Unix(expires, 0)
+	if time.Now().After(expiry) {
+		return time.Time{}, fmt.Errorf("URL has expired")
+	}
+
+	if query.Get("ip") != "" && !net.ParseIP(query.Get("ip")).Equal(ip) {
+		return time.Time{}, fmt.Errorf("URL is bound to another IP")
+	}
+
+	return expiry, nil
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignedURL(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	q := signedURLQuery("mykey", "mypath", expires, "")
+	ret, err := signedURLCheck("mykey", "mypath", q, net.ParseIP("192.168.1.1"))
+	require.NoError(t, err)
+	require.True(t, expires.Equal(ret))
+
+	_, err = signedURLCheck("mykey", "otherpath", q, net.ParseIP("192.168.1.1"))
+	require.EqualError(t, err, "invalid signature")
+
+	_, err = signedURLCheck("otherkey", "mypath", q, net.ParseIP("192.168.1.1"))
+	require.EqualError(t, err, "invalid signature")
+
+	q = signedURLQuery("mykey", "mypath", expires, "192.168.1.1")
+	_, err = signedURLCheck("mykey", "mypath", q, net.ParseIP("192.168.1.1"))
+	require.NoError(t, err)
+
+	_, err = signedURLCheck("mykey", "mypath", q, net.ParseIP("192.168.1.2"))
+	require.EqualError(t, err, "URL is bound to another IP")
+
+	q.Set("ip", "192.168.1.2")
+	_, err = signedURLCheck("mykey", "mypath", q, net.ParseIP("192.168.1.2"))
+	require.EqualError(t, err, "invalid signature")
+
+	q = signedURLQuery("mykey", "mypath", time.Now().Add(-time.Second), "")
+	_, err = signedURLCheck("mykey", "mypath", q, net.ParseIP("192.168.1.1"))
+	require.EqualError(t, err, "URL has expired")
+}
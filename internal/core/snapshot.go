@@ -0,0 +1,345 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/kballard/go-shellquote"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	// maximum number of decoded snapshots kept in cache.
+	snapshotCacheSize = 256
+
+	// maximum number of snapshots waiting to be saved to disk.
+	snapshotSaveQueueSize = 16
+
+	// maximum number of decoders that can run at the same time.
+	snapshotMaxDecoders = 4
+)
+
+// snapshotFrame is a H264 IDR frame, with SPS and PPS, in Annex-B format.
+type snapshotFrame struct {
+	time   time.Time
+	annexB []byte
+}
+
+// streamSnapshot keeps the last IDR frame of the first H264 track of a stream.
+type streamSnapshot struct {
+	trackID int
+
+	mutex sync.Mutex
+	frame *snapshotFrame
+}
+
+func newStreamSnapshot(tracks gortsplib.Tracks) *streamSnapshot {
+	s := &streamSnapshot{
+		trackID: -1,
+	}
+
+	for i, track := range tracks {
+		if _, ok := track.(*gortsplib.TrackH264); ok {
+			s.trackID = i
+			break
+		}
+	}
+
+	return s
+}
+
+// onData is called when a unit of data is written into the stream.
+func (s *streamSnapshot) onData(h264track *gortsplib.TrackH264, data *data) {
+	if data.trackID != s.trackID || !h264.IDRPresent(data.h264NALUs) {
+		return
+	}
+
+	sps := h264track.SPS()
+	pps := h264track.PPS()
+	if sps == nil || pps == nil {
+		return
+	}
+
+	nalus := [][]byte{sps, pps}
+	for _, nalu := range data.h264NALUs {
+		typ := h264.NALUType(nalu[0] & 0x1F)
+		if typ != h264.NALUTypeSPS && typ != h264.NALUTypePPS {
+			nalus = append(nalus, nalu)
+		}
+	}
+
+	// encoding copies NALUs, that may be overwritten by the source.
+	byts, err := h264.AnnexBEncode(nalus)
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.frame = &snapshotFrame{
+		time:   time.Now(),
+		annexB: byts,
+	}
+}
+
+func (s *streamSnapshot) get() *snapshotFrame {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.frame
+}
+
+type snapshotterParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+type snapshotSaveReq struct {
+	pathName string
+	frame    *snapshotFrame
+}
+
+type snapshotCacheEntry struct {
+	frameTime time.Time
+	jpeg      []byte
+}
+
+// snapshotDecode is a decoding in progress, that is shared
+// by all the requests of the same frame.
+type snapshotDecode struct {
+	frameTime time.Time
+	done      chan struct{}
+	jpeg      []byte
+	err       error
+}
+
+// snapshotter converts snapshot frames into JPEG images with an external
+// decoder, and saves them to disk.
+type snapshotter struct {
+	decoder      string
+	timeout      time.Duration
+	snapshotPath string
+	parent       snapshotterParent
+
+	ctx       context.Context
+	ctxCancel func()
+	wg        sync.WaitGroup
+	mutex     sync.Mutex
+	cache     map[string]*snapshotCacheEntry
+	pending   map[string]*snapshotDecode
+	decoders  chan struct{}
+
+	// in
+	save chan snapshotSaveReq
+}
+
+func newSnapshotter(
+	parentCtx context.Context,
+	decoder string,
+	timeout conf.StringDuration,
+	snapshotPath string,
+	parent snapshotterParent,
+) *snapshotter {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &snapshotter{
+		decoder:      decoder,
+		timeout:      time.Duration(timeout),
+		snapshotPath: snapshotPath,
+		parent:       parent,
+		ctx:          ctx,
+		ctxCancel:    ctxCancel,
+		cache:        make(map[string]*snapshotCacheEntry),
+		pending:      make(map[string]*snapshotDecode),
+		decoders:     make(chan struct{}, snapshotMaxDecoders),
+		save:         make(chan snapshotSaveReq, snapshotSaveQueueSize),
+	}
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *snapshotter) close() {
+	s.ctxCancel()
+	s.wg.Wait()
+}
+
+func (s *snapshotter) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[snapshotter] "+format, args...)
+}
+
+func (s *snapshotter) run() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case req := <-s.save:
+			err := s.doSave(req.pathName, req.frame)
+			if err != nil {
+				s.log(logger.Warn, "unable to save snapshot of path '%s': %v", req.pathName, err)
+			}
+
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *snapshotter) doSave(pathName string, frame *snapshotFrame) error {
+	byts, err := s.jpeg(pathName, frame)
+	if err != nil {
+		return err
+	}
+
+	fpath, err := joinInsideDir(s.snapshotPath, filepath.FromSlash(pathName)+".jpg")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(fpath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// write into a temporary file, in order to prevent
+	// readers from reading incomplete images.
+	tmpPath := fpath + ".tmp"
+
+	err = ioutil.WriteFile(tmpPath, byts, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, fpath)
+}
+
+// jpeg returns the JPEG image of a frame.
+// Frames are decoded once, and subsequent or concurrent requests
+// are served from cache.
+func (s *snapshotter) jpeg(pathName string, frame *snapshotFrame) ([]byte, error) {
+	s.mutex.Lock()
+
+	if entry, ok := s.cache[pathName]; ok && entry.frameTime.Equal(frame.time) {
+		s.mutex.Unlock()
+		return entry.jpeg, nil
+	}
+
+	if dec, ok := s.pending[pathName]; ok && dec.frameTime.Equal(frame.time) {
+		s.mutex.Unlock()
+		<-dec.done
+		return dec.jpeg, dec.err
+	}
+
+	dec := &snapshotDecode{
+		frameTime: frame.time,
+		done:      make(chan struct{}),
+	}
+	s.pending[pathName] = dec
+	s.mutex.Unlock()
+
+	dec.jpeg, dec.err = s.decode(frame.annexB)
+
+	s.mutex.Lock()
+
+	if s.pending[pathName] == dec {
+		delete(s.pending, pathName)
+	}
+
+	if dec.err == nil {
+		if _, ok := s.cache[pathName]; !ok && len(s.cache) >= snapshotCacheSize {
+			s.evictOldest()
+		}
+
+		s.cache[pathName] = &snapshotCacheEntry{
+			frameTime: frame.time,
+			jpeg:      dec.jpeg,
+		}
+	}
+
+	s.mutex.Unlock()
+	close(dec.done)
+
+	return dec.jpeg, dec.err
+}
+
+// evictOldest removes the oldest entry from cache. Mutex must be locked.
+func (s *snapshotter) evictOldest() {
+	var oldestKey string
+	var oldestTime time.Time
+
+	for key, entry := range s.cache {
+		if oldestTime.IsZero() || entry.frameTime.Before(oldestTime) {
+			oldestKey = key
+			oldestTime = entry.frameTime
+		}
+	}
+
+	delete(s.cache, oldestKey)
+}
+
+// decode converts a frame into a JPEG image by writing the frame into
+// the standard input of the decoder and reading the standard output.
+func (s *snapshotter) decode(annexB []byte) ([]byte, error) {
+	cmdparts, err := shellquote.Split(s.decoder)
+	if err != nil {
+		return nil, err
+	}
+	if len(cmdparts) == 0 {
+		return nil, fmt.Errorf("decoder is empty")
+	}
+
+	ctx, ctxCancel := context.WithTimeout(s.ctx, s.timeout)
+	defer ctxCancel()
+
+	// decoders are heavy, limit the ones that run at the same time
+	select {
+	case s.decoders <- struct{}{}:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("decoder failed: %v", ctx.Err())
+	}
+	defer func() { <-s.decoders }()
+
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, cmdparts[0], cmdparts[1:]...)
+	cmd.Stdin = bytes.NewReader(annexB)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("decoder failed: %v (%s)", err, msg)
+		}
+		return nil, fmt.Errorf("decoder failed: %v", err)
+	}
+
+	if stdout.Len() == 0 {
+		return nil, fmt.Errorf("decoder returned an empty image")
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// onSave is called by path.
+func (s *snapshotter) onSave(pathName string, frame *snapshotFrame) {
+	select {
+	case s.save <- snapshotSaveReq{pathName: pathName, frame: frame}:
+	default:
+		s.log(logger.Warn, "unable to save snapshot of path '%s': queue is full", pathName)
+	}
+}
@@ -0,0 +1,148 @@
+package core
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestStreamSnapshot(t *testing.T) {
+	aacTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	h264Track, err := gortsplib.NewTrackH264(96,
+		[]byte{0x67, 0x01, 0x02}, []byte{0x68, 0x03, 0x04}, nil)
+	require.NoError(t, err)
+
+	s := newStreamSnapshot(gortsplib.Tracks{aacTrack, h264Track})
+	require.Equal(t, 1, s.trackID)
+
+	// non-IDR frame
+	s.onData(h264Track, &data{
+		trackID:   1,
+		h264NALUs: [][]byte{{0x41, 0x05}},
+	})
+	require.Nil(t, s.get())
+
+	// IDR frame, with SPS that is not repeated
+	s.onData(h264Track, &data{
+		trackID:   1,
+		h264NALUs: [][]byte{{0x67, 0x01, 0x02}, {0x65, 0x06}},
+	})
+	frame := s.get()
+	require.NotNil(t, frame)
+	require.Equal(t, []byte{
+		0x00, 0x00, 0x00, 0x01, 0x67, 0x01, 0x02,
+		0x00, 0x00, 0x00, 0x01, 0x68, 0x03, 0x04,
+		0x00, 0x00, 0x00, 0x01, 0x65, 0x06,
+	}, frame.annexB)
+}
+
+func TestSnapshotter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-snapshots")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newSnapshotter(context.Background(), "cat",
+		conf.StringDuration(10*time.Second), dir, nilLogger{})
+	defer s.close()
+
+	frame := &snapshotFrame{
+		time:   time.Now(),
+		annexB: []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x06},
+	}
+
+	byts, err := s.jpeg("mypath", frame)
+	require.NoError(t, err)
+	require.Equal(t, frame.annexB, byts)
+
+	// cached images are returned until the frame changes
+	s.decoder = "false"
+
+	byts, err = s.jpeg("mypath", frame)
+	require.NoError(t, err)
+	require.Equal(t, frame.annexB, byts)
+
+	_, err = s.jpeg("mypath", &snapshotFrame{time: frame.time.Add(time.Second)})
+	require.Error(t, err)
+
+	s.decoder = "cat"
+	s.onSave("nested/path", frame)
+
+	fpath := filepath.Join(dir, "nested", "path.jpg")
+	require.Eventually(t, func() bool {
+		byts, err := ioutil.ReadFile(fpath)
+		return err == nil && string(byts) == string(frame.annexB)
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSnapshotterConcurrentRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-snapshots")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	runs := filepath.Join(dir, "runs")
+
+	s := newSnapshotter(context.Background(), "sh -c 'echo >> "+runs+"; sleep 0.2; cat'",
+		conf.StringDuration(10*time.Second), dir, nilLogger{})
+	defer s.close()
+
+	frame := &snapshotFrame{
+		time:   time.Now(),
+		annexB: []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x06},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			byts, err := s.jpeg("mypath", frame)
+			require.NoError(t, err)
+			require.Equal(t, frame.annexB, byts)
+		}()
+	}
+	wg.Wait()
+
+	// the frame is decoded once
+	byts, err := ioutil.ReadFile(runs)
+	require.NoError(t, err)
+	require.Equal(t, "\n", string(byts))
+
+	// decoders that exceed the limit wait for a free slot
+	s.timeout = 100 * time.Millisecond
+	for i := 0; i < snapshotMaxDecoders; i++ {
+		s.decoders <- struct{}{}
+	}
+
+	_, err = s.jpeg("otherpath", frame)
+	require.EqualError(t, err, "decoder failed: context deadline exceeded")
+}
+
+func TestSnapshotterOutsidePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rtsp-snapshots")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := newSnapshotter(context.Background(), "cat",
+		conf.StringDuration(10*time.Second), filepath.Join(dir, "snapshots"), nilLogger{})
+	defer s.close()
+
+	err = s.doSave("../outside", &snapshotFrame{
+		time:   time.Now(),
+		annexB: []byte{0x00, 0x00, 0x00, 0x01, 0x65, 0x06},
+	})
+	require.Error(t, err)
+
+	_, err = os.Stat(filepath.Join(dir, "outside.jpg"))
+	require.Equal(t, true, os.IsNotExist(err))
+}
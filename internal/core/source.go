@@ -7,6 +7,7 @@ package core
 // - a redirect source
 type source interface {
 	onSourceAPIDescribe() interface{}
+	protocol() string
 }
 
 // sourceStatic is an entity that can provide a static stream.
@@ -24,3 +25,8 @@ func (*sourceRedirect) onSourceAPIDescribe() interface{} {
 		Type string `json:"type"`
 	}{"redirect"}
 }
+
+// protocol implements source.
+func (*sourceRedirect) protocol() string {
+	return "redirect"
+}
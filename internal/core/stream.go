@@ -3,41 +3,42 @@ package core
 import (
 	"bytes"
 	"sync"
+	"time"
 
 	"github.com/aler9/gortsplib"
 	"github.com/aler9/gortsplib/pkg/h264"
 )
 
-type streamNonRTSPReadersMap struct {
+type streamReadersMap struct {
 	mutex sync.RWMutex
 	ma    map[reader]struct{}
 }
 
-func newStreamNonRTSPReadersMap() *streamNonRTSPReadersMap {
-	return &streamNonRTSPReadersMap{
+func newStreamReadersMap() *streamReadersMap {
+	return &streamReadersMap{
 		ma: make(map[reader]struct{}),
 	}
 }
 
-func (m *streamNonRTSPReadersMap) close() {
+func (m *streamReadersMap) close() {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.ma = nil
 }
 
-func (m *streamNonRTSPReadersMap) add(r reader) {
+func (m *streamReadersMap) add(r reader) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	m.ma[r] = struct{}{}
 }
 
-func (m *streamNonRTSPReadersMap) remove(r reader) {
+func (m *streamReadersMap) remove(r reader) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 	delete(m.ma, r)
 }
 
-func (m *streamNonRTSPReadersMap) forwardPacketRTP(data *data) {
+func (m *streamReadersMap) forwardPacketRTP(data *data) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
@@ -47,20 +48,28 @@ func (m *streamNonRTSPReadersMap) forwardPacketRTP(data *data) {
 }
 
 type stream struct {
-	nonRTSPReaders *streamNonRTSPReadersMap
-	rtspStream     *gortsplib.ServerStream
+	readers    *streamReadersMap
+	rtspStream *gortsplib.ServerStream
+	stats      *trafficStats
+	latency    *latencyStats
+	health     *streamHealth
+	snapshot   *streamSnapshot
 }
 
-func newStream(tracks gortsplib.Tracks) *stream {
+func newStream(tracks gortsplib.Tracks, latency *latencyStats) *stream {
 	s := &stream{
-		nonRTSPReaders: newStreamNonRTSPReadersMap(),
-		rtspStream:     gortsplib.NewServerStream(tracks),
+		readers:    newStreamReadersMap(),
+		rtspStream: gortsplib.NewServerStream(tracks),
+		stats:      newTrafficStats(),
+		latency:    latency,
+		health:     newStreamHealth(tracks),
+		snapshot:   newStreamSnapshot(tracks),
 	}
 	return s
 }
 
 func (s *stream) close() {
-	s.nonRTSPReaders.close()
+	s.readers.close()
 	s.rtspStream.Close()
 }
 
@@ -69,15 +78,11 @@ func (s *stream) tracks() gortsplib.Tracks {
 }
 
 func (s *stream) readerAdd(r reader) {
-	if _, ok := r.(pathRTSPSession); !ok {
-		s.nonRTSPReaders.add(r)
-	}
+	s.readers.add(r)
 }
 
 func (s *stream) readerRemove(r reader) {
-	if _, ok := r.(pathRTSPSession); !ok {
-		s.nonRTSPReaders.remove(r)
-	}
+	s.readers.remove(r)
 }
 
 func (s *stream) updateH264TrackParameters(h264track *gortsplib.TrackH264, nalus [][]byte) {
@@ -131,11 +136,23 @@ func (s *stream) writeData(data *data) {
 	if h264track, ok := track.(*gortsplib.TrackH264); ok {
 		s.updateH264TrackParameters(h264track, data.h264NALUs)
 		s.remuxH264NALUs(h264track, data)
+		s.snapshot.onData(h264track, data)
 	}
 
+	// sources that don't receive data from the network
+	// are stamped when data is written.
+	if data.ingestTime.IsZero() {
+		data.ingestTime = time.Now()
+	}
+
+	s.stats.onDataReceived(data)
+	s.latency.onDataReceived(data)
+	s.health.onData(data.ingestTime, data)
+
 	// forward to RTSP readers
 	s.rtspStream.WritePacketRTP(data.trackID, data.rtp, data.ptsEqualsDTS)
 
-	// forward to non-RTSP readers
-	s.nonRTSPReaders.forwardPacketRTP(data)
+	// forward to readers. RTSP sessions receive packets through rtspStream,
+	// but are notified anyway in order to collect statistics.
+	s.readers.forwardPacketRTP(data)
 }
@@ -0,0 +1,219 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+	"github.com/aler9/rtsp-simple-server/internal/testpattern"
+)
+
+const (
+	testPatternSourceRetryPause = 5 * time.Second
+)
+
+// testPatternSourceTimestamp converts a count of units, with the given
+// number of units per second, into a timestamp, without overflowing.
+func testPatternSourceTimestamp(count int64, rate int64) time.Duration {
+	return time.Duration(count/rate)*time.Second +
+		time.Duration(count%rate)*time.Second/time.Duration(rate)
+}
+
+type testPatternSourceParent interface {
+	log(logger.Level, string, ...interface{})
+	onSourceStaticSetReady(req pathSourceStaticSetReadyReq) pathSourceStaticSetReadyRes
+	onSourceStaticSetNotReady(req pathSourceStaticSetNotReadyReq)
+	onSourceStaticError(err error)
+}
+
+// testPatternSource publishes a synthetic stream, made of color bars
+// and of a tone, that can be used to test the server and its clients.
+type testPatternSource struct {
+	resolution conf.Resolution
+	fps        int
+	bitrate    int
+	wg         *sync.WaitGroup
+	parent     testPatternSourceParent
+
+	ctx       context.Context
+	ctxCancel func()
+}
+
+func newTestPatternSource(
+	parentCtx context.Context,
+	resolution conf.Resolution,
+	fps int,
+	bitrate int,
+	wg *sync.WaitGroup,
+	parent testPatternSourceParent,
+) *testPatternSource {
+	ctx, ctxCancel := context.WithCancel(parentCtx)
+
+	s := &testPatternSource{
+		resolution: resolution,
+		fps:        fps,
+		bitrate:    bitrate,
+		wg:         wg,
+		parent:     parent,
+		ctx:        ctx,
+		ctxCancel:  ctxCancel,
+	}
+
+	s.Log(logger.Info, "started")
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s
+}
+
+func (s *testPatternSource) close() {
+	s.Log(logger.Info, "stopped")
+	s.ctxCancel()
+}
+
+func (s *testPatternSource) Log(level logger.Level, format string, args ...interface{}) {
+	s.parent.log(level, "[testpattern source] "+format, args...)
+}
+
+func (s *testPatternSource) run() {
+	defer s.wg.Done()
+
+outer:
+	for {
+		ok := s.runInner()
+		if !ok {
+			break outer
+		}
+
+		select {
+		case <-time.After(testPatternSourceRetryPause):
+		case <-s.ctx.Done():
+			break outer
+		}
+	}
+
+	s.ctxCancel()
+}
+
+func (s *testPatternSource) runInner() bool {
+	video, err := testpattern.NewVideo(s.resolution.Width, s.resolution.Height, s.fps, s.bitrate)
+	if err != nil {
+		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
+		return true
+	}
+
+	videoTrack, err := gortsplib.NewTrackH264(96, video.SPS(), video.PPS(), nil)
+	if err != nil {
+		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
+		return true
+	}
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, testpattern.AudioSampleRate, 1, nil, 13, 3, 3)
+	if err != nil {
+		s.Log(logger.Info, "ERR: %v", err)
+		s.parent.onSourceStaticError(err)
+		return true
+	}
+
+	w, tracks := newFileSourceWriter(videoTrack, audioTrack)
+
+	res := s.parent.onSourceStaticSetReady(pathSourceStaticSetReadyReq{
+		source: s,
+		tracks: tracks,
+	})
+	if res.err != nil {
+		s.Log(logger.Info, "ERR: %v", res.err)
+		return true
+	}
+
+	s.Log(logger.Info, "ready")
+
+	w.stream = res.stream
+
+	defer func() {
+		s.parent.onSourceStaticSetNotReady(pathSourceStaticSetNotReadyReq{source: s})
+	}()
+
+	s.play(video, w.write)
+	return false
+}
+
+// play writes video frames and audio frames at the pace of the wallclock,
+// until the source is closed.
+func (s *testPatternSource) play(
+	video *testpattern.Video,
+	writeUnit func(*fileSourceUnit, time.Duration),
+) {
+	audioFrame := testpattern.AudioFrame()
+	wallStart := time.Now()
+	videoCount := 0
+	audioCount := 0
+
+	// timestamps are computed from frame counts,
+	// in order to avoid accumulating rounding errors.
+	videoPTS := func() time.Duration {
+		return testPatternSourceTimestamp(int64(videoCount), int64(s.fps))
+	}
+	audioPTS := func() time.Duration {
+		return testPatternSourceTimestamp(int64(audioCount)*testpattern.AudioSamplesPerFrame,
+			testpattern.AudioSampleRate)
+	}
+
+	for {
+		if videoPTS() <= audioPTS() {
+			pts := videoPTS()
+			if !s.wait(time.Until(wallStart.Add(pts))) {
+				return
+			}
+
+			writeUnit(&fileSourceUnit{
+				h264NALUs: video.Frame(time.Now()),
+			}, pts)
+			videoCount++
+		} else {
+			pts := audioPTS()
+			if !s.wait(time.Until(wallStart.Add(pts))) {
+				return
+			}
+
+			writeUnit(&fileSourceUnit{
+				aacAUs: [][]byte{audioFrame},
+			}, pts)
+			audioCount++
+		}
+	}
+}
+
+func (s *testPatternSource) wait(d time.Duration) bool {
+	if d <= 0 {
+		return s.ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	select {
+	case <-timer.C:
+		return true
+	case <-s.ctx.Done():
+		timer.Stop()
+		return false
+	}
+}
+
+// onSourceAPIDescribe implements source.
+func (*testPatternSource) onSourceAPIDescribe() interface{} {
+	return struct {
+		Type string `json:"type"`
+	}{"testPatternSource"}
+}
+
+// protocol implements source.
+func (*testPatternSource) protocol() string {
+	return "testpattern"
+}
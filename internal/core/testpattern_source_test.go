@@ -0,0 +1,84 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/testpattern"
+)
+
+func TestTestPatternSourceTimestamp(t *testing.T) {
+	require.Equal(t, 40*time.Millisecond, testPatternSourceTimestamp(1, 25))
+	require.Equal(t, 21333333*time.Nanosecond, testPatternSourceTimestamp(1024, 48000))
+	require.Equal(t, 1000*time.Hour+21333333*time.Nanosecond,
+		testPatternSourceTimestamp(1000*3600*48000+1024, 48000))
+}
+
+func TestTestPatternSource(t *testing.T) {
+	p, ok := newInstance("hlsDisable: yes\n" +
+		"rtmpDisable: yes\n" +
+		"paths:\n" +
+		"  pattern:\n" +
+		"    source: testpattern\n" +
+		"    sourceOnDemand: yes\n" +
+		"    testPatternResolution: 320x240\n" +
+		"    testPatternFPS: 10\n")
+	require.Equal(t, true, ok)
+	defer p.close()
+
+	videoRecv := make(chan time.Time, 1)
+	audioRecv := make(chan []byte, 1)
+
+	c := gortsplib.Client{
+		OnPacketRTP: func(ctx *gortsplib.ClientOnPacketRTPCtx) {
+			switch ctx.TrackID {
+			case 0:
+				for _, nalu := range ctx.H264NALUs {
+					if h264.NALUType(nalu[0]&0x1F) == h264.NALUTypeSEI {
+						if wc, ok := testpattern.Wallclock(nalu); ok {
+							select {
+							case videoRecv <- wc:
+							default:
+							}
+						}
+					}
+				}
+
+			case 1:
+				select {
+				case audioRecv <- ctx.Packet.Payload:
+				default:
+				}
+			}
+		},
+	}
+
+	err := c.StartReading("rtsp://localhost:8554/pattern")
+	require.NoError(t, err)
+	defer c.Close()
+
+	tracks := c.Tracks()
+	require.Equal(t, 2, len(tracks))
+
+	videoTrack, ok := tracks[0].(*gortsplib.TrackH264)
+	require.Equal(t, true, ok)
+
+	var sps h264.SPS
+	err = sps.Unmarshal(videoTrack.SPS())
+	require.NoError(t, err)
+	require.Equal(t, 320, sps.Width())
+	require.Equal(t, 240, sps.Height())
+
+	audioTrack, ok := tracks[1].(*gortsplib.TrackAAC)
+	require.Equal(t, true, ok)
+	require.Equal(t, testpattern.AudioSampleRate, audioTrack.ClockRate())
+
+	wc := <-videoRecv
+	require.WithinDuration(t, time.Now(), wc, 2*time.Second)
+
+	<-audioRecv
+}
@@ -0,0 +1,151 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib/pkg/h264"
+)
+
+// rates are computed over windows of this duration.
+const trafficStatsRateWindow = 1 * time.Second
+
+// trafficStatsData is the API representation of trafficStats.
+type trafficStatsData struct {
+	BytesReceived    uint64  `json:"bytesReceived"`
+	BytesSent        uint64  `json:"bytesSent"`
+	PacketsReceived  uint64  `json:"packetsReceived"`
+	PacketsSent      uint64  `json:"packetsSent"`
+	PacketsLost      uint64  `json:"packetsLost"`
+	Bitrate          float64 `json:"bitrate"`
+	FrameRate        float64 `json:"frameRate"`
+	KeyFrameInterval float64 `json:"keyFrameInterval"`
+}
+
+// trafficStats collects traffic statistics of a path, a publisher or a reader.
+// Sizes are the ones of RTP packets, therefore they don't include the overhead
+// of the protocol used to transfer them.
+// Lost packets are detected with RTP sequence numbers.
+type trafficStats struct {
+	mutex sync.Mutex
+
+	bytesReceived    uint64
+	bytesSent        uint64
+	packetsReceived  uint64
+	packetsSent      uint64
+	packetsLost      uint64
+	lastSequenceNums map[int]uint16
+	lastKeyFrame     time.Time
+	keyFrameInterval time.Duration
+
+	windowStart  time.Time
+	windowBytes  uint64
+	windowFrames uint64
+	bitrate      float64
+	frameRate    float64
+}
+
+func newTrafficStats() *trafficStats {
+	return &trafficStats{
+		lastSequenceNums: make(map[int]uint16),
+	}
+}
+
+func (s *trafficStats) onData(now time.Time, data *data) uint64 {
+	size := uint64(data.rtp.MarshalSize())
+
+	last, ok := s.lastSequenceNums[data.trackID]
+	if !ok {
+		s.lastSequenceNums[data.trackID] = data.rtp.SequenceNumber
+	} else {
+		// duplicated and reordered packets are ignored
+		diff := data.rtp.SequenceNumber - last
+		if diff > 0 && diff < 0x8000 {
+			s.packetsLost += uint64(diff - 1)
+			s.lastSequenceNums[data.trackID] = data.rtp.SequenceNumber
+		}
+	}
+
+	if s.windowStart.IsZero() {
+		s.windowStart = now
+	}
+
+	s.windowBytes += size
+
+	if data.h264NALUs != nil {
+		s.windowFrames++
+
+		if h264.IDRPresent(data.h264NALUs) {
+			if !s.lastKeyFrame.IsZero() {
+				s.keyFrameInterval = now.Sub(s.lastKeyFrame)
+			}
+			s.lastKeyFrame = now
+		}
+	}
+
+	if elapsed := now.Sub(s.windowStart); elapsed >= trafficStatsRateWindow {
+		s.bitrate = float64(s.windowBytes*8) / elapsed.Seconds()
+		s.frameRate = float64(s.windowFrames) / elapsed.Seconds()
+		s.windowStart = now
+		s.windowBytes = 0
+		s.windowFrames = 0
+	}
+
+	return size
+}
+
+// onDataReceived is called when a unit of data is received.
+func (s *trafficStats) onDataReceived(data *data) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bytesReceived += s.onData(time.Now(), data)
+	s.packetsReceived++
+}
+
+// onDataSent is called when a unit of data is sent.
+func (s *trafficStats) onDataSent(data *data) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.bytesSent += s.onData(time.Now(), data)
+	s.packetsSent++
+}
+
+// get returns a snapshot of the statistics.
+func (s *trafficStats) get() trafficStatsData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	bitrate := s.bitrate
+	frameRate := s.frameRate
+
+	// when data stops flowing, rates are computed with the current window
+	if !s.windowStart.IsZero() {
+		if elapsed := time.Since(s.windowStart); elapsed >= 2*trafficStatsRateWindow {
+			bitrate = float64(s.windowBytes*8) / elapsed.Seconds()
+			frameRate = float64(s.windowFrames) / elapsed.Seconds()
+		}
+	}
+
+	return trafficStatsData{
+		BytesReceived:    s.bytesReceived,
+		BytesSent:        s.bytesSent,
+		PacketsReceived:  s.packetsReceived,
+		PacketsSent:      s.packetsSent,
+		PacketsLost:      s.packetsLost,
+		Bitrate:          bitrate,
+		FrameRate:        frameRate,
+		KeyFrameInterval: s.keyFrameInterval.Seconds(),
+	}
+}
+
+// trafficStatsAdd returns the sum of the counters of two statistics.
+func trafficStatsAdd(a trafficStatsData, b trafficStatsData) trafficStatsData {
+	a.BytesReceived += b.BytesReceived
+	a.BytesSent += b.BytesSent
+	a.PacketsReceived += b.PacketsReceived
+	a.PacketsSent += b.PacketsSent
+	a.PacketsLost += b.PacketsLost
+	return a
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficStats(t *testing.T) {
+	s := newTrafficStats()
+
+	newData := func(trackID int, seq uint16, nalus [][]byte) *data {
+		return &data{
+			trackID: trackID,
+			rtp: &rtp.Packet{
+				Header: rtp.Header{
+					Version:        2,
+					SequenceNumber: seq,
+				},
+				Payload: []byte{0x01, 0x02, 0x03, 0x04},
+			},
+			h264NALUs: nalus,
+		}
+	}
+
+	idr := [][]byte{{0x05}}
+	nonIDR := [][]byte{{0x01}}
+
+	now := time.Now()
+	s.onData(now, newData(0, 65534, idr))
+	s.onData(now.Add(100*time.Millisecond), newData(0, 65535, nonIDR))
+	s.onData(now.Add(200*time.Millisecond), newData(1, 10, nil))
+	// 2 packets lost, with wrap around
+	s.onData(now.Add(300*time.Millisecond), newData(0, 2, nonIDR))
+	// reordered packet
+	s.onData(now.Add(400*time.Millisecond), newData(0, 1, nil))
+	s.onData(now.Add(500*time.Millisecond), newData(1, 12, nil))
+	s.onData(now.Add(1000*time.Millisecond), newData(0, 3, idr))
+
+	data := s.get()
+	require.Equal(t, uint64(3), data.PacketsLost)
+	require.Equal(t, 1.0, data.KeyFrameInterval)
+	require.Equal(t, float64(7*16*8), data.Bitrate)
+	require.Equal(t, 4.0, data.FrameRate)
+
+	s.onDataReceived(newData(1, 13, nil))
+	s.onDataSent(newData(1, 14, nil))
+
+	data = s.get()
+	require.Equal(t, uint64(16), data.BytesReceived)
+	require.Equal(t, uint64(1), data.PacketsReceived)
+	require.Equal(t, uint64(16), data.BytesSent)
+	require.Equal(t, uint64(1), data.PacketsSent)
+}
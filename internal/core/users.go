@@ -0,0 +1,77 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+// name of the user that matches everybody.
+const usersAnyUser = "any"
+
+// checkCredential compares a credential, that can be in plain text,
+// hashed with sha256 or hashed with bcrypt, with a value.
+func checkCredential(expected conf.Credential, value string) bool {
+	if expected.IsBcrypt() {
+		return bcrypt.CompareHashAndPassword([]byte(expected), []byte(value)) == nil
+	}
+
+	if strings.HasPrefix(string(expected), "sha256:") {
+		h := sha256.Sum256([]byte(value))
+		value = "sha256:" + base64.StdEncoding.EncodeToString(h[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(value)) == 1
+}
+
+func usersCheckPermissions(u *conf.User, ip net.IP, pathName string, action string) error {
+	if u.IPs != nil && !ipEqualOrInRange(ip, u.IPs) {
+		return fmt.Errorf("IP '%s' not allowed", ip)
+	}
+
+	perms := make([]authPermission, len(u.Permissions))
+	for i, p := range u.Permissions {
+		perms[i] = authPermission{Action: p.Action, Path: p.Path}
+	}
+
+	if !authPermissionsAllow(perms, pathName, action) {
+		return fmt.Errorf("not allowed to %s path '%s'", action, pathName)
+	}
+
+	return nil
+}
+
+// usersAuthenticate checks whether the users section allows an action on a path.
+// It returns whether the request has been handled by the users section;
+// when it hasn't, the request must be checked against the path credentials.
+func usersAuthenticate(
+	users map[string]*conf.User,
+	ip net.IP,
+	user string,
+	pass string,
+	pathName string,
+	action string,
+) (bool, error) {
+	if u, ok := users[user]; ok && user != usersAnyUser {
+		if !checkCredential(u.Pass, pass) {
+			return true, fmt.Errorf("invalid credentials")
+		}
+
+		return true, usersCheckPermissions(u, ip, pathName, action)
+	}
+
+	if u, ok := users[usersAnyUser]; ok {
+		if usersCheckPermissions(u, ip, pathName, action) == nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
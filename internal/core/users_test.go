@@ -0,0 +1,82 @@
+package core
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/aler9/rtsp-simple-server/internal/conf"
+)
+
+func TestCheckCredential(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("mypass"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	for _, ca := range []struct {
+		name     string
+		expected conf.Credential
+	}{
+		{"plain", "mypass"},
+		{"sha256", "sha256:6nHCWnpgIka0w5gkuFVniJSpb0O7m3ExnDlwCh4EUiI="},
+		{"bcrypt", conf.Credential(hash)},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			require.True(t, checkCredential(ca.expected, "mypass"))
+			require.False(t, checkCredential(ca.expected, "wrong"))
+		})
+	}
+}
+
+func TestUsersAuthenticate(t *testing.T) {
+	users := map[string]*conf.User{
+		"myuser": {
+			Pass: "mypass",
+			Permissions: conf.UserPermissions{
+				{Action: "publish", Path: "cam*"},
+				{Action: "read"},
+			},
+		},
+		"restricted": {
+			Pass: "mypass",
+			IPs:  conf.IPsOrNets{net.ParseIP("192.168.1.1")},
+			Permissions: conf.UserPermissions{
+				{Action: "read"},
+			},
+		},
+		"any": {
+			Permissions: conf.UserPermissions{
+				{Action: "read", Path: "public"},
+			},
+		},
+	}
+
+	ip := net.ParseIP("127.0.0.1")
+
+	handled, err := usersAuthenticate(users, ip, "myuser", "mypass", "cam1", "publish")
+	require.True(t, handled)
+	require.NoError(t, err)
+
+	handled, err = usersAuthenticate(users, ip, "myuser", "mypass", "other", "publish")
+	require.True(t, handled)
+	require.EqualError(t, err, "not allowed to publish path 'other'")
+
+	handled, err = usersAuthenticate(users, ip, "myuser", "wrong", "cam1", "publish")
+	require.True(t, handled)
+	require.EqualError(t, err, "invalid credentials")
+
+	handled, err = usersAuthenticate(users, ip, "restricted", "mypass", "cam1", "read")
+	require.True(t, handled)
+	require.EqualError(t, err, "IP '127.0.0.1' not allowed")
+
+	// anonymous access
+	handled, err = usersAuthenticate(users, ip, "", "", "public", "read")
+	require.True(t, handled)
+	require.NoError(t, err)
+
+	// fallback to path credentials
+	handled, err = usersAuthenticate(users, ip, "", "", "cam1", "read")
+	require.False(t, handled)
+	require.NoError(t, err)
+}
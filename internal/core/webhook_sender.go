@@ -0,0 +1,344 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+const (
+	webhookSenderLogSize       = 100
+	webhookSenderQueueSize     = 64
+	webhookSenderRetryPause    = time.Second
+	webhookSenderMaxRetryPause = 30 * time.Second
+)
+
+type webhookDeliveryState string
+
+const (
+	webhookDeliveryStatePending   webhookDeliveryState = "pending"
+	webhookDeliveryStateDelivered webhookDeliveryState = "delivered"
+	webhookDeliveryStateFailed    webhookDeliveryState = "failed"
+)
+
+type webhookSession struct {
+	Type       string `json:"type"`
+	ID         string `json:"id,omitempty"`
+	Protocol   string `json:"protocol"`
+	RemoteAddr string `json:"remoteAddr"`
+}
+
+// webhookPayload is the body of a webhook request.
+// Action is "start" when the corresponding runOn* command would be started,
+// and "stop" when it would be stopped.
+type webhookPayload struct {
+	Event   string                  `json:"event"`
+	Action  string                  `json:"action"`
+	Time    time.Time               `json:"time"`
+	Env     externalcmd.Environment `json:"env"`
+	Session *webhookSession         `json:"session,omitempty"`
+}
+
+type webhookDelivery struct {
+	ID         int                  `json:"id"`
+	Created    time.Time            `json:"created"`
+	URL        string               `json:"url"`
+	Event      string               `json:"event"`
+	Action     string               `json:"action"`
+	State      webhookDeliveryState `json:"state"`
+	Attempts   int                  `json:"attempts"`
+	StatusCode int                  `json:"statusCode,omitempty"`
+	Error      string               `json:"error,omitempty"`
+
+	body []byte
+}
+
+type webhookDeliveriesListData struct {
+	Items []webhookDelivery `json:"items"`
+}
+
+type webhookSenderStats struct {
+	delivered int64
+	failed    int64
+	dropped   int64
+}
+
+type webhookSenderParent interface {
+	Log(logger.Level, string, ...interface{})
+}
+
+// webhookSender delivers webhooks.
+// Deliveries directed to the same URL are performed in order, one at a time.
+// When too many deliveries are waiting for the same URL, the oldest ones are dropped.
+type webhookSender struct {
+	timeout  time.Duration
+	attempts int
+	secret   string
+	metrics  *metrics
+	parent   webhookSenderParent
+
+	ctx        context.Context
+	ctxCancel  func()
+	wg         sync.WaitGroup
+	httpClient *http.Client
+
+	mutex      sync.Mutex
+	nextID     int
+	queues     map[string][]*webhookDelivery
+	deliveries []*webhookDelivery
+	stats      webhookSenderStats
+}
+
+func newWebhookSender(
+	timeout time.Duration,
+	attempts int,
+	secret string,
+	metrics *metrics,
+	parent webhookSenderParent,
+) *webhookSender {
+	// deliveries are not bound to the context of core, in order to deliver
+	// the webhooks that are sent while the server is shutting down.
+	ctx, ctxCancel := context.WithCancel(context.Background())
+
+	s := &webhookSender{
+		timeout:    timeout,
+		attempts:   attempts,
+		secret:     secret,
+		metrics:    metrics,
+		parent:     parent,
+		ctx:        ctx,
+		ctxCancel:  ctxCancel,
+		httpClient: &http.Client{Timeout: timeout},
+		queues:     make(map[string][]*webhookDelivery),
+	}
+
+	if s.metrics != nil {
+		s.metrics.onWebhookSenderSet(s)
+	}
+
+	return s
+}
+
+// close waits for pending deliveries, up to timeout, then aborts them.
+func (s *webhookSender) close() {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(s.timeout):
+		s.ctxCancel()
+		<-done
+	}
+
+	s.ctxCancel()
+
+	s.mutex.Lock()
+	m := s.metrics
+	s.mutex.Unlock()
+
+	if m != nil {
+		m.onWebhookSenderSet(nil)
+	}
+}
+
+// onMetricsSet is called by core when metrics are created or closed.
+func (s *webhookSender) onMetricsSet(m *metrics) {
+	s.mutex.Lock()
+	changed := m != s.metrics
+	s.metrics = m
+	s.mutex.Unlock()
+
+	if changed && m != nil {
+		m.onWebhookSenderSet(s)
+	}
+}
+
+func (s *webhookSender) log(level logger.Level, format string, args ...interface{}) {
+	s.parent.Log(level, "[webhook] "+format, args...)
+}
+
+// send enqueues a webhook. It doesn't wait for the webhook to be delivered.
+func (s *webhookSender) send(url string, payload webhookPayload) {
+	payload.Time = time.Now()
+	if payload.Env == nil {
+		payload.Env = externalcmd.Environment{}
+	}
+
+	body, _ := json.Marshal(payload)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nextID++
+	d := &webhookDelivery{
+		ID:      s.nextID,
+		Created: payload.Time,
+		URL:     url,
+		Event:   payload.Event,
+		Action:  payload.Action,
+		State:   webhookDeliveryStatePending,
+		body:    body,
+	}
+
+	s.deliveries = append(s.deliveries, d)
+	if len(s.deliveries) > webhookSenderLogSize {
+		s.deliveries = s.deliveries[1:]
+	}
+
+	queue, ok := s.queues[url]
+
+	// the receiver is not keeping up, drop the oldest delivery
+	if len(queue) >= webhookSenderQueueSize {
+		dropped := queue[0]
+		queue = queue[1:]
+		dropped.State = webhookDeliveryStateFailed
+		dropped.Error = "queue is full"
+		s.stats.failed++
+		s.stats.dropped++
+		s.log(logger.Warn, "unable to deliver '%s' to %s: queue is full", dropped.Event, dropped.URL)
+	}
+
+	s.queues[url] = append(queue, d)
+
+	if !ok {
+		s.wg.Add(1)
+		go s.runQueue(url)
+	}
+}
+
+func (s *webhookSender) runQueue(url string) {
+	defer s.wg.Done()
+
+	for {
+		s.mutex.Lock()
+		queue := s.queues[url]
+		if len(queue) == 0 {
+			delete(s.queues, url)
+			s.mutex.Unlock()
+			return
+		}
+		d := queue[0]
+		s.queues[url] = queue[1:]
+		s.mutex.Unlock()
+
+		s.deliver(d)
+	}
+}
+
+func (s *webhookSender) deliver(d *webhookDelivery) {
+	for attempt := 1; ; attempt++ {
+		statusCode, err := s.doRequest(d)
+
+		s.mutex.Lock()
+		d.Attempts = attempt
+		d.StatusCode = statusCode
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Error = ""
+			d.State = webhookDeliveryStateDelivered
+			s.stats.delivered++
+		}
+		s.mutex.Unlock()
+
+		if err == nil {
+			return
+		}
+
+		if attempt >= s.attempts {
+			s.mutex.Lock()
+			d.State = webhookDeliveryStateFailed
+			s.stats.failed++
+			s.mutex.Unlock()
+			s.log(logger.Warn, "unable to deliver '%s' to %s: %s", d.Event, d.URL, err)
+			return
+		}
+
+		// wait 1s, 2s, 4s, ... between attempts
+		pause := webhookSenderRetryPause << (attempt - 1)
+		if pause > webhookSenderMaxRetryPause {
+			pause = webhookSenderMaxRetryPause
+		}
+
+		select {
+		case <-time.After(pause):
+		case <-s.ctx.Done():
+			s.mutex.Lock()
+			d.State = webhookDeliveryStateFailed
+			s.stats.failed++
+			s.mutex.Unlock()
+			return
+		}
+	}
+}
+
+func (s *webhookSender) doRequest(d *webhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, d.URL, bytes.NewReader(d.body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatInt(int64(d.ID), 10))
+
+	if s.secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+webhookSignature(s.secret, d.body))
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("bad status code: %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// webhookSignature returns the hex-encoded HMAC-SHA256 of a body.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webhookSender) deliveriesList() webhookDeliveriesListData {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data := webhookDeliveriesListData{
+		Items: make([]webhookDelivery, len(s.deliveries)),
+	}
+
+	for i, d := range s.deliveries {
+		data.Items[i] = *d
+	}
+
+	return data
+}
+
+// statsGet returns a snapshot of the statistics.
+func (s *webhookSender) statsGet() webhookSenderStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stats
+}
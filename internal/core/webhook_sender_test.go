@@ -0,0 +1,116 @@
+package core
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/aler9/rtsp-simple-server/internal/externalcmd"
+	"github.com/aler9/rtsp-simple-server/internal/logger"
+)
+
+type nilLogger struct{}
+
+func (nilLogger) Log(logger.Level, string, ...interface{}) {}
+
+func TestWebhookSender(t *testing.T) {
+	received := make(chan webhookPayload, 10)
+	attempts := 0
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+
+		body, _ := ioutil.ReadAll(r.Body)
+		require.Equal(t, "sha256="+webhookSignature("mysecret", body), r.Header.Get("X-Webhook-Signature"))
+		require.Equal(t, "ready", r.Header.Get("X-Webhook-Event"))
+
+		// fail the first attempt in order to test retries
+		if attempts == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		var payload webhookPayload
+		err := json.Unmarshal(body, &payload)
+		require.NoError(t, err)
+		received <- payload
+	}))
+	defer ts.Close()
+
+	s := newWebhookSender(5*time.Second, 2, "mysecret", nil, nilLogger{})
+	defer s.close()
+
+	s.send(ts.URL, webhookPayload{
+		Event:  "ready",
+		Action: "start",
+		Env: externalcmd.Environment{
+			"RTSP_PATH": "mypath",
+			"RTSP_PORT": "8554",
+		},
+	})
+
+	select {
+	case payload := <-received:
+		require.Equal(t, "ready", payload.Event)
+		require.Equal(t, "start", payload.Action)
+		require.Equal(t, externalcmd.Environment{
+			"RTSP_PATH": "mypath",
+			"RTSP_PORT": "8554",
+		}, payload.Env)
+	case <-time.After(5 * time.Second):
+		t.Errorf("webhook not received")
+	}
+
+	// wait for the delivery to be marked as delivered
+	time.Sleep(100 * time.Millisecond)
+
+	items := s.deliveriesList().Items
+	require.Equal(t, 1, len(items))
+	require.Equal(t, webhookDeliveryStateDelivered, items[0].State)
+	require.Equal(t, 2, items[0].Attempts)
+	require.Equal(t, http.StatusOK, items[0].StatusCode)
+}
+
+func TestWebhookSenderQueueFull(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+	}))
+	defer ts.Close()
+
+	s := newWebhookSender(5*time.Second, 1, "", nil, nilLogger{})
+	defer s.close()
+
+	// the first delivery is in progress
+	s.send(ts.URL, webhookPayload{Event: "ready", Action: "start"})
+	<-started
+
+	for i := 0; i < webhookSenderQueueSize+1; i++ {
+		s.send(ts.URL, webhookPayload{Event: "ready", Action: "start"})
+	}
+
+	items := s.deliveriesList().Items
+	require.Equal(t, webhookDeliveryStatePending, items[0].State)
+	require.Equal(t, webhookDeliveryStateFailed, items[1].State)
+	require.Equal(t, "queue is full", items[1].Error)
+	require.Equal(t, webhookDeliveryStatePending, items[2].State)
+
+	s.mutex.Lock()
+	require.Equal(t, webhookSenderQueueSize, len(s.queues[ts.URL]))
+	s.mutex.Unlock()
+
+	require.Equal(t, webhookSenderStats{failed: 1, dropped: 1}, s.statsGet())
+
+	close(release)
+}
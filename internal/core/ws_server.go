@@ -10,6 +10,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type WsServer struct {
@@ -21,6 +22,8 @@ type WsServer struct {
 	conf   conf.Conf
 	logger CPCLogger
 	ws     *WsClient
+	//延迟统计
+	latencyTracer *latencyTracer
 }
 
 type WsStatusListener interface {
@@ -73,15 +76,22 @@ func (s *WsServer) run() {
 				s.logger.Log(logger.Warn, err.Error())
 			}
 		}(conn)
+		firstMessage := true
 		for {
 			_, message, err := conn.ReadMessage()
 			if err != nil {
 				s.logger.Log(logger.Warn, err.Error())
 				break
 			}
+			if firstMessage {
+				// the latency of the pipeline is measured starting from the first message
+				s.latencyTracer.onCameraStart(uuid, time.Now())
+				firstMessage = false
+			}
 			s.cameraListener.OnMessage(uuid, message)
 		}
 		s.logger.Log(logger.Info, "ws 6")
+		s.latencyTracer.onCameraStop(uuid)
 		s.cameraListener.OnDisconnect(uuid)
 		// 通知cpc前端断开
 		s.notifyStreamClose(uuid)
@@ -142,13 +152,15 @@ func (s *WsServer) send(uuid string, data []byte) {
 	}
 }
 
-func RunCameraWebSocketServer(config conf.Conf, listener WsStatusListener, logger CPCLogger) *WsServer {
+func RunCameraWebSocketServer(config conf.Conf, listener WsStatusListener, logger CPCLogger,
+	latencyTracer *latencyTracer) *WsServer {
 	server := &WsServer{
 		port:           config.CameraWebSocketPort,
 		conf:           config,
 		cameraListener: listener,
 		client:         map[string]*websocket.Conn{},
 		logger:         logger,
+		latencyTracer:  latencyTracer,
 	}
 	go server.run()
 	return server
@@ -0,0 +1,258 @@
+package dash
+
+import (
+	"encoding/binary"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/aac"
+	"github.com/aler9/gortsplib/pkg/h264"
+)
+
+const (
+	videoTimeScale = 90000
+
+	sampleFlagsSync    = 0x02000000 // sample_depends_on = 2
+	sampleFlagsNonSync = 0x01010000 // sample_depends_on = 1, sample_is_non_sync_sample = 1
+)
+
+// sample is a sample of a fragmented MP4 track.
+type sample struct {
+	dts       int64 // in track time scale
+	ptsOffset int32 // in track time scale
+	duration  uint32
+	sync      bool
+	payload   []byte
+}
+
+func uint16Bytes(v uint16) []byte {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, v)
+	return buf
+}
+
+func uint32Bytes(vals ...uint32) []byte {
+	buf := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+func uint64Bytes(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, v)
+	return buf
+}
+
+// box encodes a ISO BMFF box.
+func box(typ string, payloads ...[]byte) []byte {
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+
+	buf := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(buf, uint32(size))
+	copy(buf[4:], typ)
+
+	for _, p := range payloads {
+		buf = append(buf, p...)
+	}
+
+	return buf
+}
+
+// fullBox encodes a ISO BMFF box with version and flags.
+func fullBox(typ string, version uint8, flags uint32, payloads ...[]byte) []byte {
+	header := uint32Bytes(uint32(version)<<24 | flags)
+	return box(typ, append([][]byte{header}, payloads...)...)
+}
+
+var unityMatrix = uint32Bytes(0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000)
+
+func trackBox(trackID uint32, timeScale uint32, isVideo bool, width int, height int, sampleEntry []byte) []byte {
+	volume := uint16(0x0100)
+	handlerType := "soun"
+	handlerName := "SoundHandler"
+	mediaHeader := fullBox("smhd", 0, 0, uint32Bytes(0))
+	if isVideo {
+		volume = 0
+		handlerType = "vide"
+		handlerName = "VideoHandler"
+		mediaHeader = fullBox("vmhd", 0, 1, uint16Bytes(0), make([]byte, 6))
+	}
+
+	return box("trak",
+		fullBox("tkhd", 0, 3,
+			uint32Bytes(0, 0, trackID, 0, 0, 0, 0),
+			uint16Bytes(0), // layer
+			uint16Bytes(0), // alternate group
+			uint16Bytes(volume),
+			uint16Bytes(0),
+			unityMatrix,
+			uint32Bytes(uint32(width)<<16, uint32(height)<<16)),
+		box("mdia",
+			fullBox("mdhd", 0, 0,
+				uint32Bytes(0, 0, timeScale, 0),
+				uint16Bytes(0x55c4), // language: und
+				uint16Bytes(0)),
+			fullBox("hdlr", 0, 0,
+				uint32Bytes(0),
+				[]byte(handlerType),
+				uint32Bytes(0, 0, 0),
+				append([]byte(handlerName), 0)),
+			box("minf",
+				mediaHeader,
+				box("dinf",
+					fullBox("dref", 0, 0,
+						uint32Bytes(1),
+						fullBox("url ", 0, 1))),
+				box("stbl",
+					fullBox("stsd", 0, 0, uint32Bytes(1), sampleEntry),
+					fullBox("stts", 0, 0, uint32Bytes(0)),
+					fullBox("stsc", 0, 0, uint32Bytes(0)),
+					fullBox("stsz", 0, 0, uint32Bytes(0, 0)),
+					fullBox("stco", 0, 0, uint32Bytes(0))))))
+}
+
+// initSegment generates the initialization segment of a track.
+func initSegment(trackID uint32, timeScale uint32, isVideo bool, width int, height int, sampleEntry []byte) []byte {
+	ftyp := box("ftyp",
+		[]byte("iso6"),
+		uint32Bytes(1),
+		[]byte("iso6"), []byte("mp41"), []byte("dash"))
+
+	moov := box("moov",
+		fullBox("mvhd", 0, 0,
+			uint32Bytes(0, 0, 1000, 0),
+			uint32Bytes(0x00010000), // rate
+			uint16Bytes(0x0100),     // volume
+			make([]byte, 10),
+			unityMatrix,
+			make([]byte, 24),
+			uint32Bytes(trackID+1)), // next track ID
+		trackBox(trackID, timeScale, isVideo, width, height, sampleEntry),
+		box("mvex",
+			fullBox("trex", 0, 0, uint32Bytes(trackID, 1, 0, 0, 0))))
+
+	return append(ftyp, moov...)
+}
+
+// videoInitSegment generates the initialization segment of a H264 track.
+func videoInitSegment(trackID uint32, track *gortsplib.TrackH264) []byte {
+	sps := track.SPS()
+	pps := track.PPS()
+
+	var width, height int
+	var spsp h264.SPS
+	if err := spsp.Unmarshal(sps); err == nil {
+		width = spsp.Width()
+		height = spsp.Height()
+	}
+
+	avcC := []byte{1, sps[1], sps[2], sps[3], 0xff, 0xe1}
+	avcC = append(avcC, uint16Bytes(uint16(len(sps)))...)
+	avcC = append(avcC, sps...)
+	avcC = append(avcC, 1)
+	avcC = append(avcC, uint16Bytes(uint16(len(pps)))...)
+	avcC = append(avcC, pps...)
+
+	compressorName := make([]byte, 32)
+
+	avc1 := box("avc1",
+		make([]byte, 6),
+		uint16Bytes(1), // data reference index
+		make([]byte, 16),
+		uint16Bytes(uint16(width)),
+		uint16Bytes(uint16(height)),
+		uint32Bytes(0x00480000, 0x00480000, 0), // resolution
+		uint16Bytes(1),                         // frame count
+		compressorName,
+		uint16Bytes(0x0018), // depth
+		uint16Bytes(0xffff),
+		box("avcC", avcC))
+
+	return initSegment(trackID, videoTimeScale, true, width, height, avc1)
+}
+
+// esDescriptor encodes a MPEG-4 descriptor.
+func esDescriptor(tag byte, payloads ...[]byte) []byte {
+	size := 0
+	for _, p := range payloads {
+		size += len(p)
+	}
+
+	buf := []byte{tag, byte(size)}
+	for _, p := range payloads {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// audioInitSegment generates the initialization segment of an AAC track.
+func audioInitSegment(trackID uint32, track *gortsplib.TrackAAC) ([]byte, error) {
+	conf, err := aac.MPEG4AudioConfig{
+		Type:              aac.MPEG4AudioType(track.Type()),
+		SampleRate:        track.ClockRate(),
+		ChannelCount:      track.ChannelCount(),
+		AOTSpecificConfig: track.AOTSpecificConfig(),
+	}.Encode()
+	if err != nil {
+		return nil, err
+	}
+
+	esds := fullBox("esds", 0, 0,
+		esDescriptor(0x03,
+			uint16Bytes(uint16(trackID)), // ES ID
+			[]byte{0},                    // flags
+			esDescriptor(0x04,
+				[]byte{0x40, 0x15}, // object type: audio ISO/IEC 14496-3, stream type: audio
+				[]byte{0, 0, 0},    // buffer size
+				uint32Bytes(0, 0),  // max and average bitrate
+				esDescriptor(0x05, conf)),
+			esDescriptor(0x06, []byte{2})))
+
+	mp4a := box("mp4a",
+		make([]byte, 6),
+		uint16Bytes(1), // data reference index
+		make([]byte, 8),
+		uint16Bytes(uint16(track.ChannelCount())),
+		uint16Bytes(16), // sample size
+		make([]byte, 4),
+		uint32Bytes(uint32(track.ClockRate())<<16),
+		esds)
+
+	return initSegment(trackID, uint32(track.ClockRate()), false, 0, 0, mp4a), nil
+}
+
+// mediaSegment generates a media segment that contains the given samples.
+func mediaSegment(sequenceNumber uint32, trackID uint32, samples []*sample) []byte {
+	entries := make([]byte, 0, len(samples)*16)
+	var mdatPayload []byte
+
+	for _, s := range samples {
+		flags := uint32(sampleFlagsNonSync)
+		if s.sync {
+			flags = sampleFlagsSync
+		}
+
+		entries = append(entries, uint32Bytes(s.duration, uint32(len(s.payload)), flags, uint32(s.ptsOffset))...)
+		mdatPayload = append(mdatPayload, s.payload...)
+	}
+
+	moof := func(dataOffset uint32) []byte {
+		return box("moof",
+			fullBox("mfhd", 0, 0, uint32Bytes(sequenceNumber)),
+			box("traf",
+				fullBox("tfhd", 0, 0x020000, uint32Bytes(trackID)), // default-base-is-moof
+				fullBox("tfdt", 1, 0, uint64Bytes(uint64(samples[0].dts))),
+				fullBox("trun", 1, 0x000f01, // data offset, duration, size, flags, composition offset
+					uint32Bytes(uint32(len(samples)), dataOffset),
+					entries)))
+	}
+
+	// data offset is relative to the start of moof
+	dataOffset := uint32(len(moof(0))) + 8
+
+	return append(moof(dataOffset), box("mdat", mdatPayload)...)
+}
@@ -0,0 +1,471 @@
+package dash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/aac"
+	"github.com/aler9/gortsplib/pkg/h264"
+)
+
+const (
+	videoTrackID = 1
+	audioTrackID = 2
+)
+
+type asyncReader struct {
+	generator func() []byte
+	reader    *bytes.Reader
+}
+
+func (r *asyncReader) Read(buf []byte) (int, error) {
+	if r.reader == nil {
+		r.reader = bytes.NewReader(r.generator())
+	}
+	return r.reader.Read(buf)
+}
+
+// muxerSegment is a media segment of a track.
+type muxerSegment struct {
+	number   uint64
+	start    int64 // in track time scale
+	duration int64 // in track time scale
+	content  []byte
+}
+
+// muxerTrack is a track that is split into segments.
+type muxerTrack struct {
+	name      string
+	id        uint32
+	timeScale int64
+
+	segments       []*muxerSegment
+	nextNumber     uint64
+	samples        []*sample
+	startDTS       int64
+	startPTS       int64
+	lastDTS        int64
+	bytesPerSecond int64
+}
+
+// segmentDuration returns the duration of the current segment,
+// computed with the PTS of the next sample.
+func (t *muxerTrack) segmentDuration(nextPTS int64) time.Duration {
+	if len(t.samples) == 0 {
+		return 0
+	}
+	return time.Duration((nextPTS - t.startPTS) * int64(time.Second) / t.timeScale)
+}
+
+// flush closes the current segment. The duration of the last sample
+// is the distance from the first sample of the next segment.
+func (t *muxerTrack) flush(nextDTS int64, segmentCount int) {
+	if len(t.samples) == 0 {
+		return
+	}
+
+	for i, s := range t.samples {
+		if i < len(t.samples)-1 {
+			s.duration = uint32(t.samples[i+1].dts - s.dts)
+		} else {
+			s.duration = uint32(nextDTS - s.dts)
+		}
+	}
+
+	seg := &muxerSegment{
+		number:   t.nextNumber,
+		start:    t.startDTS,
+		duration: nextDTS - t.startDTS,
+		content:  mediaSegment(uint32(t.nextNumber), t.id, t.samples),
+	}
+	t.nextNumber++
+
+	if seg.duration > 0 {
+		t.bytesPerSecond = int64(len(seg.content)) * t.timeScale / seg.duration
+	}
+
+	t.segments = append(t.segments, seg)
+	if len(t.segments) > segmentCount {
+		t.segments = t.segments[1:]
+	}
+
+	t.samples = nil
+}
+
+func (t *muxerTrack) segment(number uint64) *muxerSegment {
+	for _, s := range t.segments {
+		if s.number == number {
+			return s
+		}
+	}
+	return nil
+}
+
+// Muxer is a MPEG-DASH muxer. It produces a live MPD and fragmented MP4
+// segments, separately for every track.
+type Muxer struct {
+	segmentCount    int
+	segmentDuration time.Duration
+	videoTrack      *gortsplib.TrackH264
+	audioTrack      *gortsplib.TrackAAC
+
+	videoDTSEst *h264.DTSEstimator
+	startPTS    time.Duration
+	started     bool
+
+	mutex     sync.Mutex
+	cond      *sync.Cond
+	closed    bool
+	startTime time.Time
+	video     *muxerTrack
+	audio     *muxerTrack
+}
+
+// NewMuxer allocates a Muxer.
+func NewMuxer(
+	segmentCount int,
+	segmentDuration time.Duration,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackAAC,
+) *Muxer {
+	m := &Muxer{
+		segmentCount:    segmentCount,
+		segmentDuration: segmentDuration,
+		videoTrack:      videoTrack,
+		audioTrack:      audioTrack,
+	}
+	m.cond = sync.NewCond(&m.mutex)
+
+	if videoTrack != nil {
+		m.video = &muxerTrack{
+			name:       "video",
+			id:         videoTrackID,
+			timeScale:  videoTimeScale,
+			nextNumber: 1,
+		}
+	}
+
+	if audioTrack != nil {
+		m.audio = &muxerTrack{
+			name:       "audio",
+			id:         audioTrackID,
+			timeScale:  int64(audioTrack.ClockRate()),
+			nextNumber: 1,
+		}
+	}
+
+	return m
+}
+
+// Close closes a Muxer.
+func (m *Muxer) Close() {
+	func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.closed = true
+	}()
+
+	m.cond.Broadcast()
+}
+
+// WriteH264 writes H264 NALUs, grouped by timestamp, into the muxer.
+func (m *Muxer) WriteH264(pts time.Duration, nalus [][]byte) error {
+	idrPresent := h264.IDRPresent(nalus)
+
+	if !m.started {
+		// skip groups silently until we find one with a IDR
+		if !idrPresent {
+			return nil
+		}
+
+		m.mutex.Lock()
+		m.startTime = time.Now()
+		m.mutex.Unlock()
+
+		m.started = true
+		m.startPTS = pts
+		m.videoDTSEst = h264.NewDTSEstimator()
+	}
+
+	pts -= m.startPTS
+	dts := m.videoDTSEst.Feed(pts)
+
+	avcc, err := h264.AVCCEncode(nalus)
+	if err != nil {
+		return err
+	}
+
+	t := m.video
+	dtsTS := durationToTimeScale(dts, t.timeScale)
+	ptsTS := durationToTimeScale(pts, t.timeScale)
+
+	if idrPresent && t.segmentDuration(ptsTS) >= m.segmentDuration {
+		m.pushSegment(t, dtsTS)
+	}
+
+	if len(t.samples) == 0 {
+		t.startDTS = dtsTS
+		t.startPTS = ptsTS
+	}
+
+	t.samples = append(t.samples, &sample{
+		dts:       dtsTS,
+		ptsOffset: int32(ptsTS - dtsTS),
+		sync:      idrPresent,
+		payload:   avcc,
+	})
+	t.lastDTS = dtsTS
+
+	return nil
+}
+
+// WriteAAC writes AAC AUs, grouped by timestamp, into the muxer.
+func (m *Muxer) WriteAAC(pts time.Duration, aus [][]byte) error {
+	if !m.started {
+		// wait for the video track
+		if m.videoTrack != nil {
+			return nil
+		}
+
+		m.mutex.Lock()
+		m.startTime = time.Now()
+		m.mutex.Unlock()
+
+		m.started = true
+		m.startPTS = pts
+	}
+
+	pts -= m.startPTS
+	if pts < 0 {
+		return nil
+	}
+
+	t := m.audio
+	dtsTS := durationToTimeScale(pts, t.timeScale)
+
+	for _, au := range aus {
+		// avoid overlaps caused by rounding
+		if len(t.samples) != 0 && dtsTS <= t.lastDTS {
+			dtsTS = t.lastDTS + aac.SamplesPerAccessUnit
+		}
+
+		if t.segmentDuration(dtsTS) >= m.segmentDuration {
+			m.pushSegment(t, dtsTS)
+		}
+
+		if len(t.samples) == 0 {
+			t.startDTS = dtsTS
+			t.startPTS = dtsTS
+		}
+
+		t.samples = append(t.samples, &sample{
+			dts:     dtsTS,
+			sync:    true,
+			payload: au,
+		})
+		t.lastDTS = dtsTS
+
+		dtsTS += aac.SamplesPerAccessUnit
+	}
+
+	return nil
+}
+
+func (m *Muxer) pushSegment(t *muxerTrack, nextDTS int64) {
+	func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		t.flush(nextDTS, m.segmentCount)
+	}()
+
+	m.cond.Broadcast()
+}
+
+func durationToTimeScale(d time.Duration, timeScale int64) int64 {
+	secs := d / time.Second
+	dec := d % time.Second
+	return int64(secs)*timeScale + int64(dec)*timeScale/int64(time.Second)
+}
+
+func xmlDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', 3, 64) + "S"
+}
+
+// ready checks whether the main track contains segments.
+// The other track is listed only when it contains segments too.
+func (m *Muxer) ready() bool {
+	if m.video != nil {
+		return len(m.video.segments) != 0
+	}
+	return len(m.audio.segments) != 0
+}
+
+// MPD returns a reader to read the MPD. The given query, if not empty,
+// is added to the URLs of segments, in order to forward credentials.
+func (m *Muxer) MPD(query string) io.Reader {
+	return &asyncReader{generator: func() []byte {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+
+		for !m.closed && !m.ready() {
+			m.cond.Wait()
+		}
+
+		if m.closed {
+			return nil
+		}
+
+		suffix := ""
+		if query != "" {
+			suffix = "?" + xmlEscape(query)
+		}
+
+		now := time.Now().UTC()
+
+		cnt := `<?xml version="1.0" encoding="utf-8"?>` + "\n" +
+			`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011"` +
+			` profiles="urn:mpeg:dash:profile:isoff-live:2011"` +
+			` type="dynamic"` +
+			` availabilityStartTime="` + m.startTime.UTC().Format(time.RFC3339Nano) + `"` +
+			` publishTime="` + now.Format(time.RFC3339Nano) + `"` +
+			` minimumUpdatePeriod="` + xmlDuration(m.segmentDuration) + `"` +
+			` minBufferTime="` + xmlDuration(2*m.segmentDuration) + `"` +
+			` suggestedPresentationDelay="` + xmlDuration(3*m.segmentDuration) + `"` +
+			` timeShiftBufferDepth="` + xmlDuration(time.Duration(m.segmentCount)*m.segmentDuration) + `">` + "\n" +
+			`  <Period id="0" start="PT0S">` + "\n"
+
+		if m.video != nil && len(m.video.segments) != 0 {
+			codecs := "avc1"
+			if sps := m.videoTrack.SPS(); len(sps) >= 4 {
+				codecs = fmt.Sprintf("avc1.%02x%02x%02x", sps[1], sps[2], sps[3])
+			}
+
+			cnt += `    <AdaptationSet id="0" contentType="video" mimeType="video/mp4"` +
+				` segmentAlignment="true" startWithSAP="1">` + "\n" +
+				`      <Representation id="video" codecs="` + codecs + `"` +
+				` bandwidth="` + strconv.FormatInt(m.video.bytesPerSecond*8, 10) + `">` + "\n" +
+				m.segmentTemplate(m.video, suffix) +
+				`      </Representation>` + "\n" +
+				`    </AdaptationSet>` + "\n"
+		}
+
+		if m.audio != nil && len(m.audio.segments) != 0 {
+			cnt += `    <AdaptationSet id="1" contentType="audio" mimeType="audio/mp4"` +
+				` segmentAlignment="true" startWithSAP="1">` + "\n" +
+				`      <Representation id="audio" codecs="mp4a.40.` + strconv.FormatInt(int64(m.audioTrack.Type()), 10) + `"` +
+				` bandwidth="` + strconv.FormatInt(m.audio.bytesPerSecond*8, 10) + `"` +
+				` audioSamplingRate="` + strconv.FormatInt(int64(m.audioTrack.ClockRate()), 10) + `">` + "\n" +
+				`        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011"` +
+				` value="` + strconv.FormatInt(int64(m.audioTrack.ChannelCount()), 10) + `"/>` + "\n" +
+				m.segmentTemplate(m.audio, suffix) +
+				`      </Representation>` + "\n" +
+				`    </AdaptationSet>` + "\n"
+		}
+
+		cnt += `  </Period>` + "\n" +
+			`  <UTCTiming schemeIdUri="urn:mpeg:dash:utc:direct:2014" value="` + now.Format(time.RFC3339Nano) + `"/>` + "\n" +
+			`</MPD>` + "\n"
+
+		return []byte(cnt)
+	}}
+}
+
+func (m *Muxer) segmentTemplate(t *muxerTrack, suffix string) string {
+	cnt := `        <SegmentTemplate timescale="` + strconv.FormatInt(t.timeScale, 10) + `"` +
+		` initialization="` + t.name + `_init.mp4` + suffix + `"` +
+		` media="` + t.name + `_$Number$.m4s` + suffix + `"` +
+		` startNumber="` + strconv.FormatUint(t.segments[0].number, 10) + `">` + "\n" +
+		`          <SegmentTimeline>` + "\n"
+
+	for i, s := range t.segments {
+		cnt += `            <S`
+		if i == 0 {
+			cnt += ` t="` + strconv.FormatInt(s.start, 10) + `"`
+		}
+		cnt += ` d="` + strconv.FormatInt(s.duration, 10) + `"/>` + "\n"
+	}
+
+	cnt += `          </SegmentTimeline>` + "\n" +
+		`        </SegmentTemplate>` + "\n"
+
+	return cnt
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	for _, c := range s {
+		switch c {
+		case '&':
+			buf.WriteString("&amp;")
+		case '<':
+			buf.WriteString("&lt;")
+		case '>':
+			buf.WriteString("&gt;")
+		case '"':
+			buf.WriteString("&quot;")
+		default:
+			buf.WriteRune(c)
+		}
+	}
+	return buf.String()
+}
+
+// File returns a reader to read an initialization segment or a media segment
+// listed in the MPD, or nil if the file doesn't exist.
+func (m *Muxer) File(fname string) io.Reader {
+	var t *muxerTrack
+	switch {
+	case m.video != nil && strings.HasPrefix(fname, "video_"):
+		t = m.video
+
+	case m.audio != nil && strings.HasPrefix(fname, "audio_"):
+		t = m.audio
+
+	default:
+		return nil
+	}
+
+	rest := fname[len(t.name)+1:]
+
+	if rest == "init.mp4" {
+		if t == m.video {
+			sps := m.videoTrack.SPS()
+			if len(sps) < 4 || m.videoTrack.PPS() == nil {
+				return nil
+			}
+
+			return bytes.NewReader(videoInitSegment(t.id, m.videoTrack))
+		}
+
+		byts, err := audioInitSegment(t.id, m.audioTrack)
+		if err != nil {
+			return nil
+		}
+		return bytes.NewReader(byts)
+	}
+
+	if !strings.HasSuffix(rest, ".m4s") {
+		return nil
+	}
+
+	number, err := strconv.ParseUint(strings.TrimSuffix(rest, ".m4s"), 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s := t.segment(number)
+	if s == nil {
+		return nil
+	}
+
+	return bytes.NewReader(s.content)
+}
@@ -0,0 +1,144 @@
+package dash
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func boxTypes(t *testing.T, buf []byte) []string {
+	var ret []string
+	for len(buf) > 0 {
+		require.GreaterOrEqual(t, len(buf), 8)
+		size := int(binary.BigEndian.Uint32(buf))
+		require.LessOrEqual(t, size, len(buf))
+		ret = append(ret, string(buf[4:8]))
+		buf = buf[size:]
+	}
+	return ret
+}
+
+func TestMuxerVideoAudio(t *testing.T) {
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+
+	videoTrack, err := gortsplib.NewTrackH264(96, sps, []byte{0x68, 0xee, 0x3c, 0x80}, nil)
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	m := NewMuxer(3, 1*time.Second, videoTrack, audioTrack)
+	defer m.Close()
+
+	// group without IDR
+	err = m.WriteH264(1*time.Second, [][]byte{{0x41}})
+	require.NoError(t, err)
+
+	// audio before the first IDR
+	err = m.WriteAAC(1*time.Second, [][]byte{{0x01}})
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = m.WriteH264(time.Duration(2+i)*time.Second, [][]byte{{0x65}})
+		require.NoError(t, err)
+
+		err = m.WriteH264(time.Duration(2+i)*time.Second+500*time.Millisecond, [][]byte{{0x41}})
+		require.NoError(t, err)
+
+		err = m.WriteAAC(time.Duration(2+i)*time.Second, [][]byte{{0x01, 0x02}, {0x03, 0x04}})
+		require.NoError(t, err)
+	}
+
+	err = m.WriteAAC(5*time.Second, [][]byte{{0x01, 0x02}})
+	require.NoError(t, err)
+
+	byts, err := ioutil.ReadAll(m.MPD("jwt=a&b=c"))
+	require.NoError(t, err)
+
+	re := regexp.MustCompile(`^<\?xml version="1.0" encoding="utf-8"\?>\n` +
+		`<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" profiles="urn:mpeg:dash:profile:isoff-live:2011" type="dynamic"` +
+		` availabilityStartTime="[^"]+" publishTime="[^"]+" minimumUpdatePeriod="PT1.000S" minBufferTime="PT2.000S"` +
+		` suggestedPresentationDelay="PT3.000S" timeShiftBufferDepth="PT3.000S">\n` +
+		`  <Period id="0" start="PT0S">\n` +
+		`    <AdaptationSet id="0" contentType="video" mimeType="video/mp4" segmentAlignment="true" startWithSAP="1">\n` +
+		`      <Representation id="video" codecs="avc1.64000c" bandwidth="[0-9]+">\n` +
+		`        <SegmentTemplate timescale="90000" initialization="video_init.mp4\?jwt=a&amp;b=c"` +
+		` media="video_\$Number\$.m4s\?jwt=a&amp;b=c" startNumber="1">\n` +
+		`          <SegmentTimeline>\n` +
+		`            <S t="0" d="45000"/>\n` +
+		`            <S d="90000"/>\n` +
+		`          </SegmentTimeline>\n` +
+		`        </SegmentTemplate>\n` +
+		`      </Representation>\n` +
+		`    </AdaptationSet>\n` +
+		`    <AdaptationSet id="1" contentType="audio" mimeType="audio/mp4" segmentAlignment="true" startWithSAP="1">\n` +
+		`      <Representation id="audio" codecs="mp4a.40.2" bandwidth="[0-9]+" audioSamplingRate="44100">\n` +
+		`        <AudioChannelConfiguration schemeIdUri="urn:mpeg:dash:23003:3:audio_channel_configuration:2011" value="2"/>\n` +
+		`        <SegmentTemplate timescale="44100" initialization="audio_init.mp4\?jwt=a&amp;b=c"` +
+		` media="audio_\$Number\$.m4s\?jwt=a&amp;b=c" startNumber="1">\n` +
+		`          <SegmentTimeline>\n` +
+		`            <S t="0" d="44100"/>\n` +
+		`            <S d="44100"/>\n` +
+		`            <S d="44100"/>\n` +
+		`          </SegmentTimeline>\n` +
+		`        </SegmentTemplate>\n` +
+		`      </Representation>\n` +
+		`    </AdaptationSet>\n` +
+		`  </Period>\n` +
+		`  <UTCTiming schemeIdUri="urn:mpeg:dash:utc:direct:2014" value="[^"]+"/>\n` +
+		`</MPD>\n$`)
+	require.Regexp(t, re, string(byts))
+
+	byts, err = ioutil.ReadAll(m.File("video_init.mp4"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"ftyp", "moov"}, boxTypes(t, byts))
+
+	byts, err = ioutil.ReadAll(m.File("audio_init.mp4"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"ftyp", "moov"}, boxTypes(t, byts))
+
+	byts, err = ioutil.ReadAll(m.File("video_1.m4s"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"moof", "mdat"}, boxTypes(t, byts))
+
+	// mdat contains the two frames of the segment, in AVCC format
+	require.Equal(t, []byte{
+		0, 0, 0, 18, 'm', 'd', 'a', 't',
+		0, 0, 0, 1, 0x65,
+		0, 0, 0, 1, 0x41,
+	}, byts[len(byts)-18:])
+
+	require.Nil(t, m.File("video_3.m4s"))
+	require.Nil(t, m.File("video_init.ts"))
+	require.Nil(t, m.File("other"))
+}
+
+func TestMuxerAudioOnly(t *testing.T) {
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 48000, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	m := NewMuxer(3, 1*time.Second, nil, audioTrack)
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			m.WriteAAC(time.Duration(i)*1024*time.Second/48000, [][]byte{{0x01}})
+		}
+	}()
+
+	byts, err := ioutil.ReadAll(m.MPD(""))
+	require.NoError(t, err)
+	require.Contains(t, string(byts), `media="audio_$Number$.m4s"`)
+	require.NotContains(t, string(byts), `video`)
+
+	m.Close()
+	require.Nil(t, m.File("video_init.mp4"))
+}
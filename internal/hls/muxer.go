@@ -2,6 +2,7 @@ package hls
 
 import (
 	"io"
+	"io/ioutil"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -15,16 +16,34 @@ type Muxer struct {
 }
 
 // NewMuxer allocates a Muxer.
+// When dvrWindow is not zero, segments that exceed hlsSegmentCount are moved
+// into a temporary directory inside dvrPath (or inside the default directory
+// for temporary files, if dvrPath is empty) and are kept in the playlist
+// until they exit dvrWindow.
+// onSegmentGenerated, if not nil, is called with the duration of
+// every generated segment.
 func NewMuxer(
 	hlsSegmentCount int,
 	hlsSegmentDuration time.Duration,
 	hlsSegmentMaxSize uint64,
+	dvrWindow time.Duration,
+	dvrPath string,
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackAAC,
+	onSegmentGenerated func(time.Duration),
 ) (*Muxer, error) {
 	primaryPlaylist := newMuxerPrimaryPlaylist(videoTrack, audioTrack)
 
-	streamPlaylist := newMuxerStreamPlaylist(hlsSegmentCount)
+	dvrDir := ""
+	if dvrWindow > 0 {
+		var err error
+		dvrDir, err = ioutil.TempDir(dvrPath, "hls-dvr-")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	streamPlaylist := newMuxerStreamPlaylist(hlsSegmentCount, dvrWindow, dvrDir, onSegmentGenerated)
 
 	tsGenerator := newMuxerTSGenerator(
 		hlsSegmentCount,
@@ -32,7 +51,7 @@ func NewMuxer(
 		hlsSegmentMaxSize,
 		videoTrack,
 		audioTrack,
-		streamPlaylist)
+		streamPlaylist.pushSegment)
 
 	m := &Muxer{
 		primaryPlaylist: primaryPlaylist,
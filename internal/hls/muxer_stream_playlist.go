@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"io"
 	"math"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 type asyncReader struct {
@@ -22,7 +24,10 @@ func (r *asyncReader) Read(buf []byte) (int, error) {
 }
 
 type muxerStreamPlaylist struct {
-	hlsSegmentCount int
+	hlsSegmentCount    int
+	dvrWindow          time.Duration
+	dvrDir             string
+	onSegmentGenerated func(time.Duration)
 
 	mutex              sync.Mutex
 	cond               *sync.Cond
@@ -32,10 +37,21 @@ type muxerStreamPlaylist struct {
 	segmentDeleteCount int
 }
 
-func newMuxerStreamPlaylist(hlsSegmentCount int) *muxerStreamPlaylist {
+// newMuxerStreamPlaylist allocates a muxerStreamPlaylist.
+// When dvrDir is not empty, segments that exceed hlsSegmentCount are moved
+// into dvrDir and are kept in the playlist until they exit dvrWindow.
+func newMuxerStreamPlaylist(
+	hlsSegmentCount int,
+	dvrWindow time.Duration,
+	dvrDir string,
+	onSegmentGenerated func(time.Duration),
+) *muxerStreamPlaylist {
 	p := &muxerStreamPlaylist{
-		hlsSegmentCount: hlsSegmentCount,
-		segmentByName:   make(map[string]*muxerTSSegment),
+		hlsSegmentCount:    hlsSegmentCount,
+		dvrWindow:          dvrWindow,
+		dvrDir:             dvrDir,
+		onSegmentGenerated: onSegmentGenerated,
+		segmentByName:      make(map[string]*muxerTSSegment),
 	}
 	p.cond = sync.NewCond(&p.mutex)
 	return p
@@ -49,6 +65,10 @@ func (p *muxerStreamPlaylist) close() {
 	}()
 
 	p.cond.Broadcast()
+
+	if p.dvrDir != "" {
+		os.RemoveAll(p.dvrDir)
+	}
 }
 
 func (p *muxerStreamPlaylist) reader() io.Reader {
@@ -64,9 +84,10 @@ func (p *muxerStreamPlaylist) reader() io.Reader {
 			return nil
 		}
 
-		cnt := "#EXTM3U\n"
-		cnt += "#EXT-X-VERSION:3\n"
-		cnt += "#EXT-X-ALLOW-CACHE:NO\n"
+		var cnt strings.Builder
+		cnt.WriteString("#EXTM3U\n")
+		cnt.WriteString("#EXT-X-VERSION:3\n")
+		cnt.WriteString("#EXT-X-ALLOW-CACHE:NO\n")
 
 		targetDuration := func() uint {
 			ret := uint(0)
@@ -81,19 +102,20 @@ func (p *muxerStreamPlaylist) reader() io.Reader {
 
 			return ret
 		}()
-		cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(targetDuration), 10) + "\n"
+		cnt.WriteString("#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(targetDuration), 10) + "\n")
 
-		cnt += "#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(p.segmentDeleteCount), 10) + "\n"
-		cnt += "#EXT-X-INDEPENDENT-SEGMENTS\n"
-		cnt += "\n"
+		cnt.WriteString("#EXT-X-MEDIA-SEQUENCE:" + strconv.FormatInt(int64(p.segmentDeleteCount), 10) + "\n")
+		cnt.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+		cnt.WriteString("\n")
 
+		// with the DVR, the playlist can contain thousands of segments.
 		for _, s := range p.segments {
-			cnt += "#EXT-X-PROGRAM-DATE-TIME:" + s.startTime.Format("2006-01-02T15:04:05.999Z07:00") + "\n" +
+			cnt.WriteString("#EXT-X-PROGRAM-DATE-TIME:" + s.startTime.Format("2006-01-02T15:04:05.999Z07:00") + "\n" +
 				"#EXTINF:" + strconv.FormatFloat(s.duration().Seconds(), 'f', -1, 64) + ",\n" +
-				s.name + ".ts\n"
+				s.name + ".ts\n")
 		}
 
-		return []byte(cnt)
+		return []byte(cnt.String())
 	}}
 }
 
@@ -102,16 +124,44 @@ func (p *muxerStreamPlaylist) segment(fname string) io.Reader {
 
 	p.mutex.Lock()
 	f, ok := p.segmentByName[base]
+	var r io.Reader
+	var fpath string
+	if ok {
+		if f.fpath != "" {
+			fpath = f.fpath
+		} else {
+			r = f.reader()
+		}
+	}
 	p.mutex.Unlock()
 
 	if !ok {
 		return nil
 	}
 
-	return f.reader()
+	// segment has been moved to disk.
+	// the file is returned as it is, in order to be streamed to the client.
+	if fpath != "" {
+		f, err := os.Open(fpath)
+		if err != nil {
+			return nil
+		}
+		return f
+	}
+
+	return r
 }
 
 func (p *muxerStreamPlaylist) pushSegment(t *muxerTSSegment) {
+	if p.onSegmentGenerated != nil {
+		p.onSegmentGenerated(t.duration())
+	}
+
+	// index of the segment that exceeds hlsSegmentCount and must be moved into the DVR.
+	// Since segments are added and removed by this function only,
+	// the index doesn't change until the segment has been written.
+	toMove := -1
+
 	func() {
 		p.mutex.Lock()
 		defer p.mutex.Unlock()
@@ -119,12 +169,71 @@ func (p *muxerStreamPlaylist) pushSegment(t *muxerTSSegment) {
 		p.segmentByName[t.name] = t
 		p.segments = append(p.segments, t)
 
-		if len(p.segments) > p.hlsSegmentCount {
-			delete(p.segmentByName, p.segments[0].name)
-			p.segments = p.segments[1:]
-			p.segmentDeleteCount++
+		if p.dvrDir == "" {
+			if len(p.segments) > p.hlsSegmentCount {
+				p.removeFirstSegment()
+			}
+			return
 		}
+
+		toMove = len(p.segments) - p.hlsSegmentCount - 1
 	}()
 
 	p.cond.Broadcast()
+
+	if toMove < 0 {
+		return
+	}
+
+	// the disk is accessed without holding the mutex,
+	// in order not to block readers of the playlist and of segments.
+	fpath, err := p.segments[toMove].writeToDisk(p.dvrDir)
+
+	var toRemove []string
+
+	func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		if err != nil {
+			// segments must be contiguous: remove the segment
+			// and all the previous ones.
+			for j := 0; j <= toMove; j++ {
+				toRemove = append(toRemove, p.removeFirstSegment())
+			}
+		} else {
+			p.segments[toMove].onWrittenToDisk(fpath)
+		}
+
+		// remove segments that exit the DVR window
+		dvrDuration := time.Duration(0)
+		for _, s := range p.segments {
+			dvrDuration += s.duration()
+		}
+
+		for len(p.segments) > p.hlsSegmentCount &&
+			(dvrDuration-p.segments[0].duration()) >= p.dvrWindow {
+			dvrDuration -= p.segments[0].duration()
+			toRemove = append(toRemove, p.removeFirstSegment())
+		}
+	}()
+
+	for _, fpath := range toRemove {
+		if fpath != "" {
+			os.Remove(fpath)
+		}
+	}
+}
+
+// removeFirstSegment removes the first segment from the playlist.
+// It returns the path of its file on disk, if any, that must be removed
+// by the caller after releasing the mutex.
+func (p *muxerStreamPlaylist) removeFirstSegment() string {
+	s := p.segments[0]
+
+	delete(p.segmentByName, s.name)
+	p.segments = p.segments[1:]
+	p.segmentDeleteCount++
+
+	return s.fpath
 }
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"io/ioutil"
+	"os"
 	"regexp"
+	"strconv"
 	"testing"
 	"time"
 
@@ -20,7 +22,7 @@ func TestMuxerVideoAudio(t *testing.T) {
 	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, videoTrack, audioTrack)
+	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, 0, "", videoTrack, audioTrack, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -177,7 +179,7 @@ func TestMuxerVideoOnly(t *testing.T) {
 	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, videoTrack, nil)
+	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, 0, "", videoTrack, nil, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -261,7 +263,7 @@ func TestMuxerAudioOnly(t *testing.T) {
 	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, nil, audioTrack)
+	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, 0, "", nil, audioTrack, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -349,7 +351,7 @@ func TestMuxerCloseBeforeFirstSegment(t *testing.T) {
 	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, videoTrack, nil)
+	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, 0, "", videoTrack, nil, nil)
 	require.NoError(t, err)
 
 	// group with IDR
@@ -372,7 +374,7 @@ func TestMuxerMaxSegmentSize(t *testing.T) {
 	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, 0, videoTrack, nil)
+	m, err := NewMuxer(3, 1*time.Second, 0, 0, "", videoTrack, nil, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -386,7 +388,7 @@ func TestMuxerDoubleRead(t *testing.T) {
 	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
 	require.NoError(t, err)
 
-	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, videoTrack, nil)
+	m, err := NewMuxer(3, 1*time.Second, 50*1024*1024, 0, "", videoTrack, nil, nil)
 	require.NoError(t, err)
 	defer m.Close()
 
@@ -409,3 +411,83 @@ func TestMuxerDoubleRead(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, byts1, byts2)
 }
+
+func TestMuxerDVR(t *testing.T) {
+	dvrPath, err := ioutil.TempDir("", "hls-dvr-test")
+	require.NoError(t, err)
+	defer os.RemoveAll(dvrPath)
+
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
+	require.NoError(t, err)
+
+	m, err := NewMuxer(2, 1*time.Second, 50*1024*1024, 5*time.Second, dvrPath, videoTrack, nil, nil)
+	require.NoError(t, err)
+
+	start := time.Date(2022, 5, 10, 14, 5, 0, 0, time.UTC)
+
+	for i := 0; i < 10; i++ {
+		startPTS := time.Duration(i) * time.Second
+		s := &muxerTSSegment{
+			startTime: start.Add(startPTS),
+			name:      strconv.FormatInt(int64(1000+i), 10),
+			startPTS:  &startPTS,
+			endPTS:    startPTS + 1*time.Second,
+		}
+		s.buf.Write([]byte{byte(i)})
+		m.streamPlaylist.pushSegment(s)
+	}
+
+	// 5 segments are kept, the oldest 3 on disk and the newest 2 in memory
+	require.Equal(t, 5, len(m.streamPlaylist.segments))
+	files, err := ioutil.ReadDir(m.streamPlaylist.dvrDir)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(files))
+
+	byts, err := ioutil.ReadAll(m.StreamPlaylist())
+	require.NoError(t, err)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:3\n"+
+		"#EXT-X-ALLOW-CACHE:NO\n"+
+		"#EXT-X-TARGETDURATION:1\n"+
+		"#EXT-X-MEDIA-SEQUENCE:5\n"+
+		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
+		"\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:05Z\n"+
+		"#EXTINF:1,\n"+
+		"1005.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:06Z\n"+
+		"#EXTINF:1,\n"+
+		"1006.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:07Z\n"+
+		"#EXTINF:1,\n"+
+		"1007.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:08Z\n"+
+		"#EXTINF:1,\n"+
+		"1008.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:09Z\n"+
+		"#EXTINF:1,\n"+
+		"1009.ts\n", string(byts))
+
+	require.Equal(t, nil, m.Segment("1004.ts"))
+
+	for i := 5; i < 10; i++ {
+		r := m.Segment(strconv.FormatInt(int64(1000+i), 10) + ".ts")
+		require.NotEqual(t, nil, r)
+		byts, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.Equal(t, []byte{byte(i)}, byts)
+
+		// segments on disk are returned as files
+		f, ok := r.(*os.File)
+		require.Equal(t, i < 8, ok)
+		if ok {
+			f.Close()
+		}
+	}
+
+	m.Close()
+
+	_, err = os.Stat(m.streamPlaylist.dvrDir)
+	require.Equal(t, true, os.IsNotExist(err))
+}
@@ -2,6 +2,7 @@ package hls
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/aler9/gortsplib"
@@ -26,7 +27,10 @@ type muxerTSGenerator struct {
 	hlsSegmentMaxSize  uint64
 	videoTrack         *gortsplib.TrackH264
 	audioTrack         *gortsplib.TrackAAC
-	streamPlaylist     *muxerStreamPlaylist
+	pushSegment        func(*muxerTSSegment)
+
+	// when set, data is written into w instead of being stored into segments.
+	w io.Writer
 
 	writer         *astits.Muxer
 	currentSegment *muxerTSSegment
@@ -41,7 +45,7 @@ func newMuxerTSGenerator(
 	hlsSegmentMaxSize uint64,
 	videoTrack *gortsplib.TrackH264,
 	audioTrack *gortsplib.TrackAAC,
-	streamPlaylist *muxerStreamPlaylist,
+	pushSegment func(*muxerTSSegment),
 ) *muxerTSGenerator {
 	m := &muxerTSGenerator{
 		hlsSegmentCount:    hlsSegmentCount,
@@ -49,12 +53,15 @@ func newMuxerTSGenerator(
 		hlsSegmentMaxSize:  hlsSegmentMaxSize,
 		videoTrack:         videoTrack,
 		audioTrack:         audioTrack,
-		streamPlaylist:     streamPlaylist,
+		pushSegment:        pushSegment,
 	}
 
 	m.writer = astits.NewMuxer(
 		context.Background(),
 		writerFunc(func(p []byte) (int, error) {
+			if m.w != nil {
+				return m.w.Write(p)
+			}
 			return m.currentSegment.write(p)
 		}))
 
@@ -81,6 +88,14 @@ func newMuxerTSGenerator(
 	return m
 }
 
+// flush pushes the current segment, if it contains data.
+func (m *muxerTSGenerator) flush() {
+	if m.currentSegment != nil && m.currentSegment.startPTS != nil {
+		m.pushSegment(m.currentSegment)
+	}
+	m.currentSegment = nil
+}
+
 func (m *muxerTSGenerator) writeH264(pts time.Duration, nalus [][]byte) error {
 	now := time.Now()
 	idrPresent := h264.IDRPresent(nalus)
@@ -106,7 +121,7 @@ func (m *muxerTSGenerator) writeH264(pts time.Duration, nalus [][]byte) error {
 			m.currentSegment.startPTS != nil &&
 			(pts-*m.currentSegment.startPTS) >= m.hlsSegmentDuration {
 			m.currentSegment.endPTS = pts
-			m.streamPlaylist.pushSegment(m.currentSegment)
+			m.pushSegment(m.currentSegment)
 			m.currentSegment = newMuxerTSSegment(now, m.hlsSegmentMaxSize,
 				m.videoTrack, m.writer.WriteData)
 		}
@@ -120,7 +135,7 @@ func (m *muxerTSGenerator) writeH264(pts time.Duration, nalus [][]byte) error {
 	enc, err := h264.AnnexBEncode(nalus)
 	if err != nil {
 		if m.currentSegment.buf.Len() > 0 {
-			m.streamPlaylist.pushSegment(m.currentSegment)
+			m.pushSegment(m.currentSegment)
 		}
 		m.currentSegment = nil
 		return err
@@ -130,7 +145,7 @@ func (m *muxerTSGenerator) writeH264(pts time.Duration, nalus [][]byte) error {
 		pts, idrPresent, enc)
 	if err != nil {
 		if m.currentSegment.buf.Len() > 0 {
-			m.streamPlaylist.pushSegment(m.currentSegment)
+			m.pushSegment(m.currentSegment)
 		}
 		m.currentSegment = nil
 		return err
@@ -158,7 +173,7 @@ func (m *muxerTSGenerator) writeAAC(pts time.Duration, aus [][]byte) error {
 				m.currentSegment.startPTS != nil &&
 				(pts-*m.currentSegment.startPTS) >= m.hlsSegmentDuration {
 				m.currentSegment.endPTS = pts
-				m.streamPlaylist.pushSegment(m.currentSegment)
+				m.pushSegment(m.currentSegment)
 				m.currentSegment = newMuxerTSSegment(now, m.hlsSegmentMaxSize,
 					m.videoTrack, m.writer.WriteData)
 			}
@@ -191,7 +206,7 @@ func (m *muxerTSGenerator) writeAAC(pts time.Duration, aus [][]byte) error {
 	err = m.currentSegment.writeAAC(now.Sub(m.startPCR), pts, enc, len(aus))
 	if err != nil {
 		if m.currentSegment.buf.Len() > 0 {
-			m.streamPlaylist.pushSegment(m.currentSegment)
+			m.pushSegment(m.currentSegment)
 		}
 		m.currentSegment = nil
 		return err
@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
@@ -16,6 +19,25 @@ const (
 	pcrOffset = 500 * time.Millisecond
 )
 
+// segmentNameLen is the length of segment names, that are Unix timestamps.
+const segmentNameLen = 10
+
+// IsSegmentName checks whether a file name, without extension,
+// can be the name of a segment generated by the muxer.
+func IsSegmentName(name string) bool {
+	if len(name) != segmentNameLen {
+		return false
+	}
+
+	for _, c := range name {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
 type muxerTSSegment struct {
 	hlsSegmentMaxSize uint64
 	videoTrack        *gortsplib.TrackH264
@@ -28,6 +50,9 @@ type muxerTSSegment struct {
 	endPTS         time.Duration
 	pcrSendCounter int
 	audioAUCount   int
+
+	// path of the segment on disk, when it has been moved into the DVR.
+	fpath string
 }
 
 func newMuxerTSSegment(
@@ -68,6 +93,27 @@ func (t *muxerTSSegment) reader() io.Reader {
 	return bytes.NewReader(t.buf.Bytes())
 }
 
+// writeToDisk writes the segment into dir and returns the path of the file.
+// It can be called without locking the playlist, since the segment
+// is not modified anymore.
+func (t *muxerTSSegment) writeToDisk(dir string) (string, error) {
+	fpath := filepath.Join(dir, t.name+".ts")
+
+	err := ioutil.WriteFile(fpath, t.buf.Bytes(), 0o644)
+	if err != nil {
+		os.Remove(fpath)
+		return "", err
+	}
+
+	return fpath, nil
+}
+
+// onWrittenToDisk frees the memory of a segment that has been written to fpath.
+func (t *muxerTSSegment) onWrittenToDisk(fpath string) {
+	t.fpath = fpath
+	t.buf = bytes.Buffer{}
+}
+
 func (t *muxerTSSegment) writeH264(
 	pcr time.Duration,
 	dts time.Duration,
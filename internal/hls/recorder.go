@@ -0,0 +1,69 @@
+package hls
+
+import (
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+)
+
+// RecorderSegment is a segment generated by a Recorder.
+type RecorderSegment struct {
+	StartTime time.Time
+	Duration  time.Duration
+	Content   io.Reader
+}
+
+// Recorder generates MPEG-TS segments that are meant to be stored,
+// instead of being served with a playlist.
+type Recorder struct {
+	tsGenerator *muxerTSGenerator
+}
+
+// NewRecorder allocates a Recorder.
+// onSegment is called with every segment, in the same routine that
+// calls WriteH264() and WriteAAC().
+func NewRecorder(
+	segmentDuration time.Duration,
+	segmentMaxSize uint64,
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackAAC,
+	onSegment func(*RecorderSegment),
+) *Recorder {
+	r := &Recorder{}
+
+	r.tsGenerator = newMuxerTSGenerator(
+		0,
+		segmentDuration,
+		segmentMaxSize,
+		videoTrack,
+		audioTrack,
+		func(t *muxerTSSegment) {
+			if t.startPTS == nil {
+				return
+			}
+
+			onSegment(&RecorderSegment{
+				StartTime: t.startTime,
+				Duration:  t.duration(),
+				Content:   t.reader(),
+			})
+		})
+
+	return r
+}
+
+// Close closes a Recorder. The current segment is flushed.
+func (r *Recorder) Close() {
+	r.tsGenerator.flush()
+}
+
+// WriteH264 writes H264 NALUs, grouped by timestamp, into the recorder.
+func (r *Recorder) WriteH264(pts time.Duration, nalus [][]byte) error {
+	return r.tsGenerator.writeH264(pts, nalus)
+}
+
+// WriteAAC writes AAC AUs, grouped by timestamp, into the recorder.
+func (r *Recorder) WriteAAC(pts time.Duration, aus [][]byte) error {
+	return r.tsGenerator.writeAAC(pts, aus)
+}
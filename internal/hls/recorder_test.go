@@ -0,0 +1,144 @@
+package hls
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	var segments []*RecorderSegment
+
+	r := NewRecorder(1*time.Second, 50*1024*1024, videoTrack, audioTrack,
+		func(s *RecorderSegment) {
+			segments = append(segments, s)
+		})
+
+	// group with IDR
+	err = r.WriteH264(2*time.Second, [][]byte{
+		{7, 1, 2, 3}, // SPS
+		{8},          // PPS
+		{5},          // IDR
+	})
+	require.NoError(t, err)
+
+	err = r.WriteAAC(2500*time.Millisecond, [][]byte{
+		{0x01, 0x02, 0x03, 0x04},
+	})
+	require.NoError(t, err)
+
+	// group without IDR
+	err = r.WriteH264(3*time.Second, [][]byte{
+		{1},
+	})
+	require.NoError(t, err)
+
+	// group with IDR
+	err = r.WriteH264(4*time.Second, [][]byte{
+		{5}, // IDR
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(segments))
+	require.Equal(t, 2*time.Second, segments[0].Duration)
+
+	r.Close()
+	require.Equal(t, 2, len(segments))
+
+	byts, err := ioutil.ReadAll(segments[0].Content)
+	require.NoError(t, err)
+
+	vt, at, err := SegmentTracks(byts)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7, 1, 2, 3}, vt.SPS())
+	require.Equal(t, []byte{8}, vt.PPS())
+	require.Equal(t, 44100, at.ClockRate())
+	require.Equal(t, 2, at.ChannelCount())
+
+	sr, err := NewSegmentReader(byts)
+	require.NoError(t, err)
+
+	var video []*SegmentData
+	var audio []*SegmentData
+
+	for {
+		data, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if data.H264NALUs != nil {
+			video = append(video, data)
+		} else {
+			audio = append(audio, data)
+		}
+	}
+
+	require.Equal(t, 2, len(video))
+	require.Equal(t, time.Duration(0), video[0].PTS)
+	require.Equal(t, [][]byte{
+		{9, 240}, // AUD
+		{7, 1, 2, 3},
+		{8},
+		{5},
+	}, video[0].H264NALUs)
+	require.Equal(t, 1*time.Second, video[1].PTS)
+
+	require.Equal(t, 1, len(audio))
+	require.Equal(t, 500*time.Millisecond, audio[0].PTS)
+	require.Equal(t, []byte{0x01, 0x02, 0x03, 0x04}, audio[0].AACPackets[0].AU)
+}
+
+func TestVODPlaylist(t *testing.T) {
+	start := time.Date(2022, 5, 10, 14, 5, 0, 0, time.UTC)
+
+	byts := VODPlaylist([]VODSegment{
+		{
+			StartTime: start,
+			Duration:  10 * time.Second,
+			URI:       "seg1.ts",
+		},
+		{
+			StartTime: start.Add(10 * time.Second),
+			Duration:  9500 * time.Millisecond,
+			URI:       "seg2.ts",
+		},
+		{
+			StartTime:     start.Add(time.Minute),
+			Duration:      4 * time.Second,
+			URI:           "seg3.ts",
+			Discontinuity: true,
+		},
+	}, 2*time.Second)
+
+	require.Equal(t, "#EXTM3U\n"+
+		"#EXT-X-VERSION:3\n"+
+		"#EXT-X-PLAYLIST-TYPE:VOD\n"+
+		"#EXT-X-TARGETDURATION:10\n"+
+		"#EXT-X-MEDIA-SEQUENCE:0\n"+
+		"#EXT-X-INDEPENDENT-SEGMENTS\n"+
+		"#EXT-X-START:TIME-OFFSET=2\n"+
+		"\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:00Z\n"+
+		"#EXTINF:10,\n"+
+		"seg1.ts\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:05:10Z\n"+
+		"#EXTINF:9.5,\n"+
+		"seg2.ts\n"+
+		"#EXT-X-DISCONTINUITY\n"+
+		"#EXT-X-PROGRAM-DATE-TIME:2022-05-10T14:06:00Z\n"+
+		"#EXTINF:4,\n"+
+		"seg3.ts\n"+
+		"#EXT-X-ENDLIST\n", string(byts))
+}
@@ -0,0 +1,208 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/aac"
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/asticode/go-astits"
+)
+
+// SegmentData is a unit of data read from a segment.
+type SegmentData struct {
+	// NALUs of a H264 access unit, if the unit belongs to the video track.
+	H264NALUs [][]byte
+
+	// ADTS packets, if the unit belongs to the audio track.
+	AACPackets []*aac.ADTSPacket
+
+	PTS time.Duration
+	DTS time.Duration
+}
+
+// SegmentReader reads MPEG-TS segments generated by a Muxer or by a Recorder.
+type SegmentReader struct {
+	dem      *astits.Demuxer
+	videoPID *uint16
+	audioPID *uint16
+}
+
+// NewSegmentReader allocates a SegmentReader.
+func NewSegmentReader(byts []byte) (*SegmentReader, error) {
+	r := &SegmentReader{
+		dem: astits.NewDemuxer(context.Background(), bytes.NewReader(byts)),
+	}
+
+	// parse PMT
+	for {
+		data, err := r.dem.NextData()
+		if err != nil {
+			if err == astits.ErrNoMorePackets {
+				return nil, fmt.Errorf("PMT not found")
+			}
+			return nil, err
+		}
+
+		if data.PMT != nil {
+			for _, e := range data.PMT.ElementaryStreams {
+				switch e.StreamType {
+				case astits.StreamTypeH264Video:
+					if r.videoPID != nil {
+						return nil, fmt.Errorf("multiple video/audio tracks are not supported")
+					}
+
+					v := e.ElementaryPID
+					r.videoPID = &v
+
+				case astits.StreamTypeAACAudio:
+					if r.audioPID != nil {
+						return nil, fmt.Errorf("multiple video/audio tracks are not supported")
+					}
+
+					v := e.ElementaryPID
+					r.audioPID = &v
+				}
+			}
+			break
+		}
+	}
+
+	if r.videoPID == nil && r.audioPID == nil {
+		return nil, fmt.Errorf("segment doesn't contain tracks with supported codecs (H264 or AAC)")
+	}
+
+	return r, nil
+}
+
+// Read reads the next unit of data. It returns io.EOF when there's no more data.
+func (r *SegmentReader) Read() (*SegmentData, error) {
+	for {
+		data, err := r.dem.NextData()
+		if err != nil {
+			if err == astits.ErrNoMorePackets {
+				return nil, io.EOF
+			}
+			if strings.HasPrefix(err.Error(), "astits: parsing PES data failed") {
+				continue
+			}
+			return nil, err
+		}
+
+		if data.PES == nil {
+			continue
+		}
+
+		if data.PES.Header.OptionalHeader == nil ||
+			data.PES.Header.OptionalHeader.PTSDTSIndicator == astits.PTSDTSIndicatorNoPTSOrDTS ||
+			data.PES.Header.OptionalHeader.PTSDTSIndicator == astits.PTSDTSIndicatorIsForbidden {
+			return nil, fmt.Errorf("PTS is missing")
+		}
+
+		// remove the offset added by muxerTSSegment
+		pts := time.Duration(float64(data.PES.Header.OptionalHeader.PTS.Base)*float64(time.Second)/90000) -
+			pcrOffset
+
+		switch {
+		case r.videoPID != nil && data.PID == *r.videoPID:
+			dts := pts
+			if data.PES.Header.OptionalHeader.PTSDTSIndicator == astits.PTSDTSIndicatorBothPresent {
+				dts = time.Duration(float64(data.PES.Header.OptionalHeader.DTS.Base)*float64(time.Second)/90000) -
+					pcrOffset
+			}
+
+			nalus, err := h264.AnnexBDecode(data.PES.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			return &SegmentData{
+				H264NALUs: nalus,
+				PTS:       pts,
+				DTS:       dts,
+			}, nil
+
+		case r.audioPID != nil && data.PID == *r.audioPID:
+			pkts, err := aac.DecodeADTS(data.PES.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			return &SegmentData{
+				AACPackets: pkts,
+				PTS:        pts,
+				DTS:        pts,
+			}, nil
+		}
+	}
+}
+
+// SegmentTracks returns the tracks of a segment.
+// The parameters of the H264 track are filled with the first SPS and PPS.
+func SegmentTracks(byts []byte) (*gortsplib.TrackH264, *gortsplib.TrackAAC, error) {
+	r, err := NewSegmentReader(byts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sps []byte
+	var pps []byte
+	var audioPkt *aac.ADTSPacket
+
+	for (r.videoPID != nil && (sps == nil || pps == nil)) ||
+		(r.audioPID != nil && audioPkt == nil) {
+		data, err := r.Read()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, err
+		}
+
+		for _, nalu := range data.H264NALUs {
+			if len(nalu) == 0 {
+				continue
+			}
+
+			switch h264.NALUType(nalu[0] & 0x1F) {
+			case h264.NALUTypeSPS:
+				if sps == nil {
+					sps = nalu
+				}
+
+			case h264.NALUTypePPS:
+				if pps == nil {
+					pps = nalu
+				}
+			}
+		}
+
+		if audioPkt == nil && len(data.AACPackets) != 0 {
+			audioPkt = data.AACPackets[0]
+		}
+	}
+
+	var videoTrack *gortsplib.TrackH264
+	if r.videoPID != nil {
+		videoTrack, err = gortsplib.NewTrackH264(96, sps, pps, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var audioTrack *gortsplib.TrackAAC
+	if audioPkt != nil {
+		audioTrack, err = gortsplib.NewTrackAAC(97, audioPkt.Type, audioPkt.SampleRate,
+			audioPkt.ChannelCount, nil, 13, 3, 3)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return videoTrack, audioTrack, nil
+}
@@ -0,0 +1,46 @@
+package hls
+
+import (
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+)
+
+// Streamer writes a continuous MPEG-TS stream into a io.Writer,
+// instead of splitting it into segments.
+// The stream starts with the first IDR, and PAT and PMT are repeated
+// before every IDR, so that clients can start decoding at any time.
+type Streamer struct {
+	tsGenerator *muxerTSGenerator
+}
+
+// NewStreamer allocates a Streamer.
+func NewStreamer(
+	videoTrack *gortsplib.TrackH264,
+	audioTrack *gortsplib.TrackAAC,
+	w io.Writer,
+) *Streamer {
+	s := &Streamer{}
+
+	s.tsGenerator = newMuxerTSGenerator(
+		0,
+		0,
+		0,
+		videoTrack,
+		audioTrack,
+		func(t *muxerTSSegment) {})
+	s.tsGenerator.w = w
+
+	return s
+}
+
+// WriteH264 writes H264 NALUs, grouped by timestamp, into the stream.
+func (s *Streamer) WriteH264(pts time.Duration, nalus [][]byte) error {
+	return s.tsGenerator.writeH264(pts, nalus)
+}
+
+// WriteAAC writes AAC AUs, grouped by timestamp, into the stream.
+func (s *Streamer) WriteAAC(pts time.Duration, aus [][]byte) error {
+	return s.tsGenerator.writeAAC(pts, aus)
+}
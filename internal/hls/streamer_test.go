@@ -0,0 +1,106 @@
+package hls
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamer(t *testing.T) {
+	videoTrack, err := gortsplib.NewTrackH264(96, []byte{0x07, 0x01, 0x02, 0x03}, []byte{0x08}, nil)
+	require.NoError(t, err)
+
+	audioTrack, err := gortsplib.NewTrackAAC(97, 2, 44100, 2, nil, 13, 3, 3)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+
+	s := NewStreamer(videoTrack, audioTrack, &buf)
+
+	// group without IDR, skipped
+	err = s.WriteH264(1*time.Second, [][]byte{
+		{1},
+	})
+	require.NoError(t, err)
+
+	// audio before the first IDR, skipped
+	err = s.WriteAAC(1500*time.Millisecond, [][]byte{
+		{0x05, 0x06},
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 0, buf.Len())
+
+	// group with IDR
+	err = s.WriteH264(2*time.Second, [][]byte{
+		{7, 1, 2, 3}, // SPS
+		{8},          // PPS
+		{5},          // IDR
+	})
+	require.NoError(t, err)
+
+	// data is written without waiting for the end of the group
+	require.NotEqual(t, 0, buf.Len())
+
+	err = s.WriteAAC(2500*time.Millisecond, [][]byte{
+		{0x01, 0x02, 0x03, 0x04},
+	})
+	require.NoError(t, err)
+
+	// group without IDR
+	err = s.WriteH264(3*time.Second, [][]byte{
+		{1},
+	})
+	require.NoError(t, err)
+
+	// group with IDR
+	err = s.WriteH264(4*time.Second, [][]byte{
+		{5}, // IDR
+	})
+	require.NoError(t, err)
+
+	byts := buf.Bytes()
+
+	vt, at, err := SegmentTracks(byts)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7, 1, 2, 3}, vt.SPS())
+	require.Equal(t, []byte{8}, vt.PPS())
+	require.Equal(t, 44100, at.ClockRate())
+
+	sr, err := NewSegmentReader(byts)
+	require.NoError(t, err)
+
+	var video []*SegmentData
+	var audio []*SegmentData
+
+	for {
+		data, err := sr.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		if data.H264NALUs != nil {
+			video = append(video, data)
+		} else {
+			audio = append(audio, data)
+		}
+	}
+
+	require.Equal(t, 3, len(video))
+	require.Equal(t, time.Duration(0), video[0].PTS)
+	require.Equal(t, 1*time.Second, video[1].PTS)
+	require.Equal(t, [][]byte{
+		{9, 240}, // AUD
+		{1},
+	}, video[1].H264NALUs)
+	require.Equal(t, 2*time.Second, video[2].PTS)
+
+	require.Equal(t, 1, len(audio))
+	require.Equal(t, 500*time.Millisecond, audio[0].PTS)
+	require.Equal(t, []byte{0x01, 0x02, 0x03, 0x04}, audio[0].AACPackets[0].AU)
+}
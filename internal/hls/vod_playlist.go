@@ -0,0 +1,65 @@
+package hls
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// VODSegment is a segment listed in a VOD playlist.
+type VODSegment struct {
+	StartTime time.Time
+	Duration  time.Duration
+	URI       string
+
+	// whether timestamps of the segment are not contiguous with the ones
+	// of the previous segment.
+	Discontinuity bool
+}
+
+// VODPlaylist generates a playlist that lists a fixed group of segments.
+// If startOffset is greater than zero, players are asked to start playing
+// at this position.
+func VODPlaylist(segments []VODSegment, startOffset time.Duration) []byte {
+	cnt := "#EXTM3U\n"
+	cnt += "#EXT-X-VERSION:3\n"
+	cnt += "#EXT-X-PLAYLIST-TYPE:VOD\n"
+
+	targetDuration := func() uint {
+		ret := uint(0)
+
+		// EXTINF, when rounded to the nearest integer, must be <= EXT-X-TARGETDURATION
+		for _, s := range segments {
+			v2 := uint(math.Round(s.Duration.Seconds()))
+			if v2 > ret {
+				ret = v2
+			}
+		}
+
+		return ret
+	}()
+	cnt += "#EXT-X-TARGETDURATION:" + strconv.FormatUint(uint64(targetDuration), 10) + "\n"
+
+	cnt += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	cnt += "#EXT-X-INDEPENDENT-SEGMENTS\n"
+
+	if startOffset > 0 {
+		cnt += "#EXT-X-START:TIME-OFFSET=" + strconv.FormatFloat(startOffset.Seconds(), 'f', -1, 64) + "\n"
+	}
+
+	cnt += "\n"
+
+	for _, s := range segments {
+		if s.Discontinuity {
+			cnt += "#EXT-X-DISCONTINUITY\n"
+		}
+
+		cnt += "#EXT-X-PROGRAM-DATE-TIME:" + s.StartTime.Format("2006-01-02T15:04:05.999Z07:00") + "\n" +
+			"#EXTINF:" + strconv.FormatFloat(s.Duration.Seconds(), 'f', -1, 64) + ",\n" +
+			s.URI + "\n"
+	}
+
+	cnt += "#EXT-X-ENDLIST\n"
+
+	return []byte(cnt)
+}
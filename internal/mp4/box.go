@@ -0,0 +1,152 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// box is a ISO BMFF box.
+type box struct {
+	typ     string
+	payload []byte
+}
+
+// boxHeader is the header of a box that is still on disk.
+type boxHeader struct {
+	typ           string
+	payloadOffset int64
+	payloadSize   int64
+}
+
+// readAt reads len(buf) bytes from offset.
+func readAt(r io.ReaderAt, buf []byte, offset int64) error {
+	n, err := r.ReadAt(buf, offset)
+	if err != nil && (err != io.EOF || n != len(buf)) {
+		return err
+	}
+	return nil
+}
+
+// readBoxHeaders reads the headers of the boxes contained between offset and end,
+// without reading their payload.
+func readBoxHeaders(r io.ReaderAt, offset int64, end int64) ([]boxHeader, error) {
+	var ret []boxHeader
+	buf := make([]byte, 16)
+
+	for offset < end {
+		if end-offset < 8 {
+			return nil, fmt.Errorf("invalid box header")
+		}
+
+		err := readAt(r, buf[:8], offset)
+		if err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(buf))
+		typ := string(buf[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0: // box extends to the end of the file
+			size = end - offset
+
+		case 1: // 64-bit size
+			if end-offset < 16 {
+				return nil, fmt.Errorf("invalid box header")
+			}
+
+			err := readAt(r, buf[8:16], offset+8)
+			if err != nil {
+				return nil, err
+			}
+
+			size = int64(binary.BigEndian.Uint64(buf[8:]))
+			headerSize = 16
+		}
+
+		if size < headerSize || size > end-offset {
+			return nil, fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		ret = append(ret, boxHeader{
+			typ:           typ,
+			payloadOffset: offset + headerSize,
+			payloadSize:   size - headerSize,
+		})
+
+		offset += size
+	}
+
+	return ret, nil
+}
+
+// parseBoxes parses the boxes contained in a buffer.
+func parseBoxes(buf []byte) ([]box, error) {
+	var ret []box
+
+	for len(buf) > 0 {
+		if len(buf) < 8 {
+			return nil, fmt.Errorf("invalid box header")
+		}
+
+		size := uint64(binary.BigEndian.Uint32(buf))
+		typ := string(buf[4:8])
+		headerSize := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(buf))
+
+		case 1:
+			if len(buf) < 16 {
+				return nil, fmt.Errorf("invalid box header")
+			}
+			size = binary.BigEndian.Uint64(buf[8:])
+			headerSize = 16
+		}
+
+		if size < headerSize || size > uint64(len(buf)) {
+			return nil, fmt.Errorf("invalid size of box '%s'", typ)
+		}
+
+		ret = append(ret, box{
+			typ:     typ,
+			payload: buf[headerSize:size],
+		})
+
+		buf = buf[size:]
+	}
+
+	return ret, nil
+}
+
+// findBox returns the payload of the first box with the given type.
+func findBox(boxes []box, typ string) ([]byte, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b.payload, true
+		}
+	}
+	return nil, false
+}
+
+// findBoxPath returns the payload of the box that can be found
+// by following the given types, starting from buf.
+func findBoxPath(buf []byte, path ...string) ([]byte, error) {
+	for _, typ := range path {
+		boxes, err := parseBoxes(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		buf, ok = findBox(boxes, typ)
+		if !ok {
+			return nil, fmt.Errorf("box '%s' not found", typ)
+		}
+	}
+
+	return buf, nil
+}
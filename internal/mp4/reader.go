@@ -0,0 +1,670 @@
+// Package mp4 contains a MP4 reader.
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/aler9/gortsplib/pkg/aac"
+	"github.com/aler9/gortsplib/pkg/h264"
+)
+
+const (
+	// maximum size of the moov box, that is entirely loaded into memory.
+	maxMoovSize = 64 * 1024 * 1024
+)
+
+// Sample is a sample read from a MP4 file.
+type Sample struct {
+	// NALUs of a H264 access unit, if the sample belongs to the video track.
+	H264NALUs [][]byte
+
+	// AAC access unit, if the sample belongs to the audio track.
+	AACAU []byte
+
+	PTS time.Duration
+	DTS time.Duration
+}
+
+type trackSample struct {
+	offset    int64
+	size      uint32
+	dts       uint64
+	ptsOffset int32
+}
+
+type track struct {
+	timeScale uint64
+	samples   []trackSample
+	pos       int
+}
+
+func (t *track) duration(v int64) time.Duration {
+	ts := int64(t.timeScale)
+	return time.Duration(v/ts)*time.Second + time.Duration(v%ts)*time.Second/time.Duration(ts)
+}
+
+func (t *track) nextDTS() time.Duration {
+	return t.duration(int64(t.samples[t.pos].dts))
+}
+
+// Reader reads the samples of a non-fragmented MP4 file, in decoding order.
+// Only the first H264 track and the first AAC track are read.
+type Reader struct {
+	r          io.ReaderAt
+	videoTrack *gortsplib.TrackH264
+	audioTrack *gortsplib.TrackAAC
+	video      *track
+	audio      *track
+}
+
+// NewReader allocates a Reader.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	headers, err := readBoxHeaders(r, 0, size)
+	if err != nil {
+		return nil, err
+	}
+
+	var moov *boxHeader
+	for i, h := range headers {
+		switch h.typ {
+		case "moov":
+			moov = &headers[i]
+
+		case "moof":
+			return nil, fmt.Errorf("fragmented MP4 files are not supported")
+		}
+	}
+
+	if moov == nil {
+		return nil, fmt.Errorf("moov box not found")
+	}
+
+	if moov.payloadSize > maxMoovSize {
+		return nil, fmt.Errorf("moov box is too big")
+	}
+
+	buf := make([]byte, moov.payloadSize)
+	err = readAt(r, buf, moov.payloadOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	boxes, err := parseBoxes(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	mr := &Reader{
+		r: r,
+	}
+
+	for _, b := range boxes {
+		if b.typ != "trak" {
+			continue
+		}
+
+		err := mr.readTrak(b.payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if mr.video == nil && mr.audio == nil {
+		return nil, fmt.Errorf("file doesn't contain tracks with supported codecs (H264 or AAC)")
+	}
+
+	return mr, nil
+}
+
+func (mr *Reader) readTrak(buf []byte) error {
+	mdia, err := findBoxPath(buf, "mdia")
+	if err != nil {
+		return err
+	}
+
+	mdhd, err := findBoxPath(mdia, "mdhd")
+	if err != nil {
+		return err
+	}
+
+	timeScale, err := parseMDHD(mdhd)
+	if err != nil {
+		return err
+	}
+
+	stbl, err := findBoxPath(mdia, "minf", "stbl")
+	if err != nil {
+		return err
+	}
+
+	stsd, err := findBoxPath(stbl, "stsd")
+	if err != nil {
+		return err
+	}
+
+	entry, err := parseSTSD(stsd)
+	if err != nil {
+		return err
+	}
+
+	switch entry.typ {
+	case "avc1", "avc3":
+		if mr.video != nil {
+			return nil
+		}
+
+		videoTrack, err := parseAVC1(entry.payload)
+		if err != nil {
+			return err
+		}
+
+		t, err := readSampleTable(stbl, timeScale)
+		if err != nil {
+			return err
+		}
+
+		mr.videoTrack = videoTrack
+		mr.video = t
+
+	case "mp4a":
+		if mr.audio != nil {
+			return nil
+		}
+
+		audioTrack, err := parseMP4A(entry.payload)
+		if err != nil {
+			return err
+		}
+
+		t, err := readSampleTable(stbl, timeScale)
+		if err != nil {
+			return err
+		}
+
+		mr.audioTrack = audioTrack
+		mr.audio = t
+	}
+
+	return nil
+}
+
+// Tracks returns the tracks of the file.
+func (mr *Reader) Tracks() (*gortsplib.TrackH264, *gortsplib.TrackAAC) {
+	return mr.videoTrack, mr.audioTrack
+}
+
+// Read reads the next sample. It returns io.EOF when there are no more samples.
+func (mr *Reader) Read() (*Sample, error) {
+	var t *track
+
+	switch {
+	case mr.video != nil && mr.video.pos < len(mr.video.samples):
+		t = mr.video
+		if mr.audio != nil && mr.audio.pos < len(mr.audio.samples) &&
+			mr.audio.nextDTS() < mr.video.nextDTS() {
+			t = mr.audio
+		}
+
+	case mr.audio != nil && mr.audio.pos < len(mr.audio.samples):
+		t = mr.audio
+
+	default:
+		return nil, io.EOF
+	}
+
+	s := t.samples[t.pos]
+	t.pos++
+
+	buf := make([]byte, s.size)
+	err := readAt(mr.r, buf, s.offset)
+	if err != nil {
+		return nil, err
+	}
+
+	dts := t.duration(int64(s.dts))
+
+	if t == mr.audio {
+		return &Sample{
+			AACAU: buf,
+			PTS:   dts,
+			DTS:   dts,
+		}, nil
+	}
+
+	nalus, err := h264.AVCCDecode(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Sample{
+		H264NALUs: nalus,
+		PTS:       t.duration(int64(s.dts) + int64(s.ptsOffset)),
+		DTS:       dts,
+	}, nil
+}
+
+func parseMDHD(buf []byte) (uint64, error) {
+	var timeScale uint32
+
+	switch {
+	case len(buf) >= 20 && buf[0] == 0:
+		timeScale = binary.BigEndian.Uint32(buf[12:])
+
+	case len(buf) >= 32 && buf[0] == 1:
+		timeScale = binary.BigEndian.Uint32(buf[20:])
+
+	default:
+		return 0, fmt.Errorf("invalid mdhd box")
+	}
+
+	if timeScale == 0 {
+		return 0, fmt.Errorf("invalid time scale")
+	}
+
+	return uint64(timeScale), nil
+}
+
+// parseSTSD returns the first sample entry.
+func parseSTSD(buf []byte) (box, error) {
+	if len(buf) < 8 {
+		return box{}, fmt.Errorf("invalid stsd box")
+	}
+
+	entries, err := parseBoxes(buf[8:])
+	if err != nil {
+		return box{}, err
+	}
+
+	if len(entries) == 0 {
+		return box{}, fmt.Errorf("stsd box is empty")
+	}
+
+	return entries[0], nil
+}
+
+func parseAVC1(buf []byte) (*gortsplib.TrackH264, error) {
+	// size of the visual sample entry that precedes child boxes
+	if len(buf) < 78 {
+		return nil, fmt.Errorf("invalid avc1 box")
+	}
+
+	avcC, err := findBoxPath(buf[78:], "avcC")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(avcC) < 6 {
+		return nil, fmt.Errorf("invalid avcC box")
+	}
+
+	if (avcC[4]&0x03)+1 != 4 {
+		return nil, fmt.Errorf("unsupported NALU length size: %d", (avcC[4]&0x03)+1)
+	}
+
+	readParams := func(buf []byte, count int) ([]byte, []byte, error) {
+		var first []byte
+
+		for i := 0; i < count; i++ {
+			if len(buf) < 2 {
+				return nil, nil, fmt.Errorf("invalid avcC box")
+			}
+
+			le := int(binary.BigEndian.Uint16(buf))
+			buf = buf[2:]
+
+			if len(buf) < le {
+				return nil, nil, fmt.Errorf("invalid avcC box")
+			}
+
+			if first == nil {
+				first = buf[:le]
+			}
+			buf = buf[le:]
+		}
+
+		return first, buf, nil
+	}
+
+	sps, rest, err := readParams(avcC[6:], int(avcC[5]&0x1F))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rest) < 1 {
+		return nil, fmt.Errorf("invalid avcC box")
+	}
+
+	pps, _, err := readParams(rest[1:], int(rest[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	if sps == nil || pps == nil {
+		return nil, fmt.Errorf("SPS or PPS not found")
+	}
+
+	return gortsplib.NewTrackH264(96, sps, pps, nil)
+}
+
+func parseMP4A(buf []byte) (*gortsplib.TrackAAC, error) {
+	// size of the audio sample entry that precedes child boxes
+	if len(buf) < 28 {
+		return nil, fmt.Errorf("invalid mp4a box")
+	}
+
+	childrenOffset := 28
+	switch binary.BigEndian.Uint16(buf[8:]) {
+	case 1:
+		childrenOffset = 44
+	case 2:
+		childrenOffset = 64
+	}
+
+	if len(buf) < childrenOffset {
+		return nil, fmt.Errorf("invalid mp4a box")
+	}
+
+	children, err := parseBoxes(buf[childrenOffset:])
+	if err != nil {
+		return nil, err
+	}
+
+	esds, ok := findBox(children, "esds")
+	if !ok {
+		// QuickTime files put esds into a wave box
+		esds, err = findBoxPath(buf[childrenOffset:], "wave", "esds")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := parseESDS(esds)
+	if err != nil {
+		return nil, err
+	}
+
+	var mpegConf aac.MPEG4AudioConfig
+	err = mpegConf.Decode(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return gortsplib.NewTrackAAC(97, int(mpegConf.Type), mpegConf.SampleRate,
+		mpegConf.ChannelCount, mpegConf.AOTSpecificConfig, 13, 3, 3)
+}
+
+// readDescriptor reads a MPEG-4 descriptor, and returns its tag, its payload
+// and the remaining bytes.
+func readDescriptor(buf []byte) (byte, []byte, []byte, error) {
+	if len(buf) < 2 {
+		return 0, nil, nil, fmt.Errorf("invalid descriptor")
+	}
+
+	tag := buf[0]
+	buf = buf[1:]
+
+	le := 0
+	for i := 0; ; i++ {
+		if i == 4 || len(buf) == 0 {
+			return 0, nil, nil, fmt.Errorf("invalid descriptor")
+		}
+
+		b := buf[0]
+		buf = buf[1:]
+		le = le<<7 | int(b&0x7F)
+
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	if len(buf) < le {
+		return 0, nil, nil, fmt.Errorf("invalid descriptor")
+	}
+
+	return tag, buf[:le], buf[le:], nil
+}
+
+// findDescriptor returns the payload of the first descriptor with the given tag.
+func findDescriptor(buf []byte, tag byte) ([]byte, error) {
+	for len(buf) > 0 {
+		t, payload, rest, err := readDescriptor(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if t == tag {
+			return payload, nil
+		}
+
+		buf = rest
+	}
+
+	return nil, fmt.Errorf("descriptor %d not found", tag)
+}
+
+// parseESDS returns the AudioSpecificConfig contained in a esds box.
+func parseESDS(buf []byte) ([]byte, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("invalid esds box")
+	}
+
+	es, err := findDescriptor(buf[4:], 0x03)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(es) < 3 {
+		return nil, fmt.Errorf("invalid ES descriptor")
+	}
+
+	flags := es[2]
+	pos := 3
+	if flags&0x80 != 0 { // stream dependence
+		pos += 2
+	}
+	if flags&0x40 != 0 { // URL
+		if len(es) <= pos {
+			return nil, fmt.Errorf("invalid ES descriptor")
+		}
+		pos += 1 + int(es[pos])
+	}
+	if flags&0x20 != 0 { // OCR stream
+		pos += 2
+	}
+
+	if len(es) < pos {
+		return nil, fmt.Errorf("invalid ES descriptor")
+	}
+
+	dc, err := findDescriptor(es[pos:], 0x04)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(dc) < 13 {
+		return nil, fmt.Errorf("invalid decoder config descriptor")
+	}
+
+	if dc[0] != 0x40 {
+		return nil, fmt.Errorf("unsupported object type: %d", dc[0])
+	}
+
+	return findDescriptor(dc[13:], 0x05)
+}
+
+// readFullBoxEntries returns the entries of a full box that contains a
+// 32-bit entry count followed by entries of fixed size.
+func readFullBoxEntries(buf []byte, typ string, headerSize int, entrySize int) ([]byte, int, error) {
+	if len(buf) < headerSize {
+		return nil, 0, fmt.Errorf("invalid %s box", typ)
+	}
+
+	count := uint64(binary.BigEndian.Uint32(buf[headerSize-4:]))
+	if uint64(len(buf)-headerSize) < count*uint64(entrySize) {
+		return nil, 0, fmt.Errorf("invalid %s box", typ)
+	}
+
+	return buf[headerSize:], int(count), nil
+}
+
+func readSampleTable(stbl []byte, timeScale uint64) (*track, error) {
+	boxes, err := parseBoxes(stbl)
+	if err != nil {
+		return nil, err
+	}
+
+	// sizes
+
+	stsz, ok := findBox(boxes, "stsz")
+	if !ok {
+		return nil, fmt.Errorf("box 'stsz' not found")
+	}
+
+	if len(stsz) < 12 {
+		return nil, fmt.Errorf("invalid stsz box")
+	}
+
+	sampleSize := binary.BigEndian.Uint32(stsz[4:])
+	sampleCount := int(binary.BigEndian.Uint32(stsz[8:]))
+
+	if sampleSize == 0 && uint64(len(stsz)-12) < uint64(sampleCount)*4 {
+		return nil, fmt.Errorf("invalid stsz box")
+	}
+
+	samples := make([]trackSample, sampleCount)
+	for i := range samples {
+		if sampleSize != 0 {
+			samples[i].size = sampleSize
+		} else {
+			samples[i].size = binary.BigEndian.Uint32(stsz[12+i*4:])
+		}
+	}
+
+	// chunk offsets
+
+	var chunkOffsets []int64
+
+	if stco, ok := findBox(boxes, "stco"); ok {
+		entries, count, err := readFullBoxEntries(stco, "stco", 8, 4)
+		if err != nil {
+			return nil, err
+		}
+
+		chunkOffsets = make([]int64, count)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = int64(binary.BigEndian.Uint32(entries[i*4:]))
+		}
+	} else if co64, ok := findBox(boxes, "co64"); ok {
+		entries, count, err := readFullBoxEntries(co64, "co64", 8, 8)
+		if err != nil {
+			return nil, err
+		}
+
+		chunkOffsets = make([]int64, count)
+		for i := range chunkOffsets {
+			chunkOffsets[i] = int64(binary.BigEndian.Uint64(entries[i*8:]))
+		}
+	} else {
+		return nil, fmt.Errorf("box 'stco' not found")
+	}
+
+	// samples per chunk
+
+	stsc, ok := findBox(boxes, "stsc")
+	if !ok {
+		return nil, fmt.Errorf("box 'stsc' not found")
+	}
+
+	stscEntries, stscCount, err := readFullBoxEntries(stsc, "stsc", 8, 12)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleIndex := 0
+	for i := 0; i < stscCount && sampleIndex < sampleCount; i++ {
+		firstChunk := int(binary.BigEndian.Uint32(stscEntries[i*12:]))
+		samplesPerChunk := int(binary.BigEndian.Uint32(stscEntries[i*12+4:]))
+
+		lastChunk := len(chunkOffsets) + 1
+		if i+1 < stscCount {
+			lastChunk = int(binary.BigEndian.Uint32(stscEntries[(i+1)*12:]))
+		}
+
+		if firstChunk < 1 || lastChunk > len(chunkOffsets)+1 {
+			return nil, fmt.Errorf("invalid stsc box")
+		}
+
+		for chunk := firstChunk; chunk < lastChunk && sampleIndex < sampleCount; chunk++ {
+			offset := chunkOffsets[chunk-1]
+
+			for j := 0; j < samplesPerChunk && sampleIndex < sampleCount; j++ {
+				samples[sampleIndex].offset = offset
+				offset += int64(samples[sampleIndex].size)
+				sampleIndex++
+			}
+		}
+	}
+
+	if sampleIndex != sampleCount {
+		return nil, fmt.Errorf("invalid stsc box")
+	}
+
+	// decoding timestamps
+
+	stts, ok := findBox(boxes, "stts")
+	if !ok {
+		return nil, fmt.Errorf("box 'stts' not found")
+	}
+
+	sttsEntries, sttsCount, err := readFullBoxEntries(stts, "stts", 8, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleIndex = 0
+	dts := uint64(0)
+	for i := 0; i < sttsCount && sampleIndex < sampleCount; i++ {
+		count := int(binary.BigEndian.Uint32(sttsEntries[i*8:]))
+		delta := uint64(binary.BigEndian.Uint32(sttsEntries[i*8+4:]))
+
+		for j := 0; j < count && sampleIndex < sampleCount; j++ {
+			samples[sampleIndex].dts = dts
+			dts += delta
+			sampleIndex++
+		}
+	}
+
+	if sampleIndex != sampleCount {
+		return nil, fmt.Errorf("invalid stts box")
+	}
+
+	// composition offsets
+
+	if ctts, ok := findBox(boxes, "ctts"); ok {
+		cttsEntries, cttsCount, err := readFullBoxEntries(ctts, "ctts", 8, 8)
+		if err != nil {
+			return nil, err
+		}
+
+		sampleIndex = 0
+		for i := 0; i < cttsCount && sampleIndex < sampleCount; i++ {
+			count := int(binary.BigEndian.Uint32(cttsEntries[i*8:]))
+			offset := int32(binary.BigEndian.Uint32(cttsEntries[i*8+4:]))
+
+			for j := 0; j < count && sampleIndex < sampleCount; j++ {
+				samples[sampleIndex].ptsOffset = offset
+				sampleIndex++
+			}
+		}
+	}
+
+	return &track{
+		timeScale: timeScale,
+		samples:   samples,
+	}, nil
+}
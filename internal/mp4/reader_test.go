@@ -0,0 +1,193 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testBox(typ string, payloads ...[]byte) []byte {
+	var buf bytes.Buffer
+	size := 8
+	for _, p := range payloads {
+		size += len(p)
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(size))
+	buf.WriteString(typ)
+	for _, p := range payloads {
+		buf.Write(p)
+	}
+	return buf.Bytes()
+}
+
+func testUint32s(vals ...uint32) []byte {
+	buf := make([]byte, len(vals)*4)
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
+
+func testTrak(timeScale uint32, entry []byte, sizes []uint32, offset uint32,
+	deltas []uint32, ptsOffsets []uint32,
+) []byte {
+	mdhd := append([]byte{0, 0, 0, 0}, testUint32s(0, 0, timeScale, 0, 0)...)
+
+	stszVals := []uint32{0, 0, uint32(len(sizes))}
+	stszVals = append(stszVals, sizes...)
+
+	sttsVals := []uint32{0, uint32(len(deltas))}
+	for _, d := range deltas {
+		sttsVals = append(sttsVals, 1, d)
+	}
+
+	stblChildren := [][]byte{
+		testBox("stsd", testUint32s(0, 1), entry),
+		testBox("stsz", testUint32s(stszVals...)),
+		testBox("stco", testUint32s(0, 1, offset)),
+		testBox("stsc", testUint32s(0, 1, 1, uint32(len(sizes)), 1)),
+		testBox("stts", testUint32s(sttsVals...)),
+	}
+
+	if ptsOffsets != nil {
+		cttsVals := []uint32{0, uint32(len(ptsOffsets))}
+		for _, o := range ptsOffsets {
+			cttsVals = append(cttsVals, 1, o)
+		}
+		stblChildren = append(stblChildren, testBox("ctts", testUint32s(cttsVals...)))
+	}
+
+	return testBox("trak",
+		testBox("mdia",
+			testBox("mdhd", mdhd),
+			testBox("minf",
+				testBox("stbl", stblChildren...))))
+}
+
+func TestReader(t *testing.T) {
+	sps := []byte{
+		0x67, 0x64, 0x00, 0x0c, 0xac, 0x3b, 0x50, 0xb0,
+		0x4b, 0x42, 0x00, 0x00, 0x03, 0x00, 0x02, 0x00,
+		0x00, 0x03, 0x00, 0x3d, 0x08,
+	}
+	pps := []byte{0x68, 0xee, 0x3c, 0x80}
+
+	avcC := []byte{1, 0x64, 0x00, 0x0c, 0xff, 0xe1, 0x00, byte(len(sps))}
+	avcC = append(avcC, sps...)
+	avcC = append(avcC, 1, 0x00, byte(len(pps)))
+	avcC = append(avcC, pps...)
+	avc1 := testBox("avc1", make([]byte, 78), testBox("avcC", avcC))
+
+	esds := []byte{
+		0, 0, 0, 0,
+		0x03, 25, 0, 1, 0,
+		0x04, 17, 0x40, 0x15, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0x05, 2, 0x12, 0x10,
+		0x06, 1, 2,
+	}
+	mp4a := testBox("mp4a", make([]byte, 28), testBox("esds", esds))
+
+	videoSamples := [][]byte{
+		{0, 0, 0, 2, 0x65, 0x01},
+		{0, 0, 0, 2, 0x41, 0x02},
+	}
+	audioSamples := [][]byte{
+		{0x21, 0x01},
+		{0x21, 0x02},
+	}
+
+	var mdatPayload []byte
+	for _, s := range append(videoSamples, audioSamples...) {
+		mdatPayload = append(mdatPayload, s...)
+	}
+
+	ftyp := testBox("ftyp", []byte("isom"), testUint32s(0x200))
+	mdat := testBox("mdat", mdatPayload)
+	videoOffset := uint32(len(ftyp) + 8)
+	audioOffset := videoOffset + 12
+
+	moov := testBox("moov",
+		testTrak(90000, avc1, []uint32{6, 6}, videoOffset, []uint32{3000, 3000}, []uint32{3000, 0}),
+		testTrak(44100, mp4a, []uint32{2, 2}, audioOffset, []uint32{1024, 1024}, nil))
+
+	file := append(append(ftyp, mdat...), moov...)
+
+	r, err := NewReader(bytes.NewReader(file), int64(len(file)))
+	require.NoError(t, err)
+
+	videoTrack, audioTrack := r.Tracks()
+	require.Equal(t, sps, videoTrack.SPS())
+	require.Equal(t, pps, videoTrack.PPS())
+	require.Equal(t, 44100, audioTrack.ClockRate())
+
+	var samples []*Sample
+	for {
+		s, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		samples = append(samples, s)
+	}
+
+	require.Equal(t, []*Sample{
+		{
+			H264NALUs: [][]byte{{0x65, 0x01}},
+			PTS:       33333333 * time.Nanosecond,
+			DTS:       0,
+		},
+		{
+			AACAU: []byte{0x21, 0x01},
+			PTS:   0,
+			DTS:   0,
+		},
+		{
+			AACAU: []byte{0x21, 0x02},
+			PTS:   23219954 * time.Nanosecond,
+			DTS:   23219954 * time.Nanosecond,
+		},
+		{
+			H264NALUs: [][]byte{{0x41, 0x02}},
+			PTS:       33333333 * time.Nanosecond,
+			DTS:       33333333 * time.Nanosecond,
+		},
+	}, samples)
+}
+
+func TestReaderErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name string
+		file []byte
+		err  string
+	}{
+		{
+			"no moov",
+			testBox("ftyp", []byte("isom")),
+			"moov box not found",
+		},
+		{
+			"fragmented",
+			append(testBox("moov"), testBox("moof")...),
+			"fragmented MP4 files are not supported",
+		},
+		{
+			"no tracks",
+			testBox("moov"),
+			"file doesn't contain tracks with supported codecs (H264 or AAC)",
+		},
+		{
+			"invalid size",
+			[]byte{0, 0, 0, 100, 'm', 'o', 'o', 'v'},
+			"invalid size of box 'moov'",
+		},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(ca.file), int64(len(ca.file)))
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package rtpjpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pion/rtp"
+
+	"github.com/aler9/gortsplib/pkg/rtptimedec"
+)
+
+// ErrMorePacketsNeeded is returned when more packets are needed.
+var ErrMorePacketsNeeded = errors.New("need more packets")
+
+// ErrNonStartingPacketAndNoPrevious is returned when we received a non-starting
+// packet of an image and we didn't received anything before.
+// It's normal to receive this when we are decoding a stream that has been already
+// running for some time.
+var ErrNonStartingPacketAndNoPrevious = errors.New(
+	"received a non-starting packet without any previous starting packet")
+
+// maximum size of a JPEG image.
+const maxImageSize = 10 * 1024 * 1024
+
+// Decoder is a RTP/JPEG decoder.
+type Decoder struct {
+	timeDecoder *rtptimedec.Decoder
+	frame       *frame
+	timestamp   uint32
+}
+
+// Init initializes the decoder.
+func (d *Decoder) Init() {
+	d.timeDecoder = rtptimedec.New(rtpClockRate)
+}
+
+// Decode decodes a JPEG image from RTP/JPEG packets.
+// It returns ErrMorePacketsNeeded until the last packet of the image is received.
+func (d *Decoder) Decode(pkt *rtp.Packet) ([]byte, time.Duration, error) {
+	payload := pkt.Payload
+
+	if len(payload) < 8 {
+		d.frame = nil
+		return nil, 0, fmt.Errorf("payload is too short")
+	}
+
+	offset := uint24(payload[1:])
+	typ := payload[4]
+	q := payload[5]
+	width := int(payload[6]) * 8
+	height := int(payload[7]) * 8
+	payload = payload[8:]
+
+	var restartInterval uint16
+	if typ >= 64 && typ <= 127 {
+		if len(payload) < 4 {
+			d.frame = nil
+			return nil, 0, fmt.Errorf("payload is too short")
+		}
+		restartInterval = binary.BigEndian.Uint16(payload)
+		payload = payload[4:]
+		typ -= 64
+	}
+
+	if typ > 1 {
+		d.frame = nil
+		return nil, 0, fmt.Errorf("unsupported type: %d", typ)
+	}
+
+	if offset == 0 {
+		var tables []byte
+
+		if q >= 128 {
+			if len(payload) < 4 {
+				d.frame = nil
+				return nil, 0, fmt.Errorf("payload is too short")
+			}
+
+			if payload[1] != 0 {
+				d.frame = nil
+				return nil, 0, fmt.Errorf("16-bit quantization tables are not supported")
+			}
+
+			le := int(binary.BigEndian.Uint16(payload[2:]))
+			payload = payload[4:]
+
+			if le != 128 || len(payload) < le {
+				d.frame = nil
+				return nil, 0, fmt.Errorf("invalid quantization tables")
+			}
+
+			tables = append([]byte(nil), payload[:le]...)
+			payload = payload[le:]
+		} else {
+			tables = makeQuantizationTables(q)
+		}
+
+		d.frame = &frame{
+			typ:                typ,
+			width:              width,
+			height:             height,
+			restartInterval:    restartInterval,
+			quantizationTables: tables,
+		}
+		d.timestamp = pkt.Timestamp
+	} else {
+		if d.frame == nil {
+			return nil, 0, ErrNonStartingPacketAndNoPrevious
+		}
+
+		if pkt.Timestamp != d.timestamp || offset != len(d.frame.scan) {
+			d.frame = nil
+			return nil, 0, fmt.Errorf("discarding frame since a RTP packet is missing")
+		}
+	}
+
+	if (len(d.frame.scan) + len(payload)) > maxImageSize {
+		d.frame = nil
+		return nil, 0, fmt.Errorf("image size exceeds maximum size (%d)", maxImageSize)
+	}
+
+	d.frame.scan = append(d.frame.scan, payload...)
+
+	if !pkt.Marker {
+		return nil, 0, ErrMorePacketsNeeded
+	}
+
+	f := d.frame
+	d.frame = nil
+
+	return f.marshal(), d.timeDecoder.Decode(pkt.Timestamp), nil
+}
@@ -0,0 +1,137 @@
+package rtpjpeg
+
+import (
+	"crypto/rand"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func randUint32() uint32 {
+	var b [4]byte
+	rand.Read(b[:])
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// Encoder is a RTP/JPEG encoder.
+type Encoder struct {
+	// SSRC of packets (optional).
+	// It defaults to a random value.
+	SSRC *uint32
+
+	// initial sequence number of packets (optional).
+	// It defaults to a random value.
+	InitialSequenceNumber *uint16
+
+	// initial timestamp of packets (optional).
+	// It defaults to a random value.
+	InitialTimestamp *uint32
+
+	// maximum size of packet payloads (optional).
+	// It defaults to 1460.
+	PayloadMaxSize int
+
+	sequenceNumber uint16
+}
+
+// Init initializes the encoder.
+func (e *Encoder) Init() {
+	if e.SSRC == nil {
+		v := randUint32()
+		e.SSRC = &v
+	}
+	if e.InitialSequenceNumber == nil {
+		v := uint16(randUint32())
+		e.InitialSequenceNumber = &v
+	}
+	if e.InitialTimestamp == nil {
+		v := randUint32()
+		e.InitialTimestamp = &v
+	}
+	if e.PayloadMaxSize == 0 {
+		e.PayloadMaxSize = 1460 // 1500 (UDP MTU) - 20 (IP header) - 8 (UDP header) - 12 (RTP header)
+	}
+
+	e.sequenceNumber = *e.InitialSequenceNumber
+}
+
+func (e *Encoder) encodeTimestamp(ts time.Duration) uint32 {
+	return *e.InitialTimestamp + uint32(ts.Seconds()*rtpClockRate)
+}
+
+// Encode encodes a JPEG image into RTP/JPEG packets.
+func (e *Encoder) Encode(image []byte, pts time.Duration) ([]*rtp.Packet, error) {
+	var f frame
+	err := f.unmarshal(image)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := f.typ
+	if f.restartInterval != 0 {
+		typ += 64
+	}
+
+	ts := e.encodeTimestamp(pts)
+	data := f.scan
+	offset := 0
+	var rets []*rtp.Packet
+
+	for {
+		header := []byte{
+			0,
+			byte(offset >> 16), byte(offset >> 8), byte(offset),
+			typ,
+			255, // quantization tables are sent in-band
+			byte((f.width + 7) / 8),
+			byte((f.height + 7) / 8),
+		}
+
+		if f.restartInterval != 0 {
+			// the restart count is not used, since packets are
+			// not aligned with restart intervals
+			header = append(header, byte(f.restartInterval>>8), byte(f.restartInterval), 0xFF, 0xFF)
+		}
+
+		if offset == 0 {
+			header = append(header, 0, 0, 0, 128)
+			header = append(header, f.quantizationTables...)
+		}
+
+		avail := e.PayloadMaxSize - len(header)
+		if avail <= 0 {
+			avail = 1
+		}
+
+		le := len(data)
+		if le > avail {
+			le = avail
+		}
+
+		payload := make([]byte, len(header)+le)
+		n := copy(payload, header)
+		copy(payload[n:], data[:le])
+
+		data = data[le:]
+		offset += le
+
+		rets = append(rets, &rtp.Packet{
+			Header: rtp.Header{
+				Version:        rtpVersion,
+				PayloadType:    PayloadType,
+				SequenceNumber: e.sequenceNumber,
+				Timestamp:      ts,
+				SSRC:           *e.SSRC,
+				Marker:         len(data) == 0,
+			},
+			Payload: payload,
+		})
+		e.sequenceNumber++
+
+		if len(data) == 0 {
+			break
+		}
+	}
+
+	return rets, nil
+}
@@ -0,0 +1,355 @@
+package rtpjpeg
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// JPEG markers.
+const (
+	markerSOF0 = 0xC0
+	markerDHT  = 0xC4
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerDQT  = 0xDB
+	markerDRI  = 0xDD
+)
+
+// huffmanTable is a Huffman table of a JPEG image.
+type huffmanTable struct {
+	class   byte // table class and destination
+	counts  [16]byte
+	symbols []byte
+}
+
+// RFC 2435 doesn't transmit Huffman tables, that are assumed to be the ones
+// of section K.3 of the JPEG standard.
+var standardHuffmanTables = []huffmanTable{
+	// luminance DC
+	{
+		0x00,
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{
+			0, 1, 2, 3, 4, 5, 6, 7,
+			8, 9, 10, 11,
+		},
+	},
+	// luminance AC
+	{
+		0x10,
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	// chrominance DC
+	{
+		0x01,
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{
+			0, 1, 2, 3, 4, 5, 6, 7,
+			8, 9, 10, 11,
+		},
+	},
+	// chrominance AC
+	{
+		0x11,
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// default quantization tables, in zig-zag order (RFC 2435, appendix A).
+var (
+	defaultLumaQuantizer = [64]byte{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	}
+
+	defaultChromaQuantizer = [64]byte{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
+
+// makeQuantizationTables generates the quantization tables that correspond
+// to a Q factor between 1 and 99 (RFC 2435, appendix A).
+func makeQuantizationTables(q byte) []byte {
+	factor := int(q)
+	if factor < 1 {
+		factor = 1
+	} else if factor > 99 {
+		factor = 99
+	}
+
+	if factor < 50 {
+		factor = 5000 / factor
+	} else {
+		factor = 200 - factor*2
+	}
+
+	ret := make([]byte, 128)
+	for i := 0; i < 64; i++ {
+		for j, tbl := range [][64]byte{defaultLumaQuantizer, defaultChromaQuantizer} {
+			v := (int(tbl[i])*factor + 50) / 100
+			if v < 1 {
+				v = 1
+			} else if v > 255 {
+				v = 255
+			}
+			ret[j*64+i] = byte(v)
+		}
+	}
+	return ret
+}
+
+// frame contains the content of a JPEG image, split into the fields
+// that are transmitted with RFC 2435.
+type frame struct {
+	typ                byte
+	width              int
+	height             int
+	restartInterval    uint16
+	quantizationTables []byte // two tables of 64 bytes, in zig-zag order
+	scan               []byte // entropy-coded data
+}
+
+// unmarshal decodes a baseline JPEG image.
+func (f *frame) unmarshal(buf []byte) error {
+	if len(buf) < 2 || buf[0] != 0xFF || buf[1] != markerSOI {
+		return fmt.Errorf("SOI not found")
+	}
+	buf = buf[2:]
+
+	tables := make(map[byte][]byte)
+	var componentTables [3]byte
+	sofFound := false
+
+	for {
+		// skip fill bytes
+		for len(buf) >= 2 && buf[0] == 0xFF && buf[1] == 0xFF {
+			buf = buf[1:]
+		}
+
+		if len(buf) < 4 || buf[0] != 0xFF {
+			return fmt.Errorf("invalid marker")
+		}
+
+		marker := buf[1]
+		size := int(binary.BigEndian.Uint16(buf[2:]))
+		if size < 2 || len(buf) < 2+size {
+			return fmt.Errorf("invalid marker size")
+		}
+		payload := buf[4 : 2+size]
+		buf = buf[2+size:]
+
+		switch {
+		case marker == markerDQT:
+			for len(payload) > 0 {
+				if (payload[0] >> 4) != 0 {
+					return fmt.Errorf("16-bit quantization tables are not supported")
+				}
+				if len(payload) < 65 {
+					return fmt.Errorf("invalid quantization table")
+				}
+				tables[payload[0]&0x0F] = payload[1:65]
+				payload = payload[65:]
+			}
+
+		case marker == markerSOF0:
+			if len(payload) < 6 {
+				return fmt.Errorf("invalid SOF")
+			}
+
+			if payload[0] != 8 {
+				return fmt.Errorf("precision %d is not supported", payload[0])
+			}
+
+			f.height = int(binary.BigEndian.Uint16(payload[1:]))
+			f.width = int(binary.BigEndian.Uint16(payload[3:]))
+
+			if f.width == 0 || f.height == 0 ||
+				f.width > maxDimension || f.height > maxDimension {
+				return fmt.Errorf("unsupported size: %dx%d", f.width, f.height)
+			}
+
+			if payload[5] != 3 || len(payload) < 15 {
+				return fmt.Errorf("only images with 3 components are supported")
+			}
+
+			switch payload[7] {
+			case 0x21:
+				f.typ = 0
+
+			case 0x22:
+				f.typ = 1
+
+			default:
+				return fmt.Errorf("unsupported sampling factors: 0x%.2x", payload[7])
+			}
+
+			if payload[10] != 0x11 || payload[13] != 0x11 {
+				return fmt.Errorf("unsupported chroma sampling factors")
+			}
+
+			for i := 0; i < 3; i++ {
+				componentTables[i] = payload[8+i*3]
+			}
+
+			sofFound = true
+
+		case marker >= 0xC1 && marker <= 0xCF && marker != markerDHT && marker != 0xC8 && marker != 0xCC:
+			return fmt.Errorf("only baseline JPEG images are supported")
+
+		case marker == markerDRI:
+			if len(payload) < 2 {
+				return fmt.Errorf("invalid DRI")
+			}
+			f.restartInterval = binary.BigEndian.Uint16(payload)
+
+		case marker == markerSOS:
+			if !sofFound {
+				return fmt.Errorf("SOF not found")
+			}
+
+			if componentTables[1] != componentTables[2] || componentTables[0] == componentTables[1] {
+				return fmt.Errorf("unsupported quantization table assignment")
+			}
+
+			luma, ok := tables[componentTables[0]]
+			if !ok {
+				return fmt.Errorf("quantization table %d not found", componentTables[0])
+			}
+
+			chroma, ok := tables[componentTables[1]]
+			if !ok {
+				return fmt.Errorf("quantization table %d not found", componentTables[1])
+			}
+
+			f.quantizationTables = append(append([]byte(nil), luma...), chroma...)
+
+			// remove EOI
+			if len(buf) >= 2 && buf[len(buf)-2] == 0xFF && buf[len(buf)-1] == markerEOI {
+				buf = buf[:len(buf)-2]
+			}
+
+			f.scan = buf
+			return nil
+		}
+	}
+}
+
+func appendMarker(buf []byte, marker byte, payloads ...[]byte) []byte {
+	size := 2
+	for _, p := range payloads {
+		size += len(p)
+	}
+
+	buf = append(buf, 0xFF, marker, byte(size>>8), byte(size))
+	for _, p := range payloads {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// marshal encodes a baseline JPEG image.
+func (f frame) marshal() []byte {
+	buf := make([]byte, 0, 1024+len(f.scan))
+	buf = append(buf, 0xFF, markerSOI)
+
+	buf = appendMarker(buf, markerDQT,
+		[]byte{0}, f.quantizationTables[:64],
+		[]byte{1}, f.quantizationTables[64:128])
+
+	if f.restartInterval != 0 {
+		buf = appendMarker(buf, markerDRI,
+			[]byte{byte(f.restartInterval >> 8), byte(f.restartInterval)})
+	}
+
+	lumaSampling := byte(0x21)
+	if f.typ == 1 {
+		lumaSampling = 0x22
+	}
+
+	buf = appendMarker(buf, markerSOF0, []byte{
+		8,
+		byte(f.height >> 8), byte(f.height),
+		byte(f.width >> 8), byte(f.width),
+		3,
+		1, lumaSampling, 0,
+		2, 0x11, 1,
+		3, 0x11, 1,
+	})
+
+	for _, t := range standardHuffmanTables {
+		buf = appendMarker(buf, markerDHT, []byte{t.class}, t.counts[:], t.symbols)
+	}
+
+	buf = appendMarker(buf, markerSOS, []byte{
+		3,
+		1, 0x00,
+		2, 0x11,
+		3, 0x11,
+		0, 63, 0,
+	})
+
+	buf = append(buf, f.scan...)
+	buf = append(buf, 0xFF, markerEOI)
+
+	return buf
+}
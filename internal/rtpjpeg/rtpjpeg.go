@@ -0,0 +1,56 @@
+// Package rtpjpeg contains a RTP/JPEG decoder and encoder (RFC 2435).
+package rtpjpeg
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/aler9/gortsplib"
+)
+
+const (
+	rtpVersion   = 0x02
+	rtpClockRate = 90000 // JPEG always uses 90khz
+
+	// PayloadType is the static payload type of JPEG.
+	PayloadType = 26
+
+	// maximum width and height that can be transmitted.
+	maxDimension = 2040
+)
+
+// NewTrack allocates a JPEG track.
+func NewTrack() *gortsplib.TrackGeneric {
+	track, _ := gortsplib.NewTrackGeneric("video", []string{"26"}, "26 JPEG/90000", "")
+	return track
+}
+
+// IsTrack checks whether a track is a JPEG track.
+func IsTrack(track gortsplib.Track) bool {
+	tt, ok := track.(*gortsplib.TrackGeneric)
+	if !ok {
+		return false
+	}
+
+	md := tt.MediaDescription()
+	if md.MediaName.Media != "video" || len(md.MediaName.Formats) != 1 {
+		return false
+	}
+
+	if md.MediaName.Formats[0] == "26" {
+		return true
+	}
+
+	for _, attr := range md.Attributes {
+		if attr.Key == "rtpmap" {
+			tmp := strings.SplitN(attr.Value, " ", 2)
+			return len(tmp) == 2 && strings.HasPrefix(strings.ToUpper(tmp[1]), "JPEG/")
+		}
+	}
+
+	return false
+}
+
+func uint24(buf []byte) int {
+	return int(buf[0])<<16 | int(binary.BigEndian.Uint16(buf[1:]))
+}
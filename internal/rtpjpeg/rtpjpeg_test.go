@@ -0,0 +1,143 @@
+package rtpjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), uint8(x + y), 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	require.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestEncodeDecode(t *testing.T) {
+	enc := &Encoder{
+		SSRC: func() *uint32 {
+			v := uint32(0x9dbb7812)
+			return &v
+		}(),
+		InitialSequenceNumber: func() *uint16 {
+			v := uint16(0x44ed)
+			return &v
+		}(),
+		InitialTimestamp: func() *uint32 {
+			v := uint32(0x88776655)
+			return &v
+		}(),
+	}
+	enc.Init()
+
+	byts := testImage(t, 320, 240)
+
+	pkts, err := enc.Encode(byts, 2*time.Second)
+	require.NoError(t, err)
+	require.Greater(t, len(pkts), 1)
+
+	for i, pkt := range pkts {
+		require.Equal(t, uint8(PayloadType), pkt.PayloadType)
+		require.Equal(t, uint16(0x44ed+i), pkt.SequenceNumber)
+		require.Equal(t, uint32(0x88776655+2*90000), pkt.Timestamp)
+		require.Equal(t, i == len(pkts)-1, pkt.Marker)
+		require.LessOrEqual(t, len(pkt.Payload), 1460)
+	}
+
+	// main header of the first packet
+	require.Equal(t, []byte{0, 0, 0, 0, 1, 255, 40, 30}, pkts[0].Payload[:8])
+
+	dec := &Decoder{}
+	dec.Init()
+
+	var decoded []byte
+	for i, pkt := range pkts {
+		var err error
+		decoded, _, err = dec.Decode(pkt)
+		if i != len(pkts)-1 {
+			require.Equal(t, ErrMorePacketsNeeded, err)
+		} else {
+			require.NoError(t, err)
+		}
+	}
+
+	img1, err := jpeg.Decode(bytes.NewReader(byts))
+	require.NoError(t, err)
+
+	img2, err := jpeg.Decode(bytes.NewReader(decoded))
+	require.NoError(t, err)
+
+	require.Equal(t, img1.Bounds(), img2.Bounds())
+	require.Equal(t, img1, img2)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	enc := &Encoder{}
+	enc.Init()
+
+	pkts, err := enc.Encode(testImage(t, 64, 64), 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(pkts))
+
+	dec := &Decoder{}
+	dec.Init()
+
+	_, _, err = dec.Decode(pkts[0])
+	require.NoError(t, err)
+
+	pkts, err = enc.Encode(testImage(t, 640, 480), 0)
+	require.NoError(t, err)
+
+	_, _, err = dec.Decode(pkts[1])
+	require.Equal(t, ErrNonStartingPacketAndNoPrevious, err)
+
+	_, _, err = dec.Decode(pkts[0])
+	require.Equal(t, ErrMorePacketsNeeded, err)
+
+	_, _, err = dec.Decode(pkts[2])
+	require.EqualError(t, err, "discarding frame since a RTP packet is missing")
+}
+
+func TestEncodeErrors(t *testing.T) {
+	enc := &Encoder{}
+	enc.Init()
+
+	_, err := enc.Encode([]byte{0x01, 0x02}, 0)
+	require.EqualError(t, err, "SOI not found")
+
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil)
+	require.NoError(t, err)
+
+	_, err = enc.Encode(buf.Bytes(), 0)
+	require.EqualError(t, err, "only images with 3 components are supported")
+}
+
+func TestTrack(t *testing.T) {
+	require.Equal(t, true, IsTrack(NewTrack()))
+
+	track, err := gortsplib.NewTrackGeneric("video", []string{"96"}, "96 JPEG/90000", "")
+	require.NoError(t, err)
+	require.Equal(t, true, IsTrack(track))
+
+	track, err = gortsplib.NewTrackGeneric("video", []string{"96"}, "96 VP8/90000", "")
+	require.NoError(t, err)
+	require.Equal(t, false, IsTrack(track))
+
+	track2, err := gortsplib.NewTrackH264(96, []byte{0x67, 0x01}, []byte{0x68, 0x02}, nil)
+	require.NoError(t, err)
+	require.Equal(t, false, IsTrack(track2))
+}
@@ -0,0 +1,66 @@
+package testpattern
+
+const (
+	// AudioSampleRate is the sample rate of the audio.
+	AudioSampleRate = 48000
+
+	// AudioSamplesPerFrame is the number of samples of each audio frame.
+	AudioSamplesPerFrame = 1024
+
+	// gain of the tone, that is about -30dBFS.
+	audioGlobalGain = 180
+
+	// the tone is placed into the scalefactor band that contains
+	// the spectral coefficient 42, that is about 1kHz at 48khz.
+	audioToneBand = 10
+)
+
+// AudioFrame returns a MPEG-4 Audio AAC-LC frame of a mono tone,
+// at AudioSampleRate. The frame can be repeated indefinitely.
+func AudioFrame() []byte {
+	w := &bitWriter{}
+
+	// single_channel_element
+	w.writeBits(0, 3) // id_syn_ele: ID_SCE
+	w.writeBits(0, 4) // element_instance_tag
+
+	// individual_channel_stream
+	w.writeBits(audioGlobalGain, 8) // global_gain
+
+	// ics_info
+	w.writeBits(0, 1)               // ics_reserved_bit
+	w.writeBits(0, 2)               // window_sequence: ONLY_LONG_SEQUENCE
+	w.writeBits(0, 1)               // window_shape: sine
+	w.writeBits(audioToneBand+1, 6) // max_sfb
+	w.writeBits(0, 1)               // predictor_data_present
+
+	// section_data
+	w.writeBits(0, 4)             // sect_cb: ZERO_HCB
+	w.writeBits(audioToneBand, 5) // sect_len_incr
+	w.writeBits(7, 4)             // sect_cb: codebook 7
+	w.writeBits(1, 5)             // sect_len_incr
+
+	// scale_factor_data: the scalefactor of the band is equal to global_gain
+	w.writeBits(0, 1) // hcod_sf[60] (difference 0)
+
+	w.writeBits(0, 1) // pulse_data_present
+	w.writeBits(0, 1) // tns_data_present
+	w.writeBits(0, 1) // gain_control_data_present
+
+	// spectral_data: the band is made of coefficients 40-47,
+	// that are coded in pairs with codebook 7.
+	w.writeBits(0, 1)    // (0, 0)
+	w.writeBits(0x04, 3) // (1, 0)
+	w.writeBits(0, 1)    // sign of 1: positive
+	w.writeBits(0, 1)    // (0, 0)
+	w.writeBits(0, 1)    // (0, 0)
+
+	w.writeBits(7, 3) // id_syn_ele: ID_END
+
+	// byte_alignment
+	for !w.aligned() {
+		w.writeBit(0)
+	}
+
+	return w.buf
+}
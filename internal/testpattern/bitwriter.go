@@ -0,0 +1,100 @@
+package testpattern
+
+// bitWriter writes a H264 RBSP bit by bit.
+type bitWriter struct {
+	buf  []byte
+	cur  byte
+	nbit int
+}
+
+func (w *bitWriter) writeBit(v uint32) {
+	w.cur = w.cur<<1 | byte(v&0x01)
+	w.nbit++
+	if w.nbit == 8 {
+		w.buf = append(w.buf, w.cur)
+		w.cur = 0
+		w.nbit = 0
+	}
+}
+
+func (w *bitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		w.writeBit(v >> i)
+	}
+}
+
+func (w *bitWriter) writeFlag(v bool) {
+	if v {
+		w.writeBit(1)
+	} else {
+		w.writeBit(0)
+	}
+}
+
+// writeUE writes an unsigned Exp-Golomb code.
+func (w *bitWriter) writeUE(v uint32) {
+	v++
+	n := 0
+	for tmp := v; tmp > 1; tmp >>= 1 {
+		n++
+	}
+	w.writeBits(0, n)
+	w.writeBits(v, n+1)
+}
+
+// writeSE writes a signed Exp-Golomb code.
+func (w *bitWriter) writeSE(v int32) {
+	if v > 0 {
+		w.writeUE(uint32(2*v - 1))
+	} else {
+		w.writeUE(uint32(-2 * v))
+	}
+}
+
+func (w *bitWriter) aligned() bool {
+	return w.nbit == 0
+}
+
+func (w *bitWriter) writeBytes(byts []byte) {
+	if w.aligned() {
+		w.buf = append(w.buf, byts...)
+		return
+	}
+
+	for _, b := range byts {
+		w.writeBits(uint32(b), 8)
+	}
+}
+
+// writeTrailingBits writes rbsp_trailing_bits() and returns the content.
+func (w *bitWriter) writeTrailingBits() []byte {
+	w.writeBit(1)
+	for !w.aligned() {
+		w.writeBit(0)
+	}
+	return w.buf
+}
+
+// emulationPreventionAdd inserts emulation prevention bytes into a RBSP,
+// in order to avoid start code emulation.
+func emulationPreventionAdd(rbsp []byte) []byte {
+	ret := make([]byte, 0, len(rbsp)+len(rbsp)/64)
+	zeros := 0
+
+	for _, b := range rbsp {
+		if zeros == 2 && b <= 0x03 {
+			ret = append(ret, 0x03)
+			zeros = 0
+		}
+
+		ret = append(ret, b)
+
+		if b == 0x00 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,123 @@
+package testpattern
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aler9/gortsplib/pkg/h264"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVideoSPS(t *testing.T) {
+	for _, ca := range []struct {
+		name   string
+		width  int
+		height int
+		fps    int
+	}{
+		{"aligned", 1280, 720, 30},
+		{"cropped", 640, 360, 25},
+		{"small", 16, 2, 1},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			v, err := NewVideo(ca.width, ca.height, ca.fps, 0)
+			require.NoError(t, err)
+
+			var sps h264.SPS
+			err = sps.Unmarshal(v.SPS())
+			require.NoError(t, err)
+			require.Equal(t, ca.width, sps.Width())
+			require.Equal(t, ca.height, sps.Height())
+			require.Equal(t, float64(ca.fps), sps.FPS())
+		})
+	}
+}
+
+func TestVideoFrames(t *testing.T) {
+	v, err := NewVideo(640, 360, 25, 0)
+	require.NoError(t, err)
+	require.Equal(t, 40*time.Millisecond, v.FrameDuration())
+
+	for i := 0; i < 50; i++ {
+		now := time.Date(2022, 5, 10, 14, 5, 0, i*1000, time.UTC)
+		nalus := v.Frame(now)
+
+		var types []h264.NALUType
+		for _, nalu := range nalus {
+			types = append(types, h264.NALUType(nalu[0]&0x1F))
+		}
+
+		if (i % 25) == 0 {
+			require.Equal(t, []h264.NALUType{
+				h264.NALUTypeSPS,
+				h264.NALUTypePPS,
+				h264.NALUTypeSEI,
+				h264.NALUTypeIDR,
+			}, types)
+			require.Equal(t, true, h264.IDRPresent(nalus))
+		} else {
+			require.Equal(t, []h264.NALUType{
+				h264.NALUTypeSEI,
+				h264.NALUTypeNonIDR,
+			}, types)
+		}
+
+		wc, ok := Wallclock(nalus[len(nalus)-2])
+		require.Equal(t, true, ok)
+		require.Equal(t, now, wc.UTC())
+	}
+}
+
+func TestVideoBitrate(t *testing.T) {
+	v, err := NewVideo(640, 360, 25, 2000000)
+	require.NoError(t, err)
+
+	size := 0
+	for i := 0; i < 25*4; i++ {
+		for _, nalu := range v.Frame(time.Now()) {
+			size += 4 + len(nalu)
+		}
+	}
+
+	require.InDelta(t, 2000000*4/8, size, 2000000*4/8/100)
+}
+
+func TestVideoErrors(t *testing.T) {
+	for _, ca := range []struct {
+		name    string
+		width   int
+		height  int
+		fps     int
+		bitrate int
+		err     string
+	}{
+		{"zero width", 0, 360, 25, 0, "invalid resolution: 0x360"},
+		{"too big", 8192, 360, 25, 0, "invalid resolution: 8192x360"},
+		{"odd", 641, 360, 25, 0, "width and height must be even"},
+		{"fps", 640, 360, 0, 0, "invalid FPS: 0"},
+		{"bitrate", 640, 360, 25, -1, "invalid bitrate: -1"},
+	} {
+		t.Run(ca.name, func(t *testing.T) {
+			_, err := NewVideo(ca.width, ca.height, ca.fps, ca.bitrate)
+			require.EqualError(t, err, ca.err)
+		})
+	}
+}
+
+func TestWallclockInvalid(t *testing.T) {
+	_, ok := Wallclock([]byte{0x06, 0x05, 0x01, 0x00, 0x80})
+	require.Equal(t, false, ok)
+
+	_, ok = Wallclock([]byte{0x65, 0x01})
+	require.Equal(t, false, ok)
+}
+
+func TestEmulationPrevention(t *testing.T) {
+	require.Equal(t,
+		[]byte{0x00, 0x00, 0x03, 0x01, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x03, 0x04},
+		emulationPreventionAdd([]byte{0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x03, 0x04}))
+}
+
+func TestAudioFrame(t *testing.T) {
+	require.Equal(t, []byte{0x01, 0x68, 0x05, 0x81, 0x4e, 0x10, 0x41, 0xc0}, AudioFrame())
+}
@@ -0,0 +1,391 @@
+// Package testpattern contains a generator of synthetic H264 and AAC streams.
+package testpattern
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/aler9/gortsplib/pkg/h264"
+)
+
+const (
+	// maximum width and height of the video.
+	maxWidth  = 4096
+	maxHeight = 2304
+
+	// frame_num is coded with 4 bits.
+	log2MaxFrameNum = 4
+
+	// disable_deblocking_filter_idc that disables the filter,
+	// in order to keep the pattern unaltered.
+	disableDeblockingFilter = 1
+
+	// mb_type of a I_PCM macroblock in a I slice.
+	mbTypeIPCM = 25
+
+	// mb_type of a I_16x16 macroblock in a I slice, with vertical prediction
+	// and without residuals (I_16x16_0_0_0).
+	mbTypeI16x16Vertical = 1
+
+	// intra_chroma_pred_mode of the vertical prediction.
+	intraChromaPredVertical = 2
+)
+
+// seiUUID identifies SEI messages that contain the wallclock.
+var seiUUID = []byte{
+	0x8c, 0x5b, 0x1e, 0x4e, 0x33, 0x4f, 0x4a, 0x53,
+	0x9d, 0x36, 0x7d, 0x2e, 0x58, 0x59, 0x54, 0x50,
+}
+
+// color bars at 75%, in YCbCr BT.601 format.
+var colorBars = [][3]byte{
+	{180, 128, 128}, // white
+	{162, 44, 142},  // yellow
+	{131, 156, 44},  // cyan
+	{112, 72, 58},   // green
+	{84, 184, 198},  // magenta
+	{65, 100, 212},  // red
+	{35, 212, 114},  // blue
+}
+
+func h264NALUHeader(refIdc byte, typ byte) byte {
+	return refIdc<<5 | typ
+}
+
+// level returns the minimum level_idc that supports a given number of
+// macroblocks per frame and macroblocks per second.
+func level(frameSize int, mbPerSec int) byte {
+	for _, l := range []struct {
+		idc       byte
+		frameSize int
+		mbPerSec  int
+	}{
+		{30, 1620, 40500},
+		{31, 3600, 108000},
+		{32, 5120, 216000},
+		{40, 8192, 245760},
+		{42, 8704, 522240},
+		{50, 22080, 589824},
+		{51, 36864, 983040},
+	} {
+		if frameSize <= l.frameSize && mbPerSec <= l.mbPerSec {
+			return l.idc
+		}
+	}
+	return 52
+}
+
+// Video generates a H264 test pattern made of color bars.
+// Every group of pictures lasts one second and is made of an IDR frame and of
+// P frames that repeat it. Every frame contains a SEI message with the time
+// of its generation, and is padded with filler data in order to reach
+// the requested bitrate.
+type Video struct {
+	width   int
+	height  int
+	fps     int
+	bitrate int
+
+	mbWidth    int
+	mbHeight   int
+	sps        []byte
+	pps        []byte
+	frameCount int
+	idrCount   int
+	padBudget  int
+}
+
+// NewVideo allocates a Video.
+// width and height must be even. If bitrate (in bits per second) is zero,
+// frames are not padded.
+func NewVideo(width int, height int, fps int, bitrate int) (*Video, error) {
+	if width <= 0 || height <= 0 || width > maxWidth || height > maxHeight {
+		return nil, fmt.Errorf("invalid resolution: %dx%d", width, height)
+	}
+
+	if (width%2) != 0 || (height%2) != 0 {
+		return nil, fmt.Errorf("width and height must be even")
+	}
+
+	if fps <= 0 || fps > 120 {
+		return nil, fmt.Errorf("invalid FPS: %d", fps)
+	}
+
+	if bitrate < 0 {
+		return nil, fmt.Errorf("invalid bitrate: %d", bitrate)
+	}
+
+	v := &Video{
+		width:    width,
+		height:   height,
+		fps:      fps,
+		bitrate:  bitrate,
+		mbWidth:  (width + 15) / 16,
+		mbHeight: (height + 15) / 16,
+	}
+
+	v.sps = v.generateSPS()
+	v.pps = v.generatePPS()
+
+	return v, nil
+}
+
+// SPS returns the SPS of the video.
+func (v *Video) SPS() []byte {
+	return v.sps
+}
+
+// PPS returns the PPS of the video.
+func (v *Video) PPS() []byte {
+	return v.pps
+}
+
+// FrameDuration returns the duration of a frame.
+func (v *Video) FrameDuration() time.Duration {
+	return time.Second / time.Duration(v.fps)
+}
+
+// Frame returns the NALUs of the next frame. now is inserted into a SEI message.
+func (v *Video) Frame(now time.Time) [][]byte {
+	frameNum := v.frameCount % v.fps
+	v.frameCount++
+
+	var nalus [][]byte
+
+	if frameNum == 0 {
+		nalus = append(nalus, v.sps, v.pps, generateSEI(now), v.generateIDRSlice())
+		v.idrCount++
+	} else {
+		nalus = append(nalus, generateSEI(now), v.generatePSlice(frameNum))
+	}
+
+	if v.bitrate > 0 {
+		size := 0
+		for _, nalu := range nalus {
+			size += 4 + len(nalu)
+		}
+
+		v.padBudget += v.bitrate / 8 / v.fps
+		v.padBudget -= size
+
+		// a filler NALU takes at least 6 bytes
+		if v.padBudget > 6 {
+			nalus = append(nalus, generateFiller(v.padBudget-6))
+			v.padBudget = 0
+		}
+
+		// when frames are bigger than the requested bitrate,
+		// the exceeding size is recovered within one second at most.
+		if v.padBudget < -v.bitrate/8 {
+			v.padBudget = -v.bitrate / 8
+		}
+	}
+
+	return nalus
+}
+
+func (v *Video) generateSPS() []byte {
+	w := &bitWriter{}
+
+	w.writeBits(66, 8)   // profile_idc: baseline
+	w.writeBits(0xC0, 8) // constraint_set0_flag, constraint_set1_flag
+	w.writeBits(uint32(level(v.mbWidth*v.mbHeight, v.mbWidth*v.mbHeight*v.fps)), 8)
+	w.writeUE(0)                   // seq_parameter_set_id
+	w.writeUE(log2MaxFrameNum - 4) // log2_max_frame_num_minus4
+	w.writeUE(2)                   // pic_order_cnt_type
+	w.writeUE(1)                   // max_num_ref_frames
+	w.writeFlag(false)             // gaps_in_frame_num_value_allowed_flag
+	w.writeUE(uint32(v.mbWidth - 1))
+	w.writeUE(uint32(v.mbHeight - 1))
+	w.writeFlag(true) // frame_mbs_only_flag
+	w.writeFlag(true) // direct_8x8_inference_flag
+
+	cropRight := (v.mbWidth*16 - v.width) / 2
+	cropBottom := (v.mbHeight*16 - v.height) / 2
+	if cropRight != 0 || cropBottom != 0 {
+		w.writeFlag(true) // frame_cropping_flag
+		w.writeUE(0)
+		w.writeUE(uint32(cropRight))
+		w.writeUE(0)
+		w.writeUE(uint32(cropBottom))
+	} else {
+		w.writeFlag(false)
+	}
+
+	w.writeFlag(true)  // vui_parameters_present_flag
+	w.writeFlag(false) // aspect_ratio_info_present_flag
+	w.writeFlag(false) // overscan_info_present_flag
+	w.writeFlag(false) // video_signal_type_present_flag
+	w.writeFlag(false) // chroma_loc_info_present_flag
+	w.writeFlag(true)  // timing_info_present_flag
+	w.writeBits(1, 32) // num_units_in_tick
+	w.writeBits(uint32(v.fps*2), 32)
+	w.writeFlag(true)  // fixed_frame_rate_flag
+	w.writeFlag(false) // nal_hrd_parameters_present_flag
+	w.writeFlag(false) // vcl_hrd_parameters_present_flag
+	w.writeFlag(false) // pic_struct_present_flag
+	w.writeFlag(false) // bitstream_restriction_flag
+
+	return append([]byte{h264NALUHeader(3, 7)}, emulationPreventionAdd(w.writeTrailingBits())...)
+}
+
+func (v *Video) generatePPS() []byte {
+	w := &bitWriter{}
+
+	w.writeUE(0)       // pic_parameter_set_id
+	w.writeUE(0)       // seq_parameter_set_id
+	w.writeFlag(false) // entropy_coding_mode_flag: CAVLC
+	w.writeFlag(false) // bottom_field_pic_order_in_frame_present_flag
+	w.writeUE(0)       // num_slice_groups_minus1
+	w.writeUE(0)       // num_ref_idx_l0_default_active_minus1
+	w.writeUE(0)       // num_ref_idx_l1_default_active_minus1
+	w.writeFlag(false) // weighted_pred_flag
+	w.writeBits(0, 2)  // weighted_bipred_idc
+	w.writeSE(0)       // pic_init_qp_minus26
+	w.writeSE(0)       // pic_init_qs_minus26
+	w.writeSE(0)       // chroma_qp_index_offset
+	w.writeFlag(true)  // deblocking_filter_control_present_flag
+	w.writeFlag(false) // constrained_intra_pred_flag
+	w.writeFlag(false) // redundant_pic_cnt_present_flag
+
+	return append([]byte{h264NALUHeader(3, 8)}, emulationPreventionAdd(w.writeTrailingBits())...)
+}
+
+// generateIDRSlice generates a IDR slice that contains color bars.
+// The first row of macroblocks is coded with raw samples (I_PCM), while
+// the other rows are predicted from the row above.
+func (v *Video) generateIDRSlice() []byte {
+	w := &bitWriter{}
+
+	w.writeUE(0)                        // first_mb_in_slice
+	w.writeUE(7)                        // slice_type: I (all slices)
+	w.writeUE(0)                        // pic_parameter_set_id
+	w.writeBits(0, log2MaxFrameNum)     // frame_num
+	w.writeUE(uint32(v.idrCount % 256)) // idr_pic_id
+	w.writeFlag(false)                  // no_output_of_prior_pics_flag
+	w.writeFlag(false)                  // long_term_reference_flag
+	w.writeSE(0)                        // slice_qp_delta
+	w.writeUE(disableDeblockingFilter)  // disable_deblocking_filter_idc
+
+	for y := 0; y < v.mbHeight; y++ {
+		for x := 0; x < v.mbWidth; x++ {
+			if y == 0 {
+				w.writeUE(mbTypeIPCM)
+				for !w.aligned() {
+					w.writeBit(0) // pcm_alignment_zero_bit
+				}
+				w.writeBytes(v.pcmSamples(x))
+				continue
+			}
+
+			w.writeUE(mbTypeI16x16Vertical)
+			w.writeUE(intraChromaPredVertical)
+			w.writeSE(0) // mb_qp_delta
+
+			// coeff_token of Intra16x16DCLevel, with TotalCoeff = 0 and TrailingOnes = 0.
+			// It depends on nC, that is computed from the number of coefficients
+			// of the neighbouring blocks, that is 16 for I_PCM macroblocks.
+			if y == 1 {
+				w.writeBits(0x03, 6) // nC >= 8
+			} else {
+				w.writeBits(0x01, 1) // 0 <= nC < 2
+			}
+		}
+	}
+
+	return append([]byte{h264NALUHeader(3, 5)}, emulationPreventionAdd(w.writeTrailingBits())...)
+}
+
+// pcmSamples returns the luma and chroma samples of a macroblock
+// of the first row.
+func (v *Video) pcmSamples(mbX int) []byte {
+	ret := make([]byte, 256+64+64)
+
+	bar := func(x int) [3]byte {
+		i := x * len(colorBars) / v.width
+		if i >= len(colorBars) {
+			i = len(colorBars) - 1
+		}
+		return colorBars[i]
+	}
+
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			ret[y*16+x] = bar(mbX*16 + x)[0]
+		}
+	}
+
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			c := bar((mbX*8 + x) * 2)
+			ret[256+y*8+x] = c[1]
+			ret[256+64+y*8+x] = c[2]
+		}
+	}
+
+	return ret
+}
+
+// generatePSlice generates a P slice that repeats the previous frame,
+// by skipping all macroblocks.
+func (v *Video) generatePSlice(frameNum int) []byte {
+	w := &bitWriter{}
+
+	w.writeUE(0)                                                        // first_mb_in_slice
+	w.writeUE(5)                                                        // slice_type: P (all slices)
+	w.writeUE(0)                                                        // pic_parameter_set_id
+	w.writeBits(uint32(frameNum%(1<<log2MaxFrameNum)), log2MaxFrameNum) // frame_num
+	w.writeFlag(false)                                                  // num_ref_idx_active_override_flag
+	w.writeFlag(false)                                                  // ref_pic_list_modification_flag_l0
+	w.writeFlag(false)                                                  // adaptive_ref_pic_marking_mode_flag
+	w.writeSE(0)                                                        // slice_qp_delta
+	w.writeUE(disableDeblockingFilter)                                  // disable_deblocking_filter_idc
+	w.writeUE(uint32(v.mbWidth * v.mbHeight))                           // mb_skip_run
+
+	return append([]byte{h264NALUHeader(2, 1)}, emulationPreventionAdd(w.writeTrailingBits())...)
+}
+
+// generateSEI generates a SEI message of type user_data_unregistered,
+// that contains a wallclock.
+func generateSEI(now time.Time) []byte {
+	payload := make([]byte, 16+8)
+	copy(payload, seiUUID)
+	binary.BigEndian.PutUint64(payload[16:], uint64(now.UnixNano()/1000))
+
+	rbsp := append([]byte{
+		5,                  // payloadType: user_data_unregistered
+		byte(len(payload)), // payloadSize
+	}, payload...)
+	rbsp = append(rbsp, 0x80) // rbsp_trailing_bits
+
+	return append([]byte{h264NALUHeader(0, 6)}, emulationPreventionAdd(rbsp)...)
+}
+
+// generateFiller generates a filler data NALU of the given size.
+func generateFiller(size int) []byte {
+	ret := make([]byte, 1+size+1)
+	ret[0] = h264NALUHeader(0, 12)
+	for i := 1; i <= size; i++ {
+		ret[i] = 0xFF
+	}
+	ret[size+1] = 0x80 // rbsp_trailing_bits
+	return ret
+}
+
+// Wallclock returns the wallclock contained into a SEI NALU generated by Video.
+func Wallclock(nalu []byte) (time.Time, bool) {
+	if len(nalu) < 1 || (nalu[0]&0x1F) != 6 {
+		return time.Time{}, false
+	}
+
+	rbsp := h264.AntiCompetitionRemove(nalu[1:])
+	if len(rbsp) < 2+16+8 || rbsp[0] != 5 || rbsp[1] != 16+8 ||
+		!bytes.Equal(rbsp[2:2+16], seiUUID) {
+		return time.Time{}, false
+	}
+
+	us := binary.BigEndian.Uint64(rbsp[2+16:])
+	return time.Unix(0, int64(us)*1000), true
+}